@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Admin is the implementation of the administration actions. Each exported method is an action
+type Admin struct {
+	ctx *context.Context
+}
+
+// Empty is the reply of the actions that don't return anything
+type Empty struct{}
+
+// Serve starts serving the administration actions at the admin socket, until the context is cancelled
+func Serve(ctx *context.Context) {
+	if err := os.MkdirAll(filepath.Dir(ctx.Cfg.Admin.Socket), 0700); err != nil {
+		log.Fatalf("error creating the admin socket directory: %v", err)
+	}
+
+	// The socket of a previous Core execution is left behind if the Core hasn't been stopped gracefully
+	if err := os.Remove(ctx.Cfg.Admin.Socket); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("error removing the previous admin socket: %v", err)
+	}
+
+	lis, err := net.Listen("unix", ctx.Cfg.Admin.Socket)
+	if err != nil {
+		log.Fatalf("error listening at the admin socket '%s': %v", ctx.Cfg.Admin.Socket, err)
+	}
+
+	// Only the user running the Core can send administration actions
+	if err := os.Chmod(ctx.Cfg.Admin.Socket, 0600); err != nil {
+		log.Fatalf("error changing the admin socket permissions: %v", err)
+	}
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Admin", &Admin{ctx}); err != nil {
+		log.Fatalf("error registering the administration actions: %v", err)
+	}
+
+	log.Infof("DRLM Core listenning for administration actions at '%s'", ctx.Cfg.Admin.Socket)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				// The listener gets closed when the Core stops
+				return
+			}
+
+			go srv.ServeConn(conn)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		lis.Close()
+		ctx.WG.Done()
+	}
+}
+
+// Call sends the administration action to the running Core and waits until it finishes
+func Call(ctx *context.Context, action string, args, reply interface{}) error {
+	c, err := rpc.Dial("unix", ctx.Cfg.Admin.Socket)
+	if err != nil {
+		return fmt.Errorf("error connecting to the DRLM Core (is it running?): %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Call("Admin."+action, args, reply); err != nil {
+		// The errors returned by the actions are sent as plain text
+		if _, ok := err.(rpc.ServerError); ok {
+			return errors.New(err.Error())
+		}
+
+		return fmt.Errorf("error sending the administration action to the DRLM Core: %v", err)
+	}
+
+	return nil
+}
+
+// notFound replaces the not found errors of the DB with a readable error, since the errors lose their type when they're sent
+func notFound(err error, msg string) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return errors.New(msg)
+	}
+
+	return err
+}
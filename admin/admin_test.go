@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package admin_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/admin"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestAdminSuite struct {
+	suite.Suite
+	ctx    *context.Context
+	cancel func()
+	mock   sqlmock.Sqlmock
+	dir    string
+}
+
+func (s *TestAdminSuite) SetupTest() {
+	var err error
+	s.dir, err = ioutil.TempDir("", "drlm-admin")
+	s.Require().NoError(err)
+
+	s.ctx, s.cancel = context.WithCancel()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+
+	s.ctx.FS = tests.GenerateCtx().FS
+	tests.GenerateCfg(s.T(), s.ctx)
+	s.ctx.Cfg.Admin.Socket = filepath.Join(s.dir, "run", "core.sock")
+
+	s.ctx.WG.Add(1)
+	go admin.Serve(s.ctx)
+
+	s.Eventually(func() bool {
+		_, err := os.Stat(s.ctx.Cfg.Admin.Socket)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (s *TestAdminSuite) TearDownTest() {
+	s.cancel()
+	s.ctx.WG.Wait()
+
+	s.NoError(s.mock.ExpectationsWereMet())
+	os.RemoveAll(s.dir)
+}
+
+func TestAdmin(t *testing.T) {
+	suite.Run(t, &TestAdminSuite{})
+}
+
+func (s *TestAdminSuite) TestServe() {
+	s.Run("should only allow the user running the Core to use the socket", func() {
+		info, err := os.Stat(s.ctx.Cfg.Admin.Socket)
+		s.Require().NoError(err)
+
+		s.Equal(os.FileMode(0600), info.Mode().Perm())
+	})
+}
+
+func (s *TestAdminSuite) TestCall() {
+	s.Run("should run the action in the Core and return its reply", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		versions := func() *sqlmock.Rows {
+			return sqlmock.NewRows([]string{"id", "agent_host", "repo", "name", "version"}).
+				AddRow(1, "laptop", "default", "tar", "v1.0.0").
+				AddRow(2, "laptop", "default", "tar", "v2.0.0")
+		}
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(versions())
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(versions())
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugins"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugins"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(versions())
+
+		var rsp admin.PluginReply
+		s.NoError(admin.Call(s.ctx, "RollbackPlugin", admin.PluginArgs{Host: "laptop", Plugin: "default/tar"}, &rsp))
+		s.Equal("v1.0.0", rsp.Version)
+	})
+
+	s.Run("should return the errors of the action", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnError(gorm.ErrRecordNotFound)
+
+		s.EqualError(admin.Call(s.ctx, "AcceptHostKeys", admin.HostKeysArgs{Host: "laptop", Usr: "nefix"}, &admin.Empty{}), "agent not found")
+	})
+
+	s.Run("should return an error if the Core isn't running", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		ctx.Cfg.Admin.Socket = filepath.Join(s.dir, "missing.sock")
+
+		err := admin.Call(ctx, "AcceptHostKeys", admin.HostKeysArgs{Host: "laptop"}, &admin.Empty{})
+		s.Error(err)
+		s.Contains(err.Error(), "error connecting to the DRLM Core (is it running?)")
+	})
+}
@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package admin
+
+import (
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/models"
+)
+
+// ImportArgs are the arguments of the Import action
+type ImportArgs struct {
+	Hosts       []*agent.InventoryHost
+	Binary      []byte
+	Concurrency int
+	DryRun      bool
+}
+
+// ImportReply is the reply of the Import action. It has the error of each host, which is empty if it has been imported
+type ImportReply struct {
+	Results map[string]string
+}
+
+// Import adds and installs the agents of an inventory. The Core waits for the connection of each installed agent
+func (a *Admin) Import(args ImportArgs, reply *ImportReply) error {
+	reply.Results = map[string]string{}
+	for h, err := range agent.Import(a.ctx, args.Hosts, args.Binary, args.Concurrency, args.DryRun) {
+		reply.Results[h] = ""
+		if err != nil {
+			reply.Results[h] = err.Error()
+		}
+	}
+
+	return nil
+}
+
+// HostKeysArgs are the arguments of the host keys review actions
+type HostKeysArgs struct {
+	Host string
+	Usr  string // The user that reviews the keys, which is stored in the audit trail
+}
+
+// AcceptHostKeys accepts the pending SSH host keys of an agent, closing its open SSH sessions
+func (a *Admin) AcceptHostKeys(args HostKeysArgs, reply *Empty) error {
+	return notFound(agent.AcceptHostKeys(a.ctx, &models.Agent{Host: args.Host}, args.Usr), "agent not found")
+}
+
+// AgentArgs are the arguments of the actions on an agent
+type AgentArgs struct {
+	Host string
+}
+
+// Quarantine quarantines an agent, removing its connection from the Core and closing its SSH sessions. It fails if the
+// storage backend can't disable the agent user
+func (a *Admin) Quarantine(args AgentArgs, reply *Empty) error {
+	return notFound(agent.Quarantine(a.ctx, &models.Agent{Host: args.Host}), "agent not found")
+}
+
+// Enable enables a quarantined agent
+func (a *Admin) Enable(args AgentArgs, reply *Empty) error {
+	return notFound(agent.Enable(a.ctx, &models.Agent{Host: args.Host}), "agent not found")
+}
+
+// ChangeHostArgs are the arguments of the ChangeHost action
+type ChangeHostArgs struct {
+	Host    string
+	NewHost string
+}
+
+// ChangeHost changes the host of an agent, updating its scheduled jobs and its connection and closing its SSH sessions
+func (a *Admin) ChangeHost(args ChangeHostArgs, reply *Empty) error {
+	return notFound(agent.ChangeHost(a.ctx, &models.Agent{Host: args.Host}, args.NewHost), "agent not found")
+}
+
+// UpgradeArgs are the arguments of the Upgrade action. Either the host or the selector are required
+type UpgradeArgs struct {
+	Host      string
+	Selector  string // The label selector (`name=value,name2=value2`) of the agents
+	Version   string
+	Binary    []byte
+	BatchSize int
+}
+
+// UpgradeReply is the reply of the Upgrade action. It has the error of each agent, which is empty if it has been upgraded
+type UpgradeReply struct {
+	Results map[string]string
+}
+
+// Upgrade upgrades an agent or the agents that match a selector. The Core waits for each agent to reconnect with the new version
+func (a *Admin) Upgrade(args UpgradeArgs, reply *UpgradeReply) error {
+	reply.Results = map[string]string{}
+
+	if args.Host != "" {
+		ag := &models.Agent{Host: args.Host}
+		if err := ag.Load(a.ctx); err != nil {
+			return notFound(err, "agent not found")
+		}
+
+		reply.Results[ag.Host] = ""
+		if err := agent.Upgrade(a.ctx, ag, args.Version, args.Binary); err != nil {
+			reply.Results[ag.Host] = err.Error()
+		}
+
+		return nil
+	}
+
+	sel, err := models.ParseLabelSelector(args.Selector)
+	if err != nil {
+		return err
+	}
+
+	results, err := agent.UpgradeBySelector(a.ctx, sel, args.Version, args.Binary, args.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for h, err := range results {
+		reply.Results[h] = ""
+		if err != nil {
+			reply.Results[h] = err.Error()
+		}
+	}
+
+	return nil
+}
+
+// RotateSecretArgs are the arguments of the RotateSecret action
+type RotateSecretArgs struct {
+	Host  string
+	Minio bool // Whether the Minio secret key gets rotated too
+}
+
+// RotateSecret rotates the secret of a connected agent, sending the new one through its connection
+func (a *Admin) RotateSecret(args RotateSecretArgs, reply *Empty) error {
+	ag := &models.Agent{Host: args.Host}
+	if err := ag.Load(a.ctx); err != nil {
+		return notFound(err, "agent not found")
+	}
+
+	return agent.RotateSecret(a.ctx, ag, args.Minio)
+}
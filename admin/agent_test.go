@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package admin_test
+
+import (
+	"regexp"
+
+	"github.com/brainupdaters/drlm-core/admin"
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/minio"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+)
+
+func (s *TestAdminSuite) TestQuarantine() {
+	s.Run("should quarantine the agent even if the storage backend users aren't managed by the Core", func() {
+		s.ctx.Cfg.Minio.Backend = minio.BackendS3
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(admin.Call(s.ctx, "Quarantine", admin.AgentArgs{Host: "laptop"}, &admin.Empty{}))
+	})
+}
+
+func (s *TestAdminSuite) TestUpgrade() {
+	s.Run("should return an error if the agent isn't found", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnError(gorm.ErrRecordNotFound)
+
+		s.EqualError(admin.Call(s.ctx, "Upgrade", admin.UpgradeArgs{Host: "laptop", Version: "v1.1.0"}, &admin.UpgradeReply{}), "agent not found")
+	})
+
+	s.Run("should return an error if the selector is invalid", func() {
+		s.Error(admin.Call(s.ctx, "Upgrade", admin.UpgradeArgs{Selector: "invalid", Version: "v1.1.0"}, &admin.UpgradeReply{}))
+	})
+}
+
+func (s *TestAdminSuite) TestRotateSecret() {
+	s.Run("should return an error if the agent isn't connected to the Core", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+
+		s.EqualError(admin.Call(s.ctx, "RotateSecret", admin.RotateSecretArgs{Host: "laptop"}, &admin.Empty{}), agent.ErrAgentNotConnected.Error())
+	})
+}
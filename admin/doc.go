@@ -0,0 +1,11 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package admin has the administration actions that change the in-memory state of the running Core (the agents
+// connections, the scheduled jobs, the SSH sessions...), so they have to run inside the Core process. The Core serves
+// them through a local Unix socket, which is used by the CLI commands.
+//
+// TODO: Expose the administration actions and the rest of the CLI commands (the agents import, quarantine, host changes
+// and aliases, the host keys review, the bastions, the enrollment tokens, the plugin catalog and repositories, the
+// plugin config overrides and preview, the plugins details, rollback, pinning and deprecation...) through the GRPC API
+// once the protobuf has the RPCs for them. The CLI commands can then use the API instead of the admin socket or the DB
+package admin
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package admin
+
+import (
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/plugin"
+)
+
+// PluginArgs are the arguments of the actions on a plugin of an agent
+type PluginArgs struct {
+	Host   string
+	Plugin string // The plugin (repo/name)
+}
+
+// PluginReply is the reply of the actions on a plugin of an agent
+type PluginReply struct {
+	Version string // The version of the plugin after the action
+}
+
+// RollbackPlugin switches a plugin of an agent back to the version installed before the current one, updating the scheduled jobs that use it
+func (a *Admin) RollbackPlugin(args PluginArgs, reply *PluginReply) error {
+	p, err := plugin.Load(a.ctx, &models.Agent{Host: args.Host}, args.Plugin)
+	if err != nil {
+		return notFound(err, "agent or plugin not found")
+	}
+
+	if err := plugin.Rollback(a.ctx, p); err != nil {
+		return err
+	}
+
+	reply.Version = p.Version
+
+	return nil
+}
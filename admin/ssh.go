@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package admin
+
+import (
+	"github.com/brainupdaters/drlm-core/ssh"
+)
+
+// RotateKeyArgs are the arguments of the RotateKey action
+type RotateKeyArgs struct {
+	Partial bool // Whether the new key replaces the current one even if it can't be deployed to all the hosts
+}
+
+// RotateKeyReply is the reply of the RotateKey action. It has the error of each agent and bastion host, which is empty if
+// the new key has been deployed
+type RotateKeyReply struct {
+	Results  map[string]string
+	Pending  []string // The hosts that still have the previous key authorized
+	Reverted bool     // Whether the rotation has been reverted because the new key couldn't be deployed to all the hosts
+}
+
+// RotateKey rotates the Core SSH key, or resumes a pending partial rotation
+func (a *Admin) RotateKey(args RotateKeyArgs, reply *RotateKeyReply) error {
+	results, pending, err := ssh.RotateKey(a.ctx, args.Partial)
+	if err != nil && err != ssh.ErrKeyNotDeployed {
+		return err
+	}
+
+	reply.Results = map[string]string{}
+	for h, err := range results {
+		reply.Results[h] = ""
+		if err != nil {
+			reply.Results[h] = err.Error()
+		}
+	}
+
+	reply.Pending = pending
+	reply.Reverted = err == ssh.ErrKeyNotDeployed
+
+	return nil
+}
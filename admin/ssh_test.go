@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package admin_test
+
+import (
+	"regexp"
+
+	"github.com/brainupdaters/drlm-core/admin"
+	"github.com/brainupdaters/drlm-core/ssh"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/spf13/afero"
+)
+
+func (s *TestAdminSuite) TestRotateKey() {
+	s.Run("should finish a pending partial rotation once no host has the previous key", func() {
+		ssh.Init(s.ctx)
+		s.Require().NoError(afero.WriteFile(s.ctx.FS, "ssh/id_core.prev", []byte("previous"), 0600))
+		s.Require().NoError(afero.WriteFile(s.ctx.FS, "ssh/id_core.prev.pub", []byte("ssh-ed25519 AAAAprev drlm-core"), 0644))
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+		var rsp admin.RotateKeyReply
+		s.NoError(admin.Call(s.ctx, "RotateKey", admin.RotateKeyArgs{}, &rsp))
+		s.Empty(rsp.Results)
+		s.Empty(rsp.Pending)
+		s.False(rsp.Reverted)
+
+		exists, err := afero.Exists(s.ctx.FS, "ssh/id_core.prev")
+		s.NoError(err)
+		s.False(exists)
+	})
+}
@@ -3,37 +3,45 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
-	"os/user"
-	"path/filepath"
-	"strconv"
-	"strings"
+	"time"
 
+	"github.com/brainupdaters/drlm-core/ca"
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/minio"
 	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
 
 	"github.com/brainupdaters/drlm-common/pkg/os"
 	"github.com/brainupdaters/drlm-common/pkg/os/client"
-	"github.com/brainupdaters/drlm-common/pkg/ssh"
-	"github.com/spf13/afero"
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	log "github.com/sirupsen/logrus"
 )
 
+// InstallTimeout is the maximum time that an agent has to establish the connection with the Core after being installed
+var InstallTimeout = 5 * time.Minute
+
 // Add connects to the Agent host, creates the drlm user and copies the keys to that user, which has to be admin
 func Add(ctx *context.Context, a *models.Agent) error {
 	a.Accepted = true
 
+	// Add the Agent to the DB. It's added before creating the minio user, since the user name uses the agent ID
+	if err := a.Add(ctx); err != nil {
+		return err
+	}
+
 	var err error
 	if a.MinioKey, err = minio.CreateUser(ctx, fmt.Sprintf("drlm-agent-%d", a.ID)); err != nil {
-		return fmt.Errorf("error creating the agent minio user: %v", err)
-	}
+		if err := a.Delete(ctx); err != nil {
+			log.Errorf("error removing the agent '%s' from the DB: %v", a.Host, err)
+		}
 
-	// Add the Agent to the DB
-	if err := a.Add(ctx); err != nil {
-		return err
+		return fmt.Errorf("error creating the agent minio user: %v", err)
 	}
 
-	return nil
+	return a.Update(ctx)
 }
 
 // AddRequest adds a new agent request
@@ -47,118 +55,199 @@ func AddRequest(ctx *context.Context, a *models.Agent) error {
 	return nil
 }
 
-// Install installs the agent binary, sets up the daemon and config and starts the service
-func Install(ctx *context.Context, a *models.Agent, sshPwd string, f []byte) error {
+// Delete revokes the agent client certificates, closes the agent connection and removes the agent from the DB
+func Delete(ctx *context.Context, a *models.Agent) error {
+	if err := ca.Revoke(ctx, a.Host); err != nil {
+		return err
+	}
+
+	// Close the agent connection, so the agent can't keep talking to the Core
+	scheduler.AgentConnections.Delete(a.Host)
+
+	coreSSH.Evict(a.Host)
+
+	return a.Delete(ctx)
+}
+
+// ErrSudoRequired gets returned when installing the agent for a different user than the one of the credentials without sudo
+var ErrSudoRequired = errors.New("installing the agent for a different user than the one logging in requires sudo")
+
+// Install installs the agent binary, sets up the daemon and config and starts the service. The credentials are used to
+// authorize the Core SSH key for the agent SSH user, and to install the service. If the credentials use sudo, the agent
+// SSH user is created if it doesn't exist, so the agent can run as a dedicated service user
+func Install(ctx *context.Context, a *models.Agent, creds coreSSH.Credentials, f []byte) error {
 	// Set default values
 	if a.SSHPort == 0 {
 		a.SSHPort = 22
 	}
 
-	coreCli := &client.Local{}
-	coreOS, err := os.DetectOS(coreCli)
-	if err != nil {
-		return err
+	if creds.User == "" {
+		creds.User = a.SSHUser
+	}
+
+	if creds.User != a.SSHUser && !creds.Sudo {
+		return ErrSudoRequired
 	}
 
-	keys, err := coreOS.CmdSSHGetHostKeys(coreCli, a.Host, a.SSHPort)
+	bastions, err := models.AgentBastionChain(ctx, a)
 	if err != nil {
 		return err
 	}
-	a.SSHHostKeys = strings.Join(keys, "|||")
 
-	if err := a.Update(ctx); err != nil {
-		return fmt.Errorf("error updating the agent in the DB: %v", err)
+	keys, err := coreSSH.HostKeys(ctx, bastions, a.Host, a.SSHPort)
+	if err != nil {
+		return err
 	}
 
-	// Connect to the host through user and password
-	s, err := ssh.NewSessionWithPassword(a.Host, a.SSHPort, a.SSHUser, sshPwd, keys)
-	if err != nil {
+	// The host keys are trusted on first use. The sessions opened before might be verifying the previous keys
+	if err := a.TrustHostKeys(ctx, keys); err != nil {
 		return err
 	}
+	coreSSH.Evict(a.Host)
 
-	agentCli := &client.SSH{Session: s}
+	// Only the secret hash is stored, so a new secret is generated to write it to the agent configuration
+	if err := a.NewSecret(); err != nil {
+		return fmt.Errorf("error generating the agent secret: %v", err)
+	}
 
-	a.OS, err = os.DetectOS(agentCli)
-	if err != nil {
-		return err
+	if err := a.Update(ctx); err != nil {
+		return fmt.Errorf("error updating the agent in the DB: %v", err)
 	}
 
-	a.Arch, err = os.DetectArch(agentCli)
+	// Connect to the host through the install credentials
+	credsCli, err := coreSSH.AcquireWithCredentials(ctx, a, creds)
 	if err != nil {
 		return err
 	}
 
-	u, err := user.Current()
-	if err != nil {
+	if err := copyID(ctx, credsCli, a, creds.Sudo); err != nil {
+		credsCli.Release()
 		return err
 	}
 
-	keysPath, err := coreOS.CmdSSHGetKeysPath(coreCli, u.Username)
+	credsCli.Release()
+
+	// Connect to the host through user and key
+	agentCli, err := coreSSH.Acquire(ctx, a)
 	if err != nil {
 		return err
 	}
+	defer agentCli.Release()
 
-	pubKey, err := afero.ReadFile(ctx.FS, filepath.Join(keysPath, "id_rsa.pub"))
-	if err != nil {
-		return fmt.Errorf("error reading the Core SSH public key: %v", err)
+	if err := a.OS.CmdPkgInstallBinary(agentCli, a.SSHUser, "drlm-agent", f); err != nil {
+		return fmt.Errorf("error installing DRLM Agent: %v", err)
 	}
 
-	if err := a.OS.CmdSSHCopyID(agentCli, a.SSHUser, pubKey); err != nil {
+	if err := writeConfig(ctx, agentCli, a); err != nil {
 		return err
 	}
 
-	s.Close()
+	// The agent SSH user might not have privileges to install the service, so it's installed with the credentials. The
+	// client of the agent SSH user is released before, since both count for the maximum number of sessions with the host
+	var svcCli client.Client = agentCli
+	if creds.Sudo {
+		agentCli.Release()
 
-	// Connect to the host through user and key
-	s, err = ssh.NewSessionWithKey(ctx.FS, a.Host, a.SSHPort, a.SSHUser, keysPath, keys)
-	if err != nil {
-		return fmt.Errorf("error opening the ssh session with the agent: %v", err)
+		credsCli, err := coreSSH.AcquireWithCredentials(ctx, a, creds)
+		if err != nil {
+			return err
+		}
+		defer credsCli.Release()
+
+		svcCli = credsCli
 	}
-	defer s.Close()
 
-	agentCli.Session = s
+	// When reinstalling an agent that is connected, its current connection doesn't prove that the new binary is running
+	prev, _ := scheduler.AgentConnections.Get(a.Host)
 
-	if err := a.OS.CmdPkgInstallBinary(agentCli, a.SSHUser, "drlm-agent", f); err != nil {
-		return fmt.Errorf("error installing DRLM Agent: %v", err)
+	if err := installService(svcCli, a.OS, a.SSHUser); err != nil {
+		return err
 	}
 
-	if err := a.OS.CmdPkgWriteConfig(agentCli, a.SSHUser, "agent.toml", []byte(fmt.Sprintf(`[core]
-secret = "%s"
-
-[minio]
-access_key = "%s"
-secret_key = "%s"`, a.Secret, "drlm-agent-"+strconv.Itoa(int(a.ID)), a.MinioKey))); err != nil {
-		return fmt.Errorf("error configuring the DRLM Agent: %v", err)
+	if err := waitForConnection(ctx, a.Host, prev, InstallTimeout); err != nil {
+		return fmt.Errorf("error installing DRLM Agent: %v", err)
 	}
 
 	return nil
 }
 
-// Sync updates the agent OS information, and all the plugins specific info such as OS, OS version, program versions...
-func Sync(ctx *context.Context, a *models.Agent) error {
-	u, err := user.Current()
+// copyID detects the agent OS and arch and adds the Core public key to the authorized keys of the agent SSH user. If
+// the client runs with sudo, the agent SSH user gets created if it doesn't exist
+func copyID(ctx *context.Context, c client.Client, a *models.Agent, sudo bool) error {
+	var err error
+
+	a.OS, err = os.DetectOS(c)
 	if err != nil {
-		return fmt.Errorf("error getting the current user: %v", err)
+		return err
 	}
 
-	coreCli := &client.Local{}
-	coreOS, err := os.DetectOS(coreCli)
+	a.Arch, err = os.DetectArch(c)
 	if err != nil {
 		return err
 	}
 
-	keysPath, err := coreOS.CmdSSHGetKeysPath(coreCli, u.Username)
+	if sudo {
+		if err := createUser(c, a); err != nil {
+			return err
+		}
+	}
+
+	pubKey, err := coreSSH.PublicKey(ctx)
 	if err != nil {
 		return err
 	}
 
-	keys := strings.Split(a.SSHHostKeys, "|||")
-	s, err := ssh.NewSessionWithKey(ctx.FS, a.Host, a.SSHPort, a.SSHUser, keysPath, keys)
+	return a.OS.CmdSSHCopyID(c, a.SSHUser, pubKey)
+}
+
+// createUser creates the agent SSH user, with its home directory, if it doesn't exist
+func createUser(c client.Client, a *models.Agent) error {
+	if !a.OS.IsUnix() {
+		return os.ErrUnsupportedOS
+	}
+
+	if _, err := a.OS.CmdUserUID(c, a.SSHUser); err == nil {
+		return nil
+	}
+
+	if _, err := c.Exec("useradd", "-m", "-c", `"DRLM Agent"`, a.SSHUser); err != nil {
+		return fmt.Errorf("error creating the agent user: %v", err)
+	}
+
+	return nil
+}
+
+// waitForConnection waits until the agent has established a connection with the Core other than prev (the connection of
+// the agent before installing it, if it was already connected)
+func waitForConnection(ctx *context.Context, host string, prev drlm.DRLM_AgentConnectionServer, timeout time.Duration) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case <-ticker.C:
+			if stream, ok := scheduler.AgentConnections.Get(host); ok && stream != prev {
+				return nil
+			}
+
+		case <-deadline:
+			return errors.New("the agent hasn't established the connection with the Core")
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Sync updates the agent OS information, and all the plugins specific info such as OS, OS version, program versions...
+// It also stores a new version of the agent hardware and storage inventory, if it can be detected
+func Sync(ctx *context.Context, a *models.Agent) error {
+	c, err := coreSSH.Acquire(ctx, a)
 	if err != nil {
-		return fmt.Errorf("error opening the ssh session with the agent: %v", err)
+		return err
 	}
-	defer s.Close()
-	c := &client.SSH{Session: s}
+	defer c.Release()
 
 	a.OS, err = os.DetectOS(c)
 	if err != nil {
@@ -180,5 +269,19 @@ func Sync(ctx *context.Context, a *models.Agent) error {
 		return err
 	}
 
+	// The inventory is best-effort, so the agents whose inventory can't be detected (e.g. non Linux agents) can still be synced
+	i, err := detectInventory(c, a.OS)
+	if err != nil {
+		log.Warnf("error detecting the agent '%s' inventory: %v", a.Host, err)
+		return nil
+	}
+	i.AgentHost = a.Host
+
+	if err := i.Add(ctx); err != nil {
+		log.Warnf("error storing the agent '%s' inventory: %v", a.Host, err)
+		return nil
+	}
+	a.Inventory = i
+
 	return nil
 }
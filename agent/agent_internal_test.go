@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TestAgentInternalSuite struct {
+	suite.Suite
+}
+
+func TestAgentInternal(t *testing.T) {
+	suite.Run(t, &TestAgentInternalSuite{})
+}
+
+func (s *TestAgentInternalSuite) TestWaitForConnection() {
+	s.Run("should return when the agent establishes the connection", func() {
+		ctx, cancel := context.WithCancel()
+		defer cancel()
+
+		scheduler.AgentConnections.Add("laptop", &tests.AgentConnectionServerMock{})
+		defer scheduler.AgentConnections.Delete("laptop")
+
+		s.NoError(waitForConnection(ctx, "laptop", nil, 5*time.Second))
+	})
+
+	s.Run("should wait until the reinstalled agent establishes a new connection", func() {
+		ctx, cancel := context.WithCancel()
+		defer cancel()
+
+		prev := &tests.AgentConnectionServerMock{}
+		scheduler.AgentConnections.Add("laptop", prev)
+		defer scheduler.AgentConnections.Delete("laptop")
+
+		go func() {
+			time.Sleep(1500 * time.Millisecond)
+			scheduler.AgentConnections.Add("laptop", &tests.AgentConnectionServerMock{})
+		}()
+
+		start := time.Now()
+		s.NoError(waitForConnection(ctx, "laptop", prev, 5*time.Second))
+		s.True(time.Since(start) >= 1500*time.Millisecond)
+	})
+
+	s.Run("should return an error if the reinstalled agent doesn't establish a new connection", func() {
+		ctx, cancel := context.WithCancel()
+		defer cancel()
+
+		prev := &tests.AgentConnectionServerMock{}
+		scheduler.AgentConnections.Add("laptop", prev)
+		defer scheduler.AgentConnections.Delete("laptop")
+
+		s.EqualError(waitForConnection(ctx, "laptop", prev, 1500*time.Millisecond), "the agent hasn't established the connection with the Core")
+	})
+
+	s.Run("should return an error if the agent doesn't connect before the timeout", func() {
+		ctx, cancel := context.WithCancel()
+		defer cancel()
+
+		s.EqualError(waitForConnection(ctx, "laptop", nil, 1500*time.Millisecond), "the agent hasn't established the connection with the Core")
+	})
+}
@@ -6,11 +6,13 @@ import (
 	"errors"
 	"net/http"
 	"regexp"
+	"strconv"
 	"testing"
 
 	"github.com/brainupdaters/drlm-core/agent"
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/models"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
 	"github.com/brainupdaters/drlm-core/utils/tests"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -46,12 +48,16 @@ func (s *TestAgentSuite) TestAdd() {
 		defer ts.Close()
 
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret","ssh_port","ssh_user","ssh_host_keys","version","arch","os","os_version","distro","distro_version") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) RETURNING "agents"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret_lookup","secret_hash","ssh_port","ssh_user","version","arch","os","os_version","distro","distro_version","quarantined") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17) RETURNING "agents"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "192.168.1.61", true, tests.DBAnySecret{}, sqlmock.AnyArg(), sqlmock.AnyArg(), 0, "", "", 0, 0, "", "", "", false, 1).WillReturnResult(sqlmock.NewResult(1, 1))
 		s.mock.ExpectCommit()
 
 		a := &models.Agent{Host: "192.168.1.61"}
 
 		s.NoError(agent.Add(s.ctx, a))
+		s.Equal("drlm-agent-1", "drlm-agent-"+strconv.Itoa(int(a.ID)))
 	})
 
 	s.Run("should return an error if there's an error creating the minio user", func() {
@@ -60,6 +66,14 @@ func (s *TestAgentSuite) TestAdd() {
 		}))
 		defer ts.Close()
 
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret_lookup","secret_hash","ssh_port","ssh_user","version","arch","os","os_version","distro","distro_version","quarantined") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17) RETURNING "agents"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $1 AND ((host = $2)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(1, "192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "deleted_at"=$1 WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $2`)).WithArgs(tests.DBAnyTime{}, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
 		a := &models.Agent{Host: "192.168.1.61"}
 
 		s.EqualError(agent.Add(s.ctx, a), "error creating the agent minio user: error creating the minio user: Failed to parse server response.")
@@ -72,7 +86,7 @@ func (s *TestAgentSuite) TestAdd() {
 		defer ts.Close()
 
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret","ssh_port","ssh_user","ssh_host_keys","version","arch","os","os_version","distro","distro_version") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) RETURNING "agents"."id"`)).WillReturnError(errors.New("testing error!"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret_lookup","secret_hash","ssh_port","ssh_user","version","arch","os","os_version","distro","distro_version","quarantined") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17) RETURNING "agents"."id"`)).WillReturnError(errors.New("testing error!"))
 		s.mock.ExpectCommit()
 
 		a := &models.Agent{Host: "192.168.1.61"}
@@ -84,7 +98,7 @@ func (s *TestAgentSuite) TestAdd() {
 func (s *TestAgentSuite) TestAddRequest() {
 	s.Run("should add the agent add request correctly", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret","ssh_port","ssh_user","ssh_host_keys","version","arch","os","os_version","distro","distro_version") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) RETURNING "agents"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret_lookup","secret_hash","ssh_port","ssh_user","version","arch","os","os_version","distro","distro_version","quarantined") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17) RETURNING "agents"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		s.mock.ExpectCommit()
 
 		a := &models.Agent{Host: "192.168.1.61"}
@@ -94,7 +108,7 @@ func (s *TestAgentSuite) TestAddRequest() {
 
 	s.Run("should return an error if there's an error adding the agent add request", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret","ssh_port","ssh_user","ssh_host_keys","version","arch","os","os_version","distro","distro_version") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) RETURNING "agents"."id"`)).WillReturnError(errors.New("testing error!"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret_lookup","secret_hash","ssh_port","ssh_user","version","arch","os","os_version","distro","distro_version","quarantined") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17) RETURNING "agents"."id"`)).WillReturnError(errors.New("testing error!"))
 		s.mock.ExpectCommit()
 
 		a := &models.Agent{Host: "192.168.1.61"}
@@ -102,3 +116,33 @@ func (s *TestAgentSuite) TestAddRequest() {
 		s.EqualError(agent.AddRequest(s.ctx, a), "error adding the agent to the DB: testing error!")
 	})
 }
+
+func (s *TestAgentSuite) TestInstall() {
+	s.Run("should return an error if the credentials are of another user and don't use sudo", func() {
+		a := &models.Agent{Host: "laptop", SSHUser: "drlm"}
+
+		err := agent.Install(s.ctx, a, coreSSH.Credentials{User: "admin", Password: "p4$$w0rd"}, []byte("agent"))
+		s.Equal(agent.ErrSudoRequired, err)
+	})
+}
+
+func (s *TestAgentSuite) TestDelete() {
+	s.Run("should revoke the certificates and delete the agent", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_certificates" SET "revoked" = $1, "updated_at" = $2 WHERE "agent_certificates"."deleted_at" IS NULL AND ((agent_host = $3 AND revoked = $4))`)).WithArgs(true, tests.DBAnyTime{}, "laptop", false).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "deleted_at"=$1 WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $2`)).WithArgs(tests.DBAnyTime{}, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.Delete(s.ctx, &models.Agent{Host: "laptop"}))
+	})
+
+	s.Run("should return an error if there's an error revoking the certificates", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_certificates" SET "revoked" = $1, "updated_at" = $2 WHERE "agent_certificates"."deleted_at" IS NULL AND ((agent_host = $3 AND revoked = $4))`)).WillReturnError(errors.New("testing error"))
+
+		s.EqualError(agent.Delete(s.ctx, &models.Agent{Host: "laptop"}), "error revoking the agent certificates: testing error")
+	})
+}
@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/ca"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
+
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// clientCertName is the name of the agent client certificate file in the agent host
+	clientCertName = "agent.crt"
+	// clientKeyName is the name of the agent client certificate private key file in the agent host
+	clientKeyName = "agent.key"
+)
+
+// deployCert issues a new client certificate for the agent and writes it to the agent configuration directory
+func deployCert(ctx *context.Context, c client.Client, a *models.Agent) error {
+	cert, key, err := ca.Issue(ctx, a.Host)
+	if err != nil {
+		return fmt.Errorf("error issuing the agent certificate: %v", err)
+	}
+
+	if err := a.OS.CmdPkgWriteConfig(c, a.SSHUser, clientCertName, cert); err != nil {
+		return fmt.Errorf("error writting the agent certificate: %v", err)
+	}
+
+	if err := a.OS.CmdPkgWriteConfig(c, a.SSHUser, clientKeyName, key); err != nil {
+		return fmt.Errorf("error writting the agent certificate private key: %v", err)
+	}
+
+	home, err := a.OS.CmdFSHome(c, a.SSHUser)
+	if err != nil {
+		return fmt.Errorf("error writting the agent certificate private key: %v", err)
+	}
+
+	if err := c.Chmod(cfgPath(home, clientKeyName), 0600); err != nil {
+		return fmt.Errorf("error changing the agent certificate private key permissions: %v", err)
+	}
+
+	return nil
+}
+
+// sendCert issues a new client certificate for the agent and sends it, with its private key, through the `client_cert-bin`
+// and `client_key-bin` headers of the agent connection. The headers are sent along with the first message of the stream,
+// so the certificate can't be sent once something has been sent through it. If it can't be sent, it gets revoked.
+//
+// Generating the agent private key in the Core is a stopgap: the key is only as confidential as the connection, so it's
+// never sent if the connection doesn't use TLS (the agents can't present their certificates without TLS either). Once the
+// protocol can carry a certificate signing request, the agents should generate their keys and only send the CSR
+// TODO: Sign a CSR of the agent and send the certificate in a message once the protocol supports it
+func sendCert(ctx *context.Context, stream drlm.DRLM_AgentConnectionServer, host string) error {
+	if !ctx.Cfg.GRPC.TLS {
+		return nil
+	}
+
+	cert, key, err := ca.Issue(ctx, host)
+	if err != nil {
+		return fmt.Errorf("error issuing the agent certificate: %v", err)
+	}
+
+	if err := stream.SetHeader(metadata.Pairs("client_cert-bin", string(cert), "client_key-bin", string(key))); err != nil {
+		if err := revokeIssuedCert(ctx, cert); err != nil {
+			log.Errorf("error revoking the agent '%s' unsent certificate: %v", host, err)
+		}
+
+		return fmt.Errorf("error sending the agent certificate: %v", err)
+	}
+
+	return nil
+}
+
+// revokeIssuedCert revokes a certificate that has just been issued
+func revokeIssuedCert(ctx *context.Context, cert []byte) error {
+	b, _ := pem.Decode(cert)
+	if b == nil {
+		return errors.New("error decoding the agent certificate")
+	}
+
+	x509Cert, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return fmt.Errorf("error parsing the agent certificate: %v", err)
+	}
+
+	c := &models.AgentCertificate{Serial: x509Cert.SerialNumber.String()}
+	if err := c.Load(ctx); err != nil {
+		return err
+	}
+
+	return c.Revoke(ctx)
+}
+
+// RenewConnCert sends a new client certificate through the agent connection if the agent has no certificate or it has less
+// than a third of its lifespan left. It has to be called before anything is sent through the connection. This way, the
+// agents that aren't managed through SSH get their certificates renewed when they reconnect
+func RenewConnCert(ctx *context.Context, stream drlm.DRLM_AgentConnectionServer, host string) error {
+	cert := &models.AgentCertificate{AgentHost: host}
+	if err := cert.LoadLatest(ctx); err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+	} else if cert.ExpiresAt.After(time.Now().Add(ctx.Cfg.Security.AgentCertLifespan / 3)) {
+		return nil
+	}
+
+	return sendCert(ctx, stream, host)
+}
+
+// RenewCerts renews periodically the agents client certificates before they expire
+func RenewCerts(ctx *context.Context) {
+	ticker := time.NewTicker(time.Hour)
+
+	for {
+		select {
+		case <-ticker.C:
+			renewExpiringCerts(ctx)
+
+		case <-ctx.Done():
+			ctx.WG.Done()
+			return
+		}
+	}
+}
+
+// renewExpiringCerts renews the certificates that have less than a third of their lifespan left
+func renewExpiringCerts(ctx *context.Context) {
+	certs, err := models.AgentCertificateListExpiring(ctx, time.Now().Add(ctx.Cfg.Security.AgentCertLifespan/3))
+	if err != nil {
+		log.Errorf("error renewing the agents certificates: %v", err)
+		return
+	}
+
+	for _, cert := range certs {
+		if err := renewCert(ctx, cert); err != nil {
+			log.Errorf("error renewing the agent '%s' certificate: %v", cert.AgentHost, err)
+		}
+	}
+}
+
+// renewCert deploys a new certificate to the agent through SSH, restarts the agent service and revokes the previous certificate
+func renewCert(ctx *context.Context, cert *models.AgentCertificate) error {
+	a := &models.Agent{Host: cert.AgentHost}
+	if err := a.Load(ctx); err != nil {
+		return err
+	}
+
+	if err := a.LoadHostKeys(ctx); err != nil {
+		return err
+	}
+
+	// The agents that aren't managed through SSH get the new certificate when they reconnect (see RenewConnCert). Their
+	// certificates get revoked once they expire, so they aren't renewed again
+	if len(a.TrustedHostKeys()) == 0 {
+		if time.Now().After(cert.ExpiresAt) {
+			return cert.Revoke(ctx)
+		}
+
+		return nil
+	}
+
+	c, err := coreSSH.Acquire(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	if err := deployCert(ctx, c, a); err != nil {
+		c.Release()
+		return err
+	}
+
+	// The client is released before restarting, since the service might be restarted with a different session
+	c.Release()
+
+	if err := restartAgentService(ctx, a); err != nil {
+		return err
+	}
+
+	return cert.Revoke(ctx)
+}
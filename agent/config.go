@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+	"github.com/spf13/afero"
+)
+
+const (
+	// configName is the name of the DRLM Agent configuration file
+	configName = "agent.toml"
+	// coreCertName is the name of the Core certificate file in the agent host
+	coreCertName = "core.crt"
+	// minioCertName is the name of the Minio certificate file in the agent host
+	minioCertName = "minio.crt"
+)
+
+// writeConfig generates the agent configuration from the Core configuration and writes it to the agent host, alongside the
+// certificates that the agent needs to connect to the Core and to Minio
+func writeConfig(ctx *context.Context, c client.Client, a *models.Agent) error {
+	home, err := a.OS.CmdFSHome(c, a.SSHUser)
+	if err != nil {
+		return fmt.Errorf("error configuring the DRLM Agent: %v", err)
+	}
+
+	var coreCert string
+	if ctx.Cfg.GRPC.TLS {
+		if err := writeCert(ctx, c, a, ctx.Cfg.GRPC.CertPath, coreCertName); err != nil {
+			return err
+		}
+
+		coreCert = cfgPath(home, coreCertName)
+	}
+
+	var minioCert string
+	if ctx.Cfg.Minio.SSL {
+		if err := writeCert(ctx, c, a, ctx.Cfg.Minio.CertPath, minioCertName); err != nil {
+			return err
+		}
+
+		minioCert = cfgPath(home, minioCertName)
+	}
+
+	if err := deployCert(ctx, c, a); err != nil {
+		return err
+	}
+
+	if err := a.OS.CmdPkgWriteConfig(c, a.SSHUser, configName, []byte(fmt.Sprintf(`# Generated by DRLM
+[core]
+host = %q
+port = %d
+tls = %t
+cert_path = %q
+client_cert_path = %q
+client_key_path = %q
+secret = %q
+
+[minio]
+host = %q
+port = %d
+ssl = %t
+cert_path = %q
+access_key = %q
+secret_key = %q
+`,
+		ctx.Cfg.GRPC.Host, ctx.Cfg.GRPC.Port, ctx.Cfg.GRPC.TLS, coreCert, cfgPath(home, clientCertName), cfgPath(home, clientKeyName), a.Secret,
+		ctx.Cfg.Minio.Host, ctx.Cfg.Minio.Port, ctx.Cfg.Minio.SSL, minioCert, minio.AccessKey(ctx, "drlm-agent-"+strconv.Itoa(int(a.ID))), a.MinioKey,
+	))); err != nil {
+		return fmt.Errorf("error configuring the DRLM Agent: %v", err)
+	}
+
+	return nil
+}
+
+// cfgPath returns the path of a file in the agent configuration directory
+func cfgPath(home, name string) string {
+	return filepath.Join(home, ".config", "drlm", name)
+}
+
+// writeCert copies a certificate of the Core to the agent configuration directory
+func writeCert(ctx *context.Context, c client.Client, a *models.Agent, path, name string) error {
+	b, err := afero.ReadFile(ctx.FS, path)
+	if err != nil {
+		return fmt.Errorf("error reading the certificate '%s': %v", path, err)
+	}
+
+	if err := a.OS.CmdPkgWriteConfig(c, a.SSHUser, name, b); err != nil {
+		return fmt.Errorf("error writting the certificate '%s' to the agent: %v", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
+)
+
+// AcceptHostKeys accepts the pending SSH host keys of the agent, which replace the trusted ones. The user is stored in the audit trail
+func AcceptHostKeys(ctx *context.Context, a *models.Agent, usr string) error {
+	if err := a.Load(ctx); err != nil {
+		return err
+	}
+
+	if err := a.AcceptHostKeys(ctx, usr); err != nil {
+		return err
+	}
+
+	// The open session (if any) has been verified with the previous keys
+	coreSSH.Evict(a.Host)
+
+	return nil
+}
+
+// RejectHostKeys rejects the pending SSH host keys of the agent. The user is stored in the audit trail
+func RejectHostKeys(ctx *context.Context, a *models.Agent, usr string) error {
+	if err := a.Load(ctx); err != nil {
+		return err
+	}
+
+	return a.RejectHostKeys(ctx, usr)
+}
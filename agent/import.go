@@ -0,0 +1,449 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v2"
+)
+
+// InventoryHost is a host of an inventory file that gets imported as an agent
+type InventoryHost struct {
+	Host           string
+	SSHPort        int
+	SSHUser        string // The user that runs the agent
+	SSHPassword    string
+	SSHKeyFile     string
+	SSHKey         []byte // The content of the SSH key file. If it's empty, the key file is read
+	LoginUser      string // The user that logs in to install the agent. If it's empty, it's the SSH user
+	Become         bool
+	BecomePassword string
+	Labels         models.LabelSelector
+}
+
+// ParseInventory parses an Ansible style inventory, either in INI or YAML format. The `ansible_host`, `ansible_port`,
+// `ansible_user`, `ansible_password` (or `ansible_ssh_pass`), `ansible_ssh_private_key_file`, `ansible_become`,
+// `ansible_become_user` and `ansible_become_password` (or `ansible_become_pass`) variables are used for the connection,
+// the rest of variables of the host and its groups are used as labels. If the host uses `become` with a different
+// user, the agent is installed for that user
+func ParseInventory(r io.Reader, yml bool) ([]*InventoryHost, error) {
+	hosts := map[string]map[string]string{}
+	var err error
+	if yml {
+		err = parseYAMLInventory(r, hosts)
+	} else {
+		err = parseINIInventory(r, hosts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inventory := []*InventoryHost{}
+	for _, name := range names {
+		h, err := newInventoryHost(name, hosts[name])
+		if err != nil {
+			return nil, err
+		}
+
+		inventory = append(inventory, h)
+	}
+
+	return inventory, nil
+}
+
+// Import adds and installs the agents of the inventory, using up to concurrency parallel installations. If it's a dry run, it
+// only checks the connectivity and the host keys of the hosts. It returns the result of each host
+func Import(ctx *context.Context, hosts []*InventoryHost, f []byte, concurrency int, dryRun bool) map[string]error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := map[string]error{}
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h *InventoryHost) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var err error
+			if dryRun {
+				err = checkHost(ctx, h)
+			} else {
+				err = importHost(ctx, h, f)
+			}
+
+			mux.Lock()
+			defer mux.Unlock()
+
+			results[h.Host] = err
+			if err != nil {
+				log.Errorf("error importing the agent '%s': %v", h.Host, err)
+			}
+		}(h)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// importHost adds the host as a new agent, with its labels, and installs it
+func importHost(ctx *context.Context, h *InventoryHost, f []byte) error {
+	a := &models.Agent{
+		Host:    h.Host,
+		SSHPort: h.SSHPort,
+		SSHUser: h.SSHUser,
+	}
+
+	if err := Add(ctx, a); err != nil {
+		return err
+	}
+
+	for k, v := range h.Labels {
+		l := &models.AgentLabel{AgentHost: a.Host, Name: k, Value: v}
+		if err := l.Add(ctx); err != nil {
+			return err
+		}
+	}
+
+	creds, err := h.credentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	return Install(ctx, a, creds, f)
+}
+
+// credentials returns the SSH credentials of the host. The SSH agent of the Core configuration is also used, if any
+func (h *InventoryHost) credentials(ctx *context.Context) (coreSSH.Credentials, error) {
+	creds := coreSSH.Credentials{
+		User:         h.LoginUser,
+		Password:     h.SSHPassword,
+		AgentSocket:  ctx.Cfg.SSH.AgentSocket,
+		Sudo:         h.Become,
+		SudoPassword: h.BecomePassword,
+	}
+
+	if len(h.SSHKey) != 0 {
+		creds.PrivateKey = h.SSHKey
+	} else if h.SSHKeyFile != "" {
+		b, err := afero.ReadFile(ctx.FS, h.SSHKeyFile)
+		if err != nil {
+			return coreSSH.Credentials{}, fmt.Errorf("error reading the SSH private key: %v", err)
+		}
+
+		creds.PrivateKey = b
+	}
+
+	return creds, nil
+}
+
+// checkHost checks that the host keys of the host can be retrieved and that it's possible to open an SSH session with the host
+func checkHost(ctx *context.Context, h *InventoryHost) error {
+	bastions, err := models.BastionChain(ctx, h.Labels)
+	if err != nil {
+		return err
+	}
+
+	keys, err := coreSSH.HostKeys(ctx, bastions, h.Host, h.SSHPort)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return errors.New("error getting the host keys: no keys found")
+	}
+
+	creds, err := h.credentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	if creds.User == "" {
+		creds.User = h.SSHUser
+	}
+
+	// If there are no credentials, the Core SSH key has to be already authorized
+	var s *coreSSH.Session
+	if creds.Password != "" || len(creds.PrivateKey) != 0 || creds.AgentSocket != "" {
+		s, err = coreSSH.NewSessionWithCredentials(ctx, bastions, h.Host, h.SSHPort, creds, keys)
+	} else {
+		s, err = coreSSH.NewSession(ctx, bastions, h.Host, h.SSHPort, h.SSHUser, keys)
+	}
+	if err != nil {
+		return fmt.Errorf("error opening the ssh session: %v", err)
+	}
+
+	return s.Close()
+}
+
+func newInventoryHost(name string, vars map[string]string) (*InventoryHost, error) {
+	h := &InventoryHost{
+		Host:    name,
+		SSHPort: 22,
+		SSHUser: "root",
+		Labels:  models.LabelSelector{},
+	}
+
+	var becomeUser string
+	for k, v := range vars {
+		switch k {
+		case "ansible_host":
+			h.Host = v
+
+		case "ansible_port":
+			port, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port for the host '%s': %v", name, err)
+			}
+
+			h.SSHPort = port
+
+		case "ansible_user":
+			h.SSHUser = v
+
+		case "ansible_password", "ansible_ssh_pass":
+			h.SSHPassword = v
+
+		case "ansible_ssh_private_key_file":
+			h.SSHKeyFile = v
+
+		case "ansible_become":
+			become, err := parseInventoryBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid become for the host '%s': %v", name, err)
+			}
+
+			h.Become = become
+
+		case "ansible_become_user":
+			becomeUser = v
+
+		case "ansible_become_password", "ansible_become_pass":
+			h.BecomePassword = v
+
+		default:
+			if !strings.HasPrefix(k, "ansible_") {
+				h.Labels[k] = v
+			}
+		}
+	}
+
+	if h.Become && becomeUser != "" && becomeUser != h.SSHUser {
+		h.LoginUser = h.SSHUser
+		h.SSHUser = becomeUser
+	}
+
+	return h, nil
+}
+
+// parseInventoryBool parses a boolean variable of the inventory, which can be written in any of the YAML forms
+func parseInventoryBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "true", "yes", "y", "on", "1":
+		return true, nil
+
+	case "false", "no", "n", "off", "0":
+		return false, nil
+
+	default:
+		return false, fmt.Errorf("invalid boolean '%s'", v)
+	}
+}
+
+// parseINIInventory parses an INI inventory. The variables of the groups (`[group:vars]`) are applied to all the hosts of
+// the group, but the host variables take precedence. The groups of groups (`[group:children]`) are ignored
+func parseINIInventory(r io.Reader, hosts map[string]map[string]string) error {
+	groupHosts := map[string][]string{}
+	groupVars := map[string]map[string]string{}
+	hostVars := map[string]map[string]string{}
+
+	group := "ungrouped"
+	isVars := false
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("error parsing the inventory: invalid group at line %d", n)
+			}
+
+			group = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+			isVars = false
+
+			if strings.HasSuffix(group, ":vars") {
+				group = strings.TrimSuffix(group, ":vars")
+				isVars = true
+			}
+
+			if strings.HasSuffix(group, ":children") {
+				group = ""
+			}
+
+			continue
+		}
+
+		if group == "" {
+			continue
+		}
+
+		if isVars {
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("error parsing the inventory: invalid variable at line %d", n)
+			}
+
+			if groupVars[group] == nil {
+				groupVars[group] = map[string]string{}
+			}
+			groupVars[group][strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+
+			continue
+		}
+
+		fields := strings.Fields(line)
+		name := fields[0]
+
+		if hostVars[name] == nil {
+			hostVars[name] = map[string]string{}
+		}
+		for _, f := range fields[1:] {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("error parsing the inventory: invalid host variable at line %d", n)
+			}
+
+			hostVars[name][kv[0]] = strings.Trim(kv[1], `"'`)
+		}
+
+		groupHosts[group] = append(groupHosts[group], name)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading the inventory: %v", err)
+	}
+
+	groups := []string{}
+	for g := range groupHosts {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	for _, g := range groups {
+		for _, name := range groupHosts[g] {
+			if hosts[name] == nil {
+				hosts[name] = map[string]string{}
+
+				// The `all` group variables are applied to all the hosts
+				for k, v := range groupVars["all"] {
+					hosts[name][k] = v
+				}
+			}
+
+			for k, v := range groupVars[g] {
+				hosts[name][k] = v
+			}
+		}
+	}
+
+	for name, vars := range hostVars {
+		for k, v := range vars {
+			hosts[name][k] = v
+		}
+	}
+
+	return nil
+}
+
+type yamlInventoryGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*yamlInventoryGroup    `yaml:"children"`
+}
+
+// parseYAMLInventory parses a YAML inventory. The variables of the groups are applied to all the hosts of the group
+// and its children, but the variables of the children and the hosts take precedence
+func parseYAMLInventory(r io.Reader, hosts map[string]map[string]string) error {
+	groups := map[string]*yamlInventoryGroup{}
+	if err := yaml.NewDecoder(r).Decode(&groups); err != nil {
+		return fmt.Errorf("error parsing the inventory: %v", err)
+	}
+
+	for _, name := range sortedGroups(groups) {
+		addYAMLInventoryGroup(groups[name], map[string]string{}, hosts)
+	}
+
+	return nil
+}
+
+func addYAMLInventoryGroup(g *yamlInventoryGroup, parentVars map[string]string, hosts map[string]map[string]string) {
+	if g == nil {
+		return
+	}
+
+	vars := map[string]string{}
+	for k, v := range parentVars {
+		vars[k] = v
+	}
+	for k, v := range g.Vars {
+		vars[k] = fmt.Sprint(v)
+	}
+
+	for name, hVars := range g.Hosts {
+		if hosts[name] == nil {
+			hosts[name] = map[string]string{}
+		}
+
+		for k, v := range vars {
+			hosts[name][k] = v
+		}
+		for k, v := range hVars {
+			hosts[name][k] = fmt.Sprint(v)
+		}
+	}
+
+	for _, name := range sortedGroups(g.Children) {
+		addYAMLInventoryGroup(g.Children[name], vars, hosts)
+	}
+}
+
+// sortedGroups returns the names of the groups sorted, so the variables are always applied in the same order
+func sortedGroups(groups map[string]*yamlInventoryGroup) []string {
+	names := []string{}
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TestImportSuite struct {
+	suite.Suite
+}
+
+func TestImport(t *testing.T) {
+	suite.Run(t, &TestImportSuite{})
+}
+
+func (s *TestImportSuite) TestParseInventory() {
+	s.Run("should parse an INI inventory", func() {
+		inv := `
+# Production hosts
+standalone.example.com
+
+[web]
+web1 ansible_host=192.168.1.61 ansible_port=2222 role=frontend
+web2 ansible_user=drlm ansible_ssh_pass='p4$$w0rd'
+
+[web:vars]
+env=prod
+role=web
+
+[all:vars]
+ansible_python_interpreter=/usr/bin/python3
+dc=bcn
+
+[prod:children]
+web
+`
+
+		hosts, err := agent.ParseInventory(strings.NewReader(inv), false)
+		s.NoError(err)
+		s.Equal([]*agent.InventoryHost{
+			{
+				Host:    "standalone.example.com",
+				SSHPort: 22,
+				SSHUser: "root",
+				Labels:  models.LabelSelector{"dc": "bcn"},
+			},
+			{
+				Host:    "192.168.1.61",
+				SSHPort: 2222,
+				SSHUser: "root",
+				Labels:  models.LabelSelector{"dc": "bcn", "env": "prod", "role": "frontend"},
+			},
+			{
+				Host:        "web2",
+				SSHPort:     22,
+				SSHUser:     "drlm",
+				SSHPassword: "p4$$w0rd",
+				Labels:      models.LabelSelector{"dc": "bcn", "env": "prod", "role": "web"},
+			},
+		}, hosts)
+	})
+
+	s.Run("should parse a YAML inventory", func() {
+		inv := `
+all:
+  vars:
+    dc: bcn
+  hosts:
+    standalone.example.com:
+  children:
+    web:
+      vars:
+        env: prod
+        ansible_user: drlm
+      hosts:
+        web1:
+          ansible_host: 192.168.1.61
+          ansible_port: 2222
+          env: staging
+        web2:
+`
+
+		hosts, err := agent.ParseInventory(strings.NewReader(inv), true)
+		s.NoError(err)
+		s.Equal([]*agent.InventoryHost{
+			{
+				Host:    "standalone.example.com",
+				SSHPort: 22,
+				SSHUser: "root",
+				Labels:  models.LabelSelector{"dc": "bcn"},
+			},
+			{
+				Host:    "192.168.1.61",
+				SSHPort: 2222,
+				SSHUser: "drlm",
+				Labels:  models.LabelSelector{"dc": "bcn", "env": "staging"},
+			},
+			{
+				Host:    "web2",
+				SSHPort: 22,
+				SSHUser: "drlm",
+				Labels:  models.LabelSelector{"dc": "bcn", "env": "prod"},
+			},
+		}, hosts)
+	})
+
+	s.Run("should install the agent for the become user", func() {
+		hosts, err := agent.ParseInventory(strings.NewReader(`[db]
+db1 ansible_user=admin ansible_ssh_private_key_file=/keys/admin ansible_become=yes ansible_become_user=drlm ansible_become_pass=s3cr3t
+`), false)
+		s.NoError(err)
+		s.Equal([]*agent.InventoryHost{
+			{
+				Host:           "db1",
+				SSHPort:        22,
+				SSHUser:        "drlm",
+				SSHKeyFile:     "/keys/admin",
+				LoginUser:      "admin",
+				Become:         true,
+				BecomePassword: "s3cr3t",
+				Labels:         models.LabelSelector{},
+			},
+		}, hosts)
+	})
+
+	s.Run("should return an error if become is invalid", func() {
+		hosts, err := agent.ParseInventory(strings.NewReader("web1 ansible_become=maybe"), false)
+		s.EqualError(err, "invalid become for the host 'web1': invalid boolean 'maybe'")
+		s.Nil(hosts)
+	})
+
+	s.Run("should return an error if a port is invalid", func() {
+		hosts, err := agent.ParseInventory(strings.NewReader("web1 ansible_port=ssh"), false)
+		s.EqualError(err, `invalid port for the host 'web1': strconv.Atoi: parsing "ssh": invalid syntax`)
+		s.Nil(hosts)
+	})
+
+	s.Run("should return an error if a host variable is invalid", func() {
+		hosts, err := agent.ParseInventory(strings.NewReader("[web]\nweb1 env"), false)
+		s.EqualError(err, "error parsing the inventory: invalid host variable at line 2")
+		s.Nil(hosts)
+	})
+
+	s.Run("should return an error if the YAML is invalid", func() {
+		hosts, err := agent.ParseInventory(strings.NewReader("all: ["), true)
+		s.Error(err)
+		s.Nil(hosts)
+	})
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+)
+
+// detectInventory collects the hardware and storage inventory of the Agent OS
+// TODO: Use the drlm-common os.OS helpers once it has them for the hardware, the block devices and the network interfaces
+func detectInventory(c client.Client, o os.OS) (*models.AgentInventory, error) {
+	switch o {
+	case os.Linux:
+		i := &models.AgentInventory{}
+
+		out, err := c.Exec("hostname")
+		if err != nil {
+			return nil, fmt.Errorf("error detecting the hostname: %v", err)
+		}
+		i.Hostname = strings.TrimSpace(string(out))
+
+		out, err = c.ReadFile("/proc/meminfo")
+		if err != nil {
+			return nil, fmt.Errorf("error detecting the memory: %v", err)
+		}
+		if i.MemTotal, err = parseMemInfo(out); err != nil {
+			return nil, fmt.Errorf("error detecting the memory: %v", err)
+		}
+
+		out, err = c.Exec("lsblk", "-P", "-b", "-o", "KNAME,PKNAME,TYPE,SIZE,MODEL,FSTYPE,UUID,MOUNTPOINT")
+		if err != nil {
+			return nil, fmt.Errorf("error detecting the block devices: %v", err)
+		}
+		i.Devices = parseLsblk(out)
+
+		out, err = c.Exec("ip", "-o", "link", "show")
+		if err != nil {
+			return nil, fmt.Errorf("error detecting the network interfaces: %v", err)
+		}
+		i.NICs = parseIPLink(out)
+
+		out, err = c.Exec("ip", "-o", "addr", "show")
+		if err != nil {
+			return nil, fmt.Errorf("error detecting the network addresses: %v", err)
+		}
+		parseIPAddr(out, i.NICs)
+
+		return i, nil
+
+	default:
+		return nil, os.ErrUnsupportedOS
+	}
+}
+
+// parseMemInfo returns the total memory (in bytes) from the /proc/meminfo file
+func parseMemInfo(b []byte) (uint64, error) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) >= 2 && f[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(f[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("error parsing the total memory: %v", err)
+			}
+
+			return kb * 1024, nil
+		}
+	}
+
+	return 0, fmt.Errorf("total memory not found")
+}
+
+// parseLsblk parses the `lsblk -P` output. Devices with multiple parents (such as RAID arrays) are listed once per parent
+// by lsblk, so they get merged in a single device
+func parseLsblk(b []byte) []*models.AgentInventoryDevice {
+	devices := []*models.AgentInventoryDevice{}
+	byName := map[string]*models.AgentInventoryDevice{}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		kv := parseKeyValuePairs(s.Text())
+		if kv["KNAME"] == "" {
+			continue
+		}
+
+		d, ok := byName[kv["KNAME"]]
+		if !ok {
+			size, _ := strconv.ParseUint(kv["SIZE"], 10, 64)
+			d = &models.AgentInventoryDevice{
+				Name:       kv["KNAME"],
+				Type:       kv["TYPE"],
+				Size:       size,
+				DevModel:   kv["MODEL"],
+				FSType:     kv["FSTYPE"],
+				FSUUID:     kv["UUID"],
+				Mountpoint: kv["MOUNTPOINT"],
+			}
+
+			byName[d.Name] = d
+			devices = append(devices, d)
+		}
+
+		if p := kv["PKNAME"]; p != "" {
+			if d.Parents == "" {
+				d.Parents = p
+			} else if !strings.Contains(","+d.Parents+",", ","+p+",") {
+				d.Parents += "," + p
+			}
+		}
+	}
+
+	return devices
+}
+
+// parseKeyValuePairs parses a line with the `KEY="value" KEY2="value 2"` format
+func parseKeyValuePairs(l string) map[string]string {
+	kv := map[string]string{}
+
+	for {
+		l = strings.TrimSpace(l)
+		i := strings.Index(l, `="`)
+		if i == -1 {
+			return kv
+		}
+		k := l[:i]
+		l = l[i+2:]
+
+		j := strings.Index(l, `"`)
+		if j == -1 {
+			return kv
+		}
+		kv[k] = strings.TrimSpace(l[:j])
+		l = l[j+1:]
+	}
+}
+
+// parseIPLink parses the `ip -o link show` output
+func parseIPLink(b []byte) []*models.AgentInventoryNIC {
+	nics := []*models.AgentInventoryNIC{}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 2 {
+			continue
+		}
+
+		// VLAN interfaces are shown as `eth0.10@eth0`
+		n := &models.AgentInventoryNIC{Name: strings.Split(strings.TrimSuffix(f[1], ":"), "@")[0]}
+		if n.Name == "lo" {
+			continue
+		}
+
+		for i := 2; i < len(f)-1; i++ {
+			switch f[i] {
+			case "mtu":
+				n.MTU, _ = strconv.Atoi(f[i+1])
+			case "state":
+				n.State = f[i+1]
+			case "link/ether":
+				n.MAC = f[i+1]
+			}
+		}
+
+		nics = append(nics, n)
+	}
+
+	return nics
+}
+
+// parseIPAddr parses the `ip -o addr show` output and adds the addresses to their network interface
+func parseIPAddr(b []byte, nics []*models.AgentInventoryNIC) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 4 || (f[2] != "inet" && f[2] != "inet6") {
+			continue
+		}
+
+		for _, n := range nics {
+			if n.Name == f[1] {
+				if n.Addresses == "" {
+					n.Addresses = f[3]
+				} else {
+					n.Addresses += "," + f[3]
+				}
+			}
+		}
+	}
+}
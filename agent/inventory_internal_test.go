@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestInventoryInternalSuite struct {
+	suite.Suite
+}
+
+func TestInventoryInternal(t *testing.T) {
+	suite.Run(t, &TestInventoryInternalSuite{})
+}
+
+const (
+	testLsblk = `KNAME="sda" PKNAME="" TYPE="disk" SIZE="500107862016" MODEL="Samsung SSD 860 " FSTYPE="" UUID="" MOUNTPOINT=""
+KNAME="sda1" PKNAME="sda" TYPE="part" SIZE="536870912" MODEL="" FSTYPE="vfat" UUID="1234-ABCD" MOUNTPOINT="/boot/efi"
+KNAME="sda2" PKNAME="sda" TYPE="part" SIZE="499570991104" MODEL="" FSTYPE="linux_raid_member" UUID="a-b-c" MOUNTPOINT=""
+KNAME="sdb" PKNAME="" TYPE="disk" SIZE="500107862016" MODEL="Samsung SSD 860 " FSTYPE="" UUID="" MOUNTPOINT=""
+KNAME="sdb1" PKNAME="sdb" TYPE="part" SIZE="499570991104" MODEL="" FSTYPE="linux_raid_member" UUID="a-b-c" MOUNTPOINT=""
+KNAME="md0" PKNAME="sda2" TYPE="raid1" SIZE="499436773376" MODEL="" FSTYPE="LVM2_member" UUID="d-e-f" MOUNTPOINT=""
+KNAME="md0" PKNAME="sdb1" TYPE="raid1" SIZE="499436773376" MODEL="" FSTYPE="LVM2_member" UUID="d-e-f" MOUNTPOINT=""
+KNAME="dm-0" PKNAME="md0" TYPE="lvm" SIZE="53687091200" MODEL="" FSTYPE="ext4" UUID="g-h-i" MOUNTPOINT="/"
+`
+	testIPLink = `1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000\    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+2: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc pfifo_fast state UP mode DEFAULT group default qlen 1000\    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
+3: eth0.10@eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP mode DEFAULT group default qlen 1000\    link/ether 52:54:00:12:34:56 brd ff:ff:ff:ff:ff:ff
+`
+	testIPAddr = `1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+2: eth0    inet 192.168.1.61/24 brd 192.168.1.255 scope global eth0\       valid_lft forever preferred_lft forever
+2: eth0    inet6 fe80::5054:ff:fe12:3456/64 scope link \       valid_lft forever preferred_lft forever
+`
+)
+
+func (s *TestInventoryInternalSuite) TestDetectInventory() {
+	s.Run("should detect the inventory correctly", func() {
+		c := &tests.OSClientMock{
+			Out: map[string]string{
+				"hostname": "laptop\n",
+				"lsblk -P -b -o KNAME,PKNAME,TYPE,SIZE,MODEL,FSTYPE,UUID,MOUNTPOINT": testLsblk,
+				"ip -o link show": testIPLink,
+				"ip -o addr show": testIPAddr,
+			},
+			Files: map[string]string{
+				"/proc/meminfo": "MemTotal:        8048220 kB\nMemFree:         1213920 kB\n",
+			},
+		}
+
+		i, err := detectInventory(c, os.Linux)
+		s.Require().NoError(err)
+
+		s.Equal("laptop", i.Hostname)
+		s.Equal(uint64(8048220*1024), i.MemTotal)
+		s.Len(i.Devices, 7)
+		s.Len(i.NICs, 2)
+	})
+
+	s.Run("should return an error if there's an error detecting the memory", func() {
+		c := &tests.OSClientMock{
+			Out: map[string]string{"hostname": "laptop\n"},
+		}
+
+		_, err := detectInventory(c, os.Linux)
+		s.EqualError(err, "error detecting the memory: file not found")
+	})
+
+	s.Run("should return an error if the OS is not supported", func() {
+		_, err := detectInventory(&tests.OSClientMock{}, os.Windows)
+		s.Equal(os.ErrUnsupportedOS, err)
+	})
+}
+
+func (s *TestInventoryInternalSuite) TestParseMemInfo() {
+	s.Run("should return the total memory in bytes", func() {
+		mem, err := parseMemInfo([]byte("MemTotal:        1024 kB\n"))
+		s.NoError(err)
+		s.Equal(uint64(1024*1024), mem)
+	})
+
+	s.Run("should return an error if the total memory isn't found", func() {
+		_, err := parseMemInfo([]byte("MemFree:        1024 kB\n"))
+		s.EqualError(err, "total memory not found")
+	})
+}
+
+func (s *TestInventoryInternalSuite) TestParseLsblk() {
+	devices := parseLsblk([]byte(testLsblk))
+
+	s.Require().Len(devices, 7)
+	s.Equal(&models.AgentInventoryDevice{
+		Name:       "sda1",
+		Type:       "part",
+		Size:       536870912,
+		Parents:    "sda",
+		FSType:     "vfat",
+		FSUUID:     "1234-ABCD",
+		Mountpoint: "/boot/efi",
+	}, devices[1])
+	s.Equal("Samsung SSD 860", devices[0].DevModel)
+	s.Equal("raid1", devices[5].Type)
+	s.Equal("sda2,sdb1", devices[5].Parents)
+	s.Equal("lvm", devices[6].Type)
+	s.Equal("/", devices[6].Mountpoint)
+}
+
+func (s *TestInventoryInternalSuite) TestParseIP() {
+	nics := parseIPLink([]byte(testIPLink))
+	parseIPAddr([]byte(testIPAddr), nics)
+
+	s.Equal([]*models.AgentInventoryNIC{
+		&models.AgentInventoryNIC{
+			Name:      "eth0",
+			MAC:       "52:54:00:12:34:56",
+			MTU:       1500,
+			State:     "UP",
+			Addresses: "192.168.1.61/24,fe80::5054:ff:fe12:3456/64",
+		},
+		&models.AgentInventoryNIC{
+			Name:  "eth0.10",
+			MAC:   "52:54:00:12:34:56",
+			MTU:   1500,
+			State: "UP",
+		},
+	}, nics)
+}
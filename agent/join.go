@@ -0,0 +1,277 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrJoinRejected gets returned when the join request of an agent gets rejected automatically
+var ErrJoinRejected = errors.New("agent join request rejected")
+
+type joinDecision int
+
+const (
+	joinPending joinDecision = iota
+	joinAccept
+	joinReject
+)
+
+// NewEnrollmentToken creates a new enrollment token that can be used by the agents to join the Core without being accepted manually.
+// The labels get added to all the agents that join using the token
+func NewEnrollmentToken(ctx *context.Context, lifespan time.Duration, uses int, labels models.LabelSelector) (*models.EnrollmentToken, error) {
+	if lifespan <= 0 {
+		return nil, errors.New("the enrollment token lifespan has to be positive")
+	}
+
+	if uses < 1 {
+		return nil, errors.New("the enrollment token has to be usable at least once")
+	}
+
+	t := &models.EnrollmentToken{
+		ExpiresAt: time.Now().Add(lifespan),
+		MaxUses:   uses,
+		Labels:    labels.String(),
+	}
+
+	if err := t.Add(ctx); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Join handles the join request of an agent. The request gets rejected if the address the request comes from (addr) is denied or the
+// agent is quarantined, and accepted if the address is allowed or the agent has a valid enrollment token. Otherwise, the request stays
+// pending until it's accepted manually
+func Join(ctx *context.Context, stream drlm.DRLM_AgentConnectionServer, a *models.Agent, addr, enrollTkn string) error {
+	decision, err := joinPolicy(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	if decision == joinReject {
+		log.Warnf("agent '%s' join request has been rejected", a.Host)
+
+		if err := rejectJoin(stream); err != nil {
+			return err
+		}
+
+		return ErrJoinRejected
+	}
+
+	// The quarantined agents can't join again until they're enabled, even if they have a valid enrollment token
+	existing := &models.Agent{Host: a.Host}
+	if err := existing.Load(ctx); err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+	} else if existing.Quarantined {
+		log.Warnf("agent '%s' join request has been rejected, since the agent is quarantined", a.Host)
+
+		if err := rejectJoin(stream); err != nil {
+			return err
+		}
+
+		return models.ErrAgentQuarantined
+	}
+
+	var t *models.EnrollmentToken
+	if enrollTkn != "" {
+		t, err = useEnrollmentToken(ctx, enrollTkn)
+		if err != nil {
+			return err
+		}
+
+		if t == nil {
+			log.Warnf("agent '%s' has used an invalid enrollment token", a.Host)
+		}
+	}
+
+	if t == nil && decision != joinAccept {
+		scheduler.PendingAgentConnections.Add(a.Host, stream)
+		return AddRequest(ctx, a)
+	}
+
+	if err := Accept(ctx, stream, a); err != nil {
+		return err
+	}
+
+	if t != nil {
+		labels, err := models.ParseLabelSelector(t.Labels)
+		if err != nil {
+			return fmt.Errorf("error parsing the enrollment token labels: %v", err)
+		}
+
+		for k, v := range labels {
+			l := &models.AgentLabel{AgentHost: a.Host, Name: k, Value: v}
+			if err := l.Set(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Accept accepts an agent: it creates the agent Minio user (if the agent doesn't have one yet), generates a new secret and sends
+// the credentials and a client certificate to the agent. The quarantined agents can't be accepted
+func Accept(ctx *context.Context, stream drlm.DRLM_AgentConnectionServer, a *models.Agent) error {
+	existing := &models.Agent{Host: a.Host}
+	if err := existing.Load(ctx); err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		if err := a.Add(ctx); err != nil {
+			return err
+		}
+
+	} else {
+		if existing.Quarantined {
+			return models.ErrAgentQuarantined
+		}
+
+		existing.Arch = a.Arch
+		existing.OS = a.OS
+		*a = *existing
+	}
+
+	if a.MinioKey == "" {
+		var err error
+		if a.MinioKey, err = minio.CreateUser(ctx, "drlm-agent-"+strconv.Itoa(int(a.ID))); err != nil {
+			return fmt.Errorf("error creating the agent minio user: %v", err)
+		}
+	}
+
+	if err := a.NewSecret(); err != nil {
+		return fmt.Errorf("error generating the agent secret: %v", err)
+	}
+
+	a.Accepted = true
+	if err := a.Update(ctx); err != nil {
+		return err
+	}
+
+	// The client certificate gets sent along with the join response
+	if err := sendCert(ctx, stream, a.Host); err != nil {
+		log.Warnf("error sending the agent '%s' certificate, it's going to be sent when the agent reconnects: %v", a.Host, err)
+	}
+
+	if err := stream.Send(&drlm.AgentConnectionFromCore{
+		MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOIN_RESPONSE,
+		JoinResponse: &drlm.AgentConnectionFromCore_JoinResponse{
+			Status:         drlm.AgentConnectionFromCore_JoinResponse_STATUS_ACCEPT,
+			CoreSecret:     a.Secret,
+			MinioAccessKey: minio.AccessKey(ctx, "drlm-agent-"+strconv.Itoa(int(a.ID))),
+			MinioSecretKey: a.MinioKey,
+		},
+	}); err != nil {
+		return fmt.Errorf("error sending the join response to the agent: %v", err)
+	}
+
+	if _, ok := scheduler.PendingAgentConnections.Get(a.Host); ok {
+		scheduler.PendingAgentConnections.Delete(a.Host)
+	}
+
+	log.Infof("agent '%s' has been accepted", a.Host)
+
+	return nil
+}
+
+// rejectJoin sends the join rejection to the agent
+func rejectJoin(stream drlm.DRLM_AgentConnectionServer) error {
+	if err := stream.Send(&drlm.AgentConnectionFromCore{
+		MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOIN_RESPONSE,
+		JoinResponse: &drlm.AgentConnectionFromCore_JoinResponse{
+			Status: drlm.AgentConnectionFromCore_JoinResponse_STATUS_REJECT,
+		},
+	}); err != nil {
+		return fmt.Errorf("error sending the join response to the agent: %v", err)
+	}
+
+	return nil
+}
+
+// useEnrollmentToken loads and uses an enrollment token. If the token is invalid, expired or has already been used, it returns nil
+func useEnrollmentToken(ctx *context.Context, tkn string) (*models.EnrollmentToken, error) {
+	t := &models.EnrollmentToken{}
+	if err := t.LoadByToken(ctx, tkn); err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if !t.Valid() {
+		return nil, nil
+	}
+
+	if err := t.Use(ctx); err != nil {
+		if err == models.ErrEnrollmentTokenUsed {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// joinPolicy checks the agent address against the configured join CIDRs. The deny list takes precedence over the allow list
+func joinPolicy(ctx *context.Context, addr string) (joinDecision, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return joinPending, nil
+	}
+
+	deny, err := inCIDRs(ip, ctx.Cfg.Security.AgentJoinDeny)
+	if err != nil {
+		return joinPending, err
+	}
+
+	if deny {
+		return joinReject, nil
+	}
+
+	allow, err := inCIDRs(ip, ctx.Cfg.Security.AgentJoinAllow)
+	if err != nil {
+		return joinPending, err
+	}
+
+	if allow {
+		return joinAccept, nil
+	}
+
+	return joinPending, nil
+}
+
+func inCIDRs(ip net.IP, cidrs []string) (bool, error) {
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return false, fmt.Errorf("invalid agent join CIDR '%s': %v", c, err)
+		}
+
+		if n.Contains(ip) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TestJoinInternalSuite struct {
+	suite.Suite
+}
+
+func TestJoinInternal(t *testing.T) {
+	suite.Run(t, &TestJoinInternalSuite{})
+}
+
+func (s *TestJoinInternalSuite) TestJoinPolicy() {
+	ctx := tests.GenerateCtx()
+	tests.GenerateCfg(s.T(), ctx)
+	ctx.Cfg.Security.AgentJoinAllow = []string{"192.168.1.0/24", "10.0.0.0/8"}
+	ctx.Cfg.Security.AgentJoinDeny = []string{"192.168.1.128/25"}
+
+	s.Run("should accept the agents with an allowed address", func() {
+		decision, err := joinPolicy(ctx, "10.1.2.3")
+		s.NoError(err)
+		s.Equal(joinAccept, decision)
+	})
+
+	s.Run("should reject the agents with a denied address, even if it's also allowed", func() {
+		decision, err := joinPolicy(ctx, "192.168.1.200")
+		s.NoError(err)
+		s.Equal(joinReject, decision)
+	})
+
+	s.Run("should leave pending the agents that aren't allowed nor denied", func() {
+		decision, err := joinPolicy(ctx, "172.16.0.1")
+		s.NoError(err)
+		s.Equal(joinPending, decision)
+	})
+
+	s.Run("should leave pending the agents that aren't identified by an IP", func() {
+		decision, err := joinPolicy(ctx, "laptop")
+		s.NoError(err)
+		s.Equal(joinPending, decision)
+	})
+
+	s.Run("should return an error if there's an invalid CIDR", func() {
+		ctx.Cfg.Security.AgentJoinDeny = []string{"192.168.1.128"}
+
+		decision, err := joinPolicy(ctx, "10.1.2.3")
+		s.EqualError(err, "invalid agent join CIDR '192.168.1.128': invalid CIDR address: 192.168.1.128")
+		s.Equal(joinPending, decision)
+	})
+}
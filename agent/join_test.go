@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent_test
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/utils/secret"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestJoinSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestJoinSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+	tests.GenerateCfg(s.T(), s.ctx)
+}
+
+func (s *TestJoinSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestJoin(t *testing.T) {
+	suite.Run(t, &TestJoinSuite{})
+}
+
+func (s *TestJoinSuite) TestNewEnrollmentToken() {
+	s.Run("should create the enrollment token", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "enrollment_tokens" ("created_at","updated_at","deleted_at","lookup","hash","expires_at","max_uses","uses","labels") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "enrollment_tokens"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), tests.DBAnyTime{}, 3, 0, "env=prod,team=ops").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		t, err := agent.NewEnrollmentToken(s.ctx, time.Hour, 3, models.LabelSelector{"team": "ops", "env": "prod"})
+		s.NoError(err)
+		s.NotEmpty(t.Token)
+	})
+
+	s.Run("should return an error if the token can't be used", func() {
+		t, err := agent.NewEnrollmentToken(s.ctx, time.Hour, 0, models.LabelSelector{})
+		s.EqualError(err, "the enrollment token has to be usable at least once")
+		s.Nil(t)
+	})
+
+	s.Run("should return an error if the lifespan isn't positive", func() {
+		t, err := agent.NewEnrollmentToken(s.ctx, 0, 1, models.LabelSelector{})
+		s.EqualError(err, "the enrollment token lifespan has to be positive")
+		s.Nil(t)
+	})
+}
+
+func (s *TestJoinSuite) TestJoin() {
+	s.Run("should reject the agent if its address is denied", func() {
+		s.ctx.Cfg.Security.AgentJoinDeny = []string{"192.168.1.0/24"}
+		defer func() { s.ctx.Cfg.Security.AgentJoinDeny = []string{} }()
+
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", mock.MatchedBy(func(req *drlm.AgentConnectionFromCore) bool {
+			return req.MessageType == drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOIN_RESPONSE &&
+				req.JoinResponse.Status == drlm.AgentConnectionFromCore_JoinResponse_STATUS_REJECT
+		})).Return(nil)
+
+		s.Equal(agent.ErrJoinRejected, agent.Join(s.ctx, stream, &models.Agent{Host: "192.168.1.61"}, "192.168.1.61", ""))
+		stream.AssertExpectations(s.T())
+	})
+
+	s.Run("should reject the agent if its address is denied, even if it has been resolved to an allowed agent host", func() {
+		s.ctx.Cfg.Security.AgentJoinAllow = []string{"10.0.0.0/8"}
+		s.ctx.Cfg.Security.AgentJoinDeny = []string{"192.168.1.0/24"}
+		defer func() {
+			s.ctx.Cfg.Security.AgentJoinAllow = []string{}
+			s.ctx.Cfg.Security.AgentJoinDeny = []string{}
+		}()
+
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", mock.MatchedBy(func(req *drlm.AgentConnectionFromCore) bool {
+			return req.MessageType == drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOIN_RESPONSE &&
+				req.JoinResponse.Status == drlm.AgentConnectionFromCore_JoinResponse_STATUS_REJECT
+		})).Return(nil)
+
+		s.Equal(agent.ErrJoinRejected, agent.Join(s.ctx, stream, &models.Agent{Host: "10.0.0.1"}, "192.168.1.61", ""))
+		stream.AssertExpectations(s.T())
+	})
+
+	s.Run("should leave the join request pending if there's no policy nor enrollment token", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		stream := &tests.AgentConnectionServerMock{}
+		defer scheduler.PendingAgentConnections.Delete("192.168.1.61")
+
+		s.NoError(agent.Join(s.ctx, stream, &models.Agent{Host: "192.168.1.61"}, "192.168.1.61", ""))
+
+		_, ok := scheduler.PendingAgentConnections.Get("192.168.1.61")
+		s.True(ok)
+		stream.AssertNotCalled(s.T(), "Send", mock.Anything)
+	})
+
+	s.Run("should leave the join request pending if the enrollment token is expired", func() {
+		h, err := secret.Hash("token")
+		s.Require().NoError(err)
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "enrollment_tokens" WHERE "enrollment_tokens"."deleted_at" IS NULL AND ((lookup = $1)) ORDER BY "enrollment_tokens"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("token")).WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "expires_at", "max_uses"}).AddRow(1, h, time.Now().Add(-time.Hour), 1))
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		stream := &tests.AgentConnectionServerMock{}
+		defer scheduler.PendingAgentConnections.Delete("192.168.1.61")
+
+		s.NoError(agent.Join(s.ctx, stream, &models.Agent{Host: "192.168.1.61"}, "192.168.1.61", "token"))
+
+		_, ok := scheduler.PendingAgentConnections.Get("192.168.1.61")
+		s.True(ok)
+	})
+
+	s.Run("should accept the agent and add the labels if it has a valid enrollment token", func() {
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		h, err := secret.Hash("token")
+		s.Require().NoError(err)
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "enrollment_tokens" WHERE "enrollment_tokens"."deleted_at" IS NULL AND ((lookup = $1)) ORDER BY "enrollment_tokens"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("token")).WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "expires_at", "max_uses", "labels"}).AddRow(1, h, time.Now().Add(time.Hour), 1, "env=prod"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "enrollment_tokens" SET "uses" = uses + 1 WHERE "enrollment_tokens"."deleted_at" IS NULL AND "enrollment_tokens"."id" = $1 AND ((uses < max_uses))`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "192.168.1.61", true, tests.DBAnySecret{}, sqlmock.AnyArg(), sqlmock.AnyArg(), 0, "", "", 0, 0, "", "", "", false, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"  WHERE "agent_labels"."deleted_at" IS NULL AND (("agent_labels"."agent_host" = $1) AND ("agent_labels"."name" = $2)) ORDER BY "agent_labels"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61", "env").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_labels" ("created_at","updated_at","deleted_at","agent_host","name","value") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "agent_labels"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "192.168.1.61", "env", "prod").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", mock.MatchedBy(func(req *drlm.AgentConnectionFromCore) bool {
+			return req.MessageType == drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOIN_RESPONSE &&
+				req.JoinResponse.Status == drlm.AgentConnectionFromCore_JoinResponse_STATUS_ACCEPT &&
+				req.JoinResponse.CoreSecret != "" &&
+				req.JoinResponse.MinioAccessKey == "drlm-agent-1" &&
+				req.JoinResponse.MinioSecretKey != ""
+		})).Return(nil)
+
+		s.NoError(agent.Join(s.ctx, stream, &models.Agent{Host: "192.168.1.61"}, "192.168.1.61", "token"))
+		stream.AssertExpectations(s.T())
+	})
+
+	s.Run("should reject the agent if it's quarantined, even if it has a valid enrollment token", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "accepted", "quarantined"}).AddRow(1, "192.168.1.61", true, true))
+
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", mock.MatchedBy(func(req *drlm.AgentConnectionFromCore) bool {
+			return req.MessageType == drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOIN_RESPONSE &&
+				req.JoinResponse.Status == drlm.AgentConnectionFromCore_JoinResponse_STATUS_REJECT
+		})).Return(nil)
+
+		s.Equal(models.ErrAgentQuarantined, agent.Join(s.ctx, stream, &models.Agent{Host: "192.168.1.61"}, "192.168.1.61", "token"))
+		stream.AssertExpectations(s.T())
+	})
+}
+
+func (s *TestJoinSuite) TestAccept() {
+	s.Run("should keep the Minio user of an existing agent", func() {
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.Fail("the agent minio user shouldn't be created")
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer ts.Close()
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "minio_key"}).AddRow(1, "192.168.1.61", "minio-key"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", mock.MatchedBy(func(req *drlm.AgentConnectionFromCore) bool {
+			return req.JoinResponse.Status == drlm.AgentConnectionFromCore_JoinResponse_STATUS_ACCEPT &&
+				req.JoinResponse.MinioSecretKey == "minio-key"
+		})).Return(nil)
+
+		s.NoError(agent.Accept(s.ctx, stream, &models.Agent{Host: "192.168.1.61"}))
+		stream.AssertExpectations(s.T())
+	})
+
+	s.Run("should return an error if the agent is quarantined", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "quarantined"}).AddRow(1, "192.168.1.61", true))
+
+		stream := &tests.AgentConnectionServerMock{}
+
+		s.Equal(models.ErrAgentQuarantined, agent.Accept(s.ctx, stream, &models.Agent{Host: "192.168.1.61"}))
+		stream.AssertNotCalled(s.T(), "Send", mock.Anything)
+	})
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Quarantine stops all the activity of the agent, keeping its jobs and storage. The agent can't connect to the Core (its open
+// connection gets closed) and it can't be reached through SSH, its jobs are held and its Minio user is disabled. If the Core doesn't
+// manage the users of the storage backend, the agent keeps its storage access
+func Quarantine(ctx *context.Context, a *models.Agent) error {
+	if err := a.Load(ctx); err != nil {
+		return err
+	}
+
+	if a.Quarantined {
+		return nil
+	}
+
+	if minio.ManagesUsers(ctx) {
+		if err := minio.SetUserStatus(ctx, fmt.Sprintf("drlm-agent-%d", a.ID), false); err != nil {
+			return err
+		}
+
+	} else {
+		log.Warnf("the storage backend users aren't managed by the Core, the quarantined agent '%s' keeps the shared storage access", a.Host)
+	}
+
+	a.Quarantined = true
+	if err := a.Update(ctx); err != nil {
+		return err
+	}
+
+	scheduler.AgentConnections.Delete(a.Host)
+
+	coreSSH.Evict(a.Host)
+
+	return nil
+}
+
+// Enable enables a quarantined agent again. Its held jobs are executed once it connects to the Core
+func Enable(ctx *context.Context, a *models.Agent) error {
+	if err := a.Load(ctx); err != nil {
+		return err
+	}
+
+	if !a.Quarantined {
+		return nil
+	}
+
+	if minio.ManagesUsers(ctx) {
+		if err := minio.SetUserStatus(ctx, fmt.Sprintf("drlm-agent-%d", a.ID), true); err != nil {
+			return err
+		}
+	}
+
+	a.Quarantined = false
+
+	return a.Update(ctx)
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent_test
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func (s *TestAgentSuite) TestQuarantine() {
+	tests.GenerateCfg(s.T(), s.ctx)
+
+	s.Run("should disable the minio user and quarantine the agent", func() {
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.Equal("drlm-agent-1", r.URL.Query().Get("accessKey"))
+			s.Equal("disabled", r.URL.Query().Get("status"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WithArgs(tests.DBAnyTime{}, nil, "laptop", false, "", "", "", 0, "", "", 0, 0, "", "", "", true, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.Quarantine(s.ctx, &models.Agent{Host: "laptop"}))
+	})
+
+	s.Run("should close the agent connection", func() {
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		stream := &tests.AgentConnectionServerMock{}
+		scheduler.AgentConnections.Add("laptop", stream)
+		defer scheduler.AgentConnections.Delete("laptop")
+		closed := scheduler.AgentConnections.Closed(stream)
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.Quarantine(s.ctx, &models.Agent{Host: "laptop"}))
+
+		_, ok := scheduler.AgentConnections.Get("laptop")
+		s.False(ok)
+
+		select {
+		case <-closed:
+		default:
+			s.Fail("the agent connection hasn't been closed")
+		}
+	})
+
+	s.Run("should quarantine the agent without changing the storage user if the Core doesn't manage the storage backend users", func() {
+		s.ctx.Cfg.Minio.Backend = minio.BackendS3
+		defer func() { s.ctx.Cfg.Minio.Backend = minio.BackendMinio }()
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.Quarantine(s.ctx, &models.Agent{Host: "laptop"}))
+	})
+
+	s.Run("should do nothing if the agent is already quarantined", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "quarantined"}).AddRow(1, "laptop", true))
+
+		s.NoError(agent.Quarantine(s.ctx, &models.Agent{Host: "laptop"}))
+	})
+
+	s.Run("should return an error if there's an error disabling the minio user", func() {
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer ts.Close()
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+
+		s.EqualError(agent.Quarantine(s.ctx, &models.Agent{Host: "laptop"}), "error changing the minio user status: Failed to parse server response.")
+	})
+}
+
+func (s *TestAgentSuite) TestEnable() {
+	tests.GenerateCfg(s.T(), s.ctx)
+
+	s.Run("should enable the minio user and the agent", func() {
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.Equal("enabled", r.URL.Query().Get("status"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "quarantined"}).AddRow(1, "laptop", true))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WithArgs(tests.DBAnyTime{}, nil, "laptop", false, "", "", "", 0, "", "", 0, 0, "", "", "", false, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.Enable(s.ctx, &models.Agent{Host: "laptop"}))
+	})
+
+	s.Run("should enable the agent without changing the storage user if the Core doesn't manage the storage backend users", func() {
+		s.ctx.Cfg.Minio.Backend = minio.BackendS3
+		defer func() { s.ctx.Cfg.Minio.Backend = minio.BackendMinio }()
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "quarantined"}).AddRow(1, "laptop", true))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.Enable(s.ctx, &models.Agent{Host: "laptop"}))
+	})
+}
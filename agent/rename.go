@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
+)
+
+// ChangeHost changes the host (the address) of an agent, keeping its ID, jobs, plugins and storage. The previous host
+// becomes an alias of the agent, so the agent keeps being recognized if it connects or requests to join from it
+func ChangeHost(ctx *context.Context, a *models.Agent, host string) error {
+	if err := a.Load(ctx); err != nil {
+		return err
+	}
+
+	old := a.Host
+	if err := a.ChangeHost(ctx, host); err != nil {
+		return err
+	}
+
+	scheduler.RenameAgent(old, host)
+	coreSSH.Evict(old)
+
+	return nil
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent_test
+
+import (
+	"regexp"
+
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+)
+
+func (s *TestAgentSuite) TestChangeHost() {
+	s.Run("should change the agent host and move its connection", func() {
+		conn := &tests.AgentConnectionServerMock{}
+		scheduler.AgentConnections.Add("laptop", conn)
+		defer scheduler.AgentConnections.Delete("laptop.example.com")
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "agents"`)).WithArgs("laptop.example.com", 1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "agent_aliases"`)).WithArgs("laptop.example.com", 1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "host" = $1`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "agent_aliases"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		for i := 0; i < 8; i++ {
+			s.mock.ExpectExec(regexp.QuoteMeta(`SET "agent_host" = $1 WHERE (agent_host = $2)`)).WithArgs("laptop.example.com", "laptop").WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugin_configs" SET "target" = $1`)).WithArgs("laptop.example.com", models.PluginConfigScopeAgent, "laptop").WillReturnResult(sqlmock.NewResult(0, 0))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "agent_aliases" WHERE (alias = $1)`)).WithArgs("laptop").WillReturnResult(sqlmock.NewResult(0, 0))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_aliases"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, 1, "laptop").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		a := &models.Agent{Host: "laptop"}
+		s.NoError(agent.ChangeHost(s.ctx, a, "laptop.example.com"))
+		s.Equal("laptop.example.com", a.Host)
+
+		_, ok := scheduler.AgentConnections.Get("laptop")
+		s.False(ok)
+
+		stream, ok := scheduler.AgentConnections.Get("laptop.example.com")
+		s.True(ok)
+		s.Equal(conn, stream)
+	})
+
+	s.Run("should return an error if the agent isn't found", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnError(gorm.ErrRecordNotFound)
+
+		s.True(gorm.IsRecordNotFoundError(agent.ChangeHost(s.ctx, &models.Agent{Host: "laptop"}, "laptop.example.com")))
+	})
+
+	s.Run("should return an error if the new host is used by another agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "agents"`)).WithArgs("server", 1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		s.mock.ExpectRollback()
+
+		s.Equal(models.ErrAgentHostInUse, agent.ChangeHost(s.ctx, &models.Agent{Host: "laptop"}, "server"))
+	})
+}
@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/utils/secret"
+
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/jinzhu/gorm"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrAgentNotConnected gets returned when the operation needs the agent to be connected to the Core and it isn't
+var ErrAgentNotConnected = errors.New("agent not connected")
+
+// RotateSecret generates a new secret for the agent (and, optionally, a new Minio secret key) and sends it through the agent connection.
+// The previous secret keeps being accepted until the agent confirms the rotation (connecting with the new secret) or the grace period ends.
+// Minio can't accept two keys for the same user, so the new Minio secret key is applied right before sending it to the agent, and it's
+// only rotated if the Core manages the users of the storage backend
+func RotateSecret(ctx *context.Context, a *models.Agent, rotateMinio bool) error {
+	rotateMinio = rotateMinio && minio.ManagesUsers(ctx)
+
+	if _, ok := scheduler.AgentConnections.Get(a.Host); !ok {
+		return ErrAgentNotConnected
+	}
+
+	r := &models.AgentSecretRotation{
+		AgentHost:        a.Host,
+		PrevSecretLookup: a.SecretLookup,
+		PrevSecretHash:   a.SecretHash,
+		ExpiresAt:        time.Now().Add(ctx.Cfg.Security.AgentSecretGracePeriod),
+	}
+
+	usr := "drlm-agent-" + strconv.Itoa(int(a.ID))
+	prevMinioKey := a.MinioKey
+
+	minioKey := a.MinioKey
+	if rotateMinio {
+		var err error
+		minioKey, err = secret.New(xid.New().String())
+		if err != nil {
+			return fmt.Errorf("error generating the new agent minio secret key: %v", err)
+		}
+	}
+
+	if err := r.Add(ctx); err != nil {
+		return err
+	}
+
+	// revert restores the previous credentials of the agent, since it hasn't received the new ones
+	revert := func() {
+		if a.MinioKey != prevMinioKey {
+			if err := minio.SetUserKey(ctx, usr, prevMinioKey); err != nil {
+				log.Errorf("error reverting the agent '%s' minio secret key: %v", a.Host, err)
+			}
+		}
+
+		a.Secret = ""
+		a.SecretLookup = r.PrevSecretLookup
+		a.SecretHash = r.PrevSecretHash
+		a.MinioKey = prevMinioKey
+		if err := a.Update(ctx); err != nil {
+			log.Errorf("error reverting the agent '%s' secret rotation: %v", a.Host, err)
+		}
+
+		if err := r.Delete(ctx); err != nil {
+			log.Errorf("error reverting the agent '%s' secret rotation: %v", a.Host, err)
+		}
+	}
+
+	if err := a.NewSecret(); err != nil {
+		revert()
+		return fmt.Errorf("error generating the new agent secret: %v", err)
+	}
+
+	if rotateMinio {
+		if err := minio.SetUserKey(ctx, usr, minioKey); err != nil {
+			revert()
+			return fmt.Errorf("error applying the new agent minio secret key: %v", err)
+		}
+
+		a.MinioKey = minioKey
+	}
+
+	if err := a.Update(ctx); err != nil {
+		revert()
+		return err
+	}
+
+	// The agent stores the new credentials the same way it does when it gets accepted. The send is serialized with the
+	// rest of the sends through the agent connection (e.g. the jobs)
+	if err := scheduler.AgentConnections.Send(a.Host, &drlm.AgentConnectionFromCore{
+		MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOIN_RESPONSE,
+		JoinResponse: &drlm.AgentConnectionFromCore_JoinResponse{
+			Status:         drlm.AgentConnectionFromCore_JoinResponse_STATUS_ACCEPT,
+			CoreSecret:     a.Secret,
+			MinioAccessKey: usr,
+			MinioSecretKey: minioKey,
+		},
+	}); err != nil {
+		revert()
+
+		if err == scheduler.ErrConnNotFound {
+			return ErrAgentNotConnected
+		}
+
+		return fmt.Errorf("error sending the new secret to the agent: %v", err)
+	}
+
+	return nil
+}
+
+// ConfirmSecretRotation confirms the pending secret rotation of an agent if the agent is already using the new secret. The
+// agents that authenticate with their client certificate (s is empty) don't use the secret, so their rotation gets confirmed
+// when they reconnect after the new secret has been sent to them
+func ConfirmSecretRotation(ctx *context.Context, host, s string) error {
+	r := &models.AgentSecretRotation{AgentHost: host}
+	if err := r.LoadPending(ctx); err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if s != "" {
+		a := &models.Agent{Host: host}
+		if err := a.Load(ctx); err != nil {
+			return err
+		}
+
+		// The agent is still using the previous secret
+		if !a.CheckSecret(s) {
+			return nil
+		}
+	}
+
+	r.Confirmed = true
+	if err := r.Update(ctx); err != nil {
+		return err
+	}
+
+	log.Infof("agent '%s' has confirmed the secret rotation", host)
+
+	return nil
+}
+
+// RotateSecrets rotates periodically the secrets (and the Minio secret keys) of the connected agents that haven't been rotated
+// for longer than the configured rotation interval
+func RotateSecrets(ctx *context.Context) {
+	ticker := time.NewTicker(time.Hour)
+
+	for {
+		select {
+		case <-ticker.C:
+			if ctx.Cfg.Security.AgentSecretRotation != 0 {
+				rotateExpiredSecrets(ctx)
+			}
+
+		case <-ctx.Done():
+			ctx.WG.Done()
+			return
+		}
+	}
+}
+
+func rotateExpiredSecrets(ctx *context.Context) {
+	agents, err := models.AgentList(ctx)
+	if err != nil {
+		log.Errorf("error rotating the agents secrets: %v", err)
+		return
+	}
+
+	for _, a := range agents {
+		// Rotating the Minio key would enable the Minio user of the quarantined agents again
+		if a.Quarantined {
+			continue
+		}
+
+		last := a.CreatedAt
+
+		r := &models.AgentSecretRotation{AgentHost: a.Host}
+		if err := r.LoadLast(ctx); err != nil {
+			if !gorm.IsRecordNotFoundError(err) {
+				log.Errorf("error rotating the agent '%s' secret: %v", a.Host, err)
+				continue
+			}
+
+		} else {
+			// There's already a rotation in progress
+			if !r.Confirmed && time.Now().Before(r.ExpiresAt) {
+				continue
+			}
+
+			last = r.CreatedAt
+		}
+
+		if time.Since(last) < ctx.Cfg.Security.AgentSecretRotation {
+			continue
+		}
+
+		if _, ok := scheduler.AgentConnections.Get(a.Host); !ok {
+			continue
+		}
+
+		if err := RotateSecret(ctx, a, true); err != nil {
+			log.Errorf("error rotating the agent '%s' secret: %v", a.Host, err)
+		}
+	}
+}
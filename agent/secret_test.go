@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent_test
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/utils/secret"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSecretSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestSecretSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+	tests.GenerateCfg(s.T(), s.ctx)
+}
+
+func (s *TestSecretSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestSecret(t *testing.T) {
+	suite.Run(t, &TestSecretSuite{})
+}
+
+func (s *TestSecretSuite) TestRotateSecret() {
+	s.Run("should rotate the secret, apply the new minio key and send them to the agent", func() {
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.Equal("/minio/admin/v2/add-user", r.URL.Path)
+			s.Equal("drlm-agent-1", r.URL.Query().Get("accessKey"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		var minioKey string
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", mock.MatchedBy(func(req *drlm.AgentConnectionFromCore) bool {
+			return req.MessageType == drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOIN_RESPONSE &&
+				req.JoinResponse.Status == drlm.AgentConnectionFromCore_JoinResponse_STATUS_ACCEPT &&
+				req.JoinResponse.CoreSecret != "" &&
+				req.JoinResponse.MinioAccessKey == "drlm-agent-1" &&
+				req.JoinResponse.MinioSecretKey != "minioKey"
+		})).Run(func(args mock.Arguments) {
+			minioKey = args.Get(0).(*drlm.AgentConnectionFromCore).JoinResponse.MinioSecretKey
+		}).Return(nil)
+
+		scheduler.AgentConnections.Add("laptop", stream)
+		defer scheduler.AgentConnections.Delete("laptop")
+
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_secret_rotations" ("created_at","updated_at","deleted_at","agent_host","prev_secret_lookup","prev_secret_hash","expires_at","confirmed") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "agent_secret_rotations"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", "lookup", "hash", tests.DBAnyTime{}, false).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "updated_at" = $1, "deleted_at" = $2, "host" = $3, "accepted" = $4, "minio_key" = $5, "secret_lookup" = $6, "secret_hash" = $7, "ssh_port" = $8, "ssh_user" = $9, "version" = $10, "arch" = $11, "os" = $12, "os_version" = $13, "distro" = $14, "distro_version" = $15, "quarantined" = $16  WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $17`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		a := &models.Agent{
+			Model:        gorm.Model{ID: 1},
+			Host:         "laptop",
+			Accepted:     true,
+			SecretLookup: "lookup",
+			SecretHash:   "hash",
+			MinioKey:     "minioKey",
+		}
+
+		s.NoError(agent.RotateSecret(s.ctx, a, true))
+		s.NotEqual("hash", a.SecretHash)
+		s.True(a.CheckSecret(a.Secret))
+		s.Equal(minioKey, a.MinioKey)
+		stream.AssertExpectations(s.T())
+	})
+
+	s.Run("should revert the rotation if there's an error sending the new secret", func() {
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", mock.Anything).Return(errors.New("testing error"))
+
+		scheduler.AgentConnections.Add("laptop", stream)
+		defer scheduler.AgentConnections.Delete("laptop")
+
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_secret_rotations" ("created_at","updated_at","deleted_at","agent_host","prev_secret_lookup","prev_secret_hash","expires_at","confirmed") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "agent_secret_rotations"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "updated_at" = $1, "deleted_at" = $2, "host" = $3, "accepted" = $4, "minio_key" = $5, "secret_lookup" = $6, "secret_hash" = $7, "ssh_port" = $8, "ssh_user" = $9, "version" = $10, "arch" = $11, "os" = $12, "os_version" = $13, "distro" = $14, "distro_version" = $15, "quarantined" = $16  WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $17`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "updated_at" = $1, "deleted_at" = $2, "host" = $3, "accepted" = $4, "minio_key" = $5, "secret_lookup" = $6, "secret_hash" = $7, "ssh_port" = $8, "ssh_user" = $9, "version" = $10, "arch" = $11, "os" = $12, "os_version" = $13, "distro" = $14, "distro_version" = $15, "quarantined" = $16  WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $17`)).WithArgs(tests.DBAnyTime{}, nil, "laptop", true, "minioKey", "lookup", "hash", 0, "", "", 0, 0, "", "", "", false, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_secret_rotations" SET "deleted_at"=$1 WHERE "agent_secret_rotations"."deleted_at" IS NULL AND "agent_secret_rotations"."id" = $2`)).WithArgs(tests.DBAnyTime{}, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		a := &models.Agent{
+			Model:        gorm.Model{ID: 1},
+			Host:         "laptop",
+			Accepted:     true,
+			SecretLookup: "lookup",
+			SecretHash:   "hash",
+			MinioKey:     "minioKey",
+		}
+
+		s.EqualError(agent.RotateSecret(s.ctx, a, false), "error sending the new secret to the agent: testing error")
+		s.Equal("hash", a.SecretHash)
+		s.Equal("", a.Secret)
+	})
+
+	s.Run("should restore the previous minio key if there's an error sending the new credentials", func() {
+		keys := []string{}
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys = append(keys, r.URL.Query().Get("accessKey"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", mock.Anything).Return(errors.New("testing error"))
+
+		scheduler.AgentConnections.Add("laptop", stream)
+		defer scheduler.AgentConnections.Delete("laptop")
+
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_secret_rotations"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WithArgs(tests.DBAnyTime{}, nil, "laptop", true, "minioKey", "lookup", "hash", 0, "", "", 0, 0, "", "", "", false, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_secret_rotations" SET "deleted_at"=$1`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		a := &models.Agent{
+			Model:        gorm.Model{ID: 1},
+			Host:         "laptop",
+			Accepted:     true,
+			SecretLookup: "lookup",
+			SecretHash:   "hash",
+			MinioKey:     "minioKey",
+		}
+
+		s.EqualError(agent.RotateSecret(s.ctx, a, true), "error sending the new secret to the agent: testing error")
+		s.Equal("minioKey", a.MinioKey)
+		s.Equal([]string{"drlm-agent-1", "drlm-agent-1"}, keys)
+	})
+
+	s.Run("should return an error if the agent isn't connected", func() {
+		a := &models.Agent{Host: "laptop"}
+
+		s.Equal(agent.ErrAgentNotConnected, agent.RotateSecret(s.ctx, a, false))
+	})
+}
+
+func (s *TestSecretSuite) TestConfirmSecretRotation() {
+	hash := func(sec string) string {
+		h, err := secret.Hash(sec)
+		s.Require().NoError(err)
+
+		return h
+	}
+
+	s.Run("should confirm the rotation if the agent is using the new secret", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1 AND confirmed = $2)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs("laptop", false).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "prev_secret_hash"}).AddRow(1, "laptop", "oldhash"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "secret_hash"}).AddRow(1, "laptop", hash("newsecret")))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_secret_rotations" SET "updated_at" = $1, "deleted_at" = $2, "agent_host" = $3, "prev_secret_lookup" = $4, "prev_secret_hash" = $5, "expires_at" = $6, "confirmed" = $7  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND "agent_secret_rotations"."id" = $8`)).WithArgs(tests.DBAnyTime{}, nil, "laptop", "", "oldhash", sqlmock.AnyArg(), true, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.ConfirmSecretRotation(s.ctx, "laptop", "newsecret"))
+	})
+
+	s.Run("should confirm the rotation of the agents that authenticate with their client certificate", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"`)).WithArgs("laptop", false).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "prev_secret_hash"}).AddRow(1, "laptop", "oldhash"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_secret_rotations"`)).WithArgs(tests.DBAnyTime{}, nil, "laptop", "", "oldhash", sqlmock.AnyArg(), true, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.ConfirmSecretRotation(s.ctx, "laptop", ""))
+	})
+
+	s.Run("should not confirm the rotation if the agent is still using the previous secret", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1 AND confirmed = $2)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs("laptop", false).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "prev_secret_hash"}).AddRow(1, "laptop", "oldhash"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "secret_hash"}).AddRow(1, "laptop", hash("newsecret")))
+
+		s.NoError(agent.ConfirmSecretRotation(s.ctx, "laptop", "secret"))
+	})
+
+	s.Run("should do nothing if there's no pending rotation", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1 AND confirmed = $2)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs("laptop", false).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		s.NoError(agent.ConfirmSecretRotation(s.ctx, "laptop", "secret"))
+	})
+
+	s.Run("should return an error if there's an error loading the pending rotation", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1 AND confirmed = $2)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		s.EqualError(agent.ConfirmSecretRotation(s.ctx, "laptop", "secret"), "error loading the agent secret rotation from the DB: testing error")
+	})
+}
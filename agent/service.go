@@ -0,0 +1,252 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+)
+
+// serviceName is the name of the DRLM Agent service in the agent host
+const serviceName = "drlm-agent"
+
+const (
+	// systemdUnit is the systemd unit of the DRLM Agent service. It has to be formatted with the user and the binary path
+	systemdUnit = `# Generated by DRLM
+[Unit]
+Description=DRLM Agent
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+User=%s
+ExecStart=%s
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`
+	// openRCScript is the OpenRC init script of the DRLM Agent service. It has to be formatted with the user and the binary path
+	openRCScript = `#!/sbin/openrc-run
+# Generated by DRLM
+
+description="DRLM Agent"
+command_user="%s"
+command="%s"
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+
+depend() {
+	need net
+}
+`
+	// sysVScript is the SysV init script of the DRLM Agent service. It has to be formatted with the user and the binary path
+	sysVScript = `#!/bin/sh
+# Generated by DRLM
+### BEGIN INIT INFO
+# Provides:          drlm-agent
+# Required-Start:    $network $remote_fs
+# Required-Stop:     $network $remote_fs
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: DRLM Agent
+### END INIT INFO
+
+USER="%s"
+BIN="%s"
+PIDFILE="/var/run/drlm-agent.pid"
+
+case "$1" in
+	start)
+		start-stop-daemon --start --background --make-pidfile --pidfile "$PIDFILE" --chuid "$USER" --exec "$BIN"
+		;;
+	stop)
+		start-stop-daemon --stop --pidfile "$PIDFILE" --retry 10
+		rm -f "$PIDFILE"
+		;;
+	restart)
+		$0 stop
+		$0 start
+		;;
+	*)
+		echo "Usage: $0 {start|stop|restart}"
+		exit 1
+		;;
+esac
+`
+)
+
+// initSystem is the init system of an agent host
+type initSystem int
+
+const (
+	// initUnknown is a not known init system
+	initUnknown initSystem = iota
+	// initSystemd is the systemd init system
+	initSystemd
+	// initOpenRC is the OpenRC init system
+	initOpenRC
+	// initSysV is the SysV init system
+	initSysV
+)
+
+// detectInitSystem detects the init system of the agent host
+func detectInitSystem(c client.Client, o os.OS) (initSystem, error) {
+	switch o {
+	case os.Linux:
+		out, err := c.Exec("ps", "-p", "1", "-o", "comm=")
+		if err != nil {
+			return initUnknown, fmt.Errorf("error detecting the init system: %v", err)
+		}
+
+		switch strings.TrimSpace(string(out)) {
+		case "systemd":
+			return initSystemd, nil
+
+		case "openrc-init":
+			return initOpenRC, nil
+
+		case "init":
+			// OpenRC can also run on top of the SysV init
+			if exists, err := c.Exists("/sbin/openrc"); err == nil && exists {
+				return initOpenRC, nil
+			}
+
+			return initSysV, nil
+
+		default:
+			return initUnknown, nil
+		}
+
+	default:
+		return initUnknown, os.ErrUnsupportedOS
+	}
+}
+
+// restartAgentService restarts the DRLM Agent service of the agent. The agent SSH user can't manage the services when it's a
+// dedicated service user (see Install), so the service is restarted with the service credentials of the Core configuration
+// (usually with sudo), if there are any
+func restartAgentService(ctx *context.Context, a *models.Agent) error {
+	var c *coreSSH.Client
+	var err error
+	if ctx.Cfg.SSH.ServiceCredentials == "" {
+		c, err = coreSSH.Acquire(ctx, a)
+
+	} else {
+		var creds coreSSH.Credentials
+		creds, err = coreSSH.LoadCredentials(ctx, ctx.Cfg.SSH.ServiceCredentials)
+		if err != nil {
+			return fmt.Errorf("error loading the service credentials: %v", err)
+		}
+
+		c, err = coreSSH.AcquireWithCredentials(ctx, a, creds)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	return restartService(c, a.OS)
+}
+
+// restartService restarts the DRLM Agent service
+func restartService(c client.Client, o os.OS) error {
+	i, err := detectInitSystem(c, o)
+	if err != nil {
+		return err
+	}
+
+	switch i {
+	case initSystemd:
+		_, err = c.Exec("systemctl", "restart", serviceName)
+
+	case initOpenRC:
+		_, err = c.Exec("rc-service", serviceName, "restart")
+
+	case initSysV:
+		_, err = c.Exec("service", serviceName, "restart")
+
+	default:
+		return fmt.Errorf("error restarting the agent service: unsupported init system")
+	}
+
+	if err != nil {
+		return fmt.Errorf("error restarting the agent service: %v", err)
+	}
+
+	return nil
+}
+
+// installService installs, enables and starts the DRLM Agent service for the init system of the agent host
+func installService(c client.Client, o os.OS, usr string) error {
+	i, err := detectInitSystem(c, o)
+	if err != nil {
+		return err
+	}
+
+	home, err := o.CmdFSHome(c, usr)
+	if err != nil {
+		return fmt.Errorf("error installing the agent service: %v", err)
+	}
+	bin := filepath.Join(home, ".bin", serviceName)
+
+	var path string
+	var script string
+	var cmds [][]string
+
+	switch i {
+	case initSystemd:
+		path = filepath.Join("/etc/systemd/system", serviceName+".service")
+		script = fmt.Sprintf(systemdUnit, usr, bin)
+		cmds = [][]string{
+			{"systemctl", "daemon-reload"},
+			{"systemctl", "enable", serviceName},
+			{"systemctl", "restart", serviceName},
+		}
+
+	case initOpenRC:
+		path = filepath.Join("/etc/init.d", serviceName)
+		script = fmt.Sprintf(openRCScript, usr, bin)
+		cmds = [][]string{
+			{"rc-update", "add", serviceName, "default"},
+			{"rc-service", serviceName, "restart"},
+		}
+
+	case initSysV:
+		path = filepath.Join("/etc/init.d", serviceName)
+		script = fmt.Sprintf(sysVScript, usr, bin)
+		cmds = [][]string{
+			{"update-rc.d", serviceName, "defaults"},
+			{"service", serviceName, "restart"},
+		}
+
+	default:
+		return fmt.Errorf("error installing the agent service: unsupported init system")
+	}
+
+	if err := c.Write(path, []byte(script)); err != nil {
+		return fmt.Errorf("error writting the agent service: %v", err)
+	}
+
+	if i != initSystemd {
+		if err := c.Chmod(path, 0755); err != nil {
+			return fmt.Errorf("error making the agent service executable: %v", err)
+		}
+	}
+
+	for _, cmd := range cmds {
+		if _, err := c.Exec(cmd[0], cmd[1:]...); err != nil {
+			return fmt.Errorf("error installing the agent service: %s: %v", strings.Join(cmd, " "), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestServiceInternalSuite struct {
+	suite.Suite
+}
+
+func TestServiceInternal(t *testing.T) {
+	suite.Run(t, &TestServiceInternalSuite{})
+}
+
+func (s *TestServiceInternalSuite) TestDetectInitSystem() {
+	s.Run("should detect systemd", func() {
+		c := &tests.OSClientMock{Out: map[string]string{"ps -p 1 -o comm=": "systemd\n"}}
+
+		i, err := detectInitSystem(c, os.Linux)
+		s.NoError(err)
+		s.Equal(initSystemd, i)
+	})
+
+	s.Run("should detect SysV", func() {
+		c := &tests.OSClientMock{Out: map[string]string{"ps -p 1 -o comm=": "init\n"}}
+
+		i, err := detectInitSystem(c, os.Linux)
+		s.NoError(err)
+		s.Equal(initSysV, i)
+	})
+
+	s.Run("should return unknown if the init system isn't known", func() {
+		c := &tests.OSClientMock{Out: map[string]string{"ps -p 1 -o comm=": "runit\n"}}
+
+		i, err := detectInitSystem(c, os.Linux)
+		s.NoError(err)
+		s.Equal(initUnknown, i)
+	})
+
+	s.Run("should return an error if there's an error detecting the init system", func() {
+		_, err := detectInitSystem(&tests.OSClientMock{}, os.Linux)
+		s.EqualError(err, "error detecting the init system: command not found")
+	})
+
+	s.Run("should return an error if the OS is not supported", func() {
+		_, err := detectInitSystem(&tests.OSClientMock{}, os.Windows)
+		s.Equal(os.ErrUnsupportedOS, err)
+	})
+}
+
+func (s *TestServiceInternalSuite) TestRestartAgentService() {
+	s.Run("should return an error if the service credentials aren't in the configuration", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		ctx.Cfg.SSH.ServiceCredentials = "admin"
+
+		s.EqualError(restartAgentService(ctx, &models.Agent{Host: "laptop"}), "error loading the service credentials: unknown SSH credentials")
+	})
+}
+
+func (s *TestServiceInternalSuite) TestRestartService() {
+	s.Run("should restart the service using systemd", func() {
+		c := &tests.OSClientMock{Out: map[string]string{
+			"ps -p 1 -o comm=":             "systemd\n",
+			"systemctl restart drlm-agent": "",
+		}}
+
+		s.NoError(restartService(c, os.Linux))
+	})
+
+	s.Run("should return an error if there's an error restarting the service", func() {
+		c := &tests.OSClientMock{Out: map[string]string{"ps -p 1 -o comm=": "init\n"}}
+
+		s.EqualError(restartService(c, os.Linux), "error restarting the agent service: command not found")
+	})
+
+	s.Run("should return an error if the init system isn't supported", func() {
+		c := &tests.OSClientMock{Out: map[string]string{"ps -p 1 -o comm=": "runit\n"}}
+
+		s.EqualError(restartService(c, os.Linux), "error restarting the agent service: unsupported init system")
+	})
+}
+
+func (s *TestServiceInternalSuite) TestInstallService() {
+	passwd := "drlm:x:1000:1000::/home/drlm:/bin/bash\n"
+
+	s.Run("should install, enable and start the systemd service", func() {
+		c := &tests.OSClientMock{Out: map[string]string{
+			"ps -p 1 -o comm=":             "systemd\n",
+			"getent passwd drlm":           passwd,
+			"systemctl daemon-reload":      "",
+			"systemctl enable drlm-agent":  "",
+			"systemctl restart drlm-agent": "",
+		}}
+
+		s.NoError(installService(c, os.Linux, "drlm"))
+		s.Contains(c.Files["/etc/systemd/system/drlm-agent.service"], "User=drlm\nExecStart=/home/drlm/.bin/drlm-agent\n")
+		s.Equal([]string{
+			"ps -p 1 -o comm=",
+			"getent passwd drlm",
+			"systemctl daemon-reload",
+			"systemctl enable drlm-agent",
+			"systemctl restart drlm-agent",
+		}, c.Execs)
+	})
+
+	s.Run("should install, enable and start the OpenRC service", func() {
+		c := &tests.OSClientMock{Out: map[string]string{
+			"ps -p 1 -o comm=":                 "openrc-init\n",
+			"getent passwd drlm":               passwd,
+			"rc-update add drlm-agent default": "",
+			"rc-service drlm-agent restart":    "",
+		}}
+
+		s.NoError(installService(c, os.Linux, "drlm"))
+		s.Contains(c.Files["/etc/init.d/drlm-agent"], `command="/home/drlm/.bin/drlm-agent"`)
+	})
+
+	s.Run("should install, enable and start the SysV service", func() {
+		c := &tests.OSClientMock{Out: map[string]string{
+			"ps -p 1 -o comm=":                "init\n",
+			"getent passwd drlm":              passwd,
+			"update-rc.d drlm-agent defaults": "",
+			"service drlm-agent restart":      "",
+		}}
+
+		s.NoError(installService(c, os.Linux, "drlm"))
+		s.Contains(c.Files["/etc/init.d/drlm-agent"], `BIN="/home/drlm/.bin/drlm-agent"`)
+	})
+
+	s.Run("should return an error if there's an error enabling the service", func() {
+		c := &tests.OSClientMock{Out: map[string]string{
+			"ps -p 1 -o comm=":        "systemd\n",
+			"getent passwd drlm":      passwd,
+			"systemctl daemon-reload": "",
+		}}
+
+		s.EqualError(installService(c, os.Linux, "drlm"), "error installing the agent service: systemctl enable drlm-agent: command not found")
+	})
+
+	s.Run("should return an error if the init system isn't supported", func() {
+		c := &tests.OSClientMock{Out: map[string]string{
+			"ps -p 1 -o comm=":   "runit\n",
+			"getent passwd drlm": passwd,
+		}}
+
+		s.EqualError(installService(c, os.Linux, "drlm"), "error installing the agent service: unsupported init system")
+	})
+}
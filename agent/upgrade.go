@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
+
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// UpgradeTimeout is the maximum time that an agent has to reconnect reporting the new version after an upgrade
+	UpgradeTimeout = 5 * time.Minute
+	// ErrUpgradeHalted gets returned for the agents that haven't been upgraded because a previous batch has failed
+	ErrUpgradeHalted = errors.New("upgrade halted: a previous batch has failed")
+)
+
+// Upgrade replaces the agent binary through SSH and restarts the service. If the agent doesn't reconnect reporting
+// the new version (in the `version` metadata of the AgentConnection stream) before the UpgradeTimeout, the previous
+// binary gets restored. It has to run in the Core, since it waits for the agent connection
+func Upgrade(ctx *context.Context, a *models.Agent, version string, f []byte) error {
+	bin, bak, err := replaceBinary(ctx, a, f)
+	if err != nil {
+		return err
+	}
+
+	// The connection that the agent has before the restart doesn't prove that the new version is running
+	prev, _ := scheduler.AgentConnections.Get(a.Host)
+
+	if err := restartAgentService(ctx, a); err != nil {
+		return rollbackUpgrade(ctx, a, bin, bak, err)
+	}
+
+	if err := waitForVersion(ctx, a.Host, version, prev, UpgradeTimeout); err != nil {
+		return rollbackUpgrade(ctx, a, bin, bak, err)
+	}
+
+	c, err := coreSSH.Acquire(ctx, a)
+	if err != nil {
+		log.Warnf("error removing the agent '%s' binary backup: %v", a.Host, err)
+
+	} else {
+		if err := c.Remove(bak); err != nil {
+			log.Warnf("error removing the agent '%s' binary backup: %v", a.Host, err)
+		}
+
+		c.Release()
+	}
+
+	a.Version = version
+
+	return nil
+}
+
+// replaceBinary backs up the agent binary and installs the new one. It returns the paths of the binary and the backup
+func replaceBinary(ctx *context.Context, a *models.Agent, f []byte) (string, string, error) {
+	c, err := coreSSH.Acquire(ctx, a)
+	if err != nil {
+		return "", "", err
+	}
+	defer c.Release()
+
+	home, err := a.OS.CmdFSHome(c, a.SSHUser)
+	if err != nil {
+		return "", "", fmt.Errorf("error upgrading the agent: %v", err)
+	}
+
+	bin := filepath.Join(home, ".bin", "drlm-agent")
+	bak := bin + ".bak"
+
+	exists, err := c.Exists(bak)
+	if err != nil {
+		return "", "", fmt.Errorf("error checking the agent binary backup: %v", err)
+	}
+	if exists {
+		if err := c.Remove(bak); err != nil {
+			return "", "", fmt.Errorf("error removing the old agent binary backup: %v", err)
+		}
+	}
+
+	if err := c.Copy(bin, bak); err != nil {
+		return "", "", fmt.Errorf("error backing up the agent binary: %v", err)
+	}
+
+	if err := a.OS.CmdPkgInstallBinary(c, a.SSHUser, "drlm-agent", f); err != nil {
+		return "", "", fmt.Errorf("error installing the new agent binary: %v", err)
+	}
+
+	return bin, bak, nil
+}
+
+// rollbackUpgrade restores the previous agent binary and restarts the service
+func rollbackUpgrade(ctx *context.Context, a *models.Agent, bin, bak string, upgradeErr error) error {
+	if err := restoreBinary(ctx, a, bin, bak); err != nil {
+		return fmt.Errorf("error upgrading the agent: %v. Error restoring the previous binary: %v", upgradeErr, err)
+	}
+
+	if err := restartAgentService(ctx, a); err != nil {
+		return fmt.Errorf("error upgrading the agent: %v. Error restarting the previous version: %v", upgradeErr, err)
+	}
+
+	return fmt.Errorf("error upgrading the agent, the previous version has been restored: %v", upgradeErr)
+}
+
+// restoreBinary replaces the agent binary with its backup
+func restoreBinary(ctx *context.Context, a *models.Agent, bin, bak string) error {
+	c, err := coreSSH.Acquire(ctx, a)
+	if err != nil {
+		return err
+	}
+	defer c.Release()
+
+	// The SFTP rename fails if the destination already exists
+	if err := c.Remove(bin); err != nil {
+		return fmt.Errorf("error removing the new binary: %v", err)
+	}
+
+	if err := c.Move(bak, bin); err != nil {
+		return fmt.Errorf("error moving the backup: %v", err)
+	}
+
+	return nil
+}
+
+// waitForVersion waits until the agent has reconnected with a stream other than prev and has reported the version
+func waitForVersion(ctx *context.Context, host, version string, prev drlm.DRLM_AgentConnectionServer, timeout time.Duration) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case <-ticker.C:
+			stream, ok := scheduler.AgentConnections.Get(host)
+			if !ok || stream == prev {
+				continue
+			}
+
+			a := &models.Agent{Host: host}
+			if err := a.Load(ctx); err != nil {
+				return err
+			}
+
+			if a.Version == version {
+				return nil
+			}
+
+		case <-deadline:
+			return fmt.Errorf("the agent hasn't reconnected with the version '%s'", version)
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// UpgradeBySelector upgrades all the agents that match the label selector. The agents are upgraded in batches of batchSize
+// agents in parallel, and if an upgrade of a batch fails, the next batches aren't upgraded. It returns the result of each agent
+func UpgradeBySelector(ctx *context.Context, sel models.LabelSelector, version string, f []byte, batchSize int) (map[string]error, error) {
+	agents, err := models.AgentListBySelector(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	results := map[string]error{}
+	var mux sync.Mutex
+	failed := false
+
+	for i := 0; i < len(agents); i += batchSize {
+		end := i + batchSize
+		if end > len(agents) {
+			end = len(agents)
+		}
+
+		if failed {
+			for _, a := range agents[i:end] {
+				results[a.Host] = ErrUpgradeHalted
+			}
+
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, a := range agents[i:end] {
+			wg.Add(1)
+			go func(a *models.Agent) {
+				defer wg.Done()
+
+				err := Upgrade(ctx, a, version, f)
+
+				mux.Lock()
+				defer mux.Unlock()
+
+				results[a.Host] = err
+				if err != nil {
+					log.Errorf("error upgrading the agent '%s': %v", a.Host, err)
+					failed = true
+				}
+			}(a)
+		}
+		wg.Wait()
+	}
+
+	return results, nil
+}
+
+// UpdateVersion updates the version of the agent if it has changed
+func UpdateVersion(ctx *context.Context, host, version string) error {
+	a := &models.Agent{Host: host}
+	if err := a.Load(ctx); err != nil {
+		return err
+	}
+
+	if a.Version == version {
+		return nil
+	}
+
+	a.Version = version
+	return a.Update(ctx)
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestUpgradeInternalSuite struct {
+	suite.Suite
+}
+
+func TestUpgradeInternal(t *testing.T) {
+	suite.Run(t, &TestUpgradeInternalSuite{})
+}
+
+func (s *TestUpgradeInternalSuite) TestWaitForVersion() {
+	s.Run("should return when the agent reconnects with the new version", func() {
+		ctx, cancel := context.WithCancel()
+		defer cancel()
+		mock := tests.GenerateDB(s.T(), ctx)
+
+		scheduler.AgentConnections.Add("laptop", &tests.AgentConnectionServerMock{})
+		defer scheduler.AgentConnections.Delete("laptop")
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "version"}).AddRow(1, "laptop", "v1.1.0"))
+
+		s.NoError(waitForVersion(ctx, "laptop", "v1.1.0", nil, 5*time.Second))
+	})
+
+	s.Run("should wait until the agent reconnects after the restart", func() {
+		ctx, cancel := context.WithCancel()
+		defer cancel()
+		mock := tests.GenerateDB(s.T(), ctx)
+
+		prev := &tests.AgentConnectionServerMock{}
+		scheduler.AgentConnections.Add("laptop", prev)
+		defer scheduler.AgentConnections.Delete("laptop")
+
+		go func() {
+			time.Sleep(1500 * time.Millisecond)
+			scheduler.AgentConnections.Add("laptop", &tests.AgentConnectionServerMock{})
+		}()
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "version"}).AddRow(1, "laptop", "v1.1.0"))
+
+		start := time.Now()
+		s.NoError(waitForVersion(ctx, "laptop", "v1.1.0", prev, 5*time.Second))
+		s.True(time.Since(start) > 1500*time.Millisecond)
+		s.NoError(mock.ExpectationsWereMet())
+	})
+
+	s.Run("should return an error if the agent doesn't reconnect before the timeout", func() {
+		ctx, cancel := context.WithCancel()
+		defer cancel()
+
+		s.EqualError(waitForVersion(ctx, "laptop", "v1.1.0", nil, 1500*time.Millisecond), "the agent hasn't reconnected with the version 'v1.1.0'")
+	})
+}
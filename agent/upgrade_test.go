@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package agent_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestUpgradeSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestUpgradeSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestUpgradeSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestUpgrade(t *testing.T) {
+	suite.Run(t, &TestUpgradeSuite{})
+}
+
+func (s *TestUpgradeSuite) TestUpgradeBySelector() {
+	s.Run("should return an empty result if there are no agents that match the selector", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret_lookup, secret_hash, ssh_port, ssh_user, version, arch, os, os_version, distro, distro_version, quarantined FROM "agents"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}))
+
+		results, err := agent.UpgradeBySelector(s.ctx, models.LabelSelector{"env": "prod"}, "v1.0.0", []byte("agent"), 2)
+
+		s.NoError(err)
+		s.Equal(map[string]error{}, results)
+	})
+
+	s.Run("should return an error if there's an error listing the agents", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret_lookup, secret_hash, ssh_port, ssh_user, version, arch, os, os_version, distro, distro_version, quarantined FROM "agents"`)).WillReturnError(errors.New("testing error"))
+
+		results, err := agent.UpgradeBySelector(s.ctx, models.LabelSelector{"env": "prod"}, "v1.0.0", []byte("agent"), 2)
+
+		s.EqualError(err, "error getting the list of agents: testing error")
+		s.Nil(results)
+	})
+}
+
+func (s *TestUpgradeSuite) TestUpdateVersion() {
+	s.Run("should update the version if it has changed", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "version"}).AddRow(1, "laptop", "v1.0.0"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "updated_at" = $1, "deleted_at" = $2, "host" = $3, "accepted" = $4, "minio_key" = $5, "secret_lookup" = $6, "secret_hash" = $7, "ssh_port" = $8, "ssh_user" = $9, "version" = $10, "arch" = $11, "os" = $12, "os_version" = $13, "distro" = $14, "distro_version" = $15, "quarantined" = $16  WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $17`)).WithArgs(tests.DBAnyTime{}, nil, "laptop", false, "", "", "", 0, "", "v1.1.0", 0, 0, "", "", "", false, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError(agent.UpdateVersion(s.ctx, "laptop", "v1.1.0"))
+	})
+
+	s.Run("should not update the agent if the version hasn't changed", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "version"}).AddRow(1, "laptop", "v1.0.0"))
+
+		s.NoError(agent.UpdateVersion(s.ctx, "laptop", "v1.0.0"))
+	})
+
+	s.Run("should return an error if there's an error loading the agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		s.EqualError(agent.UpdateVersion(s.ctx, "laptop", "v1.0.0"), "error loading the agent from the DB: testing error")
+	})
+}
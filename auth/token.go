@@ -60,18 +60,18 @@ func (t *Token) Validate(ctx *context.Context) bool {
 
 // ValidateAgent checks whether an agent token (secret) is valid or not
 func (t *Token) ValidateAgent(ctx *context.Context) (string, bool) {
-	agents, err := models.AgentList(ctx)
-	if err != nil {
-		return "", false
+	a := &models.Agent{}
+	if err := a.LoadBySecret(ctx, t.String()); err == nil && a.Accepted {
+		return a.Host, true
 	}
 
-	for _, a := range agents {
-		if a.Secret == t.String() {
-			return a.Host, true
-		}
+	// The secret might be the previous secret of an agent whose secret is being rotated
+	host, err := models.AgentSecretRotationHost(ctx, t.String())
+	if err != nil {
+		return "", false
 	}
 
-	return "", false
+	return host, true
 }
 
 // Renew renews the validity of the token
@@ -10,6 +10,7 @@ import (
 
 	"github.com/brainupdaters/drlm-core/auth"
 	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/utils/secret"
 	"github.com/brainupdaters/drlm-core/utils/tests"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -89,12 +90,18 @@ func (s *TestTokenSuite) TestValidate() {
 }
 
 func (s *TestTokenSuite) TestValidateAgent() {
+	hash := func(sec string) string {
+		h, err := secret.Hash(sec)
+		s.Require().NoError(err)
+
+		return h
+	}
+
 	s.Run("should return true if the secret is valid", func() {
 		tests.GenerateCfg(s.T(), s.ctx)
 
-		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret, ssh_port, ssh_user, ssh_host_keys, version, arch, os, os_version, distro, distro_version FROM "agents" WHERE "agents"."deleted_at" IS NULL AND (("agents"."accepted" = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host", "secret"}).
-			AddRow(1, "server", "supersecret").
-			AddRow(2, "laptop", "secret"),
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((secret_lookup = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("secret")).WillReturnRows(sqlmock.NewRows([]string{"id", "host", "accepted", "secret_hash"}).
+			AddRow(2, "laptop", true, hash("secret")),
 		)
 
 		tkn := auth.Token("secret")
@@ -105,10 +112,11 @@ func (s *TestTokenSuite) TestValidateAgent() {
 		s.Equal("laptop", host)
 	})
 
-	s.Run("should return false if there's an error getting the agent list", func() {
+	s.Run("should return false if there's an error loading the agent", func() {
 		tests.GenerateCfg(s.T(), s.ctx)
 
-		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret, ssh_port, ssh_user, ssh_host_keys, version, arch, os, os_version, distro, distro_version FROM "agents" WHERE "agents"."deleted_at" IS NULL AND (("agents"."accepted" = $1))`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((secret_lookup = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations" WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((prev_secret_lookup = $1 AND confirmed = $2 AND expires_at > $3)) ORDER BY "agent_secret_rotations"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
 
 		tkn := auth.Token("secret")
 
@@ -118,13 +126,13 @@ func (s *TestTokenSuite) TestValidateAgent() {
 		s.Equal("", host)
 	})
 
-	s.Run("should return false if the secret isn't found in the agents list", func() {
+	s.Run("should return false if the secret doesn't match the hash", func() {
 		tests.GenerateCfg(s.T(), s.ctx)
 
-		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret, ssh_port, ssh_user, ssh_host_keys, version, arch, os, os_version, distro, distro_version FROM "agents" WHERE "agents"."deleted_at" IS NULL AND (("agents"."accepted" = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host", "secret"}).
-			AddRow(1, "server", "supersecret").
-			AddRow(2, "laptop", "secret"),
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((secret_lookup = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("h4ck3r")).WillReturnRows(sqlmock.NewRows([]string{"id", "host", "accepted", "secret_hash"}).
+			AddRow(2, "laptop", true, hash("secret")),
 		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations" WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((prev_secret_lookup = $1 AND confirmed = $2 AND expires_at > $3)) ORDER BY "agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("h4ck3r"), false, tests.DBAnyTime{}).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 
 		tkn := auth.Token("h4ck3r")
 
@@ -133,6 +141,36 @@ func (s *TestTokenSuite) TestValidateAgent() {
 		s.False(ok)
 		s.Equal("", host)
 	})
+
+	s.Run("should return false if the agent isn't accepted", func() {
+		tests.GenerateCfg(s.T(), s.ctx)
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((secret_lookup = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("secret")).WillReturnRows(sqlmock.NewRows([]string{"id", "host", "accepted", "secret_hash"}).
+			AddRow(2, "laptop", false, hash("secret")),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations" WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((prev_secret_lookup = $1 AND confirmed = $2 AND expires_at > $3)) ORDER BY "agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("secret"), false, tests.DBAnyTime{}).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		tkn := auth.Token("secret")
+
+		host, ok := tkn.ValidateAgent(s.ctx)
+
+		s.False(ok)
+		s.Equal("", host)
+	})
+
+	s.Run("should return true if the secret is the previous secret of a secret rotation in its grace period", func() {
+		tests.GenerateCfg(s.T(), s.ctx)
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((secret_lookup = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("secret")).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations" WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((prev_secret_lookup = $1 AND confirmed = $2 AND expires_at > $3)) ORDER BY "agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("secret"), false, tests.DBAnyTime{}).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "prev_secret_hash"}).AddRow(1, "laptop", hash("secret")))
+
+		tkn := auth.Token("secret")
+
+		host, ok := tkn.ValidateAgent(s.ctx)
+
+		s.True(ok)
+		s.Equal("laptop", host)
+	})
 }
 
 func (s *TestTokenSuite) TestRenew() {
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// caLifespan is how long the Core CA certificate is valid
+const caLifespan = 10 * 365 * 24 * time.Hour
+
+var (
+	// ErrUnknownCert gets returned when a client certificate hasn't been issued by the Core CA
+	ErrUnknownCert = errors.New("unknown certificate")
+	// ErrRevokedCert gets returned when a client certificate has been revoked
+	ErrRevokedCert = errors.New("revoked certificate")
+)
+
+// Init generates the Core CA if it doesn't exist yet
+func Init(ctx *context.Context) {
+	exists, err := afero.Exists(ctx.FS, ctx.Cfg.Security.CACertPath)
+	if err != nil {
+		log.Fatalf("error checking the Core CA: %v", err)
+	}
+
+	if !exists {
+		if err := generateCA(ctx); err != nil {
+			log.Fatalf("error generating the Core CA: %v", err)
+		}
+
+		log.Infof("generated the Core CA at '%s'", ctx.Cfg.Security.CACertPath)
+	}
+}
+
+// CertPool returns a certificate pool with the Core CA certificate
+func CertPool(ctx *context.Context) (*x509.CertPool, error) {
+	b, err := afero.ReadFile(ctx.FS, ctx.Cfg.Security.CACertPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the Core CA certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("error parsing the Core CA certificate")
+	}
+
+	return pool, nil
+}
+
+// Issue issues a new client certificate for an agent. It returns the certificate and the private key, both PEM encoded
+func Issue(ctx *context.Context, host string) ([]byte, []byte, error) {
+	caCert, caKey, err := load(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating the certificate private key: %v", err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host, Organization: []string{"DRLM Agent"}},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(ctx.Cfg.Security.AgentCertLifespan),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &k.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error issuing the certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding the certificate private key: %v", err)
+	}
+
+	c := &models.AgentCertificate{
+		AgentHost: host,
+		Serial:    serial.String(),
+		ExpiresAt: tmpl.NotAfter,
+	}
+	if err := c.Add(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+// Verify checks that a client certificate (already verified against the Core CA) hasn't been revoked and returns the agent host
+func Verify(ctx *context.Context, cert *x509.Certificate) (string, error) {
+	c := &models.AgentCertificate{Serial: cert.SerialNumber.String()}
+	if err := c.Load(ctx); err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return "", ErrUnknownCert
+		}
+
+		return "", err
+	}
+
+	if c.Revoked {
+		return "", ErrRevokedCert
+	}
+
+	// The certificates issued before changing the agent host have the previous host, which is now an alias of the agent
+	if c.AgentHost != cert.Subject.CommonName {
+		host, err := models.ResolveAgentHost(ctx, cert.Subject.CommonName)
+		if err != nil {
+			return "", err
+		}
+
+		if c.AgentHost != host {
+			return "", ErrUnknownCert
+		}
+	}
+
+	return c.AgentHost, nil
+}
+
+// Revoke revokes all the client certificates of an agent
+func Revoke(ctx *context.Context, host string) error {
+	return models.AgentCertificateRevokeAll(ctx, host)
+}
+
+// load reads the Core CA certificate and private key
+func load(ctx *context.Context) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	b, err := afero.ReadFile(ctx.FS, ctx.Cfg.Security.CACertPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading the Core CA certificate: %v", err)
+	}
+
+	p, _ := pem.Decode(b)
+	if p == nil {
+		return nil, nil, errors.New("error decoding the Core CA certificate")
+	}
+
+	cert, err := x509.ParseCertificate(p.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing the Core CA certificate: %v", err)
+	}
+
+	b, err = afero.ReadFile(ctx.FS, ctx.Cfg.Security.CAKeyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading the Core CA private key: %v", err)
+	}
+
+	p, _ = pem.Decode(b)
+	if p == nil {
+		return nil, nil, errors.New("error decoding the Core CA private key")
+	}
+
+	k, err := x509.ParseECPrivateKey(p.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing the Core CA private key: %v", err)
+	}
+
+	return cert, k, nil
+}
+
+// generateCA generates a new self signed Core CA and writes the certificate and the private key
+func generateCA(ctx *context.Context) error {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating the private key: %v", err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "DRLM Core CA", Organization: []string{"DRLM"}},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(caLifespan),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		return fmt.Errorf("error generating the certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		return fmt.Errorf("error encoding the private key: %v", err)
+	}
+
+	for _, p := range []string{ctx.Cfg.Security.CACertPath, ctx.Cfg.Security.CAKeyPath} {
+		if err := ctx.FS.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return fmt.Errorf("error creating the CA directory: %v", err)
+		}
+	}
+
+	if err := afero.WriteFile(ctx.FS, ctx.Cfg.Security.CAKeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("error writting the private key: %v", err)
+	}
+
+	if err := afero.WriteFile(ctx.FS, ctx.Cfg.Security.CACertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("error writting the certificate: %v", err)
+	}
+
+	return nil
+}
+
+// newSerial generates a new random certificate serial number
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating the certificate serial number: %v", err)
+	}
+
+	return serial, nil
+}
@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ca_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/ca"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/brainupdaters/drlm-common/pkg/test"
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestCASuite struct {
+	test.Test
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func TestCA(t *testing.T) {
+	suite.Run(t, new(TestCASuite))
+}
+
+func (s *TestCASuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	tests.GenerateCfg(s.T(), s.ctx)
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestCASuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TestCASuite) issue(host string) *x509.Certificate {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_certificates" ("created_at","updated_at","deleted_at","agent_host","serial","expires_at","revoked") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "agent_certificates"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, host, sqlmock.AnyArg(), tests.DBAnyTime{}, false).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectCommit()
+
+	b, _, err := ca.Issue(s.ctx, host)
+	s.Require().NoError(err)
+
+	p, _ := pem.Decode(b)
+	s.Require().NotNil(p)
+
+	cert, err := x509.ParseCertificate(p.Bytes)
+	s.Require().NoError(err)
+
+	return cert
+}
+
+func (s *TestCASuite) TestInit() {
+	s.Run("should generate the CA if it doesn't exist", func() {
+		ca.Init(s.ctx)
+
+		pool, err := ca.CertPool(s.ctx)
+		s.NoError(err)
+		s.Len(pool.Subjects(), 1)
+
+		exists, err := afero.Exists(s.ctx.FS, "cert/ca.key")
+		s.NoError(err)
+		s.True(exists)
+	})
+
+	s.Run("should not overwrite an existing CA", func() {
+		s.Require().NoError(afero.WriteFile(s.ctx.FS, "cert/ca.crt", []byte("ca"), 0644))
+
+		ca.Init(s.ctx)
+
+		b, err := afero.ReadFile(s.ctx.FS, "cert/ca.crt")
+		s.NoError(err)
+		s.Equal("ca", string(b))
+	})
+}
+
+func (s *TestCASuite) TestCertPool() {
+	s.Run("should return an error if the CA certificate doesn't exist", func() {
+		_, err := ca.CertPool(s.ctx)
+		s.EqualError(err, "error reading the Core CA certificate: open cert/ca.crt: file does not exist")
+	})
+
+	s.Run("should return an error if the CA certificate is invalid", func() {
+		s.Require().NoError(afero.WriteFile(s.ctx.FS, "cert/ca.crt", []byte("ca"), 0644))
+
+		_, err := ca.CertPool(s.ctx)
+		s.EqualError(err, "error parsing the Core CA certificate")
+	})
+}
+
+func (s *TestCASuite) TestIssue() {
+	s.Run("should issue a client certificate signed by the CA", func() {
+		ca.Init(s.ctx)
+
+		cert := s.issue("laptop")
+		s.Equal("laptop", cert.Subject.CommonName)
+		s.Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+		s.WithinDuration(time.Now().Add(90*24*time.Hour), cert.NotAfter, time.Minute)
+
+		pool, err := ca.CertPool(s.ctx)
+		s.Require().NoError(err)
+
+		_, err = cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		s.NoError(err)
+	})
+
+	s.Run("should return an error if there's an error storing the certificate", func() {
+		ca.Init(s.ctx)
+
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_certificates" ("created_at","updated_at","deleted_at","agent_host","serial","expires_at","revoked") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "agent_certificates"."id"`)).WillReturnError(errors.New("testing error"))
+
+		_, _, err := ca.Issue(s.ctx, "laptop")
+		s.EqualError(err, "error adding the agent certificate to the DB: testing error")
+	})
+
+	s.Run("should return an error if the CA doesn't exist", func() {
+		s.ctx.FS = afero.NewMemMapFs()
+
+		_, _, err := ca.Issue(s.ctx, "laptop")
+		s.EqualError(err, "error reading the Core CA certificate: open cert/ca.crt: file does not exist")
+	})
+}
+
+func (s *TestCASuite) TestVerify() {
+	ca.Init(s.ctx)
+	cert := s.issue("laptop")
+
+	s.Run("should return the host of the certificate", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((serial = $1)) ORDER BY "agent_certificates"."id" ASC LIMIT 1`)).WithArgs(cert.SerialNumber.String()).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "serial", "revoked"}).AddRow(1, "laptop", cert.SerialNumber.String(), false))
+
+		host, err := ca.Verify(s.ctx, cert)
+		s.NoError(err)
+		s.Equal("laptop", host)
+	})
+
+	s.Run("should return an error if the certificate has been revoked", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((serial = $1)) ORDER BY "agent_certificates"."id" ASC LIMIT 1`)).WithArgs(cert.SerialNumber.String()).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "serial", "revoked"}).AddRow(1, "laptop", cert.SerialNumber.String(), true))
+
+		_, err := ca.Verify(s.ctx, cert)
+		s.Equal(ca.ErrRevokedCert, err)
+	})
+
+	s.Run("should return an error if the certificate hasn't been issued for the agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((serial = $1)) ORDER BY "agent_certificates"."id" ASC LIMIT 1`)).WithArgs(cert.SerialNumber.String()).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "serial", "revoked"}).AddRow(1, "server", cert.SerialNumber.String(), false))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_aliases" WHERE "agent_aliases"."deleted_at" IS NULL AND ((alias = $1)) ORDER BY "agent_aliases"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnError(gorm.ErrRecordNotFound)
+
+		_, err := ca.Verify(s.ctx, cert)
+		s.Equal(ca.ErrUnknownCert, err)
+	})
+
+	s.Run("should return the agent host if the certificate has been issued for a previous host of the agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((serial = $1)) ORDER BY "agent_certificates"."id" ASC LIMIT 1`)).WithArgs(cert.SerialNumber.String()).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "serial", "revoked"}).AddRow(1, "laptop.example.com", cert.SerialNumber.String(), false))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_aliases" WHERE "agent_aliases"."deleted_at" IS NULL AND ((alias = $1)) ORDER BY "agent_aliases"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_id", "alias"}).AddRow(1, 1, "laptop"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((id = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop.example.com"))
+
+		host, err := ca.Verify(s.ctx, cert)
+		s.NoError(err)
+		s.Equal("laptop.example.com", host)
+	})
+
+	s.Run("should return an error if the certificate isn't found", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((serial = $1)) ORDER BY "agent_certificates"."id" ASC LIMIT 1`)).WithArgs(cert.SerialNumber.String()).WillReturnError(gorm.ErrRecordNotFound)
+
+		_, err := ca.Verify(s.ctx, cert)
+		s.Equal(ca.ErrUnknownCert, err)
+	})
+}
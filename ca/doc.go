@@ -0,0 +1,5 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package ca is the internal certificate authority of DRLM Core.
+// It issues, verifies and revokes the agents client certificates
+package ca
@@ -51,6 +51,7 @@ func SetDefaults(v *viper.Viper) {
 	v.AddConfigPath("/etc/drlm")
 
 	v.SetDefault("grpc", map[string]interface{}{
+		"host":      "drlm-core",
 		"port":      50051,
 		"tls":       true,
 		"cert_path": "cert/server.crt",
@@ -61,6 +62,28 @@ func SetDefaults(v *viper.Viper) {
 		"tokens_lifespan": 5 * time.Minute,
 		"login_lifespan":  240 * time.Hour,
 		"ssh_keys_path":   "./ssh",
+		"ssh_key_type":    "ed25519",
+
+		"agent_secret_rotation":     0,
+		"agent_secret_grace_period": 24 * time.Hour,
+
+		"ca_cert_path":        "cert/ca.crt",
+		"ca_key_path":         "cert/ca.key",
+		"agent_cert_lifespan": 90 * 24 * time.Hour,
+		"agent_cert_required": false,
+
+		"agent_join_allow": []string{},
+		"agent_join_deny":  []string{},
+	})
+	v.SetDefault("ssh", map[string]interface{}{
+		"connect_timeout":     30 * time.Second,
+		"command_timeout":     10 * time.Minute,
+		"keepalive_interval":  30 * time.Second,
+		"idle_timeout":        5 * time.Minute,
+		"max_sessions":        4,
+		"agent_socket":        "",
+		"credentials":         map[string]interface{}{},
+		"service_credentials": "",
 	})
 	v.SetDefault("db", map[string]interface{}{
 		"host":     "mariadb",
@@ -77,6 +100,24 @@ func SetDefaults(v *viper.Viper) {
 		"access_key": "drlm3minio",
 		"secret_key": "drlm3minio",
 		"location":   "eu-west-3",
+
+		"plugins_bucket": "drlm-plugins",
+
+		"backend": "minio",
+		"s3": map[string]interface{}{
+			"agent_access_key":        "",
+			"agent_secret_key":        "",
+			"bucket_policy_principal": "",
+		},
+	})
+	v.SetDefault("plugins", map[string]interface{}{
+		"trusted_keys": map[string][]string{},
+
+		"repositories":            map[string]string{},
+		"repositories_cache_path": "./plugins/repositories",
+	})
+	v.SetDefault("admin", map[string]interface{}{
+		"socket": "/var/run/drlm/core.sock",
 	})
 	v.SetDefault("log", map[string]interface{}{
 		"level": "info",
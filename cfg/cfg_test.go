@@ -19,6 +19,7 @@ import (
 func assertCfg(t *testing.T, ctx *context.Context) {
 	assert := assert.New(t)
 
+	assert.Equal("drlm-core", ctx.Cfg.GRPC.Host)
 	assert.Equal(50051, ctx.Cfg.GRPC.Port)
 	assert.Equal(true, ctx.Cfg.GRPC.TLS)
 	assert.Equal("cert/server.crt", ctx.Cfg.GRPC.CertPath)
@@ -29,6 +30,24 @@ func assertCfg(t *testing.T, ctx *context.Context) {
 	assert.Equal(5*time.Minute, ctx.Cfg.Security.TokensLifespan)
 	assert.Equal(240*time.Hour, ctx.Cfg.Security.LoginLifespan)
 	assert.Equal("./ssh", ctx.Cfg.Security.SSHKeysPath)
+	assert.Equal("ed25519", ctx.Cfg.Security.SSHKeyType)
+	assert.Equal(time.Duration(0), ctx.Cfg.Security.AgentSecretRotation)
+	assert.Equal(24*time.Hour, ctx.Cfg.Security.AgentSecretGracePeriod)
+	assert.Equal("cert/ca.crt", ctx.Cfg.Security.CACertPath)
+	assert.Equal("cert/ca.key", ctx.Cfg.Security.CAKeyPath)
+	assert.Equal(90*24*time.Hour, ctx.Cfg.Security.AgentCertLifespan)
+	assert.Equal(false, ctx.Cfg.Security.AgentCertRequired)
+	assert.Empty(ctx.Cfg.Security.AgentJoinAllow)
+	assert.Empty(ctx.Cfg.Security.AgentJoinDeny)
+
+	assert.Equal(30*time.Second, ctx.Cfg.SSH.ConnectTimeout)
+	assert.Equal(10*time.Minute, ctx.Cfg.SSH.CommandTimeout)
+	assert.Equal(30*time.Second, ctx.Cfg.SSH.KeepaliveInterval)
+	assert.Equal(5*time.Minute, ctx.Cfg.SSH.IdleTimeout)
+	assert.Equal(4, ctx.Cfg.SSH.MaxSessions)
+	assert.Equal("", ctx.Cfg.SSH.AgentSocket)
+	assert.Empty(ctx.Cfg.SSH.Credentials)
+	assert.Equal("", ctx.Cfg.SSH.ServiceCredentials)
 
 	assert.Equal("mariadb", ctx.Cfg.DB.Host)
 	assert.Equal(3306, ctx.Cfg.DB.Port)
@@ -43,6 +62,17 @@ func assertCfg(t *testing.T, ctx *context.Context) {
 	assert.Equal("drlm3minio", ctx.Cfg.Minio.AccessKey)
 	assert.Equal("drlm3minio", ctx.Cfg.Minio.SecretKey)
 	assert.Equal("eu-west-3", ctx.Cfg.Minio.Location)
+	assert.Equal("drlm-plugins", ctx.Cfg.Minio.PluginsBucket)
+	assert.Equal("minio", ctx.Cfg.Minio.Backend)
+	assert.Equal("", ctx.Cfg.Minio.S3.AgentAccessKey)
+	assert.Equal("", ctx.Cfg.Minio.S3.AgentSecretKey)
+	assert.Equal("", ctx.Cfg.Minio.S3.BucketPolicyPrincipal)
+
+	assert.Equal(map[string][]string{}, ctx.Cfg.Plugins.TrustedKeys)
+	assert.Equal(map[string]string{}, ctx.Cfg.Plugins.Repositories)
+	assert.Equal("./plugins/repositories", ctx.Cfg.Plugins.RepositoriesCachePath)
+
+	assert.Equal("/var/run/drlm/core.sock", ctx.Cfg.Admin.Socket)
 
 	assert.Equal("info", ctx.Cfg.Log.Level)
 	assert.Equal("/var/log/drlm/core.log", ctx.Cfg.Log.File)
@@ -12,13 +12,17 @@ import (
 type DRLMCoreConfig struct {
 	GRPC     DRLMCoreGRPCConfig     `mapstructure:"grpc"`
 	Security DRLMCoreSecurityConfig `mapstructure:"security"`
+	SSH      DRLMCoreSSHConfig      `mapstructure:"ssh"`
 	DB       DRLMCoreDBConfig       `mapstructure:"db"`
 	Minio    DRLMCoreMinioConfig    `mapstructure:"minio"`
+	Plugins  DRLMCorePluginsConfig  `mapstructure:"plugins"`
+	Admin    DRLMCoreAdminConfig    `mapstructure:"admin"`
 	Log      logger.Config          `mapstructure:"log"`
 }
 
 // DRLMCoreGRPCConfig is the configuration related with the GRPC of DRLM Core
 type DRLMCoreGRPCConfig struct {
+	Host     string `mapstructure:"host"` // The address of the Core that the agents use to connect to it
 	Port     int    `mapstructure:"port"`
 	TLS      bool   `mapstructure:"tls"`
 	CertPath string `mapstructure:"cert_path"`
@@ -32,6 +36,43 @@ type DRLMCoreSecurityConfig struct {
 	TokensLifespan time.Duration `mapstructure:"tokens_lifespan"`
 	LoginLifespan  time.Duration `mapstructure:"login_lifespan"`
 	SSHKeysPath    string        `mapstructure:"ssh_keys_path"`
+	SSHKeyType     string        `mapstructure:"ssh_key_type"` // The type of the Core SSH key pair (`ed25519` or `rsa`)
+
+	AgentSecretRotation    time.Duration `mapstructure:"agent_secret_rotation"`     // How often the agents secrets get rotated. 0 disables the rotation
+	AgentSecretGracePeriod time.Duration `mapstructure:"agent_secret_grace_period"` // How long the previous secret is accepted after a rotation
+
+	CACertPath        string        `mapstructure:"ca_cert_path"`        // The certificate of the Core CA, which issues the agents client certificates
+	CAKeyPath         string        `mapstructure:"ca_key_path"`         // The private key of the Core CA
+	AgentCertLifespan time.Duration `mapstructure:"agent_cert_lifespan"` // How long the agents client certificates are valid
+	AgentCertRequired bool          `mapstructure:"agent_cert_required"` // Whether the agents have to use a client certificate instead of the secret
+
+	AgentJoinAllow []string `mapstructure:"agent_join_allow"` // CIDRs of the agents whose join requests get accepted automatically
+	AgentJoinDeny  []string `mapstructure:"agent_join_deny"`  // CIDRs of the agents whose join requests get rejected automatically. It takes precedence over the allow list
+}
+
+// DRLMCoreSSHConfig is the configuration related with the SSH connections of DRLM Core with the agents
+type DRLMCoreSSHConfig struct {
+	ConnectTimeout    time.Duration `mapstructure:"connect_timeout"`    // How long connecting to a host (through its bastions) can take. 0 disables the timeout
+	CommandTimeout    time.Duration `mapstructure:"command_timeout"`    // How long a single command or file transfer can take. 0 disables the timeout
+	KeepaliveInterval time.Duration `mapstructure:"keepalive_interval"` // How often the keepalives are sent through the open sessions. 0 disables the keepalives
+	IdleTimeout       time.Duration `mapstructure:"idle_timeout"`       // How long an unused session is kept open to be reused. 0 closes the sessions once they're released
+	MaxSessions       int           `mapstructure:"max_sessions"`       // The maximum number of concurrent users of the sessions with a host. 0 disables the limit
+	AgentSocket       string        `mapstructure:"agent_socket"`       // The socket of an SSH agent whose keys can be used to install the agents
+
+	Credentials        map[string]DRLMCoreSSHCredentialsConfig `mapstructure:"credentials"`         // The credentials that can be used to install the agents, by ID
+	ServiceCredentials string                                  `mapstructure:"service_credentials"` // The ID of the credentials used to manage the agents services. If it's empty, the agent SSH user is used
+}
+
+// DRLMCoreSSHCredentialsConfig are credentials held by the Core that can be used to install the agents. The install requests
+// only reference them by ID, so the secrets never leave the Core
+type DRLMCoreSSHCredentialsConfig struct {
+	User           string `mapstructure:"user"`             // The user that logs in. If it's empty, the agent SSH user is used
+	Password       string `mapstructure:"password"`         // The password of the user
+	PrivateKeyPath string `mapstructure:"private_key_path"` // The path of an existing private key, in PEM format
+	Passphrase     string `mapstructure:"passphrase"`       // The passphrase of the private key, if it's encrypted
+	Agent          bool   `mapstructure:"agent"`            // Whether the keys of the SSH agent (ssh.agent_socket) are used
+	Sudo           bool   `mapstructure:"sudo"`             // Whether the operations are run with sudo
+	SudoPassword   string `mapstructure:"sudo_password"`    // The password of the user for sudo
 }
 
 // DRLMCoreDBConfig is the configuration related wtih the DB of the DRLM Core
@@ -52,4 +93,30 @@ type DRLMCoreMinioConfig struct {
 	AccessKey string `mapstructure:"access_key"`
 	SecretKey string `mapstructure:"secret_key"`
 	Location  string `mapstructure:"location"`
+
+	PluginsBucket string `mapstructure:"plugins_bucket"` // The bucket where the plugin catalog artifacts are stored
+
+	Backend string                `mapstructure:"backend"` // The storage backend: `minio` or `s3` (any S3 compatible storage, e.g. Ceph RGW)
+	S3      DRLMCoreMinioS3Config `mapstructure:"s3"`
+}
+
+// DRLMCoreMinioS3Config is the configuration of the generic S3 storage backend of the DRLM Core. All the agents share the
+// same credentials, so they aren't isolated from each other: any agent can access the buckets of the rest of the agents
+type DRLMCoreMinioS3Config struct {
+	AgentAccessKey        string `mapstructure:"agent_access_key"`        // The credentials of the agents. They have to be scoped to the buckets with the `drlm-` prefix
+	AgentSecretKey        string `mapstructure:"agent_secret_key"`        // The secret key of the agents credentials
+	BucketPolicyPrincipal string `mapstructure:"bucket_policy_principal"` // If it's set, the jobs buckets get a policy that grants access to the principal
+}
+
+// DRLMCorePluginsConfig is the configuration related with the plugins of the DRLM Core
+type DRLMCorePluginsConfig struct {
+	TrustedKeys map[string][]string `mapstructure:"trusted_keys"` // The ed25519 public keys (base64 encoded) of the publishers trusted for each plugin repo
+
+	Repositories          map[string]string `mapstructure:"repositories"`            // The URL (`https://`, `http://` or `file://`) of the index of each plugin repository
+	RepositoriesCachePath string            `mapstructure:"repositories_cache_path"` // The directory where the plugin repositories indexes are cached for offline use
+}
+
+// DRLMCoreAdminConfig is the configuration related with the administration actions of the DRLM Core
+type DRLMCoreAdminConfig struct {
+	Socket string `mapstructure:"socket"` // The Unix socket where the running Core receives the administration actions of the CLI
 }
@@ -8,8 +8,11 @@ import (
 	"os"
 	"os/signal"
 
+	"github.com/brainupdaters/drlm-core/admin"
+	"github.com/brainupdaters/drlm-core/agent"
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/ssh"
 	"github.com/brainupdaters/drlm-core/transport/grpc"
 
 	log "github.com/sirupsen/logrus"
@@ -23,6 +26,15 @@ func Main(ctx *context.Context, cancel stdContext.CancelFunc) {
 	go grpc.Serve(ctx)
 	ctx.WG.Add(1)
 
+	go admin.Serve(ctx)
+	ctx.WG.Add(1)
+
+	go agent.RotateSecrets(ctx)
+	ctx.WG.Add(1)
+
+	go agent.RenewCerts(ctx)
+	ctx.WG.Add(1)
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 
@@ -34,6 +46,8 @@ func Main(ctx *context.Context, cancel stdContext.CancelFunc) {
 		cancel()
 		ctx.WG.Wait()
 
+		ssh.CloseAll()
+
 		ctx.DB.Close()
 	}
 }
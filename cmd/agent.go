@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/brainupdaters/drlm-core/admin"
+	"github.com/brainupdaters/drlm-core/agent"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	importBinary      string
+	importConcurrency int
+	importDryRun      bool
+	importAskPass     bool
+	importAskBecome   bool
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Manage the agents",
+}
+
+var agentImportCmd = &cobra.Command{
+	Use:   "import INVENTORY",
+	Short: "Add and install the agents of an Ansible style inventory (INI or YAML)",
+	Long: `Add and install the agents of an Ansible style inventory (INI or YAML).
+
+The agents are installed by the running Core, which waits for the connection of each agent. The SSH keys of the
+inventory are read by this command and sent to the Core along with the passwords.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inv, err := os.Open(args[0])
+		if err != nil {
+			log.Fatalf("error opening the inventory: %v", err)
+		}
+		defer inv.Close()
+
+		ext := filepath.Ext(args[0])
+		hosts, err := agent.ParseInventory(inv, ext == ".yml" || ext == ".yaml")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var f []byte
+		if !importDryRun {
+			if importBinary == "" {
+				log.Fatal("the agent binary is required")
+			}
+
+			f, err = ioutil.ReadFile(importBinary)
+			if err != nil {
+				log.Fatalf("error reading the agent binary: %v", err)
+			}
+		}
+
+		if importAskPass {
+			fmt.Print("SSH password: ")
+			pwd, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println("")
+			if err != nil {
+				log.Fatalf("error reading the SSH password: %v", err)
+			}
+
+			for _, h := range hosts {
+				if h.SSHPassword == "" {
+					h.SSHPassword = string(pwd)
+				}
+			}
+		}
+
+		if importAskBecome {
+			fmt.Print("BECOME password: ")
+			pwd, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Println("")
+			if err != nil {
+				log.Fatalf("error reading the become password: %v", err)
+			}
+
+			for _, h := range hosts {
+				if h.Become && h.BecomePassword == "" {
+					h.BecomePassword = string(pwd)
+				}
+			}
+		}
+
+		for _, h := range hosts {
+			if h.SSHKeyFile != "" {
+				h.SSHKey, err = ioutil.ReadFile(h.SSHKeyFile)
+				if err != nil {
+					log.Fatalf("error reading the SSH private key of '%s': %v", h.Host, err)
+				}
+			}
+		}
+
+		ctx := initCfg()
+
+		var rsp admin.ImportReply
+		if err := admin.Call(ctx, "Import", admin.ImportArgs{
+			Hosts:       hosts,
+			Binary:      f,
+			Concurrency: importConcurrency,
+			DryRun:      importDryRun,
+		}, &rsp); err != nil {
+			log.Fatal(err)
+		}
+
+		names := []string{}
+		for h := range rsp.Results {
+			names = append(names, h)
+		}
+		sort.Strings(names)
+
+		failed := false
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tRESULT")
+		for _, h := range names {
+			if rsp.Results[h] != "" {
+				failed = true
+				fmt.Fprintf(w, "%s\t%s\n", h, rsp.Results[h])
+			} else {
+				fmt.Fprintf(w, "%s\tOK\n", h)
+			}
+		}
+		w.Flush()
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	agentImportCmd.Flags().StringVar(&importBinary, "binary", "", "path of the agent binary to install")
+	agentImportCmd.Flags().IntVar(&importConcurrency, "concurrency", 5, "maximum number of agents installed in parallel")
+	agentImportCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "only check the connectivity and the host keys of the hosts")
+	agentImportCmd.Flags().BoolVar(&importAskPass, "ask-pass", false, "ask for the SSH password of the hosts that don't have one in the inventory")
+	agentImportCmd.Flags().BoolVar(&importAskBecome, "ask-become-pass", false, "ask for the sudo password of the hosts that use become and don't have one in the inventory")
+
+	agentCmd.AddCommand(agentImportCmd)
+	rootCmd.AddCommand(agentCmd)
+}
@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/ssh"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bastionHost     string
+	bastionPort     int
+	bastionUser     string
+	bastionKeyPath  string
+	bastionVia      string
+	bastionSelector string
+)
+
+var bastionCmd = &cobra.Command{
+	Use:   "bastion",
+	Short: "Manage the SSH bastions used to reach the agents",
+	Long: `Manage the SSH bastions used to reach the agents.
+
+The agents are reached through the bastion set in their 'bastion' label or, if they don't have it,
+through the first bastion whose selector matches their labels. A bastion can be reached through
+another bastion (--via), which creates a chain of bastions.`,
+}
+
+var bastionAddCmd = &cobra.Command{
+	Use:   "add NAME",
+	Short: "Add a new bastion. Its host keys are retrieved when it's added",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := models.ParseLabelSelector(bastionSelector); err != nil {
+			log.Fatalf("error adding the bastion: %v", err)
+		}
+
+		ctx := initDB()
+		ssh.Init(ctx)
+
+		chain := []*models.Bastion{}
+		if bastionVia != "" {
+			var err error
+			chain, err = models.BastionChain(ctx, models.LabelSelector{models.BastionLabel: bastionVia})
+			if err != nil {
+				log.Fatalf("error adding the bastion: %v", err)
+			}
+		}
+
+		keys, err := ssh.HostKeys(ctx, chain, bastionHost, bastionPort)
+		if err != nil {
+			log.Fatalf("error adding the bastion: %v", err)
+		}
+
+		if len(keys) == 0 {
+			log.Fatalf("error adding the bastion: no host keys have been retrieved from '%s'", bastionHost)
+		}
+
+		b := &models.Bastion{
+			Name:     args[0],
+			Host:     bastionHost,
+			Port:     bastionPort,
+			User:     bastionUser,
+			KeyPath:  bastionKeyPath,
+			Via:      bastionVia,
+			Selector: bastionSelector,
+		}
+
+		if err := b.Add(ctx, keys); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var bastionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the bastions",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		bastions, err := models.BastionList(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tHOST\tUSER\tVIA\tSELECTOR")
+		for _, b := range bastions {
+			fmt.Fprintf(w, "%s\t%s:%d\t%s\t%s\t%s\n", b.Name, b.Host, b.Port, b.User, b.Via, b.Selector)
+		}
+		w.Flush()
+	},
+}
+
+var bastionRemoveCmd = &cobra.Command{
+	Use:   "remove NAME",
+	Short: "Remove a bastion",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		b := &models.Bastion{Name: args[0]}
+		if err := b.Delete(ctx); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("bastion not found")
+			}
+
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	bastionAddCmd.Flags().StringVar(&bastionHost, "host", "", "host of the bastion")
+	bastionAddCmd.Flags().IntVar(&bastionPort, "port", 22, "SSH port of the bastion")
+	bastionAddCmd.Flags().StringVar(&bastionUser, "user", "drlm", "SSH user of the bastion")
+	bastionAddCmd.Flags().StringVar(&bastionKeyPath, "key", "", "private key used to authenticate with the bastion (defaults to the Core SSH key)")
+	bastionAddCmd.Flags().StringVar(&bastionVia, "via", "", "name of the bastion used to reach this bastion")
+	bastionAddCmd.Flags().StringVar(&bastionSelector, "selector", "", "labels of the agents reached through the bastion (`name=value,name2=value2`)")
+	bastionAddCmd.MarkFlagRequired("host")
+
+	bastionCmd.AddCommand(bastionAddCmd, bastionListCmd, bastionRemoveCmd)
+	rootCmd.AddCommand(bastionCmd)
+}
@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/plugin"
+	"github.com/brainupdaters/drlm-core/ssh"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	catalogRepo     string
+	catalogName     string
+	catalogVersion  string
+	catalogManifest string
+	catalogBinaries []string
+	catalogSigs     []string
+	catalogSelector string
+)
+
+var catalogCmd = &cobra.Command{
+	Use:   "plugin-catalog",
+	Short: "Manage the plugins catalog of the Core",
+}
+
+var catalogUploadCmd = &cobra.Command{
+	Use:   "upload",
+	Short: "Upload a new plugin version to the catalog",
+	Long: `Upload a new plugin version to the catalog.
+
+Each binary is passed with the 'os/arch=path' format (e.g. 'linux/amd64=./drlm-plugin'). The 'any' OS or arch mean that
+the binary supports any OS or arch.
+
+Each binary has to be signed by one of the trusted keys of the plugin repo. The signature is passed with the same format
+(e.g. 'linux/amd64=./drlm-plugin.sig') and it's the raw ed25519 signature of the manifest with the plugin repo, name,
+version and the SHA-256 of the binary.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+		minio.Init(ctx)
+
+		sigs := map[string][]byte{}
+		for _, sig := range catalogSigs {
+			parts := strings.SplitN(sig, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("invalid signature '%s': it has to be 'os/arch=path'", sig)
+			}
+
+			f, err := ioutil.ReadFile(parts[1])
+			if err != nil {
+				log.Fatalf("error reading the signature: %v", err)
+			}
+
+			sigs[parts[0]] = f
+		}
+
+		bins := []plugin.Binary{}
+		for _, b := range catalogBinaries {
+			parts := strings.SplitN(b, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("invalid binary '%s': it has to be 'os/arch=path'", b)
+			}
+
+			arch, pOS, err := plugin.ParseBinaryTarget(parts[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			f, err := ioutil.ReadFile(parts[1])
+			if err != nil {
+				log.Fatalf("error reading the binary: %v", err)
+			}
+
+			bins = append(bins, plugin.Binary{Arch: arch, OS: pOS, Bin: f, Signature: sigs[parts[0]]})
+		}
+
+		var manifest []byte
+		if catalogManifest != "" {
+			var err error
+			manifest, err = ioutil.ReadFile(catalogManifest)
+			if err != nil {
+				log.Fatalf("error reading the manifest: %v", err)
+			}
+		}
+
+		c, err := plugin.Upload(ctx, catalogRepo, catalogName, catalogVersion, manifest, bins)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' uploaded to the catalog\n", c)
+	},
+}
+
+var catalogListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the plugins of the catalog",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		plugins, err := models.CatalogPluginList(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PLUGIN\tVERSION\tMANIFEST\tUPLOADED")
+		for _, c := range plugins {
+			fmt.Fprintf(w, "%s/%s\t%s\t%t\t%s\n", c.Repo, c.Name, c.Version, c.ManifestKey != "", c.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		w.Flush()
+	},
+}
+
+var catalogRemoveCmd = &cobra.Command{
+	Use:   "remove PLUGIN",
+	Short: "Remove a plugin version (repo/name@version) from the catalog",
+	Long: `Remove a plugin version (repo/name@version) from the catalog.
+
+The plugin isn't uninstalled from the agents that have it installed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+		minio.Init(ctx)
+
+		c, err := plugin.ParseCatalogRef(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := plugin.RemoveFromCatalog(ctx, c); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("plugin not found in the catalog")
+			}
+
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' removed from the catalog\n", c)
+	},
+}
+
+var catalogInstallCmd = &cobra.Command{
+	Use:   "install PLUGIN [HOST]",
+	Short: "Install a plugin version (repo/name@version) of the catalog in an agent or in the agents that match a selector",
+	Long: `Install a plugin version (repo/name@version) of the catalog in an agent or in the agents that match a selector.
+
+The binary for the arch and the OS of each agent is used. If an agent has already other versions of the plugin, the
+new version is installed side by side with them.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if (len(args) == 2) == (catalogSelector != "") {
+			log.Fatal("either the agent host or the selector are required")
+		}
+
+		ctx := initDB()
+		minio.Init(ctx)
+		ssh.Init(ctx)
+
+		c, err := plugin.ParseCatalogRef(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(args) == 2 {
+			if err := plugin.InstallFromCatalog(ctx, &models.Agent{Host: args[1]}, c); err != nil {
+				if gorm.IsRecordNotFoundError(err) {
+					log.Fatal("agent or plugin not found")
+				}
+
+				log.Fatal(err)
+			}
+
+			fmt.Printf("plugin '%s' installed in the agent '%s'\n", c, args[1])
+			return
+		}
+
+		sel, err := models.ParseLabelSelector(catalogSelector)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		results, err := plugin.InstallFromCatalogBySelector(ctx, sel, c)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("plugin not found in the catalog")
+			}
+
+			log.Fatal(err)
+		}
+
+		hosts := []string{}
+		for h := range results {
+			hosts = append(hosts, h)
+		}
+		sort.Strings(hosts)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "AGENT\tRESULT")
+		for _, h := range hosts {
+			rslt := "installed"
+			if results[h] != nil {
+				rslt = results[h].Error()
+			}
+
+			fmt.Fprintf(w, "%s\t%s\n", h, rslt)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	catalogUploadCmd.Flags().StringVar(&catalogRepo, "repo", "", "repository of the plugin")
+	catalogUploadCmd.Flags().StringVar(&catalogName, "name", "", "name of the plugin")
+	catalogUploadCmd.Flags().StringVar(&catalogVersion, "version", "", "version of the plugin")
+	catalogUploadCmd.Flags().StringVar(&catalogManifest, "manifest", "", "path of the plugin manifest")
+	catalogUploadCmd.Flags().StringArrayVar(&catalogBinaries, "binary", []string{}, "binary of the plugin (`os/arch=path`). It can be used multiple times")
+	catalogUploadCmd.Flags().StringArrayVar(&catalogSigs, "signature", []string{}, "signature of a binary of the plugin (`os/arch=path`). It can be used multiple times")
+
+	catalogInstallCmd.Flags().StringVar(&catalogSelector, "selector", "", "install the plugin in the agents that match the label selector (`name=value,name2=value2`)")
+
+	catalogCmd.AddCommand(catalogUploadCmd, catalogListCmd, catalogRemoveCmd, catalogInstallCmd)
+	rootCmd.AddCommand(catalogCmd)
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/cfg"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/db"
+	"github.com/brainupdaters/drlm-core/db/migrations"
+	"github.com/brainupdaters/drlm-core/models"
+
+	logger "github.com/brainupdaters/drlm-common/pkg/log"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+var (
+	enrollmentLifespan time.Duration
+	enrollmentUses     int
+	enrollmentLabels   string
+)
+
+var enrollmentCmd = &cobra.Command{
+	Use:   "enrollment-token",
+	Short: "Manage the agents enrollment tokens",
+}
+
+var enrollmentCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a new enrollment token",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		labels, err := models.ParseLabelSelector(enrollmentLabels)
+		if err != nil {
+			log.Fatalf("error creating the enrollment token: %v", err)
+		}
+
+		t, err := agent.NewEnrollmentToken(ctx, enrollmentLifespan, enrollmentUses, labels)
+		if err != nil {
+			log.Fatalf("error creating the enrollment token: %v", err)
+		}
+
+		fmt.Println(t.Token)
+	},
+}
+
+var enrollmentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the enrollment tokens",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		tokens, err := models.EnrollmentTokenList(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tEXPIRES\tUSES\tLABELS")
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%d\t%s\t%d/%d\t%s\n", t.ID, t.ExpiresAt.Format(time.RFC3339), t.Uses, t.MaxUses, t.Labels)
+		}
+		w.Flush()
+	},
+}
+
+var enrollmentRevokeCmd = &cobra.Command{
+	Use:   "revoke ID",
+	Short: "Revoke an enrollment token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatalf("invalid enrollment token ID: %v", err)
+		}
+
+		ctx := initDB()
+
+		t := &models.EnrollmentToken{Model: gorm.Model{ID: uint(id)}}
+		if err := t.Delete(ctx); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("enrollment token not found")
+			}
+
+			log.Fatal(err)
+		}
+	},
+}
+
+// initDB reads the configuration and initializes the DB connection
+func initDB() *context.Context {
+	ctx := initCfg()
+
+	db.Init(ctx)
+	migrations.Migrate(ctx)
+
+	return ctx
+}
+
+// initCfg initializes the configuration, which is enough to send administration actions to the running Core
+func initCfg() *context.Context {
+	ctx := context.Background()
+	ctx.FS = afero.NewOsFs()
+
+	cfg.Init(ctx, cfgFile)
+	logger.Init(ctx.Cfg.Log)
+
+	return ctx
+}
+
+func init() {
+	enrollmentCreateCmd.Flags().DurationVar(&enrollmentLifespan, "lifespan", 24*time.Hour, "how long the token is valid")
+	enrollmentCreateCmd.Flags().IntVar(&enrollmentUses, "uses", 1, "how many agents can join using the token")
+	enrollmentCreateCmd.Flags().StringVar(&enrollmentLabels, "labels", "", "labels that get added to the agents that join using the token (`name=value,name2=value2`)")
+
+	enrollmentCmd.AddCommand(enrollmentCreateCmd, enrollmentListCmd, enrollmentRevokeCmd)
+	rootCmd.AddCommand(enrollmentCmd)
+}
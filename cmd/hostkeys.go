@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"text/tabwriter"
+
+	"github.com/brainupdaters/drlm-core/admin"
+	"github.com/brainupdaters/drlm-core/agent"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var agentHostKeysCmd = &cobra.Command{
+	Use:   "host-keys",
+	Short: "Review the SSH host keys of the agents",
+	Long: `Review the SSH host keys of the agents.
+
+When the SSH host keys of an agent change (e.g. the server has been reinstalled), the new keys are stored as pending
+and the agent can't be reached through SSH until they're accepted.`,
+}
+
+var agentHostKeysShowCmd = &cobra.Command{
+	Use:   "show HOST",
+	Short: "Show the trusted and pending SSH host keys of an agent and their history",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		a := &models.Agent{Host: args[0]}
+		if err := a.Load(ctx); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("agent not found")
+			}
+
+			log.Fatal(err)
+		}
+
+		if err := a.LoadHostKeys(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+		events, err := models.AgentHostKeyEventList(ctx, a.Host)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STATE\tFINGERPRINT")
+		for _, k := range a.HostKeys {
+			state := "trusted"
+			if !k.Trusted {
+				state = "pending"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\n", state, k.Fingerprint)
+		}
+		w.Flush()
+
+		fmt.Println("")
+
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DATE\tACTION\tUSER\tOLD\tNEW")
+		for _, e := range events {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.CreatedAt.Format("2006-01-02 15:04:05"), e.Action, e.Usr, e.OldFingerprints, e.NewFingerprints)
+		}
+		w.Flush()
+	},
+}
+
+var agentHostKeysAcceptCmd = &cobra.Command{
+	Use:   "accept HOST",
+	Short: "Accept the pending SSH host keys of an agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initCfg()
+
+		if err := admin.Call(ctx, "AcceptHostKeys", admin.HostKeysArgs{Host: args[0], Usr: currentUser()}, &admin.Empty{}); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var agentHostKeysRejectCmd = &cobra.Command{
+	Use:   "reject HOST",
+	Short: "Reject the pending SSH host keys of an agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		if err := agent.RejectHostKeys(ctx, &models.Agent{Host: args[0]}, currentUser()); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("agent not found")
+			}
+
+			log.Fatal(err)
+		}
+	},
+}
+
+// currentUser returns the name of the user running the command, which is stored in the audit trails
+func currentUser() string {
+	u, err := user.Current()
+	if err != nil {
+		log.Fatalf("error getting the current user: %v", err)
+	}
+
+	return u.Username
+}
+
+func init() {
+	agentHostKeysCmd.AddCommand(agentHostKeysShowCmd, agentHostKeysAcceptCmd, agentHostKeysRejectCmd)
+	agentCmd.AddCommand(agentHostKeysCmd)
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/admin"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/plugin"
+
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var agentPluginCmd = &cobra.Command{
+	Use:   "plugin",
+	Short: "Manage the plugins of the agents",
+}
+
+var agentPluginListCmd = &cobra.Command{
+	Use:   "list HOST",
+	Short: "List the plugins of an agent with their versions, constraints and install time",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		plugins, err := plugin.List(ctx, &models.Agent{Host: args[0]})
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("agent not found")
+			}
+
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PLUGIN\tVERSION\tPINNED\tDEPRECATED\tARCH\tOS\tINSTALLED")
+		for _, p := range plugins {
+			arch := []string{}
+			for _, a := range p.Arch {
+				arch = append(arch, drlm.Arch(a).String())
+			}
+
+			pOS := []string{}
+			for _, o := range p.OS {
+				pOS = append(pOS, drlm.OS(o).String())
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%t\t%t\t%s\t%s\t%s\n", p, p.Version, p.Pinned, p.Deprecated, strings.Join(arch, ","), strings.Join(pOS, ","), p.InstalledAt.Format(time.RFC3339))
+		}
+		w.Flush()
+	},
+}
+
+var agentPluginRollbackCmd = &cobra.Command{
+	Use:   "rollback HOST PLUGIN",
+	Short: "Switch a plugin (repo/name) of an agent back to the version installed before the current one",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initCfg()
+
+		var rsp admin.PluginReply
+		if err := admin.Call(ctx, "RollbackPlugin", admin.PluginArgs{Host: args[0], Plugin: args[1]}, &rsp); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' rolled back to the version '%s'\n", args[1], rsp.Version)
+	},
+}
+
+var agentPluginPinCmd = &cobra.Command{
+	Use:   "pin HOST PLUGIN@VERSION",
+	Short: "Pin a version of a plugin of an agent, so the new jobs of the plugin use it if it matches their constraint",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		p := loadPluginVersion(ctx, args[0], args[1])
+		if err := p.Pin(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' version '%s' pinned\n", p, p.Version)
+	},
+}
+
+var agentPluginUnpinCmd = &cobra.Command{
+	Use:   "unpin HOST PLUGIN@VERSION",
+	Short: "Unpin a version of a plugin of an agent",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		p := loadPluginVersion(ctx, args[0], args[1])
+		p.Pinned = false
+		if err := p.Update(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' version '%s' unpinned\n", p, p.Version)
+	},
+}
+
+var agentPluginDeprecateCmd = &cobra.Command{
+	Use:   "deprecate HOST PLUGIN@VERSION",
+	Short: "Deprecate a version of a plugin of an agent, so the new jobs of the plugin don't use it",
+	Long: `Deprecate a version of a plugin of an agent, so the new jobs of the plugin don't use it.
+
+The jobs that are already scheduled keep using the version.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		p := loadPluginVersion(ctx, args[0], args[1])
+		p.Deprecated = true
+		if err := p.Update(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' version '%s' deprecated\n", p, p.Version)
+	},
+}
+
+var agentPluginUndeprecateCmd = &cobra.Command{
+	Use:   "undeprecate HOST PLUGIN@VERSION",
+	Short: "Undo the deprecation of a version of a plugin of an agent",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		p := loadPluginVersion(ctx, args[0], args[1])
+		p.Deprecated = false
+		if err := p.Update(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' version '%s' undeprecated\n", p, p.Version)
+	},
+}
+
+// loadPluginVersion loads the exact version (`repo/name@version`) of a plugin of an agent
+func loadPluginVersion(ctx *context.Context, host, ref string) *models.Plugin {
+	if _, v := models.ParsePluginRef(ref); v == "" {
+		log.Fatal("the plugin version is required: it has to be 'repo/name@version'")
+	}
+
+	p, err := plugin.Load(ctx, &models.Agent{Host: host}, ref)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			log.Fatal("agent or plugin version not found")
+		}
+
+		log.Fatal(err)
+	}
+
+	return p
+}
+
+func init() {
+	agentPluginCmd.AddCommand(agentPluginListCmd, agentPluginRollbackCmd, agentPluginPinCmd, agentPluginUnpinCmd, agentPluginDeprecateCmd, agentPluginUndeprecateCmd)
+	agentCmd.AddCommand(agentPluginCmd)
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/plugin"
+	"github.com/brainupdaters/drlm-core/scheduler"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pluginConfigGroup string
+	pluginConfigAgent string
+)
+
+var pluginConfigCmd = &cobra.Command{
+	Use:   "plugin-config",
+	Short: "Manage the config overrides of the plugins for groups of agents and for agents",
+	Long: `Manage the config overrides of the plugins for groups of agents and for agents.
+
+The config of the jobs is layered: the defaults of the plugin manifest, then the overrides of the groups whose label
+selector matches the agent labels, then the override of the agent and then the config of the job. The configs are JSON
+objects: the later layers override the options of the previous ones and the 'null' options remove them.`,
+}
+
+var pluginConfigSetCmd = &cobra.Command{
+	Use:   "set PLUGIN CONFIG",
+	Short: "Set the config override (a JSON object) of a plugin (repo/name) for a group of agents or for an agent",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		scope, target := pluginConfigTarget()
+		ctx := initDB()
+
+		if err := plugin.SetConfig(ctx, args[0], scope, target, args[1]); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("agent not found")
+			}
+
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' config set for the %s '%s'\n", args[0], scope, target)
+	},
+}
+
+var pluginConfigUnsetCmd = &cobra.Command{
+	Use:   "unset PLUGIN",
+	Short: "Remove the config override of a plugin (repo/name) for a group of agents or for an agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		scope, target := pluginConfigTarget()
+		ctx := initDB()
+
+		if err := plugin.UnsetConfig(ctx, args[0], scope, target); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("plugin '%s' config removed for the %s '%s'\n", args[0], scope, target)
+	},
+}
+
+var pluginConfigListCmd = &cobra.Command{
+	Use:   "list PLUGIN",
+	Short: "List the config overrides of a plugin (repo/name)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		cfgs, err := plugin.ConfigList(ctx, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SCOPE\tTARGET\tCONFIG")
+		for _, c := range cfgs {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Scope, c.Target, c.Config)
+		}
+		w.Flush()
+	},
+}
+
+var pluginConfigPreviewCmd = &cobra.Command{
+	Use:   "preview HOST JOB [CONFIG]",
+	Short: "Show the effective config of a job (repo/name or repo/name@constraint) of an agent without scheduling it",
+	Args:  cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		config := ""
+		if len(args) == 3 {
+			config = args[2]
+		}
+
+		cfg, err := scheduler.PreviewJobConfig(ctx, args[0], args[1], config)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("agent not found")
+			}
+
+			log.Fatal(err)
+		}
+
+		fmt.Println(cfg)
+	},
+}
+
+// pluginConfigTarget returns the scope and the target of the override from the flags
+func pluginConfigTarget() (models.PluginConfigScope, string) {
+	switch {
+	case pluginConfigGroup != "" && pluginConfigAgent != "":
+		log.Fatal("the --group and --agent flags can't be used at the same time")
+
+	case pluginConfigGroup != "":
+		return models.PluginConfigScopeGroup, pluginConfigGroup
+
+	case pluginConfigAgent != "":
+		return models.PluginConfigScopeAgent, pluginConfigAgent
+	}
+
+	log.Fatal("either the --group or the --agent flag is required")
+	return 0, ""
+}
+
+func init() {
+	for _, c := range []*cobra.Command{pluginConfigSetCmd, pluginConfigUnsetCmd} {
+		c.Flags().StringVar(&pluginConfigGroup, "group", "", "labels of the agents of the group (`name=value,name2=value2`)")
+		c.Flags().StringVar(&pluginConfigAgent, "agent", "", "host of the agent")
+	}
+
+	pluginConfigCmd.AddCommand(pluginConfigSetCmd, pluginConfigUnsetCmd, pluginConfigListCmd, pluginConfigPreviewCmd)
+	rootCmd.AddCommand(pluginConfigCmd)
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/admin"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var agentQuarantineCmd = &cobra.Command{
+	Use:   "quarantine HOST",
+	Short: "Stop all the activity of an agent, keeping its history and storage",
+	Long: `Stop all the activity of an agent, keeping its history and storage.
+
+The quarantined agent can't connect to the Core nor be reached through SSH, its jobs are held (they aren't failed) and its
+Minio user is disabled. The agent keeps being quarantined until it's enabled again. The quarantine is done by the running
+Core, which stops sending jobs to the agent and closes its SSH sessions. It fails if the storage backend can't disable
+the agent user.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initCfg()
+
+		if err := admin.Call(ctx, "Quarantine", admin.AgentArgs{Host: args[0]}, &admin.Empty{}); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("agent '%s' quarantined\n", args[0])
+	},
+}
+
+var agentEnableCmd = &cobra.Command{
+	Use:   "enable HOST",
+	Short: "Enable a quarantined agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initCfg()
+
+		if err := admin.Call(ctx, "Enable", admin.AgentArgs{Host: args[0]}, &admin.Empty{}); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("agent '%s' enabled\n", args[0])
+	},
+}
+
+func init() {
+	agentCmd.AddCommand(agentQuarantineCmd, agentEnableCmd)
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/admin"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var agentRenameCmd = &cobra.Command{
+	Use:   "rename HOST NEW_HOST",
+	Short: "Change the host (address) of an agent, keeping its jobs, plugins and storage",
+	Long: `Change the host (address) of an agent, keeping its jobs, plugins and storage.
+
+All the references to the agent are updated at once, and the previous host becomes an alias of the agent, so it's
+still recognized if it connects or requests to join from it. The change is done by the running Core, which also updates
+the agent scheduled jobs and connection.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initCfg()
+
+		if err := admin.Call(ctx, "ChangeHost", admin.ChangeHostArgs{Host: args[0], NewHost: args[1]}, &admin.Empty{}); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("agent '%s' renamed to '%s'\n", args[0], args[1])
+	},
+}
+
+var agentAliasCmd = &cobra.Command{
+	Use:   "alias HOST [ALIAS]",
+	Short: "List the aliases of an agent or add a new one",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		a := &models.Agent{Host: args[0]}
+		if err := a.Load(ctx); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("agent not found")
+			}
+
+			log.Fatal(err)
+		}
+
+		if len(args) == 2 {
+			if err := a.AddAlias(ctx, args[1]); err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("alias '%s' added to the agent '%s'\n", args[1], a.Host)
+			return
+		}
+
+		if err := a.LoadAliases(ctx); err != nil {
+			log.Fatal(err)
+		}
+
+		for _, al := range a.Aliases {
+			fmt.Println(al.Alias)
+		}
+	},
+}
+
+var agentUnaliasCmd = &cobra.Command{
+	Use:   "unalias HOST ALIAS",
+	Short: "Remove an alias of an agent",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		a := &models.Agent{Host: args[0]}
+		if err := a.Load(ctx); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("agent not found")
+			}
+
+			log.Fatal(err)
+		}
+
+		if err := a.DeleteAlias(ctx, args[1]); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("alias not found")
+			}
+
+			log.Fatal(err)
+		}
+
+		fmt.Printf("alias '%s' removed from the agent '%s'\n", args[1], a.Host)
+	},
+}
+
+func init() {
+	agentCmd.AddCommand(agentRenameCmd, agentAliasCmd, agentUnaliasCmd)
+}
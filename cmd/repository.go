@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/plugin"
+	"github.com/brainupdaters/drlm-core/ssh"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var repositoryCmd = &cobra.Command{
+	Use:   "plugin-repo",
+	Short: "Manage the plugin repositories of the Core",
+}
+
+var repositoryRefreshCmd = &cobra.Command{
+	Use:   "refresh [REPO]",
+	Short: "Download the index of a plugin repository or of all the configured repositories and cache it",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		repos := args
+		if len(repos) == 0 {
+			repos = plugin.Repositories(ctx)
+		}
+
+		failed := false
+		for _, r := range repos {
+			idx, err := plugin.RefreshRepository(ctx, r)
+			if err != nil {
+				log.Errorf("error refreshing the plugin repository '%s': %v", r, err)
+				failed = true
+				continue
+			}
+
+			fmt.Printf("plugin repository '%s' refreshed: %d plugins\n", r, len(idx.Plugins))
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var repositorySearchCmd = &cobra.Command{
+	Use:   "search [QUERY]",
+	Short: "Search the plugins of the repositories by their name or description",
+	Long: `Search the plugins of the repositories by their name or description.
+
+The cached indexes are used, so the search works offline. Use 'plugin-repo refresh' to update them.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+
+		query := ""
+		if len(args) == 1 {
+			query = args[0]
+		}
+
+		results, err := plugin.SearchRepositories(ctx, query)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PLUGIN\tVERSIONS\tDESCRIPTION")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s/%s\t%s\t%s\n", r.Repo, r.Name, strings.Join(r.Versions, ","), r.Description)
+		}
+		w.Flush()
+	},
+}
+
+var repositoryInstallCmd = &cobra.Command{
+	Use:   "install PLUGIN HOST",
+	Short: "Install a plugin (repo/name or repo/name@constraint) of a repository in an agent",
+	Long: `Install a plugin (repo/name or repo/name@constraint) of a repository in an agent.
+
+The highest version that matches the constraint (e.g. 'default/tar@^1.2') is installed side by side with the other
+installed versions of the plugin. The binary has to match the checksum of the index and be signed by one of the trusted
+keys of the plugin repo.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initDB()
+		ssh.Init(ctx)
+
+		v, err := plugin.InstallFromRepository(ctx, &models.Agent{Host: args[1]}, args[0])
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				log.Fatal("agent not found")
+			}
+
+			log.Fatal(err)
+		}
+
+		ref, _ := models.ParsePluginRef(args[0])
+		fmt.Printf("plugin '%s' version '%s' installed in the agent '%s'\n", ref, v, args[1])
+	},
+}
+
+func init() {
+	repositoryCmd.AddCommand(repositoryRefreshCmd, repositorySearchCmd, repositoryInstallCmd)
+	rootCmd.AddCommand(repositoryCmd)
+}
@@ -4,12 +4,14 @@ package cmd
 
 import (
 	"github.com/brainupdaters/drlm-core/auth"
+	"github.com/brainupdaters/drlm-core/ca"
 	"github.com/brainupdaters/drlm-core/cfg"
 	"github.com/brainupdaters/drlm-core/cli"
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/db"
 	"github.com/brainupdaters/drlm-core/db/migrations"
 	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/ssh"
 
 	logger "github.com/brainupdaters/drlm-common/pkg/log"
 	log "github.com/sirupsen/logrus"
@@ -33,7 +35,9 @@ var rootCmd = &cobra.Command{
 		db.Init(ctx)
 		migrations.Migrate(ctx)
 		auth.Init(ctx)
+		ca.Init(ctx)
 		minio.Init(ctx)
+		ssh.Init(ctx)
 
 		cli.Main(ctx, cancel)
 	},
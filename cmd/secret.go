@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/admin"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var rotateSecretSkipMinio bool
+
+var agentRotateSecretCmd = &cobra.Command{
+	Use:   "rotate-secret HOST",
+	Short: "Rotate the secret (and the Minio secret key) of an agent",
+	Long: `Rotate the secret (and the Minio secret key) of an agent.
+
+The rotation is done by the running Core, which sends the new credentials through the agent connection, so the agent
+has to be connected. The previous secret keeps being accepted until the agent connects with the new one or the grace
+period ends. The new Minio secret key is applied at the same time it's sent to the agent.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initCfg()
+
+		if err := admin.Call(ctx, "RotateSecret", admin.RotateSecretArgs{Host: args[0], Minio: !rotateSecretSkipMinio}, &admin.Empty{}); err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("agent '%s' secret rotated\n", args[0])
+	},
+}
+
+func init() {
+	agentRotateSecretCmd.Flags().BoolVar(&rotateSecretSkipMinio, "skip-minio", false, "keep the current Minio secret key of the agent")
+
+	agentCmd.AddCommand(agentRotateSecretCmd)
+}
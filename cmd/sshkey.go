@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brainupdaters/drlm-core/admin"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var rotateKeyPartial bool
+
+var sshKeyCmd = &cobra.Command{
+	Use:   "ssh-key",
+	Short: "Manage the Core SSH key",
+}
+
+var sshKeyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the Core SSH key",
+	Long: `Rotate the Core SSH key.
+
+The rotation is done by the running Core, which deploys the new public key to the agents managed through SSH and to the
+bastions that use the Core SSH key, and then removes the previous one from them. If the new key can't be deployed to all
+of them, the rotation is reverted, unless it's partial: then the previous key is kept authorized (and used) in the hosts
+that failed. Running the rotation again while a partial rotation is pending resumes it instead of generating a new key.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := initCfg()
+
+		var rsp admin.RotateKeyReply
+		if err := admin.Call(ctx, "RotateKey", admin.RotateKeyArgs{Partial: rotateKeyPartial}, &rsp); err != nil {
+			log.Fatal(err)
+		}
+
+		hosts := []string{}
+		for h := range rsp.Results {
+			hosts = append(hosts, h)
+		}
+		sort.Strings(hosts)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tRESULT")
+		for _, h := range hosts {
+			rslt := "deployed"
+			if rsp.Results[h] != "" {
+				rslt = rsp.Results[h]
+			}
+
+			fmt.Fprintf(w, "%s\t%s\n", h, rslt)
+		}
+		w.Flush()
+
+		if rsp.Reverted {
+			log.Fatal("the new key couldn't be deployed to all the hosts and the rotation has been reverted (use --partial to keep the previous key in the failed hosts)")
+		}
+
+		if len(rsp.Pending) != 0 {
+			fmt.Printf("\nthe hosts that still have the previous key authorized are: %s\n", strings.Join(rsp.Pending, ", "))
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	sshKeyRotateCmd.Flags().BoolVar(&rotateKeyPartial, "partial", false, "keep the previous key authorized in the hosts where the new one can't be deployed instead of reverting the rotation")
+
+	sshKeyCmd.AddCommand(sshKeyRotateCmd)
+	rootCmd.AddCommand(sshKeyCmd)
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/brainupdaters/drlm-core/admin"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	upgradeBinary    string
+	upgradeVersion   string
+	upgradeSelector  string
+	upgradeBatchSize int
+)
+
+var agentUpgradeCmd = &cobra.Command{
+	Use:   "upgrade [HOST]",
+	Short: "Upgrade the binary of an agent or of the agents that match a selector",
+	Long: `Upgrade the binary of an agent or of the agents that match a selector.
+
+The upgrade is done by the running Core, which replaces the binary through SSH, restarts the agent and waits until it
+reconnects reporting the new version. If it doesn't, the previous binary gets restored. The agents that match the
+selector are upgraded in batches, and if an upgrade of a batch fails, the next batches aren't upgraded.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if (len(args) == 1) == (upgradeSelector != "") {
+			log.Fatal("either the agent host or the selector are required")
+		}
+
+		if upgradeBinary == "" || upgradeVersion == "" {
+			log.Fatal("the agent binary and its version are required")
+		}
+
+		f, err := ioutil.ReadFile(upgradeBinary)
+		if err != nil {
+			log.Fatalf("error reading the agent binary: %v", err)
+		}
+
+		var host string
+		if len(args) == 1 {
+			host = args[0]
+		}
+
+		ctx := initCfg()
+
+		var rsp admin.UpgradeReply
+		if err := admin.Call(ctx, "Upgrade", admin.UpgradeArgs{
+			Host:      host,
+			Selector:  upgradeSelector,
+			Version:   upgradeVersion,
+			Binary:    f,
+			BatchSize: upgradeBatchSize,
+		}, &rsp); err != nil {
+			log.Fatal(err)
+		}
+
+		hosts := []string{}
+		for h := range rsp.Results {
+			hosts = append(hosts, h)
+		}
+		sort.Strings(hosts)
+
+		failed := false
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "AGENT\tRESULT")
+		for _, h := range hosts {
+			rslt := "upgraded"
+			if rsp.Results[h] != "" {
+				failed = true
+				rslt = rsp.Results[h]
+			}
+
+			fmt.Fprintf(w, "%s\t%s\n", h, rslt)
+		}
+		w.Flush()
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	agentUpgradeCmd.Flags().StringVar(&upgradeBinary, "binary", "", "path of the new agent binary")
+	agentUpgradeCmd.Flags().StringVar(&upgradeVersion, "version", "", "version of the new agent binary, that the agents report once upgraded")
+	agentUpgradeCmd.Flags().StringVar(&upgradeSelector, "selector", "", "label selector of the agents to upgrade (`name=value,name2=value2`)")
+	agentUpgradeCmd.Flags().IntVar(&upgradeBatchSize, "batch-size", 1, "number of agents upgraded in parallel")
+
+	agentCmd.AddCommand(agentUpgradeCmd)
+}
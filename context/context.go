@@ -71,3 +71,28 @@ func WithCancel() (*Context, context.CancelFunc) {
 
 	return ctx, cancel
 }
+
+// WithRequest creates a new context with the values of ctx, which gets cancelled when either ctx or the request context
+// get cancelled. The WaitGroup isn't shared with ctx, so the new context can't be used to track the background goroutines
+func WithRequest(ctx *Context, req context.Context) (*Context, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(req)
+
+	if ctx.ctx != nil {
+		go func() {
+			select {
+			case <-ctx.ctx.Done():
+				cancel()
+			case <-reqCtx.Done():
+			}
+		}()
+	}
+
+	return &Context{
+		ctx:           reqCtx,
+		FS:            ctx.FS,
+		Cfg:           ctx.Cfg,
+		DB:            ctx.DB,
+		MinioCli:      ctx.MinioCli,
+		MinioAdminCli: ctx.MinioAdminCli,
+	}, cancel
+}
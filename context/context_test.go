@@ -3,6 +3,7 @@
 package context_test
 
 import (
+	stdContext "context"
 	"testing"
 
 	"github.com/brainupdaters/drlm-core/context"
@@ -66,3 +67,30 @@ func (s *TestContextSuite) TestTODO() {
 func (s *TestContextSuite) TestWithCancel() {
 	context.WithCancel()
 }
+
+func (s *TestContextSuite) TestWithRequest() {
+	s.Run("should get cancelled when the request gets cancelled", func() {
+		ctx := context.Background()
+		req, cancelReq := stdContext.WithCancel(stdContext.Background())
+
+		reqCtx, cancel := context.WithRequest(ctx, req)
+		defer cancel()
+
+		cancelReq()
+		<-reqCtx.Done()
+
+		s.NoError(ctx.Err())
+	})
+
+	s.Run("should get cancelled when the parent context gets cancelled", func() {
+		ctx, cancelCtx := context.WithCancel()
+
+		reqCtx, cancel := context.WithRequest(ctx, stdContext.Background())
+		defer cancel()
+
+		cancelCtx()
+		<-reqCtx.Done()
+
+		s.EqualError(reqCtx.Err(), "context canceled")
+	})
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/jinzhu/gorm"
+)
+
+// moveHostKeys creates the agents host keys tables, moves the `|||` joined host keys of the agents to them (as trusted keys)
+// and drops the old column
+func moveHostKeys(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&models.AgentHostKey{}, &models.AgentHostKeyEvent{}).Error; err != nil {
+		return err
+	}
+
+	// The agents table has been created without the column
+	if !tx.Dialect().HasColumn("agents", "ssh_host_keys") {
+		return nil
+	}
+
+	rows := []struct {
+		Host        string
+		SSHHostKeys string
+	}{}
+	if err := tx.Table("agents").Select("host, ssh_host_keys").Where("deleted_at IS NULL").Scan(&rows).Error; err != nil {
+		return fmt.Errorf("error reading the agents host keys: %v", err)
+	}
+
+	for _, r := range rows {
+		for _, k := range strings.Split(r.SSHHostKeys, "|||") {
+			if k == "" {
+				continue
+			}
+
+			fp, err := models.HostKeyFingerprint(k)
+			if err != nil {
+				return fmt.Errorf("error moving the agent '%s' host keys: %v", r.Host, err)
+			}
+
+			if err := tx.Create(&models.AgentHostKey{AgentHost: r.Host, Key: k, Fingerprint: fp, Trusted: true}).Error; err != nil {
+				return fmt.Errorf("error moving the agent '%s' host keys: %v", r.Host, err)
+			}
+		}
+	}
+
+	return tx.Model(&models.Agent{}).DropColumn("ssh_host_keys").Error
+}
+
+// restoreHostKeys adds back the `|||` joined host keys column of the agents, fills it with their trusted keys and drops the host keys tables
+func restoreHostKeys(tx *gorm.DB) error {
+	if err := tx.Exec("ALTER TABLE agents ADD COLUMN ssh_host_keys varchar(9999)").Error; err != nil {
+		return fmt.Errorf("error adding the column 'ssh_host_keys' to 'agents': %v", err)
+	}
+
+	var keys []*models.AgentHostKey
+	if err := tx.Where("agent_host <> '' AND trusted = ?", true).Order("id").Find(&keys).Error; err != nil {
+		return fmt.Errorf("error reading the agents host keys: %v", err)
+	}
+
+	joined := map[string][]string{}
+	for _, k := range keys {
+		joined[k.AgentHost] = append(joined[k.AgentHost], k.Key)
+	}
+
+	for host, k := range joined {
+		if err := tx.Table("agents").Where("host = ?", host).Update("ssh_host_keys", strings.Join(k, "|||")).Error; err != nil {
+			return fmt.Errorf("error restoring the agent '%s' host keys: %v", host, err)
+		}
+	}
+
+	return tx.DropTable("agent_host_keys", "agent_host_key_events").Error
+}
+
+// moveBastionHostKeys moves the `|||` joined host keys of the bastions to the host keys table (as trusted keys) and drops the old column
+func moveBastionHostKeys(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&models.AgentHostKey{}).Error; err != nil {
+		return err
+	}
+
+	// The bastions table has been created without the column
+	if !tx.Dialect().HasColumn("bastions", "host_keys") {
+		return nil
+	}
+
+	rows := []struct {
+		Name     string
+		HostKeys string
+	}{}
+	if err := tx.Table("bastions").Select("name, host_keys").Where("deleted_at IS NULL").Scan(&rows).Error; err != nil {
+		return fmt.Errorf("error reading the bastions host keys: %v", err)
+	}
+
+	for _, r := range rows {
+		for _, k := range strings.Split(r.HostKeys, "|||") {
+			if k == "" {
+				continue
+			}
+
+			fp, err := models.HostKeyFingerprint(k)
+			if err != nil {
+				return fmt.Errorf("error moving the bastion '%s' host keys: %v", r.Name, err)
+			}
+
+			if err := tx.Create(&models.AgentHostKey{BastionName: r.Name, Key: k, Fingerprint: fp, Trusted: true}).Error; err != nil {
+				return fmt.Errorf("error moving the bastion '%s' host keys: %v", r.Name, err)
+			}
+		}
+	}
+
+	return tx.Model(&models.Bastion{}).DropColumn("host_keys").Error
+}
+
+// restoreBastionHostKeys adds back the `|||` joined host keys column of the bastions, fills it with the keys of the host keys table
+// and removes the bastions keys from the table
+func restoreBastionHostKeys(tx *gorm.DB) error {
+	if err := tx.Exec("ALTER TABLE bastions ADD COLUMN host_keys varchar(9999)").Error; err != nil {
+		return fmt.Errorf("error adding the column 'host_keys' to 'bastions': %v", err)
+	}
+
+	var keys []*models.AgentHostKey
+	if err := tx.Where("bastion_name <> ''").Order("id").Find(&keys).Error; err != nil {
+		return fmt.Errorf("error reading the bastions host keys: %v", err)
+	}
+
+	joined := map[string][]string{}
+	for _, k := range keys {
+		joined[k.BastionName] = append(joined[k.BastionName], k.Key)
+	}
+
+	for name, k := range joined {
+		if err := tx.Table("bastions").Where("name = ?", name).Update("host_keys", strings.Join(k, "|||")).Error; err != nil {
+			return fmt.Errorf("error restoring the bastion '%s' host keys: %v", name, err)
+		}
+	}
+
+	if err := tx.Unscoped().Where("bastion_name <> ''").Delete(&models.AgentHostKey{}).Error; err != nil {
+		return fmt.Errorf("error removing the bastions host keys: %v", err)
+	}
+
+	return tx.Model(&models.AgentHostKey{}).DropColumn("bastion_name").Error
+}
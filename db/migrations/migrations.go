@@ -50,6 +50,199 @@ func Migrate(ctx *context.Context) {
 				return tx.DropTable("plugins").Error
 			},
 		},
+		{
+			ID: "202003171030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.AgentInventory{}, &models.AgentInventoryDevice{}, &models.AgentInventoryNIC{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("agent_inventory_nics", "agent_inventory_devices", "agent_inventories").Error
+			},
+		},
+		{
+			ID: "202003181215",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.AgentSecretRotation{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("agent_secret_rotations").Error
+			},
+		},
+		{
+			ID: "202003191140",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.AgentLabel{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("agent_labels").Error
+			},
+		},
+		{
+			ID: "202003201030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.AgentCertificate{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("agent_certificates").Error
+			},
+		},
+		{
+			ID: "202003211130",
+			Migrate: func(tx *gorm.DB) error {
+				return hashSecrets(tx)
+			},
+			Rollback: func(tx *gorm.DB) error {
+				// The plain secrets can't be recovered from the hashes
+				return nil
+			},
+		},
+		{
+			ID: "202003221000",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.EnrollmentToken{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("enrollment_tokens").Error
+			},
+		},
+		{
+			ID: "202003231030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Bastion{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("bastions").Error
+			},
+		},
+		{
+			ID: "202003241030",
+			Migrate: func(tx *gorm.DB) error {
+				return moveHostKeys(tx)
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return restoreHostKeys(tx)
+			},
+		},
+		{
+			ID: "202003251030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Agent{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Model(&models.Agent{}).DropColumn("quarantined").Error
+			},
+		},
+		{
+			ID: "202003261030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.AgentAlias{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("agent_aliases").Error
+			},
+		},
+		{
+			ID: "202003271030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Plugin{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, c := range []string{"arch_list", "os_list", "previous_version", "installed_at"} {
+					if err := tx.Model(&models.Plugin{}).DropColumn(c).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+		{
+			ID: "202003281030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.CatalogPlugin{}, &models.CatalogPluginBinary{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("catalog_plugins", "catalog_plugin_binaries").Error
+			},
+		},
+		{
+			ID: "202003291030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.CatalogPluginBinary{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Model(&models.CatalogPluginBinary{}).DropColumn("signature").Error
+			},
+		},
+		{
+			ID: "202003301030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.PluginManifest{}, &models.PluginManifestArch{}, &models.PluginManifestPlatform{}, &models.PluginManifestConfig{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("plugin_manifests", "plugin_manifest_arches", "plugin_manifest_platforms", "plugin_manifest_configs").Error
+			},
+		},
+		{
+			ID: "202003311030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Plugin{}, &models.Job{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				for _, c := range []string{"pinned", "deprecated"} {
+					if err := tx.Model(&models.Plugin{}).DropColumn(c).Error; err != nil {
+						return err
+					}
+				}
+
+				return tx.Model(&models.Job{}).DropColumn("plugin_version").Error
+			},
+		},
+		{
+			ID: "202004021030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.PluginConfig{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("plugin_configs").Error
+			},
+		},
+		{
+			ID: "202004031030",
+			Migrate: func(tx *gorm.DB) error {
+				return moveBastionHostKeys(tx)
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return restoreBastionHostKeys(tx)
+			},
+		},
+		{
+			ID: "202004041030",
+			Migrate: func(tx *gorm.DB) error {
+				return movePreviousPluginVersions(tx)
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return restorePreviousPluginVersionsColumn(tx)
+			},
+		},
+		{
+			ID: "202004051030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Job{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Model(&models.Job{}).DropColumn("plugin_constraint").Error
+			},
+		},
+		{
+			ID: "202004061030",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.Job{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.Model(&models.Job{}).DropColumn("config_template").Error
+			},
+		},
 	})
 
 	if err := m.Migrate(); err != nil {
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/jinzhu/gorm"
+)
+
+// movePreviousPluginVersions adds the previous versions of the plugins (whose binaries are kept in the agents) as installed
+// versions of the plugins and drops the old column. The current versions get pinned if the plugin has no pinned version,
+// so the jobs keep using them
+func movePreviousPluginVersions(tx *gorm.DB) error {
+	if !tx.Dialect().HasColumn("plugins", "previous_version") {
+		return nil
+	}
+
+	rows := []struct {
+		ID              uint
+		PreviousVersion string
+	}{}
+	if err := tx.Table("plugins").Select("id, previous_version").Where("deleted_at IS NULL AND previous_version <> ''").Scan(&rows).Error; err != nil {
+		return fmt.Errorf("error reading the plugins previous versions: %v", err)
+	}
+
+	for _, r := range rows {
+		p := &models.Plugin{}
+		if err := tx.First(p, r.ID).Error; err != nil {
+			return fmt.Errorf("error moving the plugin %d previous version: %v", r.ID, err)
+		}
+
+		var versions []*models.Plugin
+		if err := tx.Where("agent_host = ? AND repo = ? AND name = ?", p.AgentHost, p.Repo, p.Name).Find(&versions).Error; err != nil {
+			return fmt.Errorf("error moving the plugin %d previous version: %v", r.ID, err)
+		}
+
+		installed := false
+		pinned := false
+		for _, v := range versions {
+			installed = installed || v.Version == r.PreviousVersion
+			pinned = pinned || v.Pinned
+		}
+
+		if !installed {
+			// The previous version has been installed before the current one
+			prev := &models.Plugin{
+				AgentHost:   p.AgentHost,
+				Repo:        p.Repo,
+				Name:        p.Name,
+				Version:     r.PreviousVersion,
+				Arch:        p.Arch,
+				OS:          p.OS,
+				InstalledAt: p.CreatedAt,
+			}
+			if err := tx.Create(prev).Error; err != nil {
+				return fmt.Errorf("error moving the plugin %d previous version: %v", r.ID, err)
+			}
+		}
+
+		if !pinned {
+			if err := tx.Model(p).UpdateColumn("pinned", true).Error; err != nil {
+				return fmt.Errorf("error pinning the plugin %d version: %v", r.ID, err)
+			}
+		}
+	}
+
+	return tx.Model(&models.Plugin{}).DropColumn("previous_version").Error
+}
+
+// restorePreviousPluginVersionsColumn adds back the previous version column of the plugins, empty. The previous versions
+// stay as installed versions of the plugins, so they can still be used and removed
+func restorePreviousPluginVersionsColumn(tx *gorm.DB) error {
+	if err := tx.Exec("ALTER TABLE plugins ADD COLUMN previous_version varchar(255)").Error; err != nil {
+		return fmt.Errorf("error adding the column 'previous_version' to 'plugins': %v", err)
+	}
+
+	return nil
+}
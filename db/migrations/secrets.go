@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/secret"
+
+	"github.com/jinzhu/gorm"
+)
+
+// hashSecrets replaces the plain agent secrets (and the plain previous secrets of the rotations) with their lookup digests and hashes
+func hashSecrets(tx *gorm.DB) error {
+	if err := hashSecretColumn(tx, "agents", "secret", "secret_lookup", "secret_hash"); err != nil {
+		return err
+	}
+
+	if err := hashSecretColumn(tx, "agent_secret_rotations", "prev_secret", "prev_secret_lookup", "prev_secret_hash"); err != nil {
+		return err
+	}
+
+	// Create the indices of the new columns
+	return tx.AutoMigrate(&models.Agent{}, &models.AgentSecretRotation{}).Error
+}
+
+// hashSecretColumn adds the lookup and the hash columns to a table, fills them with the digests of the plain secret column and drops the latter
+func hashSecretColumn(tx *gorm.DB, table, col, lookupCol, hashCol string) error {
+	// The table has been created with the hashed columns already
+	if !tx.Dialect().HasColumn(table, col) {
+		return nil
+	}
+
+	for _, c := range []string{lookupCol, hashCol} {
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s varchar(255) NOT NULL DEFAULT ''", table, c)).Error; err != nil {
+			return fmt.Errorf("error adding the column '%s' to '%s': %v", c, table, err)
+		}
+	}
+
+	rows := []struct {
+		ID     uint
+		Secret string
+	}{}
+	if err := tx.Table(table).Select(fmt.Sprintf("id, %s AS secret", col)).Scan(&rows).Error; err != nil {
+		return fmt.Errorf("error reading the secrets of '%s': %v", table, err)
+	}
+
+	for _, r := range rows {
+		h, err := secret.Hash(r.Secret)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Table(table).Where("id = ?", r.ID).Updates(map[string]interface{}{
+			lookupCol: secret.Lookup(r.Secret),
+			hashCol:   h,
+		}).Error; err != nil {
+			return fmt.Errorf("error hashing the secret of '%s' %d: %v", table, r.ID, err)
+		}
+	}
+
+	if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, col)).Error; err != nil {
+		return fmt.Errorf("error removing the column '%s' of '%s': %v", col, table, err)
+	}
+
+	return nil
+}
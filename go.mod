@@ -11,9 +11,9 @@ require (
 	github.com/minio/minio v0.0.0-20200313015741-06e30b5aa1fb
 	github.com/minio/minio-go/v6 v6.0.50-0.20200306231101-b882ba63d570
 	github.com/mitchellh/go-homedir v1.1.0
-	github.com/pelletier/go-toml v1.6.0 // indirect
+	github.com/pelletier/go-toml v1.6.0
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/pkg/sftp v1.11.0 // indirect
+	github.com/pkg/sftp v1.11.0
 	github.com/rs/xid v1.2.1
 	github.com/secure-io/sio-go v0.3.1 // indirect
 	github.com/shirou/gopsutil v2.20.2+incompatible // indirect
@@ -29,7 +29,7 @@ require (
 	google.golang.org/grpc v1.28.0
 	gopkg.in/gormigrate.v1 v1.6.0
 	gopkg.in/ini.v1 v1.54.0 // indirect
-	gopkg.in/yaml.v2 v2.2.8 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
 
 go 1.13
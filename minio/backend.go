@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package minio
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+)
+
+const (
+	// BackendMinio is the Minio storage backend. The Core manages the agents users and their policies
+	BackendMinio = "minio"
+	// BackendS3 is a generic S3 compatible storage backend (e.g. Ceph RGW). The agents use the credentials provided by
+	// the configuration, that have to be scoped by the storage to the DRLM buckets
+	BackendS3 = "s3"
+)
+
+var (
+	// ErrUnknownBackend gets returned when the configured storage backend isn't supported
+	ErrUnknownBackend = errors.New("unknown storage backend")
+	// ErrUnmanagedUsers gets returned when changing the users of a storage backend whose users are managed by the operator
+	ErrUnmanagedUsers = errors.New("the users of the storage backend are managed by the operator")
+)
+
+// Backend manages the storage users of the agents and the buckets of their jobs. The objects are stored using the S3
+// API, that's common to all the backends
+type Backend interface {
+	// CreateUser creates a new user and returns its secret key
+	CreateUser(ctx *context.Context, usr string) (string, error)
+	// SetUserKey changes the secret key of an user
+	SetUserKey(ctx *context.Context, usr, key string) error
+	// SetUserStatus enables or disables an user. The disabled users can't access their buckets
+	SetUserStatus(ctx *context.Context, usr string, enabled bool) error
+	// MakeBucketForUser creates a new bucket and adds read and write permissions to the user
+	MakeBucketForUser(ctx *context.Context, usr string) (string, error)
+	// AccessKey returns the access key that the user authenticates with
+	AccessKey(ctx *context.Context, usr string) string
+	// ManagesUsers returns whether the Core manages the users of the backend
+	ManagesUsers() bool
+}
+
+// backend returns the configured storage backend
+func backend(ctx *context.Context) Backend {
+	if ctx.Cfg.Minio.Backend == BackendS3 {
+		return &s3Backend{}
+	}
+
+	return &minioBackend{}
+}
+
+// validBackend checks whether the storage backend is supported. The empty backend is Minio
+func validBackend(b string) bool {
+	return b == "" || b == BackendMinio || b == BackendS3
+}
+
+// CreateUser creates a new user in the storage backend
+func CreateUser(ctx *context.Context, usr string) (key string, err error) {
+	return backend(ctx).CreateUser(ctx, usr)
+}
+
+// SetUserKey changes the secret key of an user of the storage backend
+func SetUserKey(ctx *context.Context, usr, key string) error {
+	return backend(ctx).SetUserKey(ctx, usr, key)
+}
+
+// SetUserStatus enables or disables an user of the storage backend. The disabled users can't access their buckets
+func SetUserStatus(ctx *context.Context, usr string, enabled bool) error {
+	return backend(ctx).SetUserStatus(ctx, usr, enabled)
+}
+
+// MakeBucketForUser creates a new bucket in the storage backend and adds read and write permissions to the user
+func MakeBucketForUser(ctx *context.Context, usr string) (string, error) {
+	return backend(ctx).MakeBucketForUser(ctx, usr)
+}
+
+// AccessKey returns the access key that the user authenticates with to the storage backend
+func AccessKey(ctx *context.Context, usr string) string {
+	return backend(ctx).AccessKey(ctx, usr)
+}
+
+// ManagesUsers returns whether the Core manages the users of the storage backend
+func ManagesUsers(ctx *context.Context) bool {
+	return backend(ctx).ManagesUsers()
+}
+
+// bucketPolicy returns the policy that grants read and write permissions of the bucket to the principal
+func bucketPolicy(bName, principal string) string {
+	return strings.NewReplacer("{BUCKET_NAME}", bName, "{PRINCIPAL}", principal).Replace(`{
+		"Version": "2012-10-17",
+		"Id": "{BUCKET_NAME}",
+		"Statement": [
+			{
+				"Effect": "Allow",
+				"Principal": {
+					"AWS": [
+						"{PRINCIPAL}"
+					]
+				},
+				"Action": [
+					"s3:ListBucket",
+					"s3:ListBucketMultipartUploads",
+					"s3:GetBucketLocation"
+				],
+				"Resource": [
+					"arn:aws:s3:::{BUCKET_NAME}"
+				]
+			},
+			{
+				"Effect": "Allow",
+				"Principal": {
+					"AWS": [
+						"{PRINCIPAL}"
+					]
+				},
+				"Action": [
+					"s3:DeleteObject",
+					"s3:GetObject",
+					"s3:ListMultipartUploadParts",
+					"s3:PutObject",
+					"s3:AbortMultipartUpload"
+				],
+				"Resource": [
+					"arn:aws:s3:::{BUCKET_NAME}/*"
+				]
+			}
+		]
+	}`)
+}
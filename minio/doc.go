@@ -1,6 +1,7 @@
 // SPDX-License-Identifier: AGPL-3.0-only
 
-// Package minio has the connection with Minio, handles
-// the user creation / policies for each agent and creates
-// an space for each job to store data
+// Package minio has the connection with the storage (Minio
+// or any S3 compatible storage), handles the user creation /
+// policies for each agent and creates an space for each job
+// to store data
 package minio
@@ -9,8 +9,16 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// Init creates the Minio connection
+// Init creates the connection with the storage backend. The Minio admin connection is only created for the Minio backend
 func Init(ctx *context.Context) {
+	if !validBackend(ctx.Cfg.Minio.Backend) {
+		log.Fatalf("%v: '%s'", ErrUnknownBackend, ctx.Cfg.Minio.Backend)
+	}
+
+	if ctx.Cfg.Minio.Backend == BackendS3 && (ctx.Cfg.Minio.S3.AgentAccessKey == "" || ctx.Cfg.Minio.S3.AgentSecretKey == "") {
+		log.Fatal("the s3 storage backend requires the agents credentials")
+	}
+
 	var err error
 	ctx.MinioCli, err = cmnMinio.NewSDK(
 		ctx.FS,
@@ -25,6 +33,11 @@ func Init(ctx *context.Context) {
 		log.Fatal(err)
 	}
 
+	if ctx.Cfg.Minio.Backend == BackendS3 {
+		log.Info("successfully created the connection to the s3 storage")
+		return
+	}
+
 	ctx.MinioAdminCli, err = cmnMinio.NewAdminClient(
 		ctx.FS,
 		ctx.Cfg.Minio.Host,
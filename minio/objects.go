@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package minio
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/minio/minio-go/v6"
+)
+
+// PutObject stores an object in a bucket of the Minio server. The bucket gets created if it doesn't exist
+func PutObject(ctx *context.Context, bucket, key string, b []byte) error {
+	exists, err := ctx.MinioCli.BucketExists(bucket)
+	if err != nil {
+		return fmt.Errorf("error checking the bucket existence: %v", err)
+	}
+
+	if !exists {
+		if err := ctx.MinioCli.MakeBucket(bucket, ctx.Cfg.Minio.Location); err != nil {
+			return fmt.Errorf("error creating the bucket: %v", err)
+		}
+	}
+
+	if _, err := ctx.MinioCli.PutObject(bucket, key, bytes.NewReader(b), int64(len(b)), minio.PutObjectOptions{ContentType: "application/octet-stream"}); err != nil {
+		return fmt.Errorf("error storing the object: %v", err)
+	}
+
+	return nil
+}
+
+// GetObject returns the content of an object of a bucket of the Minio server
+func GetObject(ctx *context.Context, bucket, key string) ([]byte, error) {
+	obj, err := ctx.MinioCli.GetObject(bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting the object: %v", err)
+	}
+	defer obj.Close()
+
+	b, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the object: %v", err)
+	}
+
+	return b, nil
+}
+
+// RemoveObject removes an object of a bucket of the Minio server
+func RemoveObject(ctx *context.Context, bucket, key string) error {
+	if err := ctx.MinioCli.RemoveObject(bucket, key); err != nil {
+		return fmt.Errorf("error removing the object: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package minio_test
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+)
+
+// bucketLocation answers the bucket location requests that the Minio client does before the object requests
+func bucketLocation(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := r.URL.Query()["location"]; !ok {
+		return false
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`))
+
+	return true
+}
+
+func (s *TestMinioSuite) TestPutObject() {
+	s.Run("should create the bucket if it doesn't exist and store the object", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		var bucketCreated bool
+		var stored []byte
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bucketLocation(w, r) {
+				return
+			}
+
+			switch {
+			case r.Method == http.MethodHead && r.URL.Path == "/drlm-plugins/":
+				w.WriteHeader(http.StatusNotFound)
+			case r.Method == http.MethodPut && r.URL.Path == "/drlm-plugins/":
+				bucketCreated = true
+				w.WriteHeader(http.StatusOK)
+			case r.Method == http.MethodPut:
+				stored, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusOK)
+			default:
+				w.WriteHeader(http.StatusOK)
+			}
+		}))
+		defer ts.Close()
+
+		s.NoError(minio.PutObject(ctx, "drlm-plugins", "default/tar/v1.0.0/linux-amd64", []byte("binary")))
+		s.True(bucketCreated)
+		s.Contains(string(stored), "binary")
+	})
+
+	s.Run("should return an error if there's an error storing the object", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bucketLocation(w, r) {
+				return
+			}
+
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer ts.Close()
+
+		s.Error(minio.PutObject(ctx, "drlm-plugins", "default/tar/v1.0.0/linux-amd64", []byte("binary")))
+	})
+}
+
+func (s *TestMinioSuite) TestGetObject() {
+	s.Run("should return the content of the object", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bucketLocation(w, r) {
+				return
+			}
+
+			w.Header().Set("Content-Length", "6")
+			w.Header().Set("Last-Modified", "Mon, 16 Mar 2020 11:53:51 GMT")
+			w.Header().Set("ETag", `"9a0364b9e99bb480dd25e1f0284c8555"`)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("binary"))
+		}))
+		defer ts.Close()
+
+		b, err := minio.GetObject(ctx, "drlm-plugins", "default/tar/v1.0.0/linux-amd64")
+		s.NoError(err)
+		s.Equal([]byte("binary"), b)
+	})
+
+	s.Run("should return an error if the object can't be read", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bucketLocation(w, r) {
+				return
+			}
+
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer ts.Close()
+
+		b, err := minio.GetObject(ctx, "drlm-plugins", "default/tar/v1.0.0/linux-amd64")
+		s.Nil(b)
+		s.Error(err)
+	})
+}
+
+func (s *TestMinioSuite) TestRemoveObject() {
+	s.Run("should remove the object correctly", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bucketLocation(w, r) {
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		s.NoError(minio.RemoveObject(ctx, "drlm-plugins", "default/tar/v1.0.0/linux-amd64"))
+	})
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package minio
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/rs/xid"
+)
+
+// s3Backend is a generic S3 compatible storage backend. The S3 API has no users management, so all the agents use the
+// credentials provided by the configuration. They have to be scoped by the storage to the buckets with the `drlm-` prefix.
+// Optionally, each bucket gets a policy that grants access to it (and only to it) to the configured principal.
+//
+// Since the credentials are shared, there's no isolation between the agents: an agent can read and write the buckets
+// of the rest of the agents, and an agent can't be disabled or have its key rotated without affecting all of them
+type s3Backend struct{}
+
+// CreateUser returns the secret key of the configured agents credentials, since the users can't be created
+func (s *s3Backend) CreateUser(ctx *context.Context, usr string) (string, error) {
+	return ctx.Cfg.Minio.S3.AgentSecretKey, nil
+}
+
+// SetUserKey returns an error, since the keys of the configured agents credentials can't be changed by the Core
+func (s *s3Backend) SetUserKey(ctx *context.Context, usr, key string) error {
+	return ErrUnmanagedUsers
+}
+
+// SetUserStatus returns an error, since all the agents share the configured credentials and they can't be disabled one by one
+func (s *s3Backend) SetUserStatus(ctx *context.Context, usr string, enabled bool) error {
+	return ErrUnmanagedUsers
+}
+
+// MakeBucketForUser creates a new bucket with the `drlm-` prefix and applies the bucket policy if there's a principal configured
+func (s *s3Backend) MakeBucketForUser(ctx *context.Context, usr string) (string, error) {
+	bName := fmt.Sprintf("drlm-%s", xid.New())
+
+	if err := ctx.MinioCli.MakeBucket(bName, ctx.Cfg.Minio.Location); err != nil {
+		return "", fmt.Errorf("error creating the storage bucket: %v", err)
+	}
+
+	if ctx.Cfg.Minio.S3.BucketPolicyPrincipal != "" {
+		if err := ctx.MinioCli.SetBucketPolicy(bName, bucketPolicy(bName, ctx.Cfg.Minio.S3.BucketPolicyPrincipal)); err != nil {
+			return "", fmt.Errorf("error applying the bucket policy: %v", err)
+		}
+	}
+
+	return bName, nil
+}
+
+// AccessKey returns the access key of the configured agents credentials
+func (s *s3Backend) AccessKey(ctx *context.Context, usr string) string {
+	return ctx.Cfg.Minio.S3.AgentAccessKey
+}
+
+// ManagesUsers returns false, since the agents credentials are managed by the operator
+func (s *s3Backend) ManagesUsers() bool {
+	return false
+}
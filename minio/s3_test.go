@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package minio_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+)
+
+// generateS3Ctx returns a context configured with the s3 storage backend
+func generateS3Ctx(s *TestMinioSuite) *context.Context {
+	ctx := tests.GenerateCtx()
+	tests.GenerateCfg(s.T(), ctx)
+
+	ctx.Cfg.Minio.Backend = minio.BackendS3
+	ctx.Cfg.Minio.S3.AgentAccessKey = "drlm-agents"
+	ctx.Cfg.Minio.S3.AgentSecretKey = "f0cKt3Rf$f0cKt3Rf$"
+
+	return ctx
+}
+
+func (s *TestMinioSuite) TestS3Init() {
+	s.Run("should exit if the storage backend is unknown", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		ctx.Cfg.Minio.Backend = "gcs"
+
+		s.Exits(func() { minio.Init(ctx) })
+	})
+
+	s.Run("should exit if the s3 storage backend has no agents credentials", func() {
+		ctx := generateS3Ctx(s)
+		ctx.Cfg.Minio.S3.AgentSecretKey = ""
+
+		s.Exits(func() { minio.Init(ctx) })
+	})
+}
+
+func (s *TestMinioSuite) TestS3Users() {
+	s.Run("should use the configured agents credentials", func() {
+		ctx := generateS3Ctx(s)
+
+		key, err := minio.CreateUser(ctx, "drlm-agent-1")
+		s.NoError(err)
+		s.Equal("f0cKt3Rf$f0cKt3Rf$", key)
+		s.Equal("drlm-agents", minio.AccessKey(ctx, "drlm-agent-1"))
+		s.False(minio.ManagesUsers(ctx))
+	})
+
+	s.Run("should return an error when changing the user key", func() {
+		ctx := generateS3Ctx(s)
+
+		s.Equal(minio.ErrUnmanagedUsers, minio.SetUserKey(ctx, "drlm-agent-1", "n3wk3y"))
+	})
+
+	s.Run("should return an error when changing the user status", func() {
+		ctx := generateS3Ctx(s)
+
+		s.Equal(minio.ErrUnmanagedUsers, minio.SetUserStatus(ctx, "drlm-agent-1", false))
+	})
+
+	s.Run("should use the user name as access key with the minio backend", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		s.Equal("drlm-agent-1", minio.AccessKey(ctx, "drlm-agent-1"))
+		s.True(minio.ManagesUsers(ctx))
+	})
+}
+
+func (s *TestMinioSuite) TestS3MakeBucketForUser() {
+	s.Run("should create the bucket without using the minio admin API", func() {
+		ctx := generateS3Ctx(s)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/drlm-") && r.URL.RawQuery == "" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			s.Fail(r.URL.String())
+		}))
+		defer ts.Close()
+
+		bName, err := minio.MakeBucketForUser(ctx, "drlm-agent-1")
+		s.NoError(err)
+		s.True(strings.HasPrefix(bName, "drlm-"))
+	})
+
+	s.Run("should apply the bucket policy to the configured principal", func() {
+		ctx := generateS3Ctx(s)
+		ctx.Cfg.Minio.S3.BucketPolicyPrincipal = "arn:aws:iam::123456789012:user/drlm-agents"
+
+		var policy string
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bucketLocation(w, r) {
+				return
+			}
+
+			if _, ok := r.URL.Query()["policy"]; ok {
+				b, err := ioutil.ReadAll(r.Body)
+				s.Require().NoError(err)
+				policy = string(b)
+
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		bName, err := minio.MakeBucketForUser(ctx, "drlm-agent-1")
+		s.NoError(err)
+		s.Contains(policy, `"arn:aws:iam::123456789012:user/drlm-agents"`)
+		s.Contains(policy, `"arn:aws:s3:::`+bName+`/*"`)
+	})
+
+	s.Run("should return an error if there's an error applying the bucket policy", func() {
+		ctx := generateS3Ctx(s)
+		ctx.Cfg.Minio.S3.BucketPolicyPrincipal = "arn:aws:iam::123456789012:user/drlm-agents"
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if bucketLocation(w, r) {
+				return
+			}
+
+			if _, ok := r.URL.Query()["policy"]; ok {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		bName, err := minio.MakeBucketForUser(ctx, "drlm-agent-1")
+		s.Equal("", bName)
+		s.Error(err)
+		s.True(strings.HasPrefix(err.Error(), "error applying the bucket policy: "))
+	})
+}
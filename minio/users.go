@@ -4,17 +4,19 @@ package minio
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/utils/secret"
 
+	"github.com/minio/minio/pkg/madmin"
 	"github.com/rs/xid"
 )
 
+// minioBackend is the Minio storage backend. It uses the Minio admin API to manage the users and their canned policies
+type minioBackend struct{}
+
 // CreateUser creates a new user to the Minio server
-// TODO: Add S3 compatibility
-func CreateUser(ctx *context.Context, usr string) (key string, err error) {
+func (m *minioBackend) CreateUser(ctx *context.Context, usr string) (string, error) {
 	pwd, err := secret.New(usr)
 	if err != nil {
 		return "", fmt.Errorf("error generating the secret key: %v", err)
@@ -27,55 +29,38 @@ func CreateUser(ctx *context.Context, usr string) (key string, err error) {
 	return pwd, nil
 }
 
-// MakeBucketForUser creates a new bucket and adds read and write permissions to the user
-// TODO: Add S3 compatibility
-func MakeBucketForUser(ctx *context.Context, usr string) (string, error) {
+// SetUserKey changes the secret key of an user of the Minio server
+func (m *minioBackend) SetUserKey(ctx *context.Context, usr, key string) error {
+	if err := ctx.MinioAdminCli.AddUser(usr, key); err != nil {
+		return fmt.Errorf("error changing the minio user key: %v", err)
+	}
+
+	return nil
+}
+
+// SetUserStatus enables or disables an user of the Minio server
+func (m *minioBackend) SetUserStatus(ctx *context.Context, usr string, enabled bool) error {
+	status := madmin.AccountDisabled
+	if enabled {
+		status = madmin.AccountEnabled
+	}
+
+	if err := ctx.MinioAdminCli.SetUserStatus(usr, status); err != nil {
+		return fmt.Errorf("error changing the minio user status: %v", err)
+	}
+
+	return nil
+}
+
+// MakeBucketForUser creates a new bucket and applies a canned policy with read and write permissions of it to the user
+func (m *minioBackend) MakeBucketForUser(ctx *context.Context, usr string) (string, error) {
 	bName := fmt.Sprintf("drlm-%s", xid.New())
 
 	if err := ctx.MinioCli.MakeBucket(bName, ctx.Cfg.Minio.Location); err != nil {
 		return "", fmt.Errorf("error creating the storage bucket: %v", err)
 	}
 
-	if err := ctx.MinioAdminCli.AddCannedPolicy(bName, strings.Replace(`{
-		"Version": "2012-10-17",
-		"Id": "{BUCKET_NAME}",
-		"Statement": [
-			{
-				"Effect": "Allow",
-				"Principal": {
-					"AWS": [
-						"*"
-					]
-				},
-				"Action": [
-					"s3:ListBucket",
-					"s3:ListBucketMultipartUploads",
-					"s3:GetBucketLocation"
-				],
-				"Resource": [
-					"arn:aws:s3:::{BUCKET_NAME}"
-				]
-			},
-			{
-				"Effect": "Allow",
-				"Principal": {
-					"AWS": [
-						"*"
-					]
-				},
-				"Action": [
-					"s3:DeleteObject",
-					"s3:GetObject",
-					"s3:ListMultipartUploadParts",
-					"s3:PutObject",
-					"s3:AbortMultipartUpload"
-				],
-				"Resource": [
-					"arn:aws:s3:::{BUCKET_NAME}/*"
-				]
-			}
-		]
-	}`, "{BUCKET_NAME}", bName, -1)); err != nil {
+	if err := ctx.MinioAdminCli.AddCannedPolicy(bName, bucketPolicy(bName, "*")); err != nil {
 		return "", fmt.Errorf("error creating the policy: %v", err)
 	}
 
@@ -85,3 +70,13 @@ func MakeBucketForUser(ctx *context.Context, usr string) (string, error) {
 
 	return bName, nil
 }
+
+// AccessKey returns the access key of the user, that's the user name
+func (m *minioBackend) AccessKey(ctx *context.Context, usr string) string {
+	return usr
+}
+
+// ManagesUsers returns true, since the Core manages the Minio users
+func (m *minioBackend) ManagesUsers() bool {
+	return true
+}
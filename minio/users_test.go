@@ -40,6 +40,59 @@ func (s *TestMinioSuite) TestCreateUser() {
 	})
 }
 
+func (s *TestMinioSuite) TestSetUserKey() {
+	s.Run("should change the user key correctly", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		s.Nil(minio.SetUserKey(ctx, "nefix", "f0cKt3Rf$f0cKt3Rf$"))
+	})
+
+	s.Run("should return an error if there's an error changing the user key", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer ts.Close()
+
+		s.EqualError(minio.SetUserKey(ctx, "nefix", "f0cKt3Rf$f0cKt3Rf$"), "error changing the minio user key: Failed to parse server response.")
+	})
+}
+
+func (s *TestMinioSuite) TestSetUserStatus() {
+	s.Run("should change the user status correctly", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.Equal("disabled", r.URL.Query().Get("status"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		s.Nil(minio.SetUserStatus(ctx, "nefix", false))
+	})
+
+	s.Run("should return an error if there's an error changing the user status", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		ts := tests.GenerateMinio(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer ts.Close()
+
+		s.EqualError(minio.SetUserStatus(ctx, "nefix", true), "error changing the minio user status: Failed to parse server response.")
+	})
+}
+
 func (s *TestMinioSuite) TestMakeBucketForUser() {
 	s.Run("should create the bucket correctly", func() {
 		ctx := tests.GenerateCtx()
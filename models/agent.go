@@ -3,6 +3,7 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/brainupdaters/drlm-core/context"
@@ -13,18 +14,21 @@ import (
 	"github.com/rs/xid"
 )
 
+// ErrAgentQuarantined gets returned when trying to reach an agent that is quarantined
+var ErrAgentQuarantined = errors.New("the agent is quarantined")
+
 // Agent (s) are the clients of DRLM Core that are installed in the servers
 type Agent struct {
 	gorm.Model
 	Host     string `gorm:"unique;not null"`
 	Accepted bool   `gorm:"not null"`
 
-	MinioKey string `gorm:"not null"`
-	Secret   string `gorm:"unique;not null"` // The secret is used for authentication
+	MinioKey     string `gorm:"not null"`
+	SecretLookup string `gorm:"unique;not null"` // Digest of the secret used to find the agent. The secret itself isn't stored
+	SecretHash   string `gorm:"not null"`        // Salted hash of the secret, which is used for authentication
 
-	SSHPort     int `gorm:"not null"`
-	SSHUser     string
-	SSHHostKeys string `gorm:"size:9999"` // The different keys are splitted with `|||` between each one
+	SSHPort int `gorm:"not null"`
+	SSHUser string
 
 	Version       string
 	Arch          os.Arch
@@ -33,21 +37,43 @@ type Agent struct {
 	Distro        string
 	DistroVersion string
 
-	Jobs    []*Job    `gorm:"-"`
-	Plugins []*Plugin `gorm:"-"`
+	Quarantined bool `gorm:"not null"` // Quarantined agents can't connect, their jobs are held and their Minio user is disabled
+
+	Secret    string          `gorm:"-"` // The plain secret. It's only available right after generating it
+	Jobs      []*Job          `gorm:"-"`
+	Plugins   []*Plugin       `gorm:"-"`
+	Labels    []*AgentLabel   `gorm:"-"`
+	HostKeys  []*AgentHostKey `gorm:"-"`
+	Aliases   []*AgentAlias   `gorm:"-"`
+	Inventory *AgentInventory `gorm:"-"`
 }
 
 // AgentList returns a list with all the agents
 func AgentList(ctx *context.Context) ([]*Agent, error) {
 	agents := []*Agent{}
 
-	if err := ctx.DB.Select("id, created_at, updated_at, host, accepted, minio_key, secret, ssh_port, ssh_user, ssh_host_keys, version, arch, os, os_version, distro, distro_version").Where(&Agent{Accepted: true}).Find(&agents).Error; err != nil {
+	if err := ctx.DB.Select("id, created_at, updated_at, host, accepted, minio_key, secret_lookup, secret_hash, ssh_port, ssh_user, version, arch, os, os_version, distro, distro_version, quarantined").Where(&Agent{Accepted: true}).Find(&agents).Error; err != nil {
 		return []*Agent{}, fmt.Errorf("error getting the list of agents: %v", err)
 	}
 
 	return agents, nil
 }
 
+// QuarantinedAgentHosts returns the hosts of all the quarantined agents
+func QuarantinedAgentHosts(ctx *context.Context) (map[string]bool, error) {
+	var hosts []string
+	if err := ctx.DB.Model(&Agent{}).Where("quarantined = ?", true).Pluck("host", &hosts).Error; err != nil {
+		return map[string]bool{}, fmt.Errorf("error getting the list of quarantined agents: %v", err)
+	}
+
+	quarantined := map[string]bool{}
+	for _, h := range hosts {
+		quarantined[h] = true
+	}
+
+	return quarantined, nil
+}
+
 // Add creates a new agent in the DB
 func (a *Agent) Add(ctx *context.Context) error {
 	if err := ctx.DB.Create(a).Error; err != nil {
@@ -59,10 +85,8 @@ func (a *Agent) Add(ctx *context.Context) error {
 
 // BeforeCreate is a hook that gets executed before creating an agent
 func (a *Agent) BeforeCreate() error {
-	if a.Secret == "" {
-		var err error
-		a.Secret, err = secret.New(xid.New().String())
-		if err != nil {
+	if a.SecretHash == "" {
+		if err := a.NewSecret(); err != nil {
 			return fmt.Errorf("generate secret: %v", err)
 		}
 	}
@@ -70,6 +94,47 @@ func (a *Agent) BeforeCreate() error {
 	return nil
 }
 
+// NewSecret generates a new secret for the agent. The plain secret is kept in the Secret field, but only the hash gets stored
+func (a *Agent) NewSecret() error {
+	s, err := secret.New(xid.New().String())
+	if err != nil {
+		return err
+	}
+
+	h, err := secret.Hash(s)
+	if err != nil {
+		return err
+	}
+
+	a.Secret = s
+	a.SecretLookup = secret.Lookup(s)
+	a.SecretHash = h
+
+	return nil
+}
+
+// CheckSecret checks whether a secret is the secret of the agent
+func (a *Agent) CheckSecret(s string) bool {
+	return secret.Check(s, a.SecretHash)
+}
+
+// LoadBySecret loads the agent from the DB using its secret
+func (a *Agent) LoadBySecret(ctx *context.Context, s string) error {
+	if err := ctx.DB.Where("secret_lookup = ?", secret.Lookup(s)).First(a).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the agent from the DB: %v", err)
+	}
+
+	if !a.CheckSecret(s) {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
 // Load loads the agent from the DB using the host
 func (a *Agent) Load(ctx *context.Context) error {
 	if err := ctx.DB.Where("host = ?", a.Host).First(a).Error; err != nil {
@@ -124,3 +189,27 @@ func (a *Agent) LoadPlugins(ctx *context.Context) error {
 
 	return nil
 }
+
+// LoadLabels loads all the labels of an agent
+func (a *Agent) LoadLabels(ctx *context.Context) error {
+	var labels []*AgentLabel
+	if err := ctx.DB.Where("agent_host = ?", a.Host).Find(&labels).Error; err != nil {
+		return fmt.Errorf("error getting the labels list: %v", err)
+	}
+
+	a.Labels = labels
+
+	return nil
+}
+
+// LoadInventory loads the latest inventory of an agent
+func (a *Agent) LoadInventory(ctx *context.Context) error {
+	i := &AgentInventory{AgentHost: a.Host}
+	if err := i.Load(ctx); err != nil {
+		return err
+	}
+
+	a.Inventory = i
+
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/secret"
 	"github.com/brainupdaters/drlm-core/utils/tests"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -41,7 +42,7 @@ func (s *TestAgentSuite) TestList() {
 	s.Run("should return a list of agents", func() {
 		now := time.Now()
 
-		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret, ssh_port, ssh_user, ssh_host_keys, version, arch, os, os_version, distro, distro_version FROM "agents" WHERE "agents"."deleted_at" IS NULL AND (("agents"."accepted" = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "host", "minio_key", "secret", "ssh_port", "ssh_user", "version", "arch", "os", "os_version", "distro", "distro_version"}).
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret_lookup, secret_hash, ssh_port, ssh_user, version, arch, os, os_version, distro, distro_version, quarantined FROM "agents" WHERE "agents"."deleted_at" IS NULL AND (("agents"."accepted" = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "host", "minio_key", "secret_hash", "ssh_port", "ssh_user", "version", "arch", "os", "os_version", "distro", "distro_version"}).
 			AddRow(1, now, now, "192.168.0.10", "minioKey", "f0cKt3Rf$", 22, "drlm", "v0.0.1", os.ArchAmd64, os.Linux, "v5.0.2", "debian", "10.0").
 			AddRow(2, now, now, "192.168.1.5", "minioKey", "f0cKt3Rf$", 22, "root", "v0.1.0", os.ArchAmd64, os.Linux, "v5.0.0", "ubuntu", "19.04"),
 		)
@@ -55,7 +56,7 @@ func (s *TestAgentSuite) TestList() {
 				},
 				Host:          "192.168.0.10",
 				MinioKey:      "minioKey",
-				Secret:        "f0cKt3Rf$",
+				SecretHash:    "f0cKt3Rf$",
 				SSHPort:       22,
 				SSHUser:       "drlm",
 				Version:       "v0.0.1",
@@ -73,7 +74,7 @@ func (s *TestAgentSuite) TestList() {
 				},
 				Host:          "192.168.1.5",
 				MinioKey:      "minioKey",
-				Secret:        "f0cKt3Rf$",
+				SecretHash:    "f0cKt3Rf$",
 				SSHPort:       22,
 				SSHUser:       "root",
 				Version:       "v0.1.0",
@@ -92,7 +93,7 @@ func (s *TestAgentSuite) TestList() {
 	})
 
 	s.Run("should return an error if there's an error getting the list of agents", func() {
-		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret, ssh_port, ssh_user, ssh_host_keys, version, arch, os, os_version, distro, distro_version FROM "agents" WHERE "agents"."deleted_at" IS NULL AND (("agents"."accepted" = $1))`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret_lookup, secret_hash, ssh_port, ssh_user, version, arch, os, os_version, distro, distro_version, quarantined FROM "agents" WHERE "agents"."deleted_at" IS NULL AND (("agents"."accepted" = $1))`)).WillReturnError(errors.New("testing error"))
 
 		agents, err := models.AgentList(s.ctx)
 
@@ -101,10 +102,28 @@ func (s *TestAgentSuite) TestList() {
 	})
 }
 
+func (s *TestAgentSuite) TestQuarantinedAgentHosts() {
+	s.Run("should return the hosts of the quarantined agents", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT host FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((quarantined = $1))`)).WithArgs(true).WillReturnRows(sqlmock.NewRows([]string{"host"}).AddRow("laptop").AddRow("server"))
+
+		hosts, err := models.QuarantinedAgentHosts(s.ctx)
+		s.NoError(err)
+		s.Equal(map[string]bool{"laptop": true, "server": true}, hosts)
+	})
+
+	s.Run("should return an error if there's an error listing the agents", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT host FROM "agents"`)).WillReturnError(errors.New("testing error"))
+
+		hosts, err := models.QuarantinedAgentHosts(s.ctx)
+		s.EqualError(err, "error getting the list of quarantined agents: testing error")
+		s.Equal(map[string]bool{}, hosts)
+	})
+}
+
 func (s *TestAgentSuite) TestAdd() {
 	s.Run("should add the agent to the DB correctly", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret","ssh_port","ssh_user","ssh_host_keys","version","arch","os","os_version","distro","distro_version") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) RETURNING "agents"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret_lookup","secret_hash","ssh_port","ssh_user","version","arch","os","os_version","distro","distro_version","quarantined") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17) RETURNING "agents"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		s.mock.ExpectCommit()
 
 		a := &models.Agent{
@@ -118,7 +137,7 @@ func (s *TestAgentSuite) TestAdd() {
 
 	s.Run("should return an error if there's an error adding the agent to the DB", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret","ssh_port","ssh_user","ssh_host_keys","version","arch","os","os_version","distro","distro_version") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) RETURNING "agents"."id"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agents" ("created_at","updated_at","deleted_at","host","accepted","minio_key","secret_lookup","secret_hash","ssh_port","ssh_user","version","arch","os","os_version","distro","distro_version","quarantined") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17) RETURNING "agents"."id"`)).WillReturnError(errors.New("testing error"))
 
 		a := &models.Agent{
 			Host:    "192.168.1.61",
@@ -170,10 +189,57 @@ func (s *TestAgentSuite) TestLoad() {
 	})
 }
 
+func (s *TestAgentSuite) TestNewSecret() {
+	s.Run("should generate a new secret and store only its hash", func() {
+		a := &models.Agent{}
+
+		s.NoError(a.NewSecret())
+		s.Len(a.Secret, 32)
+		s.Equal(secret.Lookup(a.Secret), a.SecretLookup)
+		s.NotContains(a.SecretHash, a.Secret)
+		s.True(a.CheckSecret(a.Secret))
+		s.False(a.CheckSecret("h4ck3r"))
+	})
+}
+
+func (s *TestAgentSuite) TestLoadBySecret() {
+	h, err := secret.Hash("f0cKt3Rf$")
+	s.Require().NoError(err)
+
+	s.Run("should load the agent correctly", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((secret_lookup = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("f0cKt3Rf$")).WillReturnRows(sqlmock.NewRows([]string{"id", "host", "secret_hash"}).
+			AddRow(161, "192.168.1.61", h),
+		)
+
+		a := &models.Agent{}
+
+		s.NoError(a.LoadBySecret(s.ctx, "f0cKt3Rf$"))
+		s.Equal("192.168.1.61", a.Host)
+	})
+
+	s.Run("should return a not found error if the secret doesn't match the hash", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((secret_lookup = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("h4ck3r")).WillReturnRows(sqlmock.NewRows([]string{"id", "host", "secret_hash"}).
+			AddRow(161, "192.168.1.61", h),
+		)
+
+		a := &models.Agent{}
+
+		s.True(gorm.IsRecordNotFoundError(a.LoadBySecret(s.ctx, "h4ck3r")))
+	})
+
+	s.Run("should return an error if there's an error loading the agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((secret_lookup = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		a := &models.Agent{}
+
+		s.EqualError(a.LoadBySecret(s.ctx, "f0cKt3Rf$"), "error loading the agent from the DB: testing error")
+	})
+}
+
 func (s *TestAgentSuite) TestUpdate() {
 	s.Run("should update the agent correctly", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "updated_at" = $1, "deleted_at" = $2, "host" = $3, "accepted" = $4, "minio_key" = $5, "secret" = $6, "ssh_port" = $7, "ssh_user" = $8, "ssh_host_keys" = $9, "version" = $10, "arch" = $11, "os" = $12, "os_version" = $13, "distro" = $14, "distro_version" = $15  WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $16`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "updated_at" = $1, "deleted_at" = $2, "host" = $3, "accepted" = $4, "minio_key" = $5, "secret_lookup" = $6, "secret_hash" = $7, "ssh_port" = $8, "ssh_user" = $9, "version" = $10, "arch" = $11, "os" = $12, "os_version" = $13, "distro" = $14, "distro_version" = $15, "quarantined" = $16  WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $17`)).WillReturnResult(sqlmock.NewResult(1, 1))
 		s.mock.ExpectCommit()
 
 		a := &models.Agent{
@@ -186,7 +252,7 @@ func (s *TestAgentSuite) TestUpdate() {
 
 	s.Run("should return an error if there's an error updating the agent", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "updated_at" = $1, "deleted_at" = $2, "host" = $3, "accepted" = $4, "minio_key" = $5, "secret" = $6, "ssh_port" = $7, "ssh_user" = $8, "ssh_host_keys" = $9, "version" = $10, "arch" = $11, "os" = $12, "os_version" = $13, "distro" = $14, "distro_version" = $15  WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $16`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "updated_at" = $1, "deleted_at" = $2, "host" = $3, "accepted" = $4, "minio_key" = $5, "secret_lookup" = $6, "secret_hash" = $7, "ssh_port" = $8, "ssh_user" = $9, "version" = $10, "arch" = $11, "os" = $12, "os_version" = $13, "distro" = $14, "distro_version" = $15, "quarantined" = $16  WHERE "agents"."deleted_at" IS NULL AND "agents"."id" = $17`)).WillReturnError(errors.New("testing error"))
 
 		a := &models.Agent{
 			Model: gorm.Model{ID: 1},
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrAgentHostInUse gets returned when changing the host of an agent or adding an alias to a host that's already used by another agent
+var ErrAgentHostInUse = errors.New("the host is already used by another agent")
+
+// AgentAlias is another host of an agent, usually a previous address of it. The agents that connect or request to join
+// from one of their aliases are resolved to the agent
+type AgentAlias struct {
+	gorm.Model
+	AgentID uint   `gorm:"not null;index"`
+	Alias   string `gorm:"unique;not null"`
+}
+
+// agentHostModels are all the models that reference the agents by their host (`agent_host`). The agent scope plugin
+// config overrides reference them by their target, so they're updated apart
+var agentHostModels = []interface{}{
+	&Job{},
+	&Plugin{},
+	&AgentLabel{},
+	&AgentCertificate{},
+	&AgentSecretRotation{},
+	&AgentInventory{},
+	&AgentHostKey{},
+	&AgentHostKeyEvent{},
+}
+
+// ResolveAgentHost returns the current host of the agent that has the host as alias. If the host isn't an alias of any
+// agent, the host is returned as is
+func ResolveAgentHost(ctx *context.Context, host string) (string, error) {
+	al := &AgentAlias{}
+	if err := ctx.DB.Where("alias = ?", host).First(al).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return host, nil
+		}
+
+		return "", fmt.Errorf("error loading the agent alias from the DB: %v", err)
+	}
+
+	a := &Agent{}
+	if err := ctx.DB.Where("id = ?", al.AgentID).First(a).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return host, nil
+		}
+
+		return "", fmt.Errorf("error loading the agent from the DB: %v", err)
+	}
+
+	return a.Host, nil
+}
+
+// LoadAliases loads all the aliases of an agent
+func (a *Agent) LoadAliases(ctx *context.Context) error {
+	var aliases []*AgentAlias
+	if err := ctx.DB.Where("agent_id = ?", a.ID).Order("id").Find(&aliases).Error; err != nil {
+		return fmt.Errorf("error getting the aliases list: %v", err)
+	}
+
+	a.Aliases = aliases
+
+	return nil
+}
+
+// AddAlias adds a new alias to the agent
+func (a *Agent) AddAlias(ctx *context.Context, alias string) error {
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkHostInUse(tx, a.ID, alias); err != nil {
+			return err
+		}
+
+		return addAlias(tx, a.ID, alias)
+	})
+}
+
+// DeleteAlias removes an alias of the agent
+func (a *Agent) DeleteAlias(ctx *context.Context, alias string) error {
+	rslt := ctx.DB.Unscoped().Where("agent_id = ? AND alias = ?", a.ID, alias).Delete(&AgentAlias{})
+	if rslt.Error != nil {
+		return fmt.Errorf("error removing the agent alias: %v", rslt.Error)
+	}
+
+	if rslt.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ChangeHost changes the host of the agent and all the references to it in a single transaction. The previous host
+// becomes an alias of the agent
+func (a *Agent) ChangeHost(ctx *context.Context, host string) error {
+	old := a.Host
+	if host == old {
+		return nil
+	}
+
+	if err := ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkHostInUse(tx, a.ID, host); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Agent{}).Where("id = ?", a.ID).Update("host", host).Error; err != nil {
+			return fmt.Errorf("error updating the agent host: %v", err)
+		}
+
+		if err := tx.Unscoped().Where("agent_id = ? AND alias = ?", a.ID, host).Delete(&AgentAlias{}).Error; err != nil {
+			return fmt.Errorf("error removing the agent alias: %v", err)
+		}
+
+		for _, m := range agentHostModels {
+			if err := tx.Unscoped().Model(m).Where("agent_host = ?", old).UpdateColumn("agent_host", host).Error; err != nil {
+				return fmt.Errorf("error updating the agent references: %v", err)
+			}
+		}
+
+		if err := tx.Unscoped().Model(&PluginConfig{}).Where("scope = ? AND target = ?", PluginConfigScopeAgent, old).UpdateColumn("target", host).Error; err != nil {
+			return fmt.Errorf("error updating the agent plugin configs: %v", err)
+		}
+
+		return addAlias(tx, a.ID, old)
+	}); err != nil {
+		return err
+	}
+
+	a.Host = host
+
+	return nil
+}
+
+// checkHostInUse checks that the host isn't the host or an alias of an agent other than the one with the ID
+func checkHostInUse(tx *gorm.DB, id uint, host string) error {
+	var n int
+	if err := tx.Unscoped().Model(&Agent{}).Where("host = ? AND id <> ?", host, id).Count(&n).Error; err != nil {
+		return fmt.Errorf("error checking the agent hosts: %v", err)
+	}
+
+	if n == 0 {
+		if err := tx.Model(&AgentAlias{}).Joins(`JOIN agents ON agents.id = agent_aliases.agent_id AND agents.deleted_at IS NULL`).Where("agent_aliases.alias = ? AND agent_aliases.agent_id <> ?", host, id).Count(&n).Error; err != nil {
+			return fmt.Errorf("error checking the agent aliases: %v", err)
+		}
+	}
+
+	if n != 0 {
+		return ErrAgentHostInUse
+	}
+
+	return nil
+}
+
+// addAlias adds an alias to the agent with the ID. The previous uses of the alias (by the agent itself or by deleted
+// agents) are removed
+func addAlias(tx *gorm.DB, id uint, alias string) error {
+	if err := tx.Unscoped().Where("alias = ?", alias).Delete(&AgentAlias{}).Error; err != nil {
+		return fmt.Errorf("error removing the previous agent alias: %v", err)
+	}
+
+	if err := tx.Create(&AgentAlias{AgentID: id, Alias: alias}).Error; err != nil {
+		return fmt.Errorf("error adding the agent alias to the DB: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestAliasSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestAliasSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestAliasSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestAlias(t *testing.T) {
+	suite.Run(t, &TestAliasSuite{})
+}
+
+func (s *TestAliasSuite) TestResolveAgentHost() {
+	s.Run("should return the host of the agent of the alias", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_aliases" WHERE "agent_aliases"."deleted_at" IS NULL AND ((alias = $1)) ORDER BY "agent_aliases"."id" ASC LIMIT 1`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_id", "alias"}).AddRow(1, 1, "192.168.1.61"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((id = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+
+		host, err := models.ResolveAgentHost(s.ctx, "192.168.1.61")
+		s.NoError(err)
+		s.Equal("laptop", host)
+	})
+
+	s.Run("should return the host if it's not an alias", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_aliases"`)).WithArgs("laptop").WillReturnError(gorm.ErrRecordNotFound)
+
+		host, err := models.ResolveAgentHost(s.ctx, "laptop")
+		s.NoError(err)
+		s.Equal("laptop", host)
+	})
+
+	s.Run("should return the host if the agent of the alias has been deleted", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_aliases"`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_id", "alias"}).AddRow(1, 1, "192.168.1.61"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs(1).WillReturnError(gorm.ErrRecordNotFound)
+
+		host, err := models.ResolveAgentHost(s.ctx, "192.168.1.61")
+		s.NoError(err)
+		s.Equal("192.168.1.61", host)
+	})
+
+	s.Run("should return an error if there's an error loading the alias", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_aliases"`)).WithArgs("laptop").WillReturnError(errors.New("testing error"))
+
+		host, err := models.ResolveAgentHost(s.ctx, "laptop")
+		s.EqualError(err, "error loading the agent alias from the DB: testing error")
+		s.Equal("", host)
+	})
+}
+
+func (s *TestAliasSuite) TestLoadAliases() {
+	s.Run("should load the aliases of the agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_aliases" WHERE "agent_aliases"."deleted_at" IS NULL AND ((agent_id = $1)) ORDER BY "id"`)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_id", "alias"}).AddRow(1, 1, "192.168.1.61"))
+
+		a := &models.Agent{Model: gorm.Model{ID: 1}}
+		s.NoError(a.LoadAliases(s.ctx))
+		s.Len(a.Aliases, 1)
+		s.Equal("192.168.1.61", a.Aliases[0].Alias)
+	})
+}
+
+func (s *TestAliasSuite) expectHostInUse(host string, agents, aliases int) {
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "agents" WHERE (host = $1 AND id <> $2)`)).WithArgs(host, 1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(agents))
+	if agents == 0 {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "agent_aliases" JOIN agents ON agents.id = agent_aliases.agent_id AND agents.deleted_at IS NULL WHERE "agent_aliases"."deleted_at" IS NULL AND ((agent_aliases.alias = $1 AND agent_aliases.agent_id <> $2))`)).WithArgs(host, 1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(aliases))
+	}
+}
+
+func (s *TestAliasSuite) expectAddAlias(alias string) {
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "agent_aliases" WHERE (alias = $1)`)).WithArgs(alias).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_aliases" ("created_at","updated_at","deleted_at","agent_id","alias") VALUES ($1,$2,$3,$4,$5) RETURNING "agent_aliases"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, 1, alias).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+func (s *TestAliasSuite) TestAddAlias() {
+	s.Run("should add the alias to the agent", func() {
+		s.mock.ExpectBegin()
+		s.expectHostInUse("192.168.1.61", 0, 0)
+		s.expectAddAlias("192.168.1.61")
+		s.mock.ExpectCommit()
+
+		a := &models.Agent{Model: gorm.Model{ID: 1}, Host: "laptop"}
+		s.NoError(a.AddAlias(s.ctx, "192.168.1.61"))
+	})
+
+	s.Run("should return an error if the alias is used by another agent", func() {
+		s.mock.ExpectBegin()
+		s.expectHostInUse("192.168.1.61", 0, 1)
+		s.mock.ExpectRollback()
+
+		a := &models.Agent{Model: gorm.Model{ID: 1}, Host: "laptop"}
+		s.Equal(models.ErrAgentHostInUse, a.AddAlias(s.ctx, "192.168.1.61"))
+	})
+}
+
+func (s *TestAliasSuite) TestDeleteAlias() {
+	s.Run("should remove the alias of the agent", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "agent_aliases" WHERE (agent_id = $1 AND alias = $2)`)).WithArgs(1, "192.168.1.61").WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectCommit()
+
+		a := &models.Agent{Model: gorm.Model{ID: 1}, Host: "laptop"}
+		s.NoError(a.DeleteAlias(s.ctx, "192.168.1.61"))
+	})
+
+	s.Run("should return a not found error if the agent doesn't have the alias", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "agent_aliases" WHERE (agent_id = $1 AND alias = $2)`)).WithArgs(1, "192.168.1.61").WillReturnResult(sqlmock.NewResult(0, 0))
+		s.mock.ExpectCommit()
+
+		a := &models.Agent{Model: gorm.Model{ID: 1}, Host: "laptop"}
+		s.True(gorm.IsRecordNotFoundError(a.DeleteAlias(s.ctx, "192.168.1.61")))
+	})
+}
+
+func (s *TestAliasSuite) TestChangeHost() {
+	s.Run("should change the host of the agent and all its references", func() {
+		s.mock.ExpectBegin()
+		s.expectHostInUse("laptop.example.com", 0, 0)
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents" SET "host" = $1, "updated_at" = $2 WHERE "agents"."deleted_at" IS NULL AND ((id = $3))`)).WithArgs("laptop.example.com", tests.DBAnyTime{}, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "agent_aliases" WHERE (agent_id = $1 AND alias = $2)`)).WithArgs(1, "laptop.example.com").WillReturnResult(sqlmock.NewResult(0, 0))
+		for _, t := range []string{"jobs", "plugins", "agent_labels", "agent_certificates", "agent_secret_rotations", "agent_inventories", "agent_host_keys", "agent_host_key_events"} {
+			s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "`+t+`" SET "agent_host" = $1 WHERE (agent_host = $2)`)).WithArgs("laptop.example.com", "laptop").WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugin_configs" SET "target" = $1 WHERE (scope = $2 AND target = $3)`)).WithArgs("laptop.example.com", models.PluginConfigScopeAgent, "laptop").WillReturnResult(sqlmock.NewResult(0, 1))
+		s.expectAddAlias("laptop")
+		s.mock.ExpectCommit()
+
+		a := &models.Agent{Model: gorm.Model{ID: 1}, Host: "laptop"}
+		s.NoError(a.ChangeHost(s.ctx, "laptop.example.com"))
+		s.Equal("laptop.example.com", a.Host)
+	})
+
+	s.Run("should do nothing if the host doesn't change", func() {
+		a := &models.Agent{Model: gorm.Model{ID: 1}, Host: "laptop"}
+		s.NoError(a.ChangeHost(s.ctx, "laptop"))
+	})
+
+	s.Run("should return an error if the host is used by another agent", func() {
+		s.mock.ExpectBegin()
+		s.expectHostInUse("server", 1, 0)
+		s.mock.ExpectRollback()
+
+		a := &models.Agent{Model: gorm.Model{ID: 1}, Host: "laptop"}
+		s.Equal(models.ErrAgentHostInUse, a.ChangeHost(s.ctx, "server"))
+		s.Equal("laptop", a.Host)
+	})
+
+	s.Run("should roll back the changes if there's an error updating the references", func() {
+		s.mock.ExpectBegin()
+		s.expectHostInUse("laptop.example.com", 0, 0)
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agents"`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "agent_aliases"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		a := &models.Agent{Model: gorm.Model{ID: 1}, Host: "laptop"}
+		s.EqualError(a.ChangeHost(s.ctx, "laptop.example.com"), "error updating the agent references: testing error")
+		s.Equal("laptop", a.Host)
+	})
+}
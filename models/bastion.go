@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/jinzhu/gorm"
+)
+
+// BastionLabel is the name of the agent label that sets the bastion used to reach the agent. It takes precedence over the bastions selectors
+const BastionLabel = "bastion"
+
+// maxBastionChain is the maximum number of bastions of a chain. It prevents loops in the bastions `Via`
+const maxBastionChain = 10
+
+// Bastion is an SSH jump host used to reach agents that aren't directly reachable by the Core
+type Bastion struct {
+	gorm.Model
+	Name     string `gorm:"unique;not null"`
+	Host     string `gorm:"not null"`
+	Port     int    `gorm:"not null"`
+	User     string `gorm:"not null"`
+	KeyPath  string // The private key used to authenticate with the bastion. If it's empty, the Core SSH key is used
+	Via      string // The name of the bastion used to reach this bastion
+	Selector string // The agents whose labels match the selector (`name=value,name2=value2`) are reached through the bastion
+
+	HostKeys []*AgentHostKey `gorm:"-"`
+}
+
+// BastionList returns a list with all the bastions
+func BastionList(ctx *context.Context) ([]*Bastion, error) {
+	bastions := []*Bastion{}
+
+	if err := ctx.DB.Order("id").Find(&bastions).Error; err != nil {
+		return []*Bastion{}, fmt.Errorf("error getting the list of bastions: %v", err)
+	}
+
+	return bastions, nil
+}
+
+// BastionChain returns the chain of bastions (ordered from the first hop to the last one) used to reach a host with the labels.
+// If the labels don't select any bastion, the chain is empty
+func BastionChain(ctx *context.Context, labels LabelSelector) ([]*Bastion, error) {
+	b := &Bastion{Name: labels[BastionLabel]}
+
+	if b.Name == "" {
+		bastions, err := BastionList(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, bastion := range bastions {
+			if bastion.Matches(labels) {
+				b = bastion
+				break
+			}
+		}
+
+		if b.Name == "" {
+			return []*Bastion{}, nil
+		}
+
+	} else {
+		if err := b.loadChainHop(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	chain := []*Bastion{b}
+	for b.Via != "" {
+		if len(chain) == maxBastionChain {
+			return nil, fmt.Errorf("error getting the bastion chain: the chain is longer than %d bastions", maxBastionChain)
+		}
+
+		b = &Bastion{Name: b.Via}
+		if err := b.loadChainHop(ctx); err != nil {
+			return nil, err
+		}
+
+		chain = append([]*Bastion{b}, chain...)
+	}
+
+	for _, b := range chain {
+		if err := b.LoadHostKeys(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return chain, nil
+}
+
+// AgentBastionChain returns the chain of bastions used to reach the agent, using the agent labels
+func AgentBastionChain(ctx *context.Context, a *Agent) ([]*Bastion, error) {
+	if err := a.LoadLabels(ctx); err != nil {
+		return nil, err
+	}
+
+	labels := LabelSelector{}
+	for _, l := range a.Labels {
+		labels[l.Name] = l.Value
+	}
+
+	return BastionChain(ctx, labels)
+}
+
+// Matches checks whether the bastion selector matches the labels. A bastion without selector doesn't match any labels
+func (b *Bastion) Matches(labels LabelSelector) bool {
+	sel, err := ParseLabelSelector(b.Selector)
+	if err != nil || len(sel) == 0 {
+		return false
+	}
+
+	return sel.Matches(labels)
+}
+
+// LoadHostKeys loads the SSH host keys of the bastion
+func (b *Bastion) LoadHostKeys(ctx *context.Context) error {
+	var keys []*AgentHostKey
+	if err := ctx.DB.Where("bastion_name = ?", b.Name).Order("id").Find(&keys).Error; err != nil {
+		return fmt.Errorf("error getting the bastion host keys list: %v", err)
+	}
+
+	b.HostKeys = keys
+
+	return nil
+}
+
+// Keys returns the loaded host keys of the bastion, in the known_hosts format
+func (b *Bastion) Keys() []string {
+	keys := []string{}
+	for _, k := range b.HostKeys {
+		keys = append(keys, k.Key)
+	}
+
+	return keys
+}
+
+// Add creates a new bastion in the DB with its host keys, which are trusted. A bastion can't be added without host keys
+func (b *Bastion) Add(ctx *context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return errors.New("error adding the bastion: the bastion has no host keys")
+	}
+
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(b).Error; err != nil {
+			return fmt.Errorf("error adding the bastion to the DB: %v", err)
+		}
+
+		b.HostKeys = []*AgentHostKey{}
+		for _, k := range keys {
+			fp, err := HostKeyFingerprint(k)
+			if err != nil {
+				return err
+			}
+
+			hk := &AgentHostKey{BastionName: b.Name, Key: k, Fingerprint: fp, Trusted: true}
+			if err := tx.Create(hk).Error; err != nil {
+				return fmt.Errorf("error adding the bastion host key to the DB: %v", err)
+			}
+
+			b.HostKeys = append(b.HostKeys, hk)
+		}
+
+		return nil
+	})
+}
+
+// Load loads the bastion from the DB using the name
+func (b *Bastion) Load(ctx *context.Context) error {
+	if err := ctx.DB.Where("name = ?", b.Name).First(b).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the bastion from the DB: %v", err)
+	}
+
+	return nil
+}
+
+// loadChainHop loads a bastion of a chain. If the bastion doesn't exist, the error includes its name
+func (b *Bastion) loadChainHop(ctx *context.Context) error {
+	if err := b.Load(ctx); err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return fmt.Errorf("error getting the bastion chain: bastion '%s' not found", b.Name)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the bastion (using the name) and its host keys from the DB
+func (b *Bastion) Delete(ctx *context.Context) error {
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		rslt := tx.Where("name = ?", b.Name).Delete(&Bastion{})
+		if rslt.Error != nil {
+			return fmt.Errorf("error removing the bastion: %v", rslt.Error)
+		}
+
+		if rslt.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("bastion_name = ?", b.Name).Delete(&AgentHostKey{}).Error; err != nil {
+			return fmt.Errorf("error removing the bastion host keys: %v", err)
+		}
+
+		return nil
+	})
+}
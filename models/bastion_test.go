@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestBastionSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestBastionSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestBastionSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestBastion(t *testing.T) {
+	suite.Run(t, &TestBastionSuite{})
+}
+
+func (s *TestBastionSuite) TestBastionChain() {
+	s.Run("should return the chain of the bastion set in the labels", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL AND ((name = $1)) ORDER BY "bastions"."id" ASC LIMIT 1`)).WithArgs("internal").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "host", "via"}).AddRow(2, "internal", "10.0.0.1", "dmz"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL AND ((name = $1)) ORDER BY "bastions"."id" ASC LIMIT 1`)).WithArgs("dmz").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "host"}).AddRow(1, "dmz", "dmz.example.com"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys" WHERE "agent_host_keys"."deleted_at" IS NULL AND ((bastion_name = $1)) ORDER BY "id"`)).WithArgs("dmz").WillReturnRows(sqlmock.NewRows([]string{"id", "bastion_name", "key"}).AddRow(1, "dmz", "dmz.example.com ssh-ed25519 AAAA"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys" WHERE "agent_host_keys"."deleted_at" IS NULL AND ((bastion_name = $1)) ORDER BY "id"`)).WithArgs("internal").WillReturnRows(sqlmock.NewRows([]string{"id", "bastion_name", "key"}).AddRow(2, "internal", "internal.example.com ssh-ed25519 AAAA"))
+
+		chain, err := models.BastionChain(s.ctx, models.LabelSelector{"bastion": "internal", "env": "prod"})
+		s.NoError(err)
+		s.Require().Len(chain, 2)
+		s.Equal("dmz", chain[0].Name)
+		s.Equal([]string{"dmz.example.com ssh-ed25519 AAAA"}, chain[0].Keys())
+		s.Equal("internal", chain[1].Name)
+		s.Equal([]string{"internal.example.com ssh-ed25519 AAAA"}, chain[1].Keys())
+	})
+
+	s.Run("should return the chain of the first bastion whose selector matches the labels", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL ORDER BY "id"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "host", "selector"}).
+			AddRow(1, "dmz", "dmz.example.com", "").
+			AddRow(2, "office", "office.example.com", "site=office,env=prod").
+			AddRow(3, "prod", "prod.example.com", "env=prod"),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys" WHERE "agent_host_keys"."deleted_at" IS NULL AND ((bastion_name = $1)) ORDER BY "id"`)).WithArgs("prod").WillReturnRows(sqlmock.NewRows([]string{"id", "bastion_name", "key"}).AddRow(3, "prod", "prod.example.com ssh-ed25519 AAAA"))
+
+		chain, err := models.BastionChain(s.ctx, models.LabelSelector{"env": "prod"})
+		s.NoError(err)
+		s.Require().Len(chain, 1)
+		s.Equal("prod", chain[0].Name)
+	})
+
+	s.Run("should return an empty chain if no bastion matches the labels", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL ORDER BY "id"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "selector"}).AddRow(1, "prod", "env=prod"))
+
+		chain, err := models.BastionChain(s.ctx, models.LabelSelector{"env": "dev"})
+		s.NoError(err)
+		s.Empty(chain)
+	})
+
+	s.Run("should return an error if a bastion of the chain doesn't exist", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL AND ((name = $1)) ORDER BY "bastions"."id" ASC LIMIT 1`)).WithArgs("internal").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "via"}).AddRow(2, "internal", "dmz"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL AND ((name = $1)) ORDER BY "bastions"."id" ASC LIMIT 1`)).WithArgs("dmz").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		chain, err := models.BastionChain(s.ctx, models.LabelSelector{"bastion": "internal"})
+		s.EqualError(err, "error getting the bastion chain: bastion 'dmz' not found")
+		s.Nil(chain)
+	})
+
+	s.Run("should return an error if the chain has a loop", func() {
+		for i := 0; i < 10; i++ {
+			s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL AND ((name = $1)) ORDER BY "bastions"."id" ASC LIMIT 1`)).WithArgs("loop").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "via"}).AddRow(1, "loop", "loop"))
+		}
+
+		chain, err := models.BastionChain(s.ctx, models.LabelSelector{"bastion": "loop"})
+		s.EqualError(err, "error getting the bastion chain: the chain is longer than 10 bastions")
+		s.Nil(chain)
+	})
+
+	s.Run("should return an error if there's an error loading the host keys of the chain", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL AND ((name = $1)) ORDER BY "bastions"."id" ASC LIMIT 1`)).WithArgs("dmz").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "dmz"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys"`)).WillReturnError(errors.New("testing error"))
+
+		chain, err := models.BastionChain(s.ctx, models.LabelSelector{"bastion": "dmz"})
+		s.EqualError(err, "error getting the bastion host keys list: testing error")
+		s.Nil(chain)
+	})
+
+	s.Run("should return an error if there's an error listing the bastions", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL ORDER BY "id"`)).WillReturnError(errors.New("testing error"))
+
+		chain, err := models.BastionChain(s.ctx, models.LabelSelector{})
+		s.EqualError(err, "error getting the list of bastions: testing error")
+		s.Nil(chain)
+	})
+}
+
+func (s *TestBastionSuite) TestAgentBastionChain() {
+	s.Run("should use the agent labels to get the chain", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels" WHERE "agent_labels"."deleted_at" IS NULL AND ((agent_host = $1))`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "name", "value"}).AddRow(1, "laptop", "bastion", "dmz"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions" WHERE "bastions"."deleted_at" IS NULL AND ((name = $1)) ORDER BY "bastions"."id" ASC LIMIT 1`)).WithArgs("dmz").WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "dmz"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys" WHERE "agent_host_keys"."deleted_at" IS NULL AND ((bastion_name = $1)) ORDER BY "id"`)).WithArgs("dmz").WillReturnRows(sqlmock.NewRows([]string{"id", "bastion_name", "key"}).AddRow(1, "dmz", "dmz.example.com ssh-ed25519 AAAA"))
+
+		chain, err := models.AgentBastionChain(s.ctx, &models.Agent{Host: "laptop"})
+		s.NoError(err)
+		s.Require().Len(chain, 1)
+		s.Equal("dmz", chain[0].Name)
+	})
+
+	s.Run("should return an error if there's an error loading the agent labels", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels" WHERE "agent_labels"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnError(errors.New("testing error"))
+
+		chain, err := models.AgentBastionChain(s.ctx, &models.Agent{Host: "laptop"})
+		s.EqualError(err, "error getting the labels list: testing error")
+		s.Nil(chain)
+	})
+}
+
+func (s *TestBastionSuite) TestMatches() {
+	b := &models.Bastion{Selector: "env=prod,site=bcn"}
+
+	s.True(b.Matches(models.LabelSelector{"env": "prod", "site": "bcn", "team": "ops"}))
+	s.False(b.Matches(models.LabelSelector{"env": "prod"}))
+	s.False(b.Matches(models.LabelSelector{"env": "dev", "site": "bcn"}))
+	s.False((&models.Bastion{}).Matches(models.LabelSelector{"env": "prod"}))
+}
+
+func (s *TestBastionSuite) TestKeys() {
+	b := &models.Bastion{HostKeys: []*models.AgentHostKey{{Key: "key1"}, {Key: "key2"}}}
+	s.Equal([]string{"key1", "key2"}, b.Keys())
+
+	s.Empty((&models.Bastion{}).Keys())
+}
+
+func (s *TestBastionSuite) TestAdd() {
+	key := "dmz.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBL1gLRk1rz6bWtEdvVMmXQGLHIGOjFmwOBuNvJCNLEH"
+
+	s.Run("should add the bastion and its host keys to the DB", func() {
+		fp, err := models.HostKeyFingerprint(key)
+		s.Require().NoError(err)
+
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "bastions"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_keys" ("created_at","updated_at","deleted_at","agent_host","bastion_name","key","fingerprint","trusted") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "agent_host_keys"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "", "dmz", key, fp, true).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		b := &models.Bastion{Name: "dmz", Host: "dmz.example.com", Port: 22, User: "drlm"}
+		s.NoError(b.Add(s.ctx, []string{key}))
+		s.Equal([]string{key}, b.Keys())
+	})
+
+	s.Run("should return an error if the bastion has no host keys", func() {
+		b := &models.Bastion{Name: "dmz", Host: "dmz.example.com", Port: 22, User: "drlm"}
+		s.EqualError(b.Add(s.ctx, []string{}), "error adding the bastion: the bastion has no host keys")
+	})
+
+	s.Run("should return an error if a host key is invalid", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "bastions"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectRollback()
+
+		b := &models.Bastion{Name: "dmz", Host: "dmz.example.com", Port: 22, User: "drlm"}
+		err := b.Add(s.ctx, []string{"invalid"})
+		s.Error(err)
+		s.Contains(err.Error(), "error parsing the host key")
+	})
+
+	s.Run("should return an error if there's an error adding the bastion", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "bastions"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		b := &models.Bastion{Name: "dmz", Host: "dmz.example.com", Port: 22, User: "drlm"}
+		s.EqualError(b.Add(s.ctx, []string{key}), "error adding the bastion to the DB: testing error")
+	})
+}
+
+func (s *TestBastionSuite) TestDelete() {
+	s.Run("should remove the bastion and its host keys from the DB", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bastions" SET "deleted_at"=$1 WHERE "bastions"."deleted_at" IS NULL AND ((name = $2))`)).WithArgs(tests.DBAnyTime{}, "dmz").WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_host_keys" SET "deleted_at"=$1 WHERE "agent_host_keys"."deleted_at" IS NULL AND ((bastion_name = $2))`)).WithArgs(tests.DBAnyTime{}, "dmz").WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		s.NoError((&models.Bastion{Name: "dmz"}).Delete(s.ctx))
+	})
+
+	s.Run("should return a not found error if the bastion doesn't exist", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "bastions" SET "deleted_at"=$1 WHERE "bastions"."deleted_at" IS NULL AND ((name = $2))`)).WithArgs(tests.DBAnyTime{}, "dmz").WillReturnResult(sqlmock.NewResult(1, 0))
+		s.mock.ExpectRollback()
+
+		s.True(gorm.IsRecordNotFoundError((&models.Bastion{Name: "dmz"}).Delete(s.ctx)))
+	})
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrCatalogPluginExists gets returned when uploading a plugin version that's already in the catalog
+var ErrCatalogPluginExists = errors.New("the plugin version is already in the catalog")
+
+// CatalogPlugin is a plugin version uploaded to the Core plugin catalog. Its artifacts are stored in the plugins bucket,
+// so it can be installed in the agents without uploading it again
+type CatalogPlugin struct {
+	gorm.Model
+	Repo        string `gorm:"not null;unique_index:idx_catalog_plugin"`
+	Name        string `gorm:"not null;unique_index:idx_catalog_plugin"`
+	Version     string `gorm:"not null;unique_index:idx_catalog_plugin"`
+	ManifestKey string // The key of the manifest object. It's empty if the plugin has no manifest
+
+	Binaries []*CatalogPluginBinary `gorm:"-"`
+	Manifest *PluginManifest        `gorm:"-"` // The parsed manifest. It's stored when adding the plugin to the catalog
+}
+
+// CatalogPluginBinary is a binary of a catalog plugin, built for an arch and an OS. The unknown arch or OS means that the
+// binary supports any arch or OS
+type CatalogPluginBinary struct {
+	gorm.Model
+	CatalogPluginID uint    `gorm:"not null;index"`
+	Arch            os.Arch `gorm:"not null"`
+	OS              os.OS   `gorm:"not null"`
+	Key             string  `gorm:"not null"` // The key of the binary object
+	SHA256          string  `gorm:"not null"`
+	Signature       string  `gorm:"not null"` // The ed25519 signature of the plugin publisher, base64 encoded
+	Size            int64   `gorm:"not null"`
+}
+
+func (c *CatalogPlugin) String() string {
+	return c.Repo + "/" + c.Name + "@" + c.Version
+}
+
+// CatalogPluginList returns a list with all the plugins of the catalog
+func CatalogPluginList(ctx *context.Context) ([]*CatalogPlugin, error) {
+	plugins := []*CatalogPlugin{}
+
+	if err := ctx.DB.Order("repo, name, id").Find(&plugins).Error; err != nil {
+		return []*CatalogPlugin{}, fmt.Errorf("error getting the list of catalog plugins: %v", err)
+	}
+
+	return plugins, nil
+}
+
+// Add adds the plugin, its binaries and its manifest (if it has one) to the catalog
+func (c *CatalogPlugin) Add(ctx *context.Context) error {
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		var n int
+		if err := tx.Model(&CatalogPlugin{}).Where("repo = ? AND name = ? AND version = ?", c.Repo, c.Name, c.Version).Count(&n).Error; err != nil {
+			return fmt.Errorf("error checking the catalog plugins: %v", err)
+		}
+
+		if n != 0 {
+			return ErrCatalogPluginExists
+		}
+
+		if err := tx.Create(c).Error; err != nil {
+			return fmt.Errorf("error adding the catalog plugin to the DB: %v", err)
+		}
+
+		for _, b := range c.Binaries {
+			b.CatalogPluginID = c.ID
+			if err := tx.Create(b).Error; err != nil {
+				return fmt.Errorf("error adding the catalog plugin binary to the DB: %v", err)
+			}
+		}
+
+		if c.Manifest != nil {
+			return saveManifest(tx, c.Manifest)
+		}
+
+		return nil
+	})
+}
+
+// Load loads the plugin and its binaries from the catalog using the repo, the name and the version
+func (c *CatalogPlugin) Load(ctx *context.Context) error {
+	if err := ctx.DB.Where("repo = ? AND name = ? AND version = ?", c.Repo, c.Name, c.Version).First(c).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the catalog plugin from the DB: %v", err)
+	}
+
+	var bins []*CatalogPluginBinary
+	if err := ctx.DB.Where("catalog_plugin_id = ?", c.ID).Order("id").Find(&bins).Error; err != nil {
+		return fmt.Errorf("error getting the catalog plugin binaries list: %v", err)
+	}
+
+	c.Binaries = bins
+
+	return nil
+}
+
+// Delete removes the plugin and its binaries from the catalog
+func (c *CatalogPlugin) Delete(ctx *context.Context) error {
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("catalog_plugin_id = ?", c.ID).Delete(&CatalogPluginBinary{}).Error; err != nil {
+			return fmt.Errorf("error removing the catalog plugin binaries: %v", err)
+		}
+
+		// The plugins are removed permanently, so the same version can be uploaded again
+		if err := tx.Unscoped().Delete(c).Error; err != nil {
+			return fmt.Errorf("error removing the catalog plugin: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// Binary returns the binary of the plugin for the arch and the OS. The binaries built for the exact arch and OS are
+// preferred over the ones that support any arch or OS. If there's no binary for them, it returns nil
+func (c *CatalogPlugin) Binary(arch os.Arch, pOS os.OS) *CatalogPluginBinary {
+	var match *CatalogPluginBinary
+	best := -1
+
+	for _, b := range c.Binaries {
+		if (b.Arch != os.ArchUnknown && b.Arch != arch) || (b.OS != os.Unknown && b.OS != pOS) {
+			continue
+		}
+
+		score := 0
+		if b.Arch == arch {
+			score++
+		}
+		if b.OS == pOS {
+			score++
+		}
+
+		if score > best {
+			match = b
+			best = score
+		}
+	}
+
+	return match
+}
@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestCatalogSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestCatalogSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestCatalogSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestCatalog(t *testing.T) {
+	suite.Run(t, &TestCatalogSuite{})
+}
+
+func (s *TestCatalogSuite) TestString() {
+	c := &models.CatalogPlugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+	s.Equal("default/tar@v1.0.0", c.String())
+}
+
+func (s *TestCatalogSuite) TestCatalogPluginList() {
+	s.Run("should return the list of catalog plugins", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugins" WHERE "catalog_plugins"."deleted_at" IS NULL ORDER BY repo, name, id`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version"}).
+			AddRow(1, "default", "tar", "v1.0.0").
+			AddRow(2, "default", "tar", "v1.1.0"),
+		)
+
+		plugins, err := models.CatalogPluginList(s.ctx)
+		s.NoError(err)
+		s.Len(plugins, 2)
+		s.Equal("v1.1.0", plugins[1].Version)
+	})
+
+	s.Run("should return an error if there's an error getting the list", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugins" WHERE "catalog_plugins"."deleted_at" IS NULL ORDER BY repo, name, id`)).WillReturnError(errors.New("testing error"))
+
+		plugins, err := models.CatalogPluginList(s.ctx)
+		s.EqualError(err, "error getting the list of catalog plugins: testing error")
+		s.Equal([]*models.CatalogPlugin{}, plugins)
+	})
+}
+
+func (s *TestCatalogSuite) TestAdd() {
+	s.Run("should add the plugin and its binaries", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "catalog_plugins" WHERE "catalog_plugins"."deleted_at" IS NULL AND ((repo = $1 AND name = $2 AND version = $3))`)).WithArgs("default", "tar", "v1.0.0").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "catalog_plugins"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "catalog_plugin_binaries"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		c := &models.CatalogPlugin{
+			Repo:     "default",
+			Name:     "tar",
+			Version:  "v1.0.0",
+			Binaries: []*models.CatalogPluginBinary{{Arch: os.ArchAmd64, OS: os.Linux, Key: "default/tar/v1.0.0/linux-amd64"}},
+		}
+
+		s.NoError(c.Add(s.ctx))
+		s.Equal(uint(3), c.Binaries[0].CatalogPluginID)
+	})
+
+	s.Run("should add the manifest in the same transaction", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "catalog_plugins"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "catalog_plugins"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnError(gorm.ErrRecordNotFound)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugin_manifests"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		c := &models.CatalogPlugin{
+			Repo:     "default",
+			Name:     "tar",
+			Version:  "v1.0.0",
+			Manifest: &models.PluginManifest{Repo: "default", Name: "tar", Version: "v1.0.0"},
+		}
+
+		s.EqualError(c.Add(s.ctx), "error adding the plugin manifest to the DB: testing error")
+	})
+
+	s.Run("should return an error if the plugin version is already in the catalog", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "catalog_plugins"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		s.mock.ExpectRollback()
+
+		c := &models.CatalogPlugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.Equal(models.ErrCatalogPluginExists, c.Add(s.ctx))
+	})
+
+	s.Run("should return an error if there's an error adding the plugin", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "catalog_plugins"`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "catalog_plugins"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		c := &models.CatalogPlugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.EqualError(c.Add(s.ctx), "error adding the catalog plugin to the DB: testing error")
+	})
+}
+
+func (s *TestCatalogSuite) TestLoad() {
+	s.Run("should load the plugin and its binaries", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugins" WHERE "catalog_plugins"."deleted_at" IS NULL AND ((repo = $1 AND name = $2 AND version = $3)) ORDER BY "catalog_plugins"."id" ASC LIMIT 1`)).WithArgs("default", "tar", "v1.0.0").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version", "manifest_key"}).
+			AddRow(3, "default", "tar", "v1.0.0", "default/tar/v1.0.0/manifest"),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugin_binaries" WHERE "catalog_plugin_binaries"."deleted_at" IS NULL AND ((catalog_plugin_id = $1)) ORDER BY "id"`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "catalog_plugin_id", "arch", "os", "key"}).
+			AddRow(1, 3, os.ArchAmd64, os.Linux, "default/tar/v1.0.0/linux-amd64"),
+		)
+
+		c := &models.CatalogPlugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.NoError(c.Load(s.ctx))
+		s.Equal("default/tar/v1.0.0/manifest", c.ManifestKey)
+		s.Len(c.Binaries, 1)
+	})
+
+	s.Run("should return a not found error if the plugin isn't in the catalog", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugins"`)).WillReturnError(gorm.ErrRecordNotFound)
+
+		c := &models.CatalogPlugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.True(gorm.IsRecordNotFoundError(c.Load(s.ctx)))
+	})
+
+	s.Run("should return an error if there's an error loading the plugin", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugins"`)).WillReturnError(errors.New("testing error"))
+
+		c := &models.CatalogPlugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.EqualError(c.Load(s.ctx), "error loading the catalog plugin from the DB: testing error")
+	})
+}
+
+func (s *TestCatalogSuite) TestDelete() {
+	s.Run("should remove the plugin and its binaries permanently", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "catalog_plugin_binaries" WHERE (catalog_plugin_id = $1)`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "catalog_plugins" WHERE "catalog_plugins"."id" = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectCommit()
+
+		c := &models.CatalogPlugin{Model: gorm.Model{ID: 3}}
+
+		s.NoError(c.Delete(s.ctx))
+	})
+}
+
+func (s *TestCatalogSuite) TestBinary() {
+	c := &models.CatalogPlugin{
+		Binaries: []*models.CatalogPluginBinary{
+			{Arch: os.ArchAmd64, OS: os.Unknown, Key: "any-amd64"},
+			{Arch: os.ArchAmd64, OS: os.Linux, Key: "linux-amd64"},
+		},
+	}
+
+	s.Run("should prefer the binary for the exact arch and OS", func() {
+		s.Equal("linux-amd64", c.Binary(os.ArchAmd64, os.Linux).Key)
+	})
+
+	s.Run("should fall back to the binary that supports any OS", func() {
+		s.Equal("any-amd64", c.Binary(os.ArchAmd64, os.Darwin).Key)
+	})
+
+	s.Run("should return nil if there's no binary for the arch", func() {
+		s.Nil(c.Binary(os.ArchUnknown, os.Linux))
+	})
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AgentCertificate is a client certificate issued by the Core CA to an Agent
+type AgentCertificate struct {
+	gorm.Model
+	AgentHost string    `gorm:"not null"`
+	Serial    string    `gorm:"unique;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	Revoked   bool      `gorm:"not null"`
+}
+
+// AgentCertificateListExpiring returns a list with all the not revoked certificates that expire before a date
+func AgentCertificateListExpiring(ctx *context.Context, before time.Time) ([]*AgentCertificate, error) {
+	certs := []*AgentCertificate{}
+
+	if err := ctx.DB.Where("revoked = ? AND expires_at < ?", false, before).Find(&certs).Error; err != nil {
+		return []*AgentCertificate{}, fmt.Errorf("error getting the list of expiring agent certificates: %v", err)
+	}
+
+	return certs, nil
+}
+
+// AgentCertificateRevokeAll revokes all the certificates of an agent
+func AgentCertificateRevokeAll(ctx *context.Context, host string) error {
+	if err := ctx.DB.Model(&AgentCertificate{}).Where("agent_host = ? AND revoked = ?", host, false).Update("revoked", true).Error; err != nil {
+		return fmt.Errorf("error revoking the agent certificates: %v", err)
+	}
+
+	return nil
+}
+
+// Add creates a new agent certificate in the DB
+func (c *AgentCertificate) Add(ctx *context.Context) error {
+	if err := ctx.DB.Create(c).Error; err != nil {
+		return fmt.Errorf("error adding the agent certificate to the DB: %v", err)
+	}
+
+	return nil
+}
+
+// Load loads the agent certificate from the DB using the serial
+func (c *AgentCertificate) Load(ctx *context.Context) error {
+	if err := ctx.DB.Where("serial = ?", c.Serial).First(c).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the agent certificate from the DB: %v", err)
+	}
+
+	return nil
+}
+
+// LoadLatest loads the not revoked agent certificate that expires the latest from the DB using the agent host
+func (c *AgentCertificate) LoadLatest(ctx *context.Context) error {
+	if err := ctx.DB.Where("agent_host = ? AND revoked = ?", c.AgentHost, false).Order("expires_at desc").First(c).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the agent certificate from the DB: %v", err)
+	}
+
+	return nil
+}
+
+// Revoke revokes the agent certificate
+func (c *AgentCertificate) Revoke(ctx *context.Context) error {
+	c.Revoked = true
+
+	if err := ctx.DB.Save(c).Error; err != nil {
+		return fmt.Errorf("error revoking the agent certificate: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestAgentCertificateSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestAgentCertificateSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestAgentCertificateSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestAgentCertificate(t *testing.T) {
+	suite.Run(t, &TestAgentCertificateSuite{})
+}
+
+func (s *TestAgentCertificateSuite) TestListExpiring() {
+	s.Run("should return the list of expiring certificates", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((revoked = $1 AND expires_at < $2))`)).WithArgs(false, tests.DBAnyTime{}).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "serial"}).
+			AddRow(1, "laptop", "1234").
+			AddRow(2, "server", "5678"),
+		)
+
+		certs, err := models.AgentCertificateListExpiring(s.ctx, time.Now())
+		s.NoError(err)
+		s.Len(certs, 2)
+		s.Equal("laptop", certs[0].AgentHost)
+		s.Equal("5678", certs[1].Serial)
+	})
+
+	s.Run("should return an error if there's an error listing the certificates", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((revoked = $1 AND expires_at < $2))`)).WillReturnError(errors.New("testing error"))
+
+		certs, err := models.AgentCertificateListExpiring(s.ctx, time.Now())
+		s.EqualError(err, "error getting the list of expiring agent certificates: testing error")
+		s.Equal([]*models.AgentCertificate{}, certs)
+	})
+}
+
+func (s *TestAgentCertificateSuite) TestRevokeAll() {
+	s.Run("should revoke all the certificates of the agent", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_certificates" SET "revoked" = $1, "updated_at" = $2 WHERE "agent_certificates"."deleted_at" IS NULL AND ((agent_host = $3 AND revoked = $4))`)).WithArgs(true, tests.DBAnyTime{}, "laptop", false).WillReturnResult(sqlmock.NewResult(0, 2))
+		s.mock.ExpectCommit()
+
+		s.NoError(models.AgentCertificateRevokeAll(s.ctx, "laptop"))
+	})
+
+	s.Run("should return an error if there's an error revoking the certificates", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_certificates" SET "revoked" = $1, "updated_at" = $2 WHERE "agent_certificates"."deleted_at" IS NULL AND ((agent_host = $3 AND revoked = $4))`)).WillReturnError(errors.New("testing error"))
+
+		s.EqualError(models.AgentCertificateRevokeAll(s.ctx, "laptop"), "error revoking the agent certificates: testing error")
+	})
+}
+
+func (s *TestAgentCertificateSuite) TestAdd() {
+	s.Run("should add the certificate correctly", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_certificates" ("created_at","updated_at","deleted_at","agent_host","serial","expires_at","revoked") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "agent_certificates"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", "1234", tests.DBAnyTime{}, false).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		c := &models.AgentCertificate{AgentHost: "laptop", Serial: "1234", ExpiresAt: time.Now()}
+
+		s.NoError(c.Add(s.ctx))
+		s.Equal(uint(1), c.ID)
+	})
+
+	s.Run("should return an error if there's an error adding the certificate", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_certificates" ("created_at","updated_at","deleted_at","agent_host","serial","expires_at","revoked") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "agent_certificates"."id"`)).WillReturnError(errors.New("testing error"))
+
+		c := &models.AgentCertificate{AgentHost: "laptop", Serial: "1234"}
+
+		s.EqualError(c.Add(s.ctx), "error adding the agent certificate to the DB: testing error")
+	})
+}
+
+func (s *TestAgentCertificateSuite) TestLoad() {
+	s.Run("should load the certificate correctly", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((serial = $1)) ORDER BY "agent_certificates"."id" ASC LIMIT 1`)).WithArgs("1234").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "serial", "revoked"}).AddRow(1, "laptop", "1234", true))
+
+		c := &models.AgentCertificate{Serial: "1234"}
+
+		s.NoError(c.Load(s.ctx))
+		s.Equal("laptop", c.AgentHost)
+		s.True(c.Revoked)
+	})
+
+	s.Run("should return a not found error if the certificate isn't found", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((serial = $1)) ORDER BY "agent_certificates"."id" ASC LIMIT 1`)).WillReturnError(gorm.ErrRecordNotFound)
+
+		c := &models.AgentCertificate{Serial: "1234"}
+
+		s.True(gorm.IsRecordNotFoundError(c.Load(s.ctx)))
+	})
+
+	s.Run("should return an error if there's an error loading the certificate", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_certificates" WHERE "agent_certificates"."deleted_at" IS NULL AND ((serial = $1)) ORDER BY "agent_certificates"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		c := &models.AgentCertificate{Serial: "1234"}
+
+		s.EqualError(c.Load(s.ctx), "error loading the agent certificate from the DB: testing error")
+	})
+}
+
+func (s *TestAgentCertificateSuite) TestRevoke() {
+	s.Run("should revoke the certificate", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_certificates" SET "updated_at" = $1, "deleted_at" = $2, "agent_host" = $3, "serial" = $4, "expires_at" = $5, "revoked" = $6 WHERE "agent_certificates"."deleted_at" IS NULL AND "agent_certificates"."id" = $7`)).WithArgs(tests.DBAnyTime{}, nil, "laptop", "1234", tests.DBAnyTime{}, true, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		c := &models.AgentCertificate{Model: gorm.Model{ID: 1}, AgentHost: "laptop", Serial: "1234"}
+
+		s.NoError(c.Revoke(s.ctx))
+		s.True(c.Revoked)
+	})
+
+	s.Run("should return an error if there's an error revoking the certificate", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_certificates" SET "updated_at" = $1, "deleted_at" = $2, "agent_host" = $3, "serial" = $4, "expires_at" = $5, "revoked" = $6 WHERE "agent_certificates"."deleted_at" IS NULL AND "agent_certificates"."id" = $7`)).WillReturnError(errors.New("testing error"))
+
+		c := &models.AgentCertificate{Model: gorm.Model{ID: 1}, AgentHost: "laptop", Serial: "1234"}
+
+		s.EqualError(c.Revoke(s.ctx), "error revoking the agent certificate: testing error")
+	})
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/utils/secret"
+
+	"github.com/jinzhu/gorm"
+	"github.com/rs/xid"
+)
+
+// ErrEnrollmentTokenUsed gets returned when an enrollment token has already been used the maximum number of times
+var ErrEnrollmentTokenUsed = errors.New("enrollment token already used")
+
+// EnrollmentToken is a time limited token that agents can use to join the Core without needing to be accepted manually
+type EnrollmentToken struct {
+	gorm.Model
+	Token     string    `gorm:"-"`               // The plain token. It's only available when the token is created
+	Lookup    string    `gorm:"unique;not null"` // Digest of the token used to find it
+	Hash      string    `gorm:"not null"`        // Salted hash of the token
+	ExpiresAt time.Time `gorm:"not null"`
+	MaxUses   int       `gorm:"not null"` // How many agents can join using the token
+	Uses      int       `gorm:"not null"`
+	Labels    string    // Labels that get added to the agents that join using the token, with the `name=value,name2=value2` format
+}
+
+// EnrollmentTokenList returns a list with all the enrollment tokens
+func EnrollmentTokenList(ctx *context.Context) ([]*EnrollmentToken, error) {
+	tokens := []*EnrollmentToken{}
+
+	if err := ctx.DB.Find(&tokens).Error; err != nil {
+		return []*EnrollmentToken{}, fmt.Errorf("error getting the list of enrollment tokens: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// Add generates the token and creates the enrollment token in the DB
+func (t *EnrollmentToken) Add(ctx *context.Context) error {
+	tkn, err := secret.New(xid.New().String())
+	if err != nil {
+		return fmt.Errorf("error generating the enrollment token: %v", err)
+	}
+
+	h, err := secret.Hash(tkn)
+	if err != nil {
+		return fmt.Errorf("error generating the enrollment token: %v", err)
+	}
+
+	t.Token = tkn
+	t.Lookup = secret.Lookup(tkn)
+	t.Hash = h
+
+	if err := ctx.DB.Create(t).Error; err != nil {
+		return fmt.Errorf("error adding the enrollment token to the DB: %v", err)
+	}
+
+	return nil
+}
+
+// LoadByToken loads the enrollment token from the DB using the plain token
+func (t *EnrollmentToken) LoadByToken(ctx *context.Context, tkn string) error {
+	if err := ctx.DB.Where("lookup = ?", secret.Lookup(tkn)).First(t).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the enrollment token from the DB: %v", err)
+	}
+
+	if !secret.Check(tkn, t.Hash) {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Valid checks whether the enrollment token hasn't expired and can still be used
+func (t *EnrollmentToken) Valid() bool {
+	return time.Now().Before(t.ExpiresAt) && t.Uses < t.MaxUses
+}
+
+// Use increments the uses of the enrollment token. The increment is done in the DB, so a token can't be used more
+// times than allowed by concurrent joins
+func (t *EnrollmentToken) Use(ctx *context.Context) error {
+	rslt := ctx.DB.Model(t).Where("uses < max_uses").UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if rslt.Error != nil {
+		return fmt.Errorf("error using the enrollment token: %v", rslt.Error)
+	}
+
+	if rslt.RowsAffected == 0 {
+		return ErrEnrollmentTokenUsed
+	}
+
+	t.Uses++
+
+	return nil
+}
+
+// Delete removes the enrollment token (using the ID) from the DB
+func (t *EnrollmentToken) Delete(ctx *context.Context) error {
+	rslt := ctx.DB.Where("id = ?", t.ID).Delete(&EnrollmentToken{})
+	if rslt.Error != nil {
+		return fmt.Errorf("error removing the enrollment token: %v", rslt.Error)
+	}
+
+	if rslt.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/secret"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestEnrollmentTokenSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestEnrollmentTokenSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestEnrollmentTokenSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestEnrollmentToken(t *testing.T) {
+	suite.Run(t, &TestEnrollmentTokenSuite{})
+}
+
+func (s *TestEnrollmentTokenSuite) TestList() {
+	s.Run("should return the list of enrollment tokens", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "enrollment_tokens" WHERE "enrollment_tokens"."deleted_at" IS NULL`)).WillReturnRows(sqlmock.NewRows([]string{"id", "max_uses", "labels"}).
+			AddRow(1, 1, "env=prod").
+			AddRow(2, 10, ""),
+		)
+
+		tokens, err := models.EnrollmentTokenList(s.ctx)
+		s.NoError(err)
+		s.Len(tokens, 2)
+		s.Equal("env=prod", tokens[0].Labels)
+		s.Equal(10, tokens[1].MaxUses)
+	})
+
+	s.Run("should return an error if there's an error listing the enrollment tokens", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "enrollment_tokens" WHERE "enrollment_tokens"."deleted_at" IS NULL`)).WillReturnError(errors.New("testing error"))
+
+		tokens, err := models.EnrollmentTokenList(s.ctx)
+		s.EqualError(err, "error getting the list of enrollment tokens: testing error")
+		s.Equal([]*models.EnrollmentToken{}, tokens)
+	})
+}
+
+func (s *TestEnrollmentTokenSuite) TestAdd() {
+	s.Run("should generate the token and add it to the DB", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "enrollment_tokens" ("created_at","updated_at","deleted_at","lookup","hash","expires_at","max_uses","uses","labels") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "enrollment_tokens"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), tests.DBAnyTime{}, 1, 0, "env=prod").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		t := &models.EnrollmentToken{ExpiresAt: time.Now().Add(time.Hour), MaxUses: 1, Labels: "env=prod"}
+
+		s.NoError(t.Add(s.ctx))
+		s.NotEmpty(t.Token)
+		s.Equal(secret.Lookup(t.Token), t.Lookup)
+		s.True(secret.Check(t.Token, t.Hash))
+	})
+
+	s.Run("should return an error if there's an error adding the token to the DB", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "enrollment_tokens" ("created_at","updated_at","deleted_at","lookup","hash","expires_at","max_uses","uses","labels") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "enrollment_tokens"."id"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		t := &models.EnrollmentToken{ExpiresAt: time.Now().Add(time.Hour), MaxUses: 1}
+
+		s.EqualError(t.Add(s.ctx), "error adding the enrollment token to the DB: testing error")
+	})
+}
+
+func (s *TestEnrollmentTokenSuite) TestLoadByToken() {
+	h, err := secret.Hash("token")
+	s.Require().NoError(err)
+
+	s.Run("should load the enrollment token", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "enrollment_tokens" WHERE "enrollment_tokens"."deleted_at" IS NULL AND ((lookup = $1)) ORDER BY "enrollment_tokens"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("token")).WillReturnRows(sqlmock.NewRows([]string{"id", "hash", "max_uses"}).AddRow(1, h, 5))
+
+		t := &models.EnrollmentToken{}
+
+		s.NoError(t.LoadByToken(s.ctx, "token"))
+		s.Equal(uint(1), t.ID)
+		s.Equal(5, t.MaxUses)
+	})
+
+	s.Run("should return a not found error if the hash doesn't match", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "enrollment_tokens" WHERE "enrollment_tokens"."deleted_at" IS NULL AND ((lookup = $1)) ORDER BY "enrollment_tokens"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("token")).WillReturnRows(sqlmock.NewRows([]string{"id", "hash"}).AddRow(1, "invalid$hash"))
+
+		t := &models.EnrollmentToken{}
+
+		s.True(gorm.IsRecordNotFoundError(t.LoadByToken(s.ctx, "token")))
+	})
+
+	s.Run("should return a not found error if the token isn't found", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "enrollment_tokens" WHERE "enrollment_tokens"."deleted_at" IS NULL AND ((lookup = $1)) ORDER BY "enrollment_tokens"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		t := &models.EnrollmentToken{}
+
+		s.True(gorm.IsRecordNotFoundError(t.LoadByToken(s.ctx, "token")))
+	})
+
+	s.Run("should return an error if there's an error loading the token", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "enrollment_tokens" WHERE "enrollment_tokens"."deleted_at" IS NULL AND ((lookup = $1)) ORDER BY "enrollment_tokens"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		t := &models.EnrollmentToken{}
+
+		s.EqualError(t.LoadByToken(s.ctx, "token"), "error loading the enrollment token from the DB: testing error")
+	})
+}
+
+func (s *TestEnrollmentTokenSuite) TestValid() {
+	s.Run("should be valid if it hasn't expired and it has uses left", func() {
+		t := &models.EnrollmentToken{ExpiresAt: time.Now().Add(time.Hour), MaxUses: 2, Uses: 1}
+		s.True(t.Valid())
+	})
+
+	s.Run("should not be valid if it has expired", func() {
+		t := &models.EnrollmentToken{ExpiresAt: time.Now().Add(-time.Hour), MaxUses: 2}
+		s.False(t.Valid())
+	})
+
+	s.Run("should not be valid if it has been used the maximum number of times", func() {
+		t := &models.EnrollmentToken{ExpiresAt: time.Now().Add(time.Hour), MaxUses: 1, Uses: 1}
+		s.False(t.Valid())
+	})
+}
+
+func (s *TestEnrollmentTokenSuite) TestUse() {
+	s.Run("should increment the uses of the token", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "enrollment_tokens" SET "uses" = uses + 1 WHERE "enrollment_tokens"."deleted_at" IS NULL AND "enrollment_tokens"."id" = $1 AND ((uses < max_uses))`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		t := &models.EnrollmentToken{Model: gorm.Model{ID: 1}, MaxUses: 1}
+
+		s.NoError(t.Use(s.ctx))
+		s.Equal(1, t.Uses)
+	})
+
+	s.Run("should return an error if the token has already been used the maximum number of times", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "enrollment_tokens" SET "uses" = uses + 1 WHERE "enrollment_tokens"."deleted_at" IS NULL AND "enrollment_tokens"."id" = $1 AND ((uses < max_uses))`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 0))
+		s.mock.ExpectCommit()
+
+		t := &models.EnrollmentToken{Model: gorm.Model{ID: 1}, MaxUses: 1, Uses: 1}
+
+		s.Equal(models.ErrEnrollmentTokenUsed, t.Use(s.ctx))
+		s.Equal(1, t.Uses)
+	})
+
+	s.Run("should return an error if there's an error using the token", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "enrollment_tokens" SET "uses" = uses + 1 WHERE "enrollment_tokens"."deleted_at" IS NULL AND "enrollment_tokens"."id" = $1 AND ((uses < max_uses))`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		t := &models.EnrollmentToken{Model: gorm.Model{ID: 1}, MaxUses: 1}
+
+		s.EqualError(t.Use(s.ctx), "error using the enrollment token: testing error")
+	})
+}
+
+func (s *TestEnrollmentTokenSuite) TestDelete() {
+	s.Run("should remove the token from the DB", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "enrollment_tokens" SET "deleted_at"=$1 WHERE "enrollment_tokens"."deleted_at" IS NULL AND ((id = $2))`)).WithArgs(tests.DBAnyTime{}, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		t := &models.EnrollmentToken{Model: gorm.Model{ID: 1}}
+
+		s.NoError(t.Delete(s.ctx))
+	})
+
+	s.Run("should return a not found error if the token doesn't exist", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "enrollment_tokens" SET "deleted_at"=$1 WHERE "enrollment_tokens"."deleted_at" IS NULL AND ((id = $2))`)).WithArgs(tests.DBAnyTime{}, 1).WillReturnResult(sqlmock.NewResult(1, 0))
+		s.mock.ExpectCommit()
+
+		t := &models.EnrollmentToken{Model: gorm.Model{ID: 1}}
+
+		s.True(gorm.IsRecordNotFoundError(t.Delete(s.ctx)))
+	})
+}
@@ -0,0 +1,281 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/jinzhu/gorm"
+	stdSSH "golang.org/x/crypto/ssh"
+)
+
+// ErrNoPendingHostKeys gets returned when accepting or rejecting the host keys of an agent without host key changes
+var ErrNoPendingHostKeys = errors.New("the agent has no pending SSH host keys")
+
+// AgentHostKey is an SSH host key of an agent. The trusted keys are used to verify the agent host. The keys that aren't
+// trusted are the ones presented by the host after its keys have changed, which have to be reviewed and accepted.
+// The host keys of the bastions are stored in the same table, with the bastion name instead of the agent host
+type AgentHostKey struct {
+	gorm.Model
+	AgentHost   string `gorm:"not null;index"`
+	BastionName string `gorm:"index"`
+	Key         string `gorm:"size:9999;not null"` // The key in the known_hosts format
+	Fingerprint string `gorm:"not null"`
+	Trusted     bool   `gorm:"not null"`
+}
+
+// HostKeyAction is an action of the audit trail of the agents SSH host keys
+type HostKeyAction string
+
+const (
+	// HostKeyActionTrust is when the host keys are trusted when installing the agent
+	HostKeyActionTrust HostKeyAction = "trust"
+	// HostKeyActionMismatch is when the host presents keys that aren't trusted
+	HostKeyActionMismatch HostKeyAction = "mismatch"
+	// HostKeyActionAccept is when the pending host keys get accepted and replace the trusted ones
+	HostKeyActionAccept HostKeyAction = "accept"
+	// HostKeyActionReject is when the pending host keys get rejected
+	HostKeyActionReject HostKeyAction = "reject"
+)
+
+// AgentHostKeyEvent is an entry of the audit trail of the agents SSH host keys
+type AgentHostKeyEvent struct {
+	gorm.Model
+	AgentHost       string        `gorm:"not null;index"`
+	Action          HostKeyAction `gorm:"not null"`
+	Usr             string        // The user that has done the action. It's empty for the actions done by the Core
+	OldFingerprints string        `gorm:"size:9999"` // The different fingerprints are splitted with `,` between each one
+	NewFingerprints string        `gorm:"size:9999"`
+}
+
+// HostKeyFingerprint returns the SHA256 fingerprint of a host key in the known_hosts format
+func HostKeyFingerprint(key string) (string, error) {
+	_, _, k, _, _, err := stdSSH.ParseKnownHosts([]byte(key))
+	if err != nil {
+		return "", fmt.Errorf("error parsing the host key: %v", err)
+	}
+
+	return stdSSH.FingerprintSHA256(k), nil
+}
+
+// AgentHostKeyEventList returns the audit trail of the SSH host keys of an agent
+func AgentHostKeyEventList(ctx *context.Context, host string) ([]*AgentHostKeyEvent, error) {
+	events := []*AgentHostKeyEvent{}
+
+	if err := ctx.DB.Where("agent_host = ?", host).Order("id").Find(&events).Error; err != nil {
+		return []*AgentHostKeyEvent{}, fmt.Errorf("error getting the host keys events list: %v", err)
+	}
+
+	return events, nil
+}
+
+// LoadHostKeys loads all the SSH host keys of an agent, both the trusted and the pending ones
+func (a *Agent) LoadHostKeys(ctx *context.Context) error {
+	var keys []*AgentHostKey
+	if err := ctx.DB.Where("agent_host = ?", a.Host).Order("id").Find(&keys).Error; err != nil {
+		return fmt.Errorf("error getting the host keys list: %v", err)
+	}
+
+	a.HostKeys = keys
+
+	return nil
+}
+
+// TrustedHostKeys returns the loaded trusted SSH host keys of the agent
+func (a *Agent) TrustedHostKeys() []*AgentHostKey {
+	return a.filterHostKeys(true)
+}
+
+// PendingHostKeys returns the loaded SSH host keys of the agent that are pending to be reviewed. If there are pending keys,
+// the agent host keys have changed and the agent can't be reached through SSH until they're accepted
+func (a *Agent) PendingHostKeys() []*AgentHostKey {
+	return a.filterHostKeys(false)
+}
+
+func (a *Agent) filterHostKeys(trusted bool) []*AgentHostKey {
+	keys := []*AgentHostKey{}
+	for _, k := range a.HostKeys {
+		if k.Trusted == trusted {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// TrustHostKeys replaces all the SSH host keys of the agent with the keys, which are trusted
+func (a *Agent) TrustHostKeys(ctx *context.Context, keys []string) error {
+	if err := a.LoadHostKeys(ctx); err != nil {
+		return err
+	}
+
+	old := a.TrustedHostKeys()
+
+	return a.replaceHostKeys(ctx, func(tx *gorm.DB) error {
+		if err := tx.Where("agent_host = ?", a.Host).Delete(&AgentHostKey{}).Error; err != nil {
+			return fmt.Errorf("error removing the old host keys: %v", err)
+		}
+
+		added, err := addHostKeys(tx, a.Host, keys, true)
+		if err != nil {
+			return err
+		}
+
+		return addHostKeyEvent(tx, a.Host, HostKeyActionTrust, "", old, added)
+	})
+}
+
+// RecordHostKeyMismatch stores the keys presented by the host as pending keys, replacing the previous pending ones. If the
+// keys are already pending, nothing gets recorded
+func (a *Agent) RecordHostKeyMismatch(ctx *context.Context, keys []string) error {
+	if err := a.LoadHostKeys(ctx); err != nil {
+		return err
+	}
+
+	pending := []string{}
+	for _, k := range a.PendingHostKeys() {
+		pending = append(pending, k.Key)
+	}
+
+	if sameHostKeys(pending, keys) {
+		return nil
+	}
+
+	return a.replaceHostKeys(ctx, func(tx *gorm.DB) error {
+		if err := tx.Where("agent_host = ? AND trusted = ?", a.Host, false).Delete(&AgentHostKey{}).Error; err != nil {
+			return fmt.Errorf("error removing the old pending host keys: %v", err)
+		}
+
+		added, err := addHostKeys(tx, a.Host, keys, false)
+		if err != nil {
+			return err
+		}
+
+		return addHostKeyEvent(tx, a.Host, HostKeyActionMismatch, "", a.TrustedHostKeys(), added)
+	})
+}
+
+// AcceptHostKeys replaces the trusted SSH host keys of the agent with the pending ones
+func (a *Agent) AcceptHostKeys(ctx *context.Context, usr string) error {
+	if err := a.LoadHostKeys(ctx); err != nil {
+		return err
+	}
+
+	pending := a.PendingHostKeys()
+	if len(pending) == 0 {
+		return ErrNoPendingHostKeys
+	}
+
+	return a.replaceHostKeys(ctx, func(tx *gorm.DB) error {
+		if err := tx.Where("agent_host = ? AND trusted = ?", a.Host, true).Delete(&AgentHostKey{}).Error; err != nil {
+			return fmt.Errorf("error removing the old host keys: %v", err)
+		}
+
+		if err := tx.Model(&AgentHostKey{}).Where("agent_host = ? AND trusted = ?", a.Host, false).Update("trusted", true).Error; err != nil {
+			return fmt.Errorf("error trusting the pending host keys: %v", err)
+		}
+
+		return addHostKeyEvent(tx, a.Host, HostKeyActionAccept, usr, a.TrustedHostKeys(), pending)
+	})
+}
+
+// RejectHostKeys removes the pending SSH host keys of the agent
+func (a *Agent) RejectHostKeys(ctx *context.Context, usr string) error {
+	if err := a.LoadHostKeys(ctx); err != nil {
+		return err
+	}
+
+	pending := a.PendingHostKeys()
+	if len(pending) == 0 {
+		return ErrNoPendingHostKeys
+	}
+
+	return a.replaceHostKeys(ctx, func(tx *gorm.DB) error {
+		if err := tx.Where("agent_host = ? AND trusted = ?", a.Host, false).Delete(&AgentHostKey{}).Error; err != nil {
+			return fmt.Errorf("error removing the pending host keys: %v", err)
+		}
+
+		return addHostKeyEvent(tx, a.Host, HostKeyActionReject, usr, a.TrustedHostKeys(), pending)
+	})
+}
+
+// replaceHostKeys runs the changes of the agent host keys in a transaction and reloads the keys
+func (a *Agent) replaceHostKeys(ctx *context.Context, f func(tx *gorm.DB) error) error {
+	if err := ctx.DB.Transaction(f); err != nil {
+		return err
+	}
+
+	return a.LoadHostKeys(ctx)
+}
+
+// addHostKeys adds the keys of the host to the DB
+func addHostKeys(tx *gorm.DB, host string, keys []string, trusted bool) ([]*AgentHostKey, error) {
+	added := []*AgentHostKey{}
+	for _, k := range keys {
+		fp, err := HostKeyFingerprint(k)
+		if err != nil {
+			return nil, err
+		}
+
+		hk := &AgentHostKey{AgentHost: host, Key: k, Fingerprint: fp, Trusted: trusted}
+		if err := tx.Create(hk).Error; err != nil {
+			return nil, fmt.Errorf("error adding the host key to the DB: %v", err)
+		}
+
+		added = append(added, hk)
+	}
+
+	return added, nil
+}
+
+// addHostKeyEvent adds a new entry to the host keys audit trail
+func addHostKeyEvent(tx *gorm.DB, host string, action HostKeyAction, usr string, old, new []*AgentHostKey) error {
+	e := &AgentHostKeyEvent{
+		AgentHost:       host,
+		Action:          action,
+		Usr:             usr,
+		OldFingerprints: strings.Join(Fingerprints(old), ","),
+		NewFingerprints: strings.Join(Fingerprints(new), ","),
+	}
+
+	if err := tx.Create(e).Error; err != nil {
+		return fmt.Errorf("error adding the host keys event to the DB: %v", err)
+	}
+
+	return nil
+}
+
+// Fingerprints returns the fingerprints of the host keys
+func Fingerprints(keys []*AgentHostKey) []string {
+	fps := []string{}
+	for _, k := range keys {
+		fps = append(fps, k.Fingerprint)
+	}
+
+	return fps
+}
+
+// sameHostKeys checks whether two lists have the same keys, regardless of their order
+func sameHostKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	testOldHostKey = "laptop ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBL1gLRk1rz6bWtEdvVMmXQGLHIGOjFmwOBuNvJCNLEH"
+	testOldHostFp  = "SHA256:57DGMLTVWc+/dLDexiOjU1l5K7Z2XVw15lW4C53r8Pc"
+	testNewHostKey = "laptop ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHbdmyhz0GHUiQlk1VVRN3XnVUPU3YbZkX8JUnHa0ORq"
+	testNewHostFp  = "SHA256:4KX3AfXpb6qRWa+rNVxb2sbpr8kXqIBbX8qFhsOth8M"
+)
+
+type TestHostKeySuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestHostKeySuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestHostKeySuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestHostKey(t *testing.T) {
+	suite.Run(t, &TestHostKeySuite{})
+}
+
+func (s *TestHostKeySuite) expectHostKeys(keys ...*models.AgentHostKey) {
+	rows := sqlmock.NewRows([]string{"id", "agent_host", "key", "fingerprint", "trusted"})
+	for _, k := range keys {
+		rows.AddRow(k.ID, k.AgentHost, k.Key, k.Fingerprint, k.Trusted)
+	}
+
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys" WHERE "agent_host_keys"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY "id"`)).WithArgs("laptop").WillReturnRows(rows)
+}
+
+func oldHostKey() *models.AgentHostKey {
+	k := &models.AgentHostKey{AgentHost: "laptop", Key: testOldHostKey, Fingerprint: testOldHostFp, Trusted: true}
+	k.ID = 1
+
+	return k
+}
+
+func newHostKey(trusted bool) *models.AgentHostKey {
+	k := &models.AgentHostKey{AgentHost: "laptop", Key: testNewHostKey, Fingerprint: testNewHostFp, Trusted: trusted}
+	k.ID = 2
+
+	return k
+}
+
+func (s *TestHostKeySuite) TestHostKeyFingerprint() {
+	s.Run("should return the SHA256 fingerprint of the key", func() {
+		fp, err := models.HostKeyFingerprint(testOldHostKey)
+		s.NoError(err)
+		s.Equal(testOldHostFp, fp)
+	})
+
+	s.Run("should return an error if the key is invalid", func() {
+		_, err := models.HostKeyFingerprint("invalid")
+		s.Error(err)
+		s.Contains(err.Error(), "error parsing the host key")
+	})
+}
+
+func (s *TestHostKeySuite) TestLoadHostKeys() {
+	s.Run("should load and split the trusted and pending keys", func() {
+		s.expectHostKeys(oldHostKey(), newHostKey(false))
+
+		a := &models.Agent{Host: "laptop"}
+		s.NoError(a.LoadHostKeys(s.ctx))
+		s.Equal([]string{testOldHostFp}, models.Fingerprints(a.TrustedHostKeys()))
+		s.Equal([]string{testNewHostFp}, models.Fingerprints(a.PendingHostKeys()))
+	})
+
+	s.Run("should return an error if there's an error loading the keys", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys"`)).WillReturnError(errors.New("testing error"))
+
+		a := &models.Agent{Host: "laptop"}
+		s.EqualError(a.LoadHostKeys(s.ctx), "error getting the host keys list: testing error")
+	})
+}
+
+func (s *TestHostKeySuite) TestTrustHostKeys() {
+	s.Run("should replace all the keys and add the event", func() {
+		s.expectHostKeys(oldHostKey())
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_host_keys" SET "deleted_at"=$1 WHERE "agent_host_keys"."deleted_at" IS NULL AND ((agent_host = $2))`)).WithArgs(tests.DBAnyTime{}, "laptop").WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_keys" ("created_at","updated_at","deleted_at","agent_host","bastion_name","key","fingerprint","trusted") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "agent_host_keys"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", "", testNewHostKey, testNewHostFp, true).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_key_events" ("created_at","updated_at","deleted_at","agent_host","action","usr","old_fingerprints","new_fingerprints") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "agent_host_key_events"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", models.HostKeyActionTrust, "", testOldHostFp, testNewHostFp).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.expectHostKeys(newHostKey(true))
+
+		a := &models.Agent{Host: "laptop"}
+		s.NoError(a.TrustHostKeys(s.ctx, []string{testNewHostKey}))
+		s.Equal([]string{testNewHostFp}, models.Fingerprints(a.TrustedHostKeys()))
+	})
+
+	s.Run("should rollback the changes if a key is invalid", func() {
+		s.expectHostKeys()
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_host_keys" SET "deleted_at"=$1`)).WillReturnResult(sqlmock.NewResult(1, 0))
+		s.mock.ExpectRollback()
+
+		a := &models.Agent{Host: "laptop"}
+		err := a.TrustHostKeys(s.ctx, []string{"invalid"})
+		s.Error(err)
+		s.Contains(err.Error(), "error parsing the host key")
+	})
+}
+
+func (s *TestHostKeySuite) TestRecordHostKeyMismatch() {
+	s.Run("should store the new keys as pending and add the event", func() {
+		s.expectHostKeys(oldHostKey())
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_host_keys" SET "deleted_at"=$1 WHERE "agent_host_keys"."deleted_at" IS NULL AND ((agent_host = $2 AND trusted = $3))`)).WithArgs(tests.DBAnyTime{}, "laptop", false).WillReturnResult(sqlmock.NewResult(1, 0))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_keys"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", "", testNewHostKey, testNewHostFp, false).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_key_events"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", models.HostKeyActionMismatch, "", testOldHostFp, testNewHostFp).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.expectHostKeys(oldHostKey(), newHostKey(false))
+
+		a := &models.Agent{Host: "laptop"}
+		s.NoError(a.RecordHostKeyMismatch(s.ctx, []string{testNewHostKey}))
+		s.Len(a.PendingHostKeys(), 1)
+	})
+
+	s.Run("should not record anything if the keys are already pending", func() {
+		s.expectHostKeys(oldHostKey(), newHostKey(false))
+
+		a := &models.Agent{Host: "laptop"}
+		s.NoError(a.RecordHostKeyMismatch(s.ctx, []string{testNewHostKey}))
+	})
+}
+
+func (s *TestHostKeySuite) TestAcceptHostKeys() {
+	s.Run("should replace the trusted keys with the pending ones and add the event", func() {
+		s.expectHostKeys(oldHostKey(), newHostKey(false))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_host_keys" SET "deleted_at"=$1 WHERE "agent_host_keys"."deleted_at" IS NULL AND ((agent_host = $2 AND trusted = $3))`)).WithArgs(tests.DBAnyTime{}, "laptop", true).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_host_keys" SET "trusted" = $1, "updated_at" = $2 WHERE "agent_host_keys"."deleted_at" IS NULL AND ((agent_host = $3 AND trusted = $4))`)).WithArgs(true, tests.DBAnyTime{}, "laptop", false).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_key_events"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", models.HostKeyActionAccept, "admin", testOldHostFp, testNewHostFp).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.expectHostKeys(newHostKey(true))
+
+		a := &models.Agent{Host: "laptop"}
+		s.NoError(a.AcceptHostKeys(s.ctx, "admin"))
+		s.Empty(a.PendingHostKeys())
+		s.Equal([]string{testNewHostFp}, models.Fingerprints(a.TrustedHostKeys()))
+	})
+
+	s.Run("should return an error if there are no pending keys", func() {
+		s.expectHostKeys(oldHostKey())
+
+		a := &models.Agent{Host: "laptop"}
+		s.Equal(models.ErrNoPendingHostKeys, a.AcceptHostKeys(s.ctx, "admin"))
+	})
+}
+
+func (s *TestHostKeySuite) TestRejectHostKeys() {
+	s.Run("should remove the pending keys and add the event", func() {
+		s.expectHostKeys(oldHostKey(), newHostKey(false))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_host_keys" SET "deleted_at"=$1 WHERE "agent_host_keys"."deleted_at" IS NULL AND ((agent_host = $2 AND trusted = $3))`)).WithArgs(tests.DBAnyTime{}, "laptop", false).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_key_events"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", models.HostKeyActionReject, "admin", testOldHostFp, testNewHostFp).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+		s.expectHostKeys(oldHostKey())
+
+		a := &models.Agent{Host: "laptop"}
+		s.NoError(a.RejectHostKeys(s.ctx, "admin"))
+		s.Empty(a.PendingHostKeys())
+	})
+
+	s.Run("should return an error if there are no pending keys", func() {
+		s.expectHostKeys(oldHostKey())
+
+		a := &models.Agent{Host: "laptop"}
+		s.Equal(models.ErrNoPendingHostKeys, a.RejectHostKeys(s.ctx, "admin"))
+	})
+}
+
+func (s *TestHostKeySuite) TestAgentHostKeyEventList() {
+	s.Run("should return the events of the agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_key_events" WHERE "agent_host_key_events"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY "id"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "action"}).AddRow(1, "laptop", "trust").AddRow(2, "laptop", "mismatch"))
+
+		events, err := models.AgentHostKeyEventList(s.ctx, "laptop")
+		s.NoError(err)
+		s.Require().Len(events, 2)
+		s.Equal(models.HostKeyActionMismatch, events[1].Action)
+	})
+
+	s.Run("should return an error if there's an error getting the events", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_key_events"`)).WillReturnError(errors.New("testing error"))
+
+		events, err := models.AgentHostKeyEventList(s.ctx, "laptop")
+		s.EqualError(err, "error getting the host keys events list: testing error")
+		s.Empty(events)
+	})
+}
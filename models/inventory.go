@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AgentInventory is a snapshot of the hardware and storage of an Agent. Each sync stores a new version, so the history is kept
+type AgentInventory struct {
+	gorm.Model
+	AgentHost string `gorm:"not null"`
+	Version   int    `gorm:"not null"`
+
+	Hostname string
+	MemTotal uint64 // The total memory in bytes
+
+	Devices []*AgentInventoryDevice `gorm:"foreignkey:InventoryID"`
+	NICs    []*AgentInventoryNIC    `gorm:"foreignkey:InventoryID"`
+}
+
+// AgentInventoryDevice is a block device of an Agent (disk, partition, LVM volume, RAID array...)
+type AgentInventoryDevice struct {
+	gorm.Model
+	InventoryID uint   `gorm:"not null"`
+	Name        string `gorm:"not null"`
+	Type        string `gorm:"not null"` // The type reported by the OS (e.g. `disk`, `part`, `lvm`, `raid1`...)
+	Size        uint64 // The size in bytes
+	DevModel    string
+	Parents     string // The devices that the device is built on top of, splitted with `,`
+	FSType      string
+	FSUUID      string
+	Mountpoint  string
+}
+
+// AgentInventoryNIC is a network interface of an Agent
+type AgentInventoryNIC struct {
+	gorm.Model
+	InventoryID uint   `gorm:"not null"`
+	Name        string `gorm:"not null"`
+	MAC         string
+	MTU         int
+	State       string
+	Addresses   string // The addresses (in CIDR notation) of the interface, splitted with `,`
+}
+
+// Add stores a new version of the inventory of the agent in the DB. The agent row is locked while the version is computed,
+// so concurrent syncs of the same agent don't store the same version
+func (i *AgentInventory) Add(ctx *context.Context) error {
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Select("id").Where("host = ?", i.AgentHost).First(&Agent{}).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return err
+			}
+
+			return fmt.Errorf("error locking the agent: %v", err)
+		}
+
+		var last AgentInventory
+		if err := tx.Select("version").Where("agent_host = ?", i.AgentHost).Order("version desc").First(&last).Error; err != nil {
+			if !gorm.IsRecordNotFoundError(err) {
+				return fmt.Errorf("error getting the last agent inventory version: %v", err)
+			}
+		}
+		i.Version = last.Version + 1
+
+		if err := tx.Create(i).Error; err != nil {
+			return fmt.Errorf("error adding the agent inventory to the DB: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// Load loads the latest version of the inventory of the agent from the DB
+func (i *AgentInventory) Load(ctx *context.Context) error {
+	if err := ctx.DB.Preload("Devices").Preload("NICs").Where("agent_host = ?", i.AgentHost).Order("version desc").First(i).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the agent inventory from the DB: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestAgentInventorySuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestAgentInventorySuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestAgentInventorySuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestAgentInventory(t *testing.T) {
+	suite.Run(t, &TestAgentInventorySuite{})
+}
+
+func (s *TestAgentInventorySuite) TestAdd() {
+	s.Run("should add a new version of the inventory correctly", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1 FOR UPDATE`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT version FROM "agent_inventories"  WHERE "agent_inventories"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY version desc,"agent_inventories"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_inventories" ("created_at","updated_at","deleted_at","agent_host","version","hostname","mem_total") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "agent_inventories"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", 3, "laptop", 8589934592).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_inventory_devices" ("created_at","updated_at","deleted_at","inventory_id","name","type","size","dev_model","parents","fs_type","fs_uuid","mountpoint") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) RETURNING "agent_inventory_devices"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_inventory_nics" ("created_at","updated_at","deleted_at","inventory_id","name","mac","mtu","state","addresses") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "agent_inventory_nics"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		i := &models.AgentInventory{
+			AgentHost: "laptop",
+			Hostname:  "laptop",
+			MemTotal:  8589934592,
+			Devices: []*models.AgentInventoryDevice{
+				&models.AgentInventoryDevice{Name: "sda", Type: "disk", Size: 500107862016},
+			},
+			NICs: []*models.AgentInventoryNIC{
+				&models.AgentInventoryNIC{Name: "eth0", MAC: "52:54:00:12:34:56", MTU: 1500, State: "UP"},
+			},
+		}
+
+		s.NoError(i.Add(s.ctx))
+		s.Equal(3, i.Version)
+	})
+
+	s.Run("should start with the first version if there's no previous inventory", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1 FOR UPDATE`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT version FROM "agent_inventories"  WHERE "agent_inventories"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY version desc,"agent_inventories"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_inventories" ("created_at","updated_at","deleted_at","agent_host","version","hostname","mem_total") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "agent_inventories"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", 1, "", 0).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		i := &models.AgentInventory{AgentHost: "laptop"}
+
+		s.NoError(i.Add(s.ctx))
+		s.Equal(1, i.Version)
+	})
+
+	s.Run("should return an error if there's an error locking the agent", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1 FOR UPDATE`)).WithArgs("laptop").WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		i := &models.AgentInventory{AgentHost: "laptop"}
+
+		s.EqualError(i.Add(s.ctx), "error locking the agent: testing error")
+	})
+
+	s.Run("should return an error if there's an error getting the last version", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1 FOR UPDATE`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT version FROM "agent_inventories"  WHERE "agent_inventories"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY version desc,"agent_inventories"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		i := &models.AgentInventory{AgentHost: "laptop"}
+
+		s.EqualError(i.Add(s.ctx), "error getting the last agent inventory version: testing error")
+	})
+
+	s.Run("should return an error if there's an error adding the inventory", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM "agents"  WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1 FOR UPDATE`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT version FROM "agent_inventories"  WHERE "agent_inventories"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY version desc,"agent_inventories"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_inventories" ("created_at","updated_at","deleted_at","agent_host","version","hostname","mem_total") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "agent_inventories"."id"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		i := &models.AgentInventory{AgentHost: "laptop"}
+
+		s.EqualError(i.Add(s.ctx), "error adding the agent inventory to the DB: testing error")
+	})
+}
+
+func (s *TestAgentInventorySuite) TestLoad() {
+	s.Run("should load the latest version of the inventory correctly", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_inventories"  WHERE "agent_inventories"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY version desc,"agent_inventories"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "version", "hostname"}).AddRow(3, "laptop", 3, "laptop"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_inventory_devices"  WHERE "agent_inventory_devices"."deleted_at" IS NULL AND (("inventory_id" IN ($1)))`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "inventory_id", "name", "type"}).AddRow(1, 3, "sda", "disk"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_inventory_nics"  WHERE "agent_inventory_nics"."deleted_at" IS NULL AND (("inventory_id" IN ($1)))`)).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "inventory_id", "name"}).AddRow(1, 3, "eth0"))
+
+		i := &models.AgentInventory{AgentHost: "laptop"}
+
+		s.NoError(i.Load(s.ctx))
+		s.Equal(3, i.Version)
+		s.Equal("laptop", i.Hostname)
+		s.Require().Len(i.Devices, 1)
+		s.Equal("sda", i.Devices[0].Name)
+		s.Require().Len(i.NICs, 1)
+		s.Equal("eth0", i.NICs[0].Name)
+	})
+
+	s.Run("should return a not found error if the agent has no inventory", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_inventories"  WHERE "agent_inventories"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY version desc,"agent_inventories"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		i := &models.AgentInventory{AgentHost: "laptop"}
+
+		s.EqualError(i.Load(s.ctx), "record not found")
+	})
+
+	s.Run("should return an error if there's an error loading the inventory", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_inventories"  WHERE "agent_inventories"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY version desc,"agent_inventories"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		i := &models.AgentInventory{AgentHost: "laptop"}
+
+		s.EqualError(i.Load(s.ctx), "error loading the agent inventory from the DB: testing error")
+	})
+}
@@ -21,12 +21,17 @@ type Job struct {
 	AgentHost  string    `gorm:"not null"`
 	Status     JobStatus `gorm:"not null"`
 	Time       time.Time
-	Config     string `gorm:"not null"`
+	Config     string `gorm:"not null"` // The effective config: the plugin defaults merged with the group, agent and job overrides
 	BucketName string `gorm:"not null;unique"`
 	Info       string
 
 	Mux            sync.Mutex `gorm:"-"`
 	ReconnAttempts int
+
+	PluginVersion    string // The exact version of the plugin that the job was resolved to
+	PluginConstraint string // The version constraint the job was resolved with (e.g. `~1.2`). The scheduled jobs are resolved again within it when the plugin versions change
+
+	ConfigTemplate bool // Whether the config is a template with the agent facts. Only the templates get rendered before starting the job
 }
 
 // JobStatus is the status of a job
@@ -78,7 +78,7 @@ func (s *TestJobSuite) TestList() {
 func (s *TestJobSuite) TestAdd() {
 	s.Run("should add the job to the DB", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING "jobs"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts","plugin_version","plugin_constraint","config_template") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING "jobs"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).
 			AddRow(1),
 		)
 		s.mock.ExpectCommit()
@@ -95,7 +95,7 @@ func (s *TestJobSuite) TestAdd() {
 
 	s.Run("should return an error if there's an error adding the job to the DB", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING "jobs"."id"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts","plugin_version","plugin_constraint","config_template") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING "jobs"."id"`)).WillReturnError(errors.New("testing error"))
 		s.mock.ExpectCommit()
 
 		j := models.Job{
@@ -188,7 +188,7 @@ func (s *TestJobSuite) TestLoad() {
 func (s *TestJobSuite) TestUpdate() {
 	s.Run("should update the job correctly", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $11`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10, "plugin_version" = $11, "plugin_constraint" = $12, "config_template" = $13  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $14`)).WillReturnResult(sqlmock.NewResult(1, 1))
 		s.mock.ExpectCommit()
 
 		j := &models.Job{
@@ -201,7 +201,7 @@ func (s *TestJobSuite) TestUpdate() {
 
 	s.Run("should return an error if there's an error updating the job", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $11`)).WillReturnError(errors.New(`testing error`))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10, "plugin_version" = $11, "plugin_constraint" = $12, "config_template" = $13  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $14`)).WillReturnError(errors.New(`testing error`))
 
 		j := &models.Job{
 			Model:     gorm.Model{ID: 1},
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AgentLabel is a name / value label of an Agent. Labels are used to select groups of agents
+type AgentLabel struct {
+	gorm.Model
+	AgentHost string `gorm:"not null"`
+	Name      string `gorm:"not null"`
+	Value     string
+}
+
+// Add adds the label to the DB
+func (l *AgentLabel) Add(ctx *context.Context) error {
+	if err := ctx.DB.Create(l).Error; err != nil {
+		return fmt.Errorf("error adding the agent label to the DB: %v", err)
+	}
+
+	return nil
+}
+
+// Set adds the label to the DB or, if the agent already has a label with the same name, updates its value
+func (l *AgentLabel) Set(ctx *context.Context) error {
+	if err := ctx.DB.Where(AgentLabel{AgentHost: l.AgentHost, Name: l.Name}).Assign(AgentLabel{Value: l.Value}).FirstOrCreate(l).Error; err != nil {
+		return fmt.Errorf("error setting the agent label in the DB: %v", err)
+	}
+
+	return nil
+}
+
+// Delete removes the label (using the agent host and the label name) from the DB
+func (l *AgentLabel) Delete(ctx *context.Context) error {
+	if err := ctx.DB.Where("agent_host = ? AND name = ?", l.AgentHost, l.Name).Delete(&AgentLabel{}).Error; err != nil {
+		return fmt.Errorf("error removing the agent label: %v", err)
+	}
+
+	return nil
+}
+
+// LabelSelector selects agents by their labels. All the labels have to match
+type LabelSelector map[string]string
+
+// ParseLabelSelector parses a label selector with the `name=value,name2=value2` format
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	sel := LabelSelector{}
+
+	for _, l := range strings.Split(s, ",") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return LabelSelector{}, fmt.Errorf("invalid label selector: '%s'", l)
+		}
+
+		sel[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return sel, nil
+}
+
+// String returns the label selector with the `name=value,name2=value2` format
+func (sel LabelSelector) String() string {
+	labels := []string{}
+	for k, v := range sel {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+
+	return strings.Join(labels, ",")
+}
+
+// Matches checks whether all the labels of the selector are in the labels
+func (sel LabelSelector) Matches(labels LabelSelector) bool {
+	for k, v := range sel {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AgentListBySelector returns a list with all the agents that match the label selector
+func AgentListBySelector(ctx *context.Context, sel LabelSelector) ([]*Agent, error) {
+	agents := []*Agent{}
+
+	names := []string{}
+	for k := range sel {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	q := ctx.DB.Select("id, created_at, updated_at, host, accepted, minio_key, secret_lookup, secret_hash, ssh_port, ssh_user, version, arch, os, os_version, distro, distro_version, quarantined").Where(&Agent{Accepted: true})
+	for _, k := range names {
+		q = q.Where("host IN (SELECT agent_host FROM agent_labels WHERE deleted_at IS NULL AND name = ? AND value = ?)", k, sel[k])
+	}
+
+	if err := q.Find(&agents).Error; err != nil {
+		return []*Agent{}, fmt.Errorf("error getting the list of agents: %v", err)
+	}
+
+	return agents, nil
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestAgentLabelSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestAgentLabelSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestAgentLabelSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestAgentLabel(t *testing.T) {
+	suite.Run(t, &TestAgentLabelSuite{})
+}
+
+func (s *TestAgentLabelSuite) TestAdd() {
+	s.Run("should add the label correctly", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_labels" ("created_at","updated_at","deleted_at","agent_host","name","value") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "agent_labels"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", "env", "prod").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		l := &models.AgentLabel{AgentHost: "laptop", Name: "env", Value: "prod"}
+
+		s.NoError(l.Add(s.ctx))
+	})
+
+	s.Run("should return an error if there's an error adding the label", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_labels" ("created_at","updated_at","deleted_at","agent_host","name","value") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "agent_labels"."id"`)).WillReturnError(errors.New("testing error"))
+
+		l := &models.AgentLabel{AgentHost: "laptop", Name: "env", Value: "prod"}
+
+		s.EqualError(l.Add(s.ctx), "error adding the agent label to the DB: testing error")
+	})
+}
+
+func (s *TestAgentLabelSuite) TestSet() {
+	s.Run("should add the label if the agent doesn't have it", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"  WHERE "agent_labels"."deleted_at" IS NULL AND (("agent_labels"."agent_host" = $1) AND ("agent_labels"."name" = $2)) ORDER BY "agent_labels"."id" ASC LIMIT 1`)).WithArgs("laptop", "env").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_labels" ("created_at","updated_at","deleted_at","agent_host","name","value") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "agent_labels"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", "env", "prod").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		l := &models.AgentLabel{AgentHost: "laptop", Name: "env", Value: "prod"}
+
+		s.NoError(l.Set(s.ctx))
+	})
+
+	s.Run("should update the label value if the agent already has it", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"  WHERE "agent_labels"."deleted_at" IS NULL AND (("agent_labels"."agent_host" = $1) AND ("agent_labels"."name" = $2)) ORDER BY "agent_labels"."id" ASC LIMIT 1`)).WithArgs("laptop", "env").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "name", "value"}).AddRow(1, "laptop", "env", "dev"))
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_labels" SET "updated_at" = $1, "value" = $2  WHERE "agent_labels"."deleted_at" IS NULL AND "agent_labels"."id" = $3 AND (("agent_labels"."agent_host" = $4) AND ("agent_labels"."name" = $5))`)).WithArgs(tests.DBAnyTime{}, "prod", 1, "laptop", "env").WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		l := &models.AgentLabel{AgentHost: "laptop", Name: "env", Value: "prod"}
+
+		s.NoError(l.Set(s.ctx))
+		s.Equal(uint(1), l.ID)
+	})
+
+	s.Run("should return an error if there's an error setting the label", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnError(errors.New("testing error"))
+
+		l := &models.AgentLabel{AgentHost: "laptop", Name: "env", Value: "prod"}
+
+		s.EqualError(l.Set(s.ctx), "error setting the agent label in the DB: testing error")
+	})
+}
+
+func (s *TestAgentLabelSuite) TestDelete() {
+	s.Run("should remove the label correctly", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_labels" SET "deleted_at"=$1 WHERE "agent_labels"."deleted_at" IS NULL AND ((agent_host = $2 AND name = $3))`)).WithArgs(tests.DBAnyTime{}, "laptop", "env").WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		l := &models.AgentLabel{AgentHost: "laptop", Name: "env"}
+
+		s.NoError(l.Delete(s.ctx))
+	})
+
+	s.Run("should return an error if there's an error removing the label", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_labels" SET "deleted_at"=$1 WHERE "agent_labels"."deleted_at" IS NULL AND ((agent_host = $2 AND name = $3))`)).WillReturnError(errors.New("testing error"))
+
+		l := &models.AgentLabel{AgentHost: "laptop", Name: "env"}
+
+		s.EqualError(l.Delete(s.ctx), "error removing the agent label: testing error")
+	})
+}
+
+func (s *TestAgentLabelSuite) TestParseLabelSelector() {
+	s.Run("should parse the selector correctly", func() {
+		sel, err := models.ParseLabelSelector("env=prod, dc = bcn,empty=")
+
+		s.NoError(err)
+		s.Equal(models.LabelSelector{"env": "prod", "dc": "bcn", "empty": ""}, sel)
+		s.Equal("dc=bcn,empty=,env=prod", sel.String())
+	})
+
+	s.Run("should return an empty selector if the selector is empty", func() {
+		sel, err := models.ParseLabelSelector("")
+
+		s.NoError(err)
+		s.Equal(models.LabelSelector{}, sel)
+	})
+
+	s.Run("should return an error if the selector is invalid", func() {
+		_, err := models.ParseLabelSelector("env=prod,dc")
+
+		s.EqualError(err, "invalid label selector: 'dc'")
+	})
+}
+
+func (s *TestAgentLabelSuite) TestAgentListBySelector() {
+	s.Run("should return the agents that match the selector", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret_lookup, secret_hash, ssh_port, ssh_user, version, arch, os, os_version, distro, distro_version, quarantined FROM "agents" WHERE "agents"."deleted_at" IS NULL AND (("agents"."accepted" = $1) AND (host IN (SELECT agent_host FROM agent_labels WHERE deleted_at IS NULL AND name = $2 AND value = $3)) AND (host IN (SELECT agent_host FROM agent_labels WHERE deleted_at IS NULL AND name = $4 AND value = $5)))`)).WithArgs(true, "dc", "bcn", "env", "prod").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+
+		agents, err := models.AgentListBySelector(s.ctx, models.LabelSelector{"env": "prod", "dc": "bcn"})
+
+		s.NoError(err)
+		s.Require().Len(agents, 1)
+		s.Equal("laptop", agents[0].Host)
+	})
+
+	s.Run("should return an error if there's an error getting the list of agents", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, created_at, updated_at, host, accepted, minio_key, secret_lookup, secret_hash, ssh_port, ssh_user, version, arch, os, os_version, distro, distro_version, quarantined FROM "agents"`)).WillReturnError(errors.New("testing error"))
+
+		agents, err := models.AgentListBySelector(s.ctx, models.LabelSelector{"env": "prod"})
+
+		s.EqualError(err, "error getting the list of agents: testing error")
+		s.Equal([]*models.Agent{}, agents)
+	})
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/jinzhu/gorm"
+)
+
+// PluginManifest is the manifest of a plugin version. It declares the compatibility of the plugin and its metadata
+type PluginManifest struct {
+	gorm.Model
+	Repo    string `gorm:"not null;unique_index:idx_plugin_manifest"`
+	Name    string `gorm:"not null;unique_index:idx_plugin_manifest"`
+	Version string `gorm:"not null;unique_index:idx_plugin_manifest"`
+
+	ManifestVersion int `gorm:"not null"` // The version of the manifest format
+	Description     string
+	MinAgentVersion string        // The minimum version of the agent required by the plugin. Empty means any version
+	DefaultTimeout  time.Duration // How long the jobs of the plugin can take by default. 0 disables the timeout
+
+	Archs     []*PluginManifestArch     `gorm:"-"`
+	Platforms []*PluginManifestPlatform `gorm:"-"`
+	Config    []*PluginManifestConfig   `gorm:"-"`
+}
+
+// PluginManifestArch is an arch supported by a plugin. If the manifest has no archs, any arch is supported
+type PluginManifestArch struct {
+	gorm.Model
+	ManifestID uint    `gorm:"not null;index"`
+	Arch       os.Arch `gorm:"not null"`
+}
+
+// PluginManifestPlatform is an OS (and optionally a distro of it) supported by a plugin. The versions are constraints
+// (e.g. `>= 9, < 12`) and empty means any version. If the manifest has no platforms, any OS is supported
+type PluginManifestPlatform struct {
+	gorm.Model
+	ManifestID     uint  `gorm:"not null;index"`
+	OS             os.OS `gorm:"not null"`
+	OSVersions     string
+	Distro         string // Empty means any distro of the OS
+	DistroVersions string
+}
+
+// PluginManifestConfig is an option of the configuration schema of a plugin
+type PluginManifestConfig struct {
+	gorm.Model
+	ManifestID  uint   `gorm:"not null;index"`
+	Name        string `gorm:"not null"`
+	Type        string `gorm:"not null"` // The type of the option (`string`, `int`, `bool` or `duration`)
+	Required    bool   `gorm:"not null"`
+	Default     string
+	Description string
+}
+
+// Save stores the manifest in the DB. If the plugin version already has a manifest, it gets replaced
+func (m *PluginManifest) Save(ctx *context.Context) error {
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		return saveManifest(tx, m)
+	})
+}
+
+// Load loads the manifest of the plugin version from the DB using the repo, the name and the version
+func (m *PluginManifest) Load(ctx *context.Context) error {
+	if err := ctx.DB.Where("repo = ? AND name = ? AND version = ?", m.Repo, m.Name, m.Version).First(m).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the plugin manifest from the DB: %v", err)
+	}
+
+	var archs []*PluginManifestArch
+	if err := ctx.DB.Where("manifest_id = ?", m.ID).Order("id").Find(&archs).Error; err != nil {
+		return fmt.Errorf("error getting the plugin manifest archs: %v", err)
+	}
+
+	var platforms []*PluginManifestPlatform
+	if err := ctx.DB.Where("manifest_id = ?", m.ID).Order("id").Find(&platforms).Error; err != nil {
+		return fmt.Errorf("error getting the plugin manifest platforms: %v", err)
+	}
+
+	var cfg []*PluginManifestConfig
+	if err := ctx.DB.Where("manifest_id = ?", m.ID).Order("id").Find(&cfg).Error; err != nil {
+		return fmt.Errorf("error getting the plugin manifest config: %v", err)
+	}
+
+	m.Archs = archs
+	m.Platforms = platforms
+	m.Config = cfg
+
+	return nil
+}
+
+// LoadManifest loads the manifest of the installed version of the plugin. If the version has no manifest, the manifest is nil
+func (p *Plugin) LoadManifest(ctx *context.Context) error {
+	m := &PluginManifest{Repo: p.Repo, Name: p.Name, Version: p.Version}
+	if err := m.Load(ctx); err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			p.Manifest = nil
+			return nil
+		}
+
+		return err
+	}
+
+	p.Manifest = m
+
+	return nil
+}
+
+// saveManifest replaces the manifest of the plugin version and its normalized tables inside the transaction
+func saveManifest(tx *gorm.DB, m *PluginManifest) error {
+	var prev PluginManifest
+	if err := tx.Unscoped().Where("repo = ? AND name = ? AND version = ?", m.Repo, m.Name, m.Version).First(&prev).Error; err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			return fmt.Errorf("error loading the previous plugin manifest: %v", err)
+		}
+	} else {
+		for _, t := range []interface{}{&PluginManifestArch{}, &PluginManifestPlatform{}, &PluginManifestConfig{}} {
+			if err := tx.Unscoped().Where("manifest_id = ?", prev.ID).Delete(t).Error; err != nil {
+				return fmt.Errorf("error removing the previous plugin manifest: %v", err)
+			}
+		}
+
+		if err := tx.Unscoped().Delete(&prev).Error; err != nil {
+			return fmt.Errorf("error removing the previous plugin manifest: %v", err)
+		}
+	}
+
+	m.ID = 0
+	if err := tx.Create(m).Error; err != nil {
+		return fmt.Errorf("error adding the plugin manifest to the DB: %v", err)
+	}
+
+	for _, a := range m.Archs {
+		a.ID = 0
+		a.ManifestID = m.ID
+		if err := tx.Create(a).Error; err != nil {
+			return fmt.Errorf("error adding the plugin manifest arch to the DB: %v", err)
+		}
+	}
+
+	for _, p := range m.Platforms {
+		p.ID = 0
+		p.ManifestID = m.ID
+		if err := tx.Create(p).Error; err != nil {
+			return fmt.Errorf("error adding the plugin manifest platform to the DB: %v", err)
+		}
+	}
+
+	for _, c := range m.Config {
+		c.ID = 0
+		c.ManifestID = m.ID
+		if err := tx.Create(c).Error; err != nil {
+			return fmt.Errorf("error adding the plugin manifest config to the DB: %v", err)
+		}
+	}
+
+	return nil
+}
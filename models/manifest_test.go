@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestPluginManifestSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestPluginManifestSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestPluginManifestSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestPluginManifest(t *testing.T) {
+	suite.Run(t, &TestPluginManifestSuite{})
+}
+
+func (s *TestPluginManifestSuite) TestSave() {
+	s.Run("should store the manifest and its normalized tables", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests" WHERE (repo = $1 AND name = $2 AND version = $3) ORDER BY "plugin_manifests"."id" ASC LIMIT 1`)).WithArgs("default", "tar", "v1.0.0").WillReturnError(gorm.ErrRecordNotFound)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugin_manifest_arches"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugin_manifest_platforms"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugin_manifest_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		m := &models.PluginManifest{
+			Repo:      "default",
+			Name:      "tar",
+			Version:   "v1.0.0",
+			Archs:     []*models.PluginManifestArch{{Arch: os.ArchAmd64}},
+			Platforms: []*models.PluginManifestPlatform{{OS: os.Linux, Distro: "debian", DistroVersions: ">= 9"}},
+			Config:    []*models.PluginManifestConfig{{Name: "compression", Type: "string"}},
+		}
+
+		s.NoError(m.Save(s.ctx))
+		s.Equal(uint(2), m.Archs[0].ManifestID)
+		s.Equal(uint(2), m.Platforms[0].ManifestID)
+		s.Equal(uint(2), m.Config[0].ManifestID)
+	})
+
+	s.Run("should replace the previous manifest of the version", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version"}).AddRow(1, "default", "tar", "v1.0.0"))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "plugin_manifest_arches" WHERE (manifest_id = $1)`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "plugin_manifest_platforms" WHERE (manifest_id = $1)`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "plugin_manifest_configs" WHERE (manifest_id = $1)`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "plugin_manifests" WHERE "plugin_manifests"."id" = $1`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		s.mock.ExpectCommit()
+
+		m := &models.PluginManifest{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.NoError(m.Save(s.ctx))
+		s.Equal(uint(2), m.ID)
+	})
+
+	s.Run("should return an error if there's an error adding the manifest", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnError(gorm.ErrRecordNotFound)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugin_manifests"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		m := &models.PluginManifest{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.EqualError(m.Save(s.ctx), "error adding the plugin manifest to the DB: testing error")
+	})
+}
+
+func (s *TestPluginManifestSuite) TestLoad() {
+	s.Run("should load the manifest and its normalized tables", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests" WHERE "plugin_manifests"."deleted_at" IS NULL AND ((repo = $1 AND name = $2 AND version = $3)) ORDER BY "plugin_manifests"."id" ASC LIMIT 1`)).WithArgs("default", "tar", "v1.0.0").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version", "manifest_version", "min_agent_version"}).
+			AddRow(2, "default", "tar", "v1.0.0", 1, "v0.1.0"),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifest_arches" WHERE "plugin_manifest_arches"."deleted_at" IS NULL AND ((manifest_id = $1)) ORDER BY "id"`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "manifest_id", "arch"}).
+			AddRow(1, 2, os.ArchAmd64),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifest_platforms" WHERE "plugin_manifest_platforms"."deleted_at" IS NULL AND ((manifest_id = $1)) ORDER BY "id"`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "manifest_id", "os", "distro", "distro_versions"}).
+			AddRow(1, 2, os.Linux, "debian", ">= 9"),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifest_configs" WHERE "plugin_manifest_configs"."deleted_at" IS NULL AND ((manifest_id = $1)) ORDER BY "id"`)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "manifest_id", "name", "type"}))
+
+		m := &models.PluginManifest{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.NoError(m.Load(s.ctx))
+		s.Equal("v0.1.0", m.MinAgentVersion)
+		s.Equal(os.ArchAmd64, m.Archs[0].Arch)
+		s.Equal("debian", m.Platforms[0].Distro)
+		s.Len(m.Config, 0)
+	})
+
+	s.Run("should return an error if there's an error loading the manifest", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnError(errors.New("testing error"))
+
+		m := &models.PluginManifest{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.EqualError(m.Load(s.ctx), "error loading the plugin manifest from the DB: testing error")
+	})
+}
+
+func (s *TestPluginManifestSuite) TestLoadManifest() {
+	s.Run("should have no manifest if the plugin version has no manifest", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WithArgs("default", "tar", "v1.0.0").WillReturnError(gorm.ErrRecordNotFound)
+
+		p := &models.Plugin{Repo: "default", Name: "tar", Version: "v1.0.0", Manifest: &models.PluginManifest{}}
+
+		s.NoError(p.LoadManifest(s.ctx))
+		s.Nil(p.Manifest)
+	})
+}
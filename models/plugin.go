@@ -4,6 +4,9 @@ package models
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/brainupdaters/drlm-core/context"
 
@@ -19,19 +22,142 @@ type Plugin struct {
 	Version   string `gorm:"not null"`
 	AgentHost string `gorm:"not null"`
 	Agent     *Agent `gorm:"foreignkey:Host;association_foreignkey:AgentHost"`
-	// TODO: This should be stored in the DB
-	Arch []os.Arch `gorm:"-"`
-	OS   []os.OS   `gorm:"-"`
+
+	Arch     []os.Arch `gorm:"-"`
+	OS       []os.OS   `gorm:"-"`
+	ArchList string    // The supported archs are stored splitted with `,` between each one. Use Arch instead
+	OSList   string    // The supported OSs are stored splitted with `,` between each one. Use OS instead
+
+	InstalledAt time.Time
+
+	Manifest *PluginManifest `gorm:"-"` // The manifest of the installed version. It's stored when adding or updating the plugin
+
+	Pinned     bool `gorm:"not null"` // The pinned version is preferred over the other installed versions of the plugin when resolving the jobs
+	Deprecated bool `gorm:"not null"` // The deprecated versions aren't used by the new jobs
 }
 
 func (p *Plugin) String() string {
 	return p.Repo + "/" + p.Name
 }
 
-// Add adds a new plugin in the DB
+// BinName returns the name of the binary of a version of the plugin
+func (p *Plugin) BinName(version string) string {
+	return fmt.Sprintf("drlm-plugin-%s-%s-%s", p.Repo, p.Name, version)
+}
+
+// Add adds a new plugin in the DB, with its manifest (if it has one)
 func (p *Plugin) Add(ctx *context.Context) error {
-	if err := ctx.DB.Create(p).Error; err != nil {
-		return fmt.Errorf("error adding the plugin to the DB: %v", err)
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(p).Error; err != nil {
+			return fmt.Errorf("error adding the plugin to the DB: %v", err)
+		}
+
+		if p.Manifest != nil {
+			return saveManifest(tx, p.Manifest)
+		}
+
+		return nil
+	})
+}
+
+// Load loads the plugin of the agent from the DB using the agent host and the plugin (`repo/name`). If the version is
+// set, that exact version is loaded
+func (p *Plugin) Load(ctx *context.Context) error {
+	db := ctx.DB.Where("agent_host = ? AND repo = ? AND name = ?", p.AgentHost, p.Repo, p.Name)
+	if p.Version != "" {
+		db = db.Where("version = ?", p.Version)
+	}
+
+	if err := db.First(p).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the plugin from the DB: %v", err)
+	}
+
+	return nil
+}
+
+// Update updates the plugin in the DB, with its manifest (if it has one)
+func (p *Plugin) Update(ctx *context.Context) error {
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(p).Error; err != nil {
+			return fmt.Errorf("error updating the plugin: %v", err)
+		}
+
+		if p.Manifest != nil {
+			return saveManifest(tx, p.Manifest)
+		}
+
+		return nil
+	})
+}
+
+// Delete removes the plugin from the DB
+func (p *Plugin) Delete(ctx *context.Context) error {
+	if err := ctx.DB.Delete(p).Error; err != nil {
+		return fmt.Errorf("error removing the plugin: %v", err)
+	}
+
+	return nil
+}
+
+// ActiveJobs returns the number of jobs of the plugin that are scheduled or running
+func (p *Plugin) ActiveJobs(ctx *context.Context) (int, error) {
+	var n int
+	if err := ctx.DB.Model(&Job{}).Where("plugin_id = ? AND status IN (?)", p.ID, []JobStatus{JobStatusScheduled, JobStatusRunning}).Count(&n).Error; err != nil {
+		return 0, fmt.Errorf("error counting the plugin jobs: %v", err)
+	}
+
+	return n, nil
+}
+
+// BeforeSave is a hook that gets executed before saving a plugin
+func (p *Plugin) BeforeSave() error {
+	archs := []string{}
+	for _, a := range p.Arch {
+		archs = append(archs, strconv.Itoa(int(a)))
+	}
+	p.ArchList = strings.Join(archs, ",")
+
+	oss := []string{}
+	for _, o := range p.OS {
+		oss = append(oss, strconv.Itoa(int(o)))
+	}
+	p.OSList = strings.Join(oss, ",")
+
+	return nil
+}
+
+// AfterFind is a hook that gets executed after loading a plugin
+func (p *Plugin) AfterFind() error {
+	p.Arch = nil
+	for _, a := range strings.Split(p.ArchList, ",") {
+		if a == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(a)
+		if err != nil {
+			return fmt.Errorf("invalid plugin arch '%s': %v", a, err)
+		}
+
+		p.Arch = append(p.Arch, os.Arch(i))
+	}
+
+	p.OS = nil
+	for _, o := range strings.Split(p.OSList, ",") {
+		if o == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(o)
+		if err != nil {
+			return fmt.Errorf("invalid plugin OS '%s': %v", o, err)
+		}
+
+		p.OS = append(p.OS, os.OS(i))
 	}
 
 	return nil
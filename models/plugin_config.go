@@ -0,0 +1,274 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PluginConfigScope is the scope of a plugin config override
+type PluginConfigScope int
+
+const (
+	// PluginConfigScopeGroup applies the override to the agents whose labels match the target label selector
+	PluginConfigScopeGroup PluginConfigScope = iota
+	// PluginConfigScopeAgent applies the override to the agent whose host is the target
+	PluginConfigScopeAgent
+)
+
+func (s PluginConfigScope) String() string {
+	switch s {
+	case PluginConfigScopeGroup:
+		return "group"
+	case PluginConfigScopeAgent:
+		return "agent"
+	default:
+		return "unknown"
+	}
+}
+
+// PluginConfig is an override of the configuration of a plugin for a group of agents or for an agent. The configuration
+// of the jobs is layered: the manifest defaults, then the group overrides, then the agent override and then the job one
+type PluginConfig struct {
+	gorm.Model
+	Repo   string            `gorm:"not null;unique_index:idx_plugin_config"`
+	Name   string            `gorm:"not null;unique_index:idx_plugin_config"`
+	Scope  PluginConfigScope `gorm:"not null;unique_index:idx_plugin_config"`
+	Target string            `gorm:"not null;unique_index:idx_plugin_config"` // The label selector (`name=value,name2=value2`) or the agent host
+	Config string            `gorm:"not null"`                                // A JSON object with the options that get overridden
+}
+
+// PluginConfigList returns all the config overrides of a plugin. The group overrides are applied in the same order
+func PluginConfigList(ctx *context.Context, repo, name string) ([]*PluginConfig, error) {
+	cfgs := []*PluginConfig{}
+
+	if err := ctx.DB.Where("repo = ? AND name = ?", repo, name).Order("id").Find(&cfgs).Error; err != nil {
+		return []*PluginConfig{}, fmt.Errorf("error getting the list of plugin configs: %v", err)
+	}
+
+	return cfgs, nil
+}
+
+// Save stores the override in the DB. If the plugin already has an override for the scope and the target, it gets replaced
+func (c *PluginConfig) Save(ctx *context.Context) error {
+	if _, err := parsePluginConfig(c.Config); err != nil {
+		return err
+	}
+
+	return ctx.DB.Transaction(func(tx *gorm.DB) error {
+		var prev PluginConfig
+		if err := tx.Where("repo = ? AND name = ? AND scope = ? AND target = ?", c.Repo, c.Name, c.Scope, c.Target).First(&prev).Error; err != nil {
+			if !gorm.IsRecordNotFoundError(err) {
+				return fmt.Errorf("error loading the previous plugin config: %v", err)
+			}
+
+			if err := tx.Create(c).Error; err != nil {
+				return fmt.Errorf("error adding the plugin config to the DB: %v", err)
+			}
+
+			return nil
+		}
+
+		c.Model = prev.Model
+		if err := tx.Save(c).Error; err != nil {
+			return fmt.Errorf("error updating the plugin config: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// Delete removes the override (using the repo, the name, the scope and the target) from the DB
+func (c *PluginConfig) Delete(ctx *context.Context) error {
+	// The overrides are removed permanently, so they can be set again
+	if err := ctx.DB.Unscoped().Where("repo = ? AND name = ? AND scope = ? AND target = ?", c.Repo, c.Name, c.Scope, c.Target).Delete(&PluginConfig{}).Error; err != nil {
+		return fmt.Errorf("error removing the plugin config: %v", err)
+	}
+
+	return nil
+}
+
+// Matches checks whether the override applies to the agent with the host and the labels. A group override without
+// selector doesn't match any agent
+func (c *PluginConfig) Matches(host string, labels LabelSelector) bool {
+	switch c.Scope {
+	case PluginConfigScopeGroup:
+		sel, err := ParseLabelSelector(c.Target)
+		if err != nil || len(sel) == 0 {
+			return false
+		}
+
+		return sel.Matches(labels)
+
+	case PluginConfigScopeAgent:
+		return c.Target == host
+
+	default:
+		return false
+	}
+}
+
+// EffectivePluginConfig returns the configuration of a job of the plugin in the agent: the defaults of the plugin
+// manifest, merged with the group overrides that match the agent labels, the agent override and the job config. If
+// there are no defaults nor overrides, the job config is returned as it is, so it doesn't need to be a JSON object
+func EffectivePluginConfig(ctx *context.Context, a *Agent, p *Plugin, config string) (string, error) {
+	if err := p.LoadManifest(ctx); err != nil {
+		return "", err
+	}
+
+	if err := a.LoadLabels(ctx); err != nil {
+		return "", err
+	}
+
+	labels := LabelSelector{}
+	for _, l := range a.Labels {
+		labels[l.Name] = l.Value
+	}
+
+	cfgs, err := PluginConfigList(ctx, p.Repo, p.Name)
+	if err != nil {
+		return "", err
+	}
+
+	defaults, err := manifestDefaults(p.Manifest)
+	if err != nil {
+		return "", err
+	}
+
+	layers := []string{}
+	if defaults != "" {
+		layers = append(layers, defaults)
+	}
+	for _, scope := range []PluginConfigScope{PluginConfigScopeGroup, PluginConfigScopeAgent} {
+		for _, c := range cfgs {
+			if c.Scope == scope && c.Matches(a.Host, labels) {
+				layers = append(layers, c.Config)
+			}
+		}
+	}
+
+	// The plugins that don't use JSON configs keep working as long as there's nothing to merge the job config with
+	if len(layers) == 0 {
+		return config, nil
+	}
+	layers = append(layers, config)
+
+	return MergePluginConfig(layers...)
+}
+
+// MergePluginConfig merges the JSON objects of the configs. The later configs override the options of the previous ones
+// (the nested objects are merged too) and the `null` options remove them. If all the configs are empty, it's empty
+func MergePluginConfig(cfgs ...string) (string, error) {
+	var merged map[string]interface{}
+
+	for _, c := range cfgs {
+		cfg, err := parsePluginConfig(c)
+		if err != nil {
+			return "", err
+		}
+
+		if cfg == nil {
+			continue
+		}
+
+		if merged == nil {
+			merged = map[string]interface{}{}
+		}
+
+		mergeConfigObject(merged, cfg)
+	}
+
+	if merged == nil {
+		return "", nil
+	}
+
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return "", fmt.Errorf("error encoding the plugin config: %v", err)
+	}
+
+	return string(b), nil
+}
+
+// parsePluginConfig parses a plugin config. The empty config is nil
+func parsePluginConfig(c string) (map[string]interface{}, error) {
+	if c == "" {
+		return nil, nil
+	}
+
+	var cfg map[string]interface{}
+	if err := json.Unmarshal([]byte(c), &cfg); err != nil || cfg == nil {
+		return nil, fmt.Errorf("invalid plugin config: it has to be a JSON object")
+	}
+
+	return cfg, nil
+}
+
+// mergeConfigObject merges the src object into the dst one
+func mergeConfigObject(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+
+		srcObj, srcOk := v.(map[string]interface{})
+		dstObj, dstOk := dst[k].(map[string]interface{})
+		if srcOk && dstOk {
+			mergeConfigObject(dstObj, srcObj)
+			continue
+		}
+
+		dst[k] = v
+	}
+}
+
+// manifestDefaults returns the defaults of the configuration schema of the manifest as a config
+func manifestDefaults(m *PluginManifest) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+
+	cfg := map[string]interface{}{}
+	for _, c := range m.Config {
+		if c.Default == "" {
+			continue
+		}
+
+		switch c.Type {
+		case "int":
+			i, err := strconv.ParseInt(c.Default, 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("invalid default value of the config option '%s': %v", c.Name, err)
+			}
+			cfg[c.Name] = i
+
+		case "bool":
+			b, err := strconv.ParseBool(c.Default)
+			if err != nil {
+				return "", fmt.Errorf("invalid default value of the config option '%s': %v", c.Name, err)
+			}
+			cfg[c.Name] = b
+
+		default:
+			cfg[c.Name] = c.Default
+		}
+	}
+
+	if len(cfg) == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("error encoding the plugin config: %v", err)
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestPluginConfigSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestPluginConfigSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestPluginConfigSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestPluginConfig(t *testing.T) {
+	suite.Run(t, &TestPluginConfigSuite{})
+}
+
+func (s *TestPluginConfigSuite) TestSave() {
+	s.Run("should add the config if the plugin has no override for the target", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs" WHERE "plugin_configs"."deleted_at" IS NULL AND ((repo = $1 AND name = $2 AND scope = $3 AND target = $4))`)).WithArgs("default", "tar", models.PluginConfigScopeAgent, "laptop").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugin_configs" ("created_at","updated_at","deleted_at","repo","name","scope","target","config") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "plugin_configs"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "default", "tar", models.PluginConfigScopeAgent, "laptop", `{"level": 9}`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		c := &models.PluginConfig{Repo: "default", Name: "tar", Scope: models.PluginConfigScopeAgent, Target: "laptop", Config: `{"level": 9}`}
+
+		s.NoError(c.Save(s.ctx))
+	})
+
+	s.Run("should replace the previous override of the target", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WithArgs("default", "tar", models.PluginConfigScopeGroup, "env=prod").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "scope", "target", "config"}).AddRow(3, "default", "tar", models.PluginConfigScopeGroup, "env=prod", `{"level": 1}`))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugin_configs" SET "updated_at" = $1, "deleted_at" = $2, "repo" = $3, "name" = $4, "scope" = $5, "target" = $6, "config" = $7 WHERE "plugin_configs"."deleted_at" IS NULL AND "plugin_configs"."id" = $8`)).WithArgs(tests.DBAnyTime{}, nil, "default", "tar", models.PluginConfigScopeGroup, "env=prod", `{"level": 9}`, 3).WillReturnResult(sqlmock.NewResult(3, 1))
+		s.mock.ExpectCommit()
+
+		c := &models.PluginConfig{Repo: "default", Name: "tar", Scope: models.PluginConfigScopeGroup, Target: "env=prod", Config: `{"level": 9}`}
+
+		s.NoError(c.Save(s.ctx))
+		s.Equal(uint(3), c.ID)
+	})
+
+	s.Run("should return an error if the config isn't a JSON object", func() {
+		c := &models.PluginConfig{Repo: "default", Name: "tar", Scope: models.PluginConfigScopeAgent, Target: "laptop", Config: `[1, 2]`}
+
+		s.EqualError(c.Save(s.ctx), "invalid plugin config: it has to be a JSON object")
+	})
+
+	s.Run("should return an error if there's an error loading the previous override", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		c := &models.PluginConfig{Repo: "default", Name: "tar", Scope: models.PluginConfigScopeAgent, Target: "laptop"}
+
+		s.EqualError(c.Save(s.ctx), "error loading the previous plugin config: testing error")
+	})
+}
+
+func (s *TestPluginConfigSuite) TestDelete() {
+	s.Run("should remove the override correctly", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "plugin_configs" WHERE (repo = $1 AND name = $2 AND scope = $3 AND target = $4)`)).WithArgs("default", "tar", models.PluginConfigScopeAgent, "laptop").WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		c := &models.PluginConfig{Repo: "default", Name: "tar", Scope: models.PluginConfigScopeAgent, Target: "laptop"}
+
+		s.NoError(c.Delete(s.ctx))
+	})
+}
+
+func (s *TestPluginConfigSuite) TestEffectivePluginConfig() {
+	s.Run("should layer the manifest defaults, the group overrides, the agent override and the job config", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WithArgs("default", "tar", "v1.0.0").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version"}).AddRow(1, "default", "tar", "v1.0.0"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifest_arches"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifest_platforms"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifest_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "type", "default"}).
+			AddRow(1, "compression", "string", "gzip").
+			AddRow(2, "level", "int", "6").
+			AddRow(3, "verbose", "bool", "false").
+			AddRow(4, "path", "string", ""),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "name", "value"}).
+			AddRow(1, "laptop", "env", "prod").
+			AddRow(2, "laptop", "site", "bcn"),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs" WHERE "plugin_configs"."deleted_at" IS NULL AND ((repo = $1 AND name = $2)) ORDER BY "id"`)).WithArgs("default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "scope", "target", "config"}).
+			AddRow(1, models.PluginConfigScopeAgent, "laptop", `{"level": 9}`).
+			AddRow(2, models.PluginConfigScopeGroup, "env=prod", `{"level": 3, "compression": "zstd", "exclude": {"tmp": true}}`).
+			AddRow(3, models.PluginConfigScopeGroup, "env=dev", `{"compression": "none"}`).
+			AddRow(4, models.PluginConfigScopeGroup, "site=bcn", `{"exclude": {"cache": true}}`).
+			AddRow(5, models.PluginConfigScopeAgent, "desktop", `{"level": 1}`),
+		)
+
+		cfg, err := models.EffectivePluginConfig(s.ctx, &models.Agent{Host: "laptop"}, &models.Plugin{Repo: "default", Name: "tar", Version: "v1.0.0"}, `{"verbose": true, "exclude": {"tmp": null}}`)
+		s.NoError(err)
+		s.JSONEq(`{"compression": "zstd", "level": 9, "verbose": true, "exclude": {"cache": true}}`, cfg)
+	})
+
+	s.Run("should keep the job config as it is if there's nothing to merge it with", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "scope", "target", "config"}).
+			AddRow(1, models.PluginConfigScopeAgent, "desktop", `{"level": 1}`),
+		)
+
+		cfg, err := models.EffectivePluginConfig(s.ctx, &models.Agent{Host: "laptop"}, &models.Plugin{Repo: "default", Name: "tar"}, "level=9")
+		s.NoError(err)
+		s.Equal("level=9", cfg)
+	})
+
+	s.Run("should return an error if the job config isn't a JSON object and there are overrides", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "scope", "target", "config"}).
+			AddRow(1, models.PluginConfigScopeAgent, "laptop", `{"level": 1}`),
+		)
+
+		cfg, err := models.EffectivePluginConfig(s.ctx, &models.Agent{Host: "laptop"}, &models.Plugin{Repo: "default", Name: "tar"}, "level=9")
+		s.EqualError(err, "invalid plugin config: it has to be a JSON object")
+		s.Equal("", cfg)
+	})
+
+	s.Run("should return an error if there's an error getting the overrides", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnError(errors.New("testing error"))
+
+		cfg, err := models.EffectivePluginConfig(s.ctx, &models.Agent{Host: "laptop"}, &models.Plugin{Repo: "default", Name: "tar"}, "")
+		s.EqualError(err, "error getting the list of plugin configs: testing error")
+		s.Equal("", cfg)
+	})
+}
+
+func (s *TestPluginConfigSuite) TestMergePluginConfig() {
+	s.Run("should return an empty config if all the configs are empty", func() {
+		cfg, err := models.MergePluginConfig("", "")
+		s.NoError(err)
+		s.Equal("", cfg)
+	})
+
+	s.Run("should keep an empty object", func() {
+		cfg, err := models.MergePluginConfig("", "{}")
+		s.NoError(err)
+		s.Equal("{}", cfg)
+	})
+
+	s.Run("should override the objects with other values", func() {
+		cfg, err := models.MergePluginConfig(`{"exclude": {"tmp": true}}`, `{"exclude": ["tmp"]}`)
+		s.NoError(err)
+		s.JSONEq(`{"exclude": ["tmp"]}`, cfg)
+	})
+}
+
+func (s *TestPluginConfigSuite) TestMatches() {
+	s.Run("should match the group overrides by the agent labels", func() {
+		c := &models.PluginConfig{Scope: models.PluginConfigScopeGroup, Target: "env=prod,site=bcn"}
+
+		s.True(c.Matches("laptop", models.LabelSelector{"env": "prod", "site": "bcn", "os": "linux"}))
+		s.False(c.Matches("laptop", models.LabelSelector{"env": "prod"}))
+	})
+
+	s.Run("should not match the group overrides without selector", func() {
+		c := &models.PluginConfig{Scope: models.PluginConfigScopeGroup}
+
+		s.False(c.Matches("laptop", models.LabelSelector{"env": "prod"}))
+	})
+
+	s.Run("should match the agent overrides by the agent host", func() {
+		c := &models.PluginConfig{Scope: models.PluginConfigScopeAgent, Target: "laptop"}
+
+		s.True(c.Matches("laptop", models.LabelSelector{}))
+		s.False(c.Matches("desktop", models.LabelSelector{}))
+	})
+}
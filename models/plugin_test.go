@@ -12,6 +12,8 @@ import (
 	"github.com/brainupdaters/drlm-core/utils/tests"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -46,7 +48,7 @@ func (s *TestPluginSuite) TestString() {
 func (s *TestPluginSuite) TestAdd() {
 	s.Run("should add the plugin correctly to the DB", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugins" ("created_at","updated_at","deleted_at","repo","name","version","agent_host") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "plugins"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugins" ("created_at","updated_at","deleted_at","repo","name","version","agent_host","arch_list","os_list","installed_at","pinned","deprecated") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) RETURNING "plugins"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).
 			AddRow(1),
 		)
 		s.mock.ExpectCommit()
@@ -63,7 +65,7 @@ func (s *TestPluginSuite) TestAdd() {
 
 	s.Run("should return an error if there's an error addintg the plugin", func() {
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugins" ("created_at","updated_at","deleted_at","repo","name","version","agent_host") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "plugins"."id"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "plugins" ("created_at","updated_at","deleted_at","repo","name","version","agent_host","arch_list","os_list","installed_at","pinned","deprecated") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) RETURNING "plugins"."id"`)).WillReturnError(errors.New("testing error"))
 
 		p := models.Plugin{
 			Repo:      "default",
@@ -75,3 +77,79 @@ func (s *TestPluginSuite) TestAdd() {
 		s.EqualError(p.Add(s.ctx), "error adding the plugin to the DB: testing error")
 	})
 }
+
+func (s *TestPluginSuite) TestBinName() {
+	p := &models.Plugin{
+		Repo: "default",
+		Name: "tar",
+	}
+
+	s.Equal("drlm-plugin-default-tar-v1.0.0", p.BinName("v1.0.0"))
+}
+
+func (s *TestPluginSuite) TestLoad() {
+	s.Run("should load the plugin and its constraints", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1 AND repo = $2 AND name = $3)) ORDER BY "plugins"."id" ASC LIMIT 1`)).WithArgs("laptop", "default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version", "agent_host", "arch_list", "os_list"}).
+			AddRow(1, "default", "tar", "v1.0.0", "laptop", "1", "1,3"),
+		)
+
+		p := &models.Plugin{AgentHost: "laptop", Repo: "default", Name: "tar"}
+		s.NoError(p.Load(s.ctx))
+		s.Equal("v1.0.0", p.Version)
+		s.Equal([]os.Arch{os.ArchAmd64}, p.Arch)
+		s.Equal([]os.OS{os.Linux, os.Darwin}, p.OS)
+	})
+
+	s.Run("should return a not found error if the plugin isn't found", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnError(gorm.ErrRecordNotFound)
+
+		p := &models.Plugin{AgentHost: "laptop", Repo: "default", Name: "tar"}
+		s.True(gorm.IsRecordNotFoundError(p.Load(s.ctx)))
+	})
+
+	s.Run("should return an error if there's an error loading the plugin", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnError(errors.New("testing error"))
+
+		p := &models.Plugin{AgentHost: "laptop", Repo: "default", Name: "tar"}
+		s.EqualError(p.Load(s.ctx), "error loading the plugin from the DB: testing error")
+	})
+}
+
+func (s *TestPluginSuite) TestUpdate() {
+	s.Run("should store the plugin constraints", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugins" SET "updated_at" = $1, "deleted_at" = $2, "repo" = $3, "name" = $4, "version" = $5, "agent_host" = $6, "arch_list" = $7, "os_list" = $8, "installed_at" = $9, "pinned" = $10, "deprecated" = $11 WHERE "plugins"."deleted_at" IS NULL AND "plugins"."id" = $12`)).WithArgs(tests.DBAnyTime{}, nil, "default", "tar", "v1.1.0", "laptop", "1", "1", tests.DBAnyTime{}, false, false, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		p := &models.Plugin{
+			Model:     gorm.Model{ID: 1},
+			Repo:      "default",
+			Name:      "tar",
+			Version:   "v1.1.0",
+			AgentHost: "laptop",
+			Arch:      []os.Arch{os.ArchAmd64},
+			OS:        []os.OS{os.Linux},
+		}
+
+		s.NoError(p.Update(s.ctx))
+	})
+}
+
+func (s *TestPluginSuite) TestActiveJobs() {
+	s.Run("should return the number of scheduled and running jobs", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "jobs" WHERE "jobs"."deleted_at" IS NULL AND ((plugin_id = $1 AND status IN ($2,$3)))`)).WithArgs(1, models.JobStatusScheduled, models.JobStatusRunning).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+		p := &models.Plugin{Model: gorm.Model{ID: 1}}
+		n, err := p.ActiveJobs(s.ctx)
+		s.NoError(err)
+		s.Equal(2, n)
+	})
+
+	s.Run("should return an error if there's an error counting the jobs", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "jobs"`)).WillReturnError(errors.New("testing error"))
+
+		p := &models.Plugin{Model: gorm.Model{ID: 1}}
+		_, err := p.ActiveJobs(s.ctx)
+		s.EqualError(err, "error counting the plugin jobs: testing error")
+	})
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/utils/version"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrPluginDeprecated gets returned when all the installed versions of a plugin that match a constraint are deprecated
+var ErrPluginDeprecated = errors.New("the installed versions of the plugin that match the constraint are deprecated")
+
+// PluginVersions returns all the installed versions of a plugin (`repo/name`) of an agent
+func PluginVersions(ctx *context.Context, host, repo, name string) ([]*Plugin, error) {
+	plugins := []*Plugin{}
+
+	if err := ctx.DB.Where("agent_host = ? AND repo = ? AND name = ?", host, repo, name).Order("id").Find(&plugins).Error; err != nil {
+		return []*Plugin{}, fmt.Errorf("error getting the plugin versions: %v", err)
+	}
+
+	return plugins, nil
+}
+
+// ParsePluginRef splits a plugin reference (`repo/name` or `repo/name@constraint`, e.g. `default/tar@^1.2`) into the
+// plugin and the version constraint
+func ParsePluginRef(ref string) (string, string) {
+	parts := strings.SplitN(ref, "@", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// ResolvePluginVersion returns the installed version of the plugin that best matches the version constraint: the pinned
+// version (if it matches the constraint) or the highest one. A version can also be referenced by its exact name, even if
+// it isn't a valid version. The deprecated versions are skipped unless includeDeprecated is true. If no version matches
+// the constraint, it returns a not found error
+func ResolvePluginVersion(plugins []*Plugin, constraint string, includeDeprecated bool) (*Plugin, error) {
+	var c *version.Constraint
+	exact := false
+	for _, p := range plugins {
+		if constraint != "" && p.Version == constraint {
+			exact = true
+		}
+	}
+
+	if !exact {
+		var err error
+		if c, err = version.ParseConstraint(constraint); err != nil {
+			return nil, err
+		}
+	}
+
+	var best *Plugin
+	var bestVersion version.Version
+	deprecated := false
+
+	for _, p := range plugins {
+		v, vErr := version.Parse(p.Version)
+
+		if exact {
+			if p.Version != constraint {
+				continue
+			}
+		} else if c.String() != "" && c.String() != "*" && (vErr != nil || !c.Check(v)) {
+			continue
+		}
+
+		if p.Deprecated && !includeDeprecated {
+			deprecated = true
+			continue
+		}
+
+		switch {
+		case best == nil:
+		case p.Pinned && !best.Pinned:
+		case best.Pinned && !p.Pinned:
+			continue
+		// The versions that aren't valid are the lowest ones. Between them, the last installed is preferred
+		case vErr == nil && bestVersion.Parts == nil:
+		case vErr != nil && bestVersion.Parts != nil:
+			continue
+		case vErr == nil && v.Compare(bestVersion) < 0:
+			continue
+		}
+
+		best = p
+		bestVersion = v
+	}
+
+	if best == nil {
+		if deprecated {
+			return nil, ErrPluginDeprecated
+		}
+
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return best, nil
+}
+
+// Pin pins the version of the plugin. The rest of the versions of the plugin installed in the agent get unpinned
+func (p *Plugin) Pin(ctx *context.Context) error {
+	if err := ctx.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Plugin{}).Where("agent_host = ? AND repo = ? AND name = ? AND id <> ?", p.AgentHost, p.Repo, p.Name, p.ID).UpdateColumn("pinned", false).Error; err != nil {
+			return fmt.Errorf("error unpinning the plugin versions: %v", err)
+		}
+
+		if err := tx.Model(p).UpdateColumn("pinned", true).Error; err != nil {
+			return fmt.Errorf("error pinning the plugin version: %v", err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	p.Pinned = true
+
+	return nil
+}
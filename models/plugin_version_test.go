@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+)
+
+func (s *TestPluginSuite) TestParsePluginRef() {
+	s.Run("should parse the plugin and the constraint", func() {
+		p, c := models.ParsePluginRef("default/tar@^1.2")
+		s.Equal("default/tar", p)
+		s.Equal("^1.2", c)
+	})
+
+	s.Run("should return an empty constraint if there's no constraint", func() {
+		p, c := models.ParsePluginRef("default/tar")
+		s.Equal("default/tar", p)
+		s.Equal("", c)
+	})
+}
+
+func (s *TestPluginSuite) TestResolvePluginVersion() {
+	plugins := func() []*models.Plugin {
+		return []*models.Plugin{
+			{Model: gorm.Model{ID: 1}, Version: "v1.2.0"},
+			{Model: gorm.Model{ID: 2}, Version: "v1.10.0"},
+			{Model: gorm.Model{ID: 3}, Version: "v2.0.0"},
+			{Model: gorm.Model{ID: 4}, Version: "v1.3.0"},
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		constraint string
+		modify     func(p []*models.Plugin)
+		expected   uint
+	}{
+		{"the highest version if there's no constraint", "", func(p []*models.Plugin) {}, 3},
+		{"the highest version that matches the constraint", "^1.2", func(p []*models.Plugin) {}, 2},
+		{"the exact version", "v1.3.0", func(p []*models.Plugin) {}, 4},
+		{"the pinned version if it matches the constraint", "^1.2", func(p []*models.Plugin) { p[0].Pinned = true }, 1},
+		{"the highest version if the pinned version doesn't match the constraint", "^1.2", func(p []*models.Plugin) { p[2].Pinned = true }, 2},
+		{"the highest version that isn't deprecated", "^1.2", func(p []*models.Plugin) { p[1].Deprecated = true }, 4},
+		{"the last installed version between the invalid versions", "", func(p []*models.Plugin) {
+			for _, v := range p {
+				v.Version = "dev"
+			}
+		}, 4},
+	} {
+		s.Run("should return "+tc.name, func() {
+			p := plugins()
+			tc.modify(p)
+
+			rslt, err := models.ResolvePluginVersion(p, tc.constraint, false)
+			s.NoError(err)
+			s.Equal(tc.expected, rslt.ID)
+		})
+	}
+
+	s.Run("should return the deprecated versions if they are included", func() {
+		p := plugins()
+		p[2].Deprecated = true
+
+		rslt, err := models.ResolvePluginVersion(p, "v2.0.0", true)
+		s.NoError(err)
+		s.Equal(uint(3), rslt.ID)
+	})
+
+	s.Run("should return an error if the matching versions are deprecated", func() {
+		p := plugins()
+		p[2].Deprecated = true
+
+		rslt, err := models.ResolvePluginVersion(p, "^2", false)
+		s.Equal(models.ErrPluginDeprecated, err)
+		s.Nil(rslt)
+	})
+
+	s.Run("should return a not found error if no version matches the constraint", func() {
+		rslt, err := models.ResolvePluginVersion(plugins(), "^3", false)
+		s.True(gorm.IsRecordNotFoundError(err))
+		s.Nil(rslt)
+	})
+
+	s.Run("should return an error if the constraint is invalid", func() {
+		rslt, err := models.ResolvePluginVersion(plugins(), ">= one", false)
+		s.Error(err)
+		s.Nil(rslt)
+	})
+}
+
+func (s *TestPluginSuite) TestPluginVersions() {
+	s.Run("should return the installed versions of the plugin", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1 AND repo = $2 AND name = $3)) ORDER BY "id"`)).WithArgs("laptop", "default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version"}).
+			AddRow(1, "default", "tar", "v1.0.0").
+			AddRow(2, "default", "tar", "v1.1.0"),
+		)
+
+		plugins, err := models.PluginVersions(s.ctx, "laptop", "default", "tar")
+		s.NoError(err)
+		s.Len(plugins, 2)
+	})
+
+	s.Run("should return an error if there's an error getting the versions", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WillReturnError(errors.New("testing error"))
+
+		plugins, err := models.PluginVersions(s.ctx, "laptop", "default", "tar")
+		s.EqualError(err, "error getting the plugin versions: testing error")
+		s.Len(plugins, 0)
+	})
+}
+
+func (s *TestPluginSuite) TestPin() {
+	s.Run("should pin the version and unpin the rest", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugins" SET "pinned" = $1 WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $2 AND repo = $3 AND name = $4 AND id <> $5))`)).WithArgs(false, "laptop", "default", "tar", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugins" SET "pinned" = $1 WHERE "plugins"."deleted_at" IS NULL AND "plugins"."id" = $2`)).WithArgs(true, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectCommit()
+
+		p := &models.Plugin{Model: gorm.Model{ID: 2}, AgentHost: "laptop", Repo: "default", Name: "tar", Version: "v1.1.0"}
+
+		s.NoError(p.Pin(s.ctx))
+		s.True(p.Pinned)
+	})
+
+	s.Run("should return an error if there's an error unpinning the rest of the versions", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugins"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectRollback()
+
+		p := &models.Plugin{Model: gorm.Model{ID: 2}, AgentHost: "laptop", Repo: "default", Name: "tar", Version: "v1.1.0"}
+
+		s.EqualError(p.Pin(s.ctx), "error unpinning the plugin versions: testing error")
+		s.False(p.Pinned)
+	})
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/utils/secret"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AgentSecretRotation is a rotation of the secret of an Agent. The previous secret keeps being accepted
+// until the Agent confirms the rotation or the grace period ends
+type AgentSecretRotation struct {
+	gorm.Model
+	AgentHost        string    `gorm:"not null"`
+	PrevSecretLookup string    `gorm:"index;not null"` // Digest of the previous secret used to find the rotation
+	PrevSecretHash   string    `gorm:"not null"`       // Salted hash of the previous secret
+	ExpiresAt        time.Time `gorm:"not null"`       // When the previous secret stops being accepted
+	Confirmed        bool      `gorm:"not null"`
+}
+
+// Add creates a new secret rotation in the DB
+func (r *AgentSecretRotation) Add(ctx *context.Context) error {
+	if err := ctx.DB.Create(r).Error; err != nil {
+		return fmt.Errorf("error adding the agent secret rotation to the DB: %v", err)
+	}
+
+	return nil
+}
+
+// LoadPending loads the latest unconfirmed secret rotation of the agent from the DB
+func (r *AgentSecretRotation) LoadPending(ctx *context.Context) error {
+	if err := ctx.DB.Where("agent_host = ? AND confirmed = ?", r.AgentHost, false).Order("id desc").First(r).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the agent secret rotation from the DB: %v", err)
+	}
+
+	return nil
+}
+
+// LoadLast loads the latest secret rotation (confirmed or not) of the agent from the DB
+func (r *AgentSecretRotation) LoadLast(ctx *context.Context) error {
+	if err := ctx.DB.Where("agent_host = ?", r.AgentHost).Order("id desc").First(r).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		return fmt.Errorf("error loading the agent secret rotation from the DB: %v", err)
+	}
+
+	return nil
+}
+
+// Update updates the secret rotation in the DB
+func (r *AgentSecretRotation) Update(ctx *context.Context) error {
+	if err := ctx.DB.Save(r).Error; err != nil {
+		return fmt.Errorf("error updating the agent secret rotation: %v", err)
+	}
+
+	return nil
+}
+
+// Delete removes the secret rotation from the DB
+func (r *AgentSecretRotation) Delete(ctx *context.Context) error {
+	if err := ctx.DB.Delete(r).Error; err != nil {
+		return fmt.Errorf("error removing the agent secret rotation: %v", err)
+	}
+
+	return nil
+}
+
+// AgentSecretRotationHost returns the host of the agent that has the secret as the previous secret of an unconfirmed rotation
+// that is still in its grace period
+func AgentSecretRotationHost(ctx *context.Context, s string) (string, error) {
+	var r AgentSecretRotation
+	if err := ctx.DB.Where("prev_secret_lookup = ? AND confirmed = ? AND expires_at > ?", secret.Lookup(s), false, time.Now()).First(&r).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return "", err
+		}
+
+		return "", fmt.Errorf("error getting the agent secret rotation: %v", err)
+	}
+
+	if !secret.Check(s, r.PrevSecretHash) {
+		return "", gorm.ErrRecordNotFound
+	}
+
+	return r.AgentHost, nil
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package models_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/secret"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestAgentSecretRotationSuite struct {
+	suite.Suite
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
+}
+
+func (s *TestAgentSecretRotationSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestAgentSecretRotationSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestAgentSecretRotation(t *testing.T) {
+	suite.Run(t, &TestAgentSecretRotationSuite{})
+}
+
+func (s *TestAgentSecretRotationSuite) TestAdd() {
+	s.Run("should add the secret rotation correctly", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_secret_rotations" ("created_at","updated_at","deleted_at","agent_host","prev_secret_lookup","prev_secret_hash","expires_at","confirmed") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "agent_secret_rotations"."id"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "laptop", "lookup", "hash", tests.DBAnyTime{}, false).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		r := &models.AgentSecretRotation{
+			AgentHost:        "laptop",
+			PrevSecretLookup: "lookup",
+			PrevSecretHash:   "hash",
+			ExpiresAt:        time.Now().Add(24 * time.Hour),
+		}
+
+		s.NoError(r.Add(s.ctx))
+	})
+
+	s.Run("should return an error if there's an error adding the secret rotation", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_secret_rotations" ("created_at","updated_at","deleted_at","agent_host","prev_secret_lookup","prev_secret_hash","expires_at","confirmed") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "agent_secret_rotations"."id"`)).WillReturnError(errors.New("testing error"))
+
+		r := &models.AgentSecretRotation{AgentHost: "laptop", PrevSecretLookup: "lookup", PrevSecretHash: "hash"}
+
+		s.EqualError(r.Add(s.ctx), "error adding the agent secret rotation to the DB: testing error")
+	})
+}
+
+func (s *TestAgentSecretRotationSuite) TestLoadPending() {
+	s.Run("should load the pending secret rotation correctly", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1 AND confirmed = $2)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs("laptop", false).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "prev_secret_hash"}).AddRow(1, "laptop", "hash"))
+
+		r := &models.AgentSecretRotation{AgentHost: "laptop"}
+
+		s.NoError(r.LoadPending(s.ctx))
+		s.Equal("hash", r.PrevSecretHash)
+	})
+
+	s.Run("should return a not found error if there's no pending rotation", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1 AND confirmed = $2)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		r := &models.AgentSecretRotation{AgentHost: "laptop"}
+
+		s.True(gorm.IsRecordNotFoundError(r.LoadPending(s.ctx)))
+	})
+
+	s.Run("should return an error if there's an error loading the rotation", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1 AND confirmed = $2)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		r := &models.AgentSecretRotation{AgentHost: "laptop"}
+
+		s.EqualError(r.LoadPending(s.ctx), "error loading the agent secret rotation from the DB: testing error")
+	})
+}
+
+func (s *TestAgentSecretRotationSuite) TestLoadLast() {
+	s.Run("should load the last secret rotation correctly", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "confirmed"}).AddRow(2, "laptop", true))
+
+		r := &models.AgentSecretRotation{AgentHost: "laptop"}
+
+		s.NoError(r.LoadLast(s.ctx))
+		s.Equal(uint(2), r.ID)
+		s.True(r.Confirmed)
+	})
+
+	s.Run("should return an error if there's an error loading the rotation", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations"  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((agent_host = $1)) ORDER BY id desc,"agent_secret_rotations"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		r := &models.AgentSecretRotation{AgentHost: "laptop"}
+
+		s.EqualError(r.LoadLast(s.ctx), "error loading the agent secret rotation from the DB: testing error")
+	})
+}
+
+func (s *TestAgentSecretRotationSuite) TestUpdate() {
+	s.Run("should return an error if there's an error updating the rotation", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_secret_rotations" SET "updated_at" = $1, "deleted_at" = $2, "agent_host" = $3, "prev_secret_lookup" = $4, "prev_secret_hash" = $5, "expires_at" = $6, "confirmed" = $7  WHERE "agent_secret_rotations"."deleted_at" IS NULL AND "agent_secret_rotations"."id" = $8`)).WillReturnError(errors.New("testing error"))
+
+		r := &models.AgentSecretRotation{Model: gorm.Model{ID: 1}, AgentHost: "laptop", Confirmed: true}
+
+		s.EqualError(r.Update(s.ctx), "error updating the agent secret rotation: testing error")
+	})
+}
+
+func (s *TestAgentSecretRotationSuite) TestDelete() {
+	s.Run("should delete the rotation correctly", func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_secret_rotations" SET "deleted_at"=$1 WHERE "agent_secret_rotations"."deleted_at" IS NULL AND "agent_secret_rotations"."id" = $2`)).WithArgs(tests.DBAnyTime{}, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		s.mock.ExpectCommit()
+
+		r := &models.AgentSecretRotation{Model: gorm.Model{ID: 1}}
+
+		s.NoError(r.Delete(s.ctx))
+	})
+}
+
+func (s *TestAgentSecretRotationSuite) TestAgentSecretRotationHost() {
+	h, err := secret.Hash("secret")
+	s.Require().NoError(err)
+
+	s.Run("should return the host of the agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations" WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((prev_secret_lookup = $1 AND confirmed = $2 AND expires_at > $3)) ORDER BY "agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("secret"), false, tests.DBAnyTime{}).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "prev_secret_hash"}).AddRow(1, "laptop", h))
+
+		host, err := models.AgentSecretRotationHost(s.ctx, "secret")
+
+		s.NoError(err)
+		s.Equal("laptop", host)
+	})
+
+	s.Run("should return a not found error if the secret doesn't match the hash", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations" WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((prev_secret_lookup = $1 AND confirmed = $2 AND expires_at > $3)) ORDER BY "agent_secret_rotations"."id" ASC LIMIT 1`)).WithArgs(secret.Lookup("h4ck3r"), false, tests.DBAnyTime{}).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "prev_secret_hash"}).AddRow(1, "laptop", h))
+
+		host, err := models.AgentSecretRotationHost(s.ctx, "h4ck3r")
+
+		s.True(gorm.IsRecordNotFoundError(err))
+		s.Equal("", host)
+	})
+
+	s.Run("should return an error if there's an error getting the rotation", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_secret_rotations" WHERE "agent_secret_rotations"."deleted_at" IS NULL AND ((prev_secret_lookup = $1 AND confirmed = $2 AND expires_at > $3)) ORDER BY "agent_secret_rotations"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
+
+		host, err := models.AgentSecretRotationHost(s.ctx, "secret")
+
+		s.EqualError(err, "error getting the agent secret rotation: testing error")
+		s.Equal("", host)
+	})
+}
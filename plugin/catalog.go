@@ -0,0 +1,352 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoCatalogBinary gets returned when the catalog plugin has no binary for the arch and the OS of the agent
+	ErrNoCatalogBinary = errors.New("the catalog plugin has no binary for the agent arch and OS")
+	// ErrChecksumMismatch gets returned when the checksum of a plugin binary doesn't match the expected one
+	ErrChecksumMismatch = errors.New("the plugin binary checksum doesn't match")
+)
+
+// Binary is a plugin binary built for an arch and an OS, signed by the plugin publisher. The unknown arch or OS mean that
+// the binary supports any arch or OS
+type Binary struct {
+	Arch      os.Arch
+	OS        os.OS
+	Bin       []byte
+	Signature []byte
+}
+
+// Upload uploads a new plugin version to the catalog. The manifest and the binaries are stored in the plugins bucket. All
+// the binaries have to be signed by one of the trusted keys of the plugin repo
+func Upload(ctx *context.Context, repo, name, version string, manifest []byte, bins []Binary) (*models.CatalogPlugin, error) {
+	if repo == "" || name == "" || version == "" {
+		return nil, errors.New("the plugin repo, name and version are required")
+	}
+
+	if len(bins) == 0 {
+		return nil, errors.New("the plugin has no binaries")
+	}
+
+	var m *models.PluginManifest
+	if len(manifest) != 0 {
+		var err error
+		if m, err = ParseManifest(manifest); err != nil {
+			return nil, err
+		}
+
+		if m.Repo != repo || m.Name != name || m.Version != version {
+			return nil, fmt.Errorf("the manifest is for the plugin '%s/%s@%s'", m.Repo, m.Name, m.Version)
+		}
+	}
+
+	c := &models.CatalogPlugin{Repo: repo, Name: name, Version: version}
+	if err := c.Load(ctx); err == nil {
+		return nil, models.ErrCatalogPluginExists
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+
+	prefix := path.Join(repo, name, version)
+	targets := map[string]bool{}
+
+	for _, b := range bins {
+		target := binaryTarget(b.Arch, b.OS)
+		if targets[target] {
+			return nil, fmt.Errorf("duplicated plugin binary for '%s'", target)
+		}
+		targets[target] = true
+
+		if err := Verify(ctx, repo, name, version, b.Bin, b.Signature); err != nil {
+			return nil, fmt.Errorf("error verifying the plugin binary for '%s': %v", target, err)
+		}
+
+		c.Binaries = append(c.Binaries, &models.CatalogPluginBinary{
+			Arch:      b.Arch,
+			OS:        b.OS,
+			Key:       path.Join(prefix, target),
+			SHA256:    Checksum(b.Bin),
+			Signature: base64.StdEncoding.EncodeToString(b.Signature),
+			Size:      int64(len(b.Bin)),
+		})
+	}
+
+	// If the upload fails, the objects already stored get removed, so there are no orphaned objects in the bucket
+	var uploaded []string
+	fail := func(err error) (*models.CatalogPlugin, error) {
+		removeObjects(ctx, uploaded)
+		return nil, err
+	}
+
+	for i, b := range bins {
+		if err := minio.PutObject(ctx, ctx.Cfg.Minio.PluginsBucket, c.Binaries[i].Key, b.Bin); err != nil {
+			return fail(fmt.Errorf("error uploading the plugin binary: %v", err))
+		}
+		uploaded = append(uploaded, c.Binaries[i].Key)
+	}
+
+	if len(manifest) != 0 {
+		c.ManifestKey = path.Join(prefix, "manifest")
+		if err := minio.PutObject(ctx, ctx.Cfg.Minio.PluginsBucket, c.ManifestKey, manifest); err != nil {
+			return fail(fmt.Errorf("error uploading the plugin manifest: %v", err))
+		}
+		uploaded = append(uploaded, c.ManifestKey)
+	}
+
+	c.Manifest = m
+	if err := c.Add(ctx); err != nil {
+		return fail(err)
+	}
+
+	return c, nil
+}
+
+// removeObjects removes the objects from the plugins bucket. The errors are only logged, since it's used to clean up
+func removeObjects(ctx *context.Context, keys []string) {
+	for _, k := range keys {
+		if err := minio.RemoveObject(ctx, ctx.Cfg.Minio.PluginsBucket, k); err != nil {
+			log.Warnf("error removing the plugin artifact '%s': %v", k, err)
+		}
+	}
+}
+
+// RemoveFromCatalog removes a plugin version and its artifacts from the catalog. The plugins already installed in the agents aren't removed
+func RemoveFromCatalog(ctx *context.Context, c *models.CatalogPlugin) error {
+	if err := c.Load(ctx); err != nil {
+		return err
+	}
+
+	keys := []string{}
+	for _, b := range c.Binaries {
+		keys = append(keys, b.Key)
+	}
+	if c.ManifestKey != "" {
+		keys = append(keys, c.ManifestKey)
+	}
+
+	for _, k := range keys {
+		if err := minio.RemoveObject(ctx, ctx.Cfg.Minio.PluginsBucket, k); err != nil {
+			return fmt.Errorf("error removing the plugin artifacts: %v", err)
+		}
+	}
+
+	return c.Delete(ctx)
+}
+
+// Manifest returns the manifest of a catalog plugin. If the plugin has no manifest, it returns nil
+func Manifest(ctx *context.Context, c *models.CatalogPlugin) ([]byte, error) {
+	if c.ManifestKey == "" {
+		return nil, nil
+	}
+
+	return minio.GetObject(ctx, ctx.Cfg.Minio.PluginsBucket, c.ManifestKey)
+}
+
+// InstallFromCatalog installs a catalog plugin in an agent, using the binary for the agent arch and OS. If the agent
+// already has other versions of the plugin, the new version is installed side by side with them
+func InstallFromCatalog(ctx *context.Context, a *models.Agent, c *models.CatalogPlugin) error {
+	if err := a.Load(ctx); err != nil {
+		return err
+	}
+
+	if err := c.Load(ctx); err != nil {
+		return err
+	}
+
+	return installFromCatalog(ctx, a, c, map[string][]byte{})
+}
+
+// InstallFromCatalogBySelector installs a catalog plugin in all the agents that match the label selector. It returns the
+// result of each agent
+func InstallFromCatalogBySelector(ctx *context.Context, sel models.LabelSelector, c *models.CatalogPlugin) (map[string]error, error) {
+	if err := c.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	agents, err := models.AgentListBySelector(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	// The binaries are downloaded only once, even if there are multiple agents using them
+	downloaded := map[string][]byte{}
+
+	results := map[string]error{}
+	for _, a := range agents {
+		results[a.Host] = installFromCatalog(ctx, a, c, downloaded)
+		if results[a.Host] != nil {
+			log.Errorf("error installing the plugin '%s' in the agent '%s': %v", c, a.Host, results[a.Host])
+		}
+	}
+
+	return results, nil
+}
+
+// installFromCatalog installs or updates the catalog plugin in the agent. The downloaded binaries are cached in the map
+func installFromCatalog(ctx *context.Context, a *models.Agent, c *models.CatalogPlugin, downloaded map[string][]byte) error {
+	bin := c.Binary(a.Arch, a.OS)
+	if bin == nil {
+		return ErrNoCatalogBinary
+	}
+
+	f, ok := downloaded[bin.Key]
+	if !ok {
+		var err error
+		f, err = minio.GetObject(ctx, ctx.Cfg.Minio.PluginsBucket, bin.Key)
+		if err != nil {
+			return fmt.Errorf("error downloading the plugin binary: %v", err)
+		}
+
+		if Checksum(f) != bin.SHA256 {
+			return ErrChecksumMismatch
+		}
+
+		downloaded[bin.Key] = f
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(bin.Signature)
+	if err != nil {
+		return fmt.Errorf("error decoding the plugin signature: %v", err)
+	}
+
+	m := &models.PluginManifest{Repo: c.Repo, Name: c.Name, Version: c.Version}
+	if err := m.Load(ctx); err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+
+		m = nil
+	}
+
+	return installVersion(ctx, a, c.Repo, c.Name, c.Version, m, bin, f, sig)
+}
+
+// installVersion installs a plugin version in the agent, side by side with the other installed versions of the plugin. The
+// binary is the one of the catalog or the repository for the agent arch and OS. If the version is already installed, it
+// does nothing
+func installVersion(ctx *context.Context, a *models.Agent, repo, name, version string, m *models.PluginManifest, bin *models.CatalogPluginBinary, f, sig []byte) error {
+	installed, err := models.PluginVersions(ctx, a.Host, repo, name)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range installed {
+		if i.Version == version {
+			return nil
+		}
+	}
+
+	p := &models.Plugin{AgentHost: a.Host, Repo: repo, Name: name, Version: version}
+	setCatalogConstraints(p, m, bin)
+	p.InstalledAt = time.Now()
+
+	return Add(ctx, p, a, f, sig)
+}
+
+// setCatalogConstraints sets the manifest of the catalog or repository plugin (if it has one) and the arch and OS
+// constraints of the plugin. The constraints of the binary take precedence over the ones declared in the manifest
+func setCatalogConstraints(p *models.Plugin, m *models.PluginManifest, b *models.CatalogPluginBinary) {
+	p.Arch, p.OS = nil, nil
+	if m != nil {
+		// The catalog manifests are always for the catalog plugin
+		applyManifest(p, m)
+	}
+
+	arch, pOS := binaryConstraints(b)
+	if arch != nil {
+		p.Arch = arch
+	}
+	if pOS != nil {
+		p.OS = pOS
+	}
+}
+
+// binaryConstraints returns the arch and OS constraints of a catalog binary
+func binaryConstraints(b *models.CatalogPluginBinary) ([]os.Arch, []os.OS) {
+	var arch []os.Arch
+	if b.Arch != os.ArchUnknown {
+		arch = []os.Arch{b.Arch}
+	}
+
+	var pOS []os.OS
+	if b.OS != os.Unknown {
+		pOS = []os.OS{b.OS}
+	}
+
+	return arch, pOS
+}
+
+// binaryTarget returns the `os-arch` target of a binary (e.g. `linux-amd64`). The unknown arch or OS are `any`
+func binaryTarget(arch os.Arch, pOS os.OS) string {
+	a := "any"
+	if arch != os.ArchUnknown {
+		a = strings.ToLower(strings.TrimPrefix(drlm.Arch(arch).String(), "ARCH_"))
+	}
+
+	o := "any"
+	if pOS != os.Unknown {
+		o = strings.ToLower(strings.TrimPrefix(drlm.OS(pOS).String(), "OS_"))
+	}
+
+	return o + "-" + a
+}
+
+// ParseBinaryTarget parses a `os/arch` target (e.g. `linux/amd64`). The `any` OS or arch are the unknown ones
+func ParseBinaryTarget(target string) (os.Arch, os.OS, error) {
+	parts := strings.SplitN(target, "/", 2)
+	if len(parts) != 2 {
+		return os.ArchUnknown, os.Unknown, fmt.Errorf("invalid plugin binary target '%s': it has to be 'os/arch'", target)
+	}
+
+	pOS := os.Unknown
+	if parts[0] != "any" {
+		var err error
+		if pOS, err = parseOS(parts[0]); err != nil {
+			return os.ArchUnknown, os.Unknown, err
+		}
+	}
+
+	arch := os.ArchUnknown
+	if parts[1] != "any" {
+		var err error
+		if arch, err = parseArch(parts[1]); err != nil {
+			return os.ArchUnknown, os.Unknown, err
+		}
+	}
+
+	return arch, pOS, nil
+}
+
+// ParseCatalogRef parses a reference to a catalog plugin with the `repo/name@version` format
+func ParseCatalogRef(ref string) (*models.CatalogPlugin, error) {
+	parts := strings.SplitN(ref, "@", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid catalog plugin '%s': it has to be 'repo/name@version'", ref)
+	}
+
+	repo, name, err := parse(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog plugin '%s': it has to be 'repo/name@version'", ref)
+	}
+
+	return &models.CatalogPlugin{Repo: repo, Name: name, Version: parts[1]}, nil
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/plugin"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/jinzhu/gorm"
+)
+
+func (s *TestPluginSuite) TestUpload() {
+	s.Run("should return an error if the repo, the name or the version are missing", func() {
+		c, err := plugin.Upload(s.ctx, "default", "", "v1.0.0", nil, []plugin.Binary{{Bin: []byte("binary")}})
+		s.Nil(c)
+		s.EqualError(err, "the plugin repo, name and version are required")
+	})
+
+	s.Run("should return an error if there are no binaries", func() {
+		c, err := plugin.Upload(s.ctx, "default", "tar", "v1.0.0", nil, nil)
+		s.Nil(c)
+		s.EqualError(err, "the plugin has no binaries")
+	})
+
+	s.Run("should return an error if the plugin version is already in the catalog", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugins" WHERE "catalog_plugins"."deleted_at" IS NULL AND ((repo = $1 AND name = $2 AND version = $3))`)).WithArgs("default", "tar", "v1.0.0").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version"}).
+			AddRow(1, "default", "tar", "v1.0.0"),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugin_binaries"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		c, err := plugin.Upload(s.ctx, "default", "tar", "v1.0.0", nil, []plugin.Binary{{Bin: []byte("binary")}})
+		s.Nil(c)
+		s.Equal(models.ErrCatalogPluginExists, err)
+	})
+
+	s.Run("should remove the uploaded binaries if there's an error uploading the plugin", func() {
+		tests.GenerateCfg(s.T(), s.ctx)
+
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		s.Require().NoError(err)
+		s.ctx.Cfg.Plugins.TrustedKeys = map[string][]string{"default": {base64.StdEncoding.EncodeToString(pub)}}
+
+		bins := []plugin.Binary{}
+		for _, pOS := range []os.OS{os.Linux, os.Darwin} {
+			b := []byte(fmt.Sprintf("binary-%d", pOS))
+			bins = append(bins, plugin.Binary{
+				Arch:      os.ArchAmd64,
+				OS:        pOS,
+				Bin:       b,
+				Signature: ed25519.Sign(priv, plugin.SignedManifest("default", "tar", "v1.0.0", plugin.Checksum(b))),
+			})
+		}
+
+		var removed []string
+		ts := tests.GenerateMinio(s.ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case hasQuery(r, "location"):
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`))
+			case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/darwin-amd64"):
+				w.WriteHeader(http.StatusForbidden)
+			case r.Method == http.MethodDelete:
+				removed = append(removed, strings.TrimPrefix(r.URL.Path, "/drlm-plugins/"))
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusOK)
+			}
+		}))
+		defer ts.Close()
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugins"`)).WillReturnError(gorm.ErrRecordNotFound)
+
+		c, err := plugin.Upload(s.ctx, "default", "tar", "v1.0.0", nil, bins)
+		s.Nil(c)
+		s.Error(err)
+		s.True(strings.HasPrefix(err.Error(), "error uploading the plugin binary: "))
+		s.Equal([]string{"default/tar/v1.0.0/linux-amd64"}, removed)
+	})
+
+	s.Run("should return an error if a binary isn't signed", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "catalog_plugins"`)).WillReturnError(gorm.ErrRecordNotFound)
+
+		c, err := plugin.Upload(s.ctx, "default", "tar", "v1.0.0", nil, []plugin.Binary{{Arch: os.ArchAmd64, OS: os.Linux, Bin: []byte("binary")}})
+		s.Nil(c)
+		s.EqualError(err, "error verifying the plugin binary for 'linux-amd64': the plugin isn't signed")
+	})
+}
+
+func (s *TestPluginSuite) TestParseBinaryTarget() {
+	s.Run("should parse the target correctly", func() {
+		arch, pOS, err := plugin.ParseBinaryTarget("linux/amd64")
+		s.NoError(err)
+		s.Equal(os.ArchAmd64, arch)
+		s.Equal(os.Linux, pOS)
+	})
+
+	s.Run("should parse any OS or arch as unknown", func() {
+		arch, pOS, err := plugin.ParseBinaryTarget("any/any")
+		s.NoError(err)
+		s.Equal(os.ArchUnknown, arch)
+		s.Equal(os.Unknown, pOS)
+	})
+
+	s.Run("should return an error if the target has an invalid format", func() {
+		_, _, err := plugin.ParseBinaryTarget("linux")
+		s.EqualError(err, "invalid plugin binary target 'linux': it has to be 'os/arch'")
+	})
+
+	s.Run("should return an error if the OS is unknown", func() {
+		_, _, err := plugin.ParseBinaryTarget("amiga/amd64")
+		s.EqualError(err, "unknown OS 'amiga'")
+	})
+
+	s.Run("should return an error if the arch is unknown", func() {
+		_, _, err := plugin.ParseBinaryTarget("linux/mips")
+		s.EqualError(err, "unknown arch 'mips'")
+	})
+}
+
+func (s *TestPluginSuite) TestParseCatalogRef() {
+	s.Run("should parse the reference correctly", func() {
+		c, err := plugin.ParseCatalogRef("default/tar@v1.0.0")
+		s.NoError(err)
+		s.Equal(&models.CatalogPlugin{Repo: "default", Name: "tar", Version: "v1.0.0"}, c)
+	})
+
+	s.Run("should return an error if the version is missing", func() {
+		c, err := plugin.ParseCatalogRef("default/tar")
+		s.Nil(c)
+		s.EqualError(err, "invalid catalog plugin 'default/tar': it has to be 'repo/name@version'")
+	})
+
+	s.Run("should return an error if the plugin is invalid", func() {
+		c, err := plugin.ParseCatalogRef("tar@v1.0.0")
+		s.Nil(c)
+		s.EqualError(err, "invalid catalog plugin 'tar@v1.0.0': it has to be 'repo/name@version'")
+	})
+}
+
+// hasQuery returns whether the request has the query parameter
+func hasQuery(r *http.Request, param string) bool {
+	_, ok := r.URL.Query()[param]
+	return ok
+}
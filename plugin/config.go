@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+)
+
+// SetConfig sets the config override of a plugin (`repo/name`) for a group of agents (the target is a label selector) or
+// for an agent (the target is the agent host). The config is a JSON object and it replaces the previous override
+func SetConfig(ctx *context.Context, plugin string, scope models.PluginConfigScope, target, config string) error {
+	repo, name, err := parse(plugin)
+	if err != nil {
+		return err
+	}
+
+	if err := validateConfigTarget(ctx, scope, target); err != nil {
+		return err
+	}
+
+	c := &models.PluginConfig{
+		Repo:   repo,
+		Name:   name,
+		Scope:  scope,
+		Target: target,
+		Config: config,
+	}
+
+	return c.Save(ctx)
+}
+
+// UnsetConfig removes the config override of a plugin (`repo/name`) for a group of agents or for an agent
+func UnsetConfig(ctx *context.Context, plugin string, scope models.PluginConfigScope, target string) error {
+	repo, name, err := parse(plugin)
+	if err != nil {
+		return err
+	}
+
+	c := &models.PluginConfig{
+		Repo:   repo,
+		Name:   name,
+		Scope:  scope,
+		Target: target,
+	}
+
+	return c.Delete(ctx)
+}
+
+// ConfigList returns the config overrides of a plugin (`repo/name`)
+func ConfigList(ctx *context.Context, plugin string) ([]*models.PluginConfig, error) {
+	repo, name, err := parse(plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.PluginConfigList(ctx, repo, name)
+}
+
+// validateConfigTarget checks that the target of a config override is a valid label selector or an existing agent
+func validateConfigTarget(ctx *context.Context, scope models.PluginConfigScope, target string) error {
+	switch scope {
+	case models.PluginConfigScopeGroup:
+		sel, err := models.ParseLabelSelector(target)
+		if err != nil {
+			return err
+		}
+
+		if len(sel) == 0 {
+			return fmt.Errorf("invalid label selector: the group config requires at least one label")
+		}
+
+	case models.PluginConfigScopeAgent:
+		return (&models.Agent{Host: target}).Load(ctx)
+
+	default:
+		return fmt.Errorf("unknown plugin config scope %d", scope)
+	}
+
+	return nil
+}
@@ -0,0 +1,419 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/version"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/pelletier/go-toml"
+)
+
+// ManifestVersion is the latest version of the manifest format that's supported
+const ManifestVersion = 1
+
+// manifestConfigTypes are the types of the options of the plugins configuration schema
+var manifestConfigTypes = []string{"string", "int", "bool", "duration"}
+
+// manifest is the TOML manifest of a plugin version:
+//
+//	manifest_version = 1
+//	repo = "default"
+//	name = "tar"
+//	version = "v1.0.0"
+//	description = "Backups using tar"
+//	min_agent_version = "v0.1.0"
+//	default_timeout = "2h"
+//	arch = ["amd64"]
+//
+//	[[os]]
+//	name = "linux"
+//
+//	  [[os.distro]]
+//	  name = "debian"
+//	  versions = ">= 9, < 12"
+//
+//	[config.compression]
+//	type = "string"
+//	default = "gzip"
+//	description = "The compression algorithm"
+type manifest struct {
+	ManifestVersion int                             `toml:"manifest_version"`
+	Repo            string                          `toml:"repo"`
+	Name            string                          `toml:"name"`
+	Version         string                          `toml:"version"`
+	Description     string                          `toml:"description"`
+	MinAgentVersion string                          `toml:"min_agent_version"`
+	DefaultTimeout  string                          `toml:"default_timeout"`
+	Arch            []string                        `toml:"arch"`
+	OS              []manifestOS                    `toml:"os"`
+	Config          map[string]manifestConfigOption `toml:"-"` // The config options are decoded from the TOML tree, since their defaults have different types
+}
+
+type manifestOS struct {
+	Name     string           `toml:"name"`
+	Versions string           `toml:"versions"`
+	Distros  []manifestDistro `toml:"distro"`
+}
+
+type manifestDistro struct {
+	Name     string `toml:"name"`
+	Versions string `toml:"versions"`
+}
+
+type manifestConfigOption struct {
+	Type        string
+	Required    bool
+	Default     interface{}
+	Description string
+}
+
+// ParseManifest parses and validates a TOML plugin manifest and returns it normalized
+func ParseManifest(b []byte) (*models.PluginManifest, error) {
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the plugin manifest: %v", err)
+	}
+
+	var raw manifest
+	if err := tree.Unmarshal(&raw); err != nil {
+		return nil, fmt.Errorf("error parsing the plugin manifest: %v", err)
+	}
+
+	if raw.Config, err = decodeConfig(tree); err != nil {
+		return nil, fmt.Errorf("invalid plugin manifest: %v", err)
+	}
+
+	if raw.ManifestVersion < 1 || raw.ManifestVersion > ManifestVersion {
+		return nil, fmt.Errorf("invalid plugin manifest: unsupported manifest version %d", raw.ManifestVersion)
+	}
+
+	if raw.Repo == "" || raw.Name == "" || raw.Version == "" {
+		return nil, fmt.Errorf("invalid plugin manifest: the repo, name and version are required")
+	}
+
+	m := &models.PluginManifest{
+		Repo:            raw.Repo,
+		Name:            raw.Name,
+		Version:         raw.Version,
+		ManifestVersion: raw.ManifestVersion,
+		Description:     raw.Description,
+		MinAgentVersion: raw.MinAgentVersion,
+	}
+
+	if m.MinAgentVersion != "" {
+		if _, err := version.Parse(m.MinAgentVersion); err != nil {
+			return nil, fmt.Errorf("invalid plugin manifest: invalid min_agent_version: %v", err)
+		}
+	}
+
+	if raw.DefaultTimeout != "" {
+		d, err := time.ParseDuration(raw.DefaultTimeout)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid plugin manifest: invalid default_timeout '%s'", raw.DefaultTimeout)
+		}
+
+		m.DefaultTimeout = d
+	}
+
+	for _, a := range raw.Arch {
+		arch, err := parseArch(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin manifest: %v", err)
+		}
+
+		m.Archs = append(m.Archs, &models.PluginManifestArch{Arch: arch})
+	}
+
+	for _, o := range raw.OS {
+		pOS, err := parseOS(o.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin manifest: %v", err)
+		}
+
+		if _, err := version.ParseConstraint(o.Versions); err != nil {
+			return nil, fmt.Errorf("invalid plugin manifest: invalid '%s' versions: %v", o.Name, err)
+		}
+
+		if len(o.Distros) == 0 {
+			m.Platforms = append(m.Platforms, &models.PluginManifestPlatform{OS: pOS, OSVersions: o.Versions})
+			continue
+		}
+
+		for _, d := range o.Distros {
+			if d.Name == "" {
+				return nil, fmt.Errorf("invalid plugin manifest: the '%s' distros require a name", o.Name)
+			}
+
+			if _, err := version.ParseConstraint(d.Versions); err != nil {
+				return nil, fmt.Errorf("invalid plugin manifest: invalid '%s' versions: %v", d.Name, err)
+			}
+
+			m.Platforms = append(m.Platforms, &models.PluginManifestPlatform{
+				OS:             pOS,
+				OSVersions:     o.Versions,
+				Distro:         d.Name,
+				DistroVersions: d.Versions,
+			})
+		}
+	}
+
+	for _, name := range sortedKeys(raw.Config) {
+		opt := raw.Config[name]
+
+		cfg, err := parseConfigOption(name, opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin manifest: %v", err)
+		}
+
+		m.Config = append(m.Config, cfg)
+	}
+
+	return m, nil
+}
+
+// SetManifest parses the TOML manifest of the plugin and sets it, along with the arch and OS constraints declared in it.
+// The manifest gets stored when the plugin is added or updated
+func SetManifest(p *models.Plugin, b []byte) error {
+	m, err := ParseManifest(b)
+	if err != nil {
+		return err
+	}
+
+	return applyManifest(p, m)
+}
+
+// applyManifest sets the manifest of the plugin and the arch and OS constraints declared in it
+func applyManifest(p *models.Plugin, m *models.PluginManifest) error {
+	if m.Repo != p.Repo || m.Name != p.Name {
+		return fmt.Errorf("the manifest is for the plugin '%s/%s' instead of '%s'", m.Repo, m.Name, p)
+	}
+
+	p.Manifest = m
+
+	p.Arch = nil
+	for _, a := range m.Archs {
+		p.Arch = append(p.Arch, a.Arch)
+	}
+
+	p.OS = nil
+	seen := map[os.OS]bool{}
+	for _, pl := range m.Platforms {
+		if !seen[pl.OS] {
+			p.OS = append(p.OS, pl.OS)
+			seen[pl.OS] = true
+		}
+	}
+
+	return nil
+}
+
+// checkCompatible checks that the agent (using the information synced from it) is compatible with the plugin manifest
+func checkCompatible(m *models.PluginManifest, v string, a *models.Agent) error {
+	if m.Version != v {
+		return fmt.Errorf("the manifest is for the version '%s' instead of '%s'", m.Version, v)
+	}
+
+	if m.MinAgentVersion != "" {
+		if cmp, err := version.Compare(a.Version, m.MinAgentVersion); err != nil || cmp < 0 {
+			return fmt.Errorf("unsupported agent version: the plugin requires at least '%s'", m.MinAgentVersion)
+		}
+	}
+
+	if len(m.Archs) != 0 {
+		found := false
+		for _, arch := range m.Archs {
+			if arch.Arch == a.Arch {
+				found = true
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("unsupported arch")
+		}
+	}
+
+	if len(m.Platforms) == 0 {
+		return nil
+	}
+
+	osFound, osVersionFound := false, false
+	for _, pl := range m.Platforms {
+		if pl.OS != a.OS {
+			continue
+		}
+		osFound = true
+
+		if !matchVersion(pl.OSVersions, a.OSVersion) {
+			continue
+		}
+		osVersionFound = true
+
+		if pl.Distro != "" && !strings.EqualFold(pl.Distro, strings.Trim(a.Distro, `"'`)) {
+			continue
+		}
+
+		if matchVersion(pl.DistroVersions, a.DistroVersion) {
+			return nil
+		}
+	}
+
+	switch {
+	case !osFound:
+		return fmt.Errorf("unsupported os")
+	case !osVersionFound:
+		return fmt.Errorf("unsupported os version")
+	default:
+		return fmt.Errorf("unsupported distro or distro version")
+	}
+}
+
+// matchVersion returns whether the version matches the constraint. The versions that can't be parsed only match the
+// empty constraints
+func matchVersion(constraint, v string) bool {
+	c, err := version.ParseConstraint(constraint)
+	if err != nil {
+		return false
+	}
+
+	if c.String() == "" || c.String() == "*" {
+		return true
+	}
+
+	parsed, err := version.Parse(v)
+	if err != nil {
+		return false
+	}
+
+	return c.Check(parsed)
+}
+
+// parseConfigOption parses and validates an option of the configuration schema of the manifest
+func parseConfigOption(name string, opt manifestConfigOption) (*models.PluginManifestConfig, error) {
+	valid := false
+	for _, t := range manifestConfigTypes {
+		if opt.Type == t {
+			valid = true
+		}
+	}
+
+	if !valid {
+		return nil, fmt.Errorf("invalid type '%s' of the config option '%s'", opt.Type, name)
+	}
+
+	cfg := &models.PluginManifestConfig{
+		Name:        name,
+		Type:        opt.Type,
+		Required:    opt.Required,
+		Description: opt.Description,
+	}
+
+	if opt.Default == nil {
+		return cfg, nil
+	}
+
+	var ok bool
+	switch opt.Type {
+	case "string":
+		cfg.Default, ok = opt.Default.(string)
+
+	case "int":
+		var i int64
+		if i, ok = opt.Default.(int64); ok {
+			cfg.Default = strconv.FormatInt(i, 10)
+		}
+
+	case "bool":
+		var b bool
+		if b, ok = opt.Default.(bool); ok {
+			cfg.Default = strconv.FormatBool(b)
+		}
+
+	case "duration":
+		var d string
+		if d, ok = opt.Default.(string); ok {
+			_, err := time.ParseDuration(d)
+			ok = err == nil
+			cfg.Default = d
+		}
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("invalid default value of the config option '%s': it has to be a %s", name, opt.Type)
+	}
+
+	return cfg, nil
+}
+
+// parseArch parses an arch name (e.g. `amd64`)
+func parseArch(s string) (os.Arch, error) {
+	v, ok := drlm.Arch_value["ARCH_"+strings.ToUpper(s)]
+	if !ok {
+		return os.ArchUnknown, fmt.Errorf("unknown arch '%s'", s)
+	}
+
+	return os.Arch(v), nil
+}
+
+// parseOS parses an OS name (e.g. `linux`)
+func parseOS(s string) (os.OS, error) {
+	v, ok := drlm.OS_value["OS_"+strings.ToUpper(s)]
+	if !ok {
+		return os.Unknown, fmt.Errorf("unknown OS '%s'", s)
+	}
+
+	return os.OS(v), nil
+}
+
+// decodeConfig decodes the config options of the manifest TOML tree
+func decodeConfig(tree *toml.Tree) (map[string]manifestConfigOption, error) {
+	cfg := map[string]manifestConfigOption{}
+
+	if !tree.Has("config") {
+		return cfg, nil
+	}
+
+	opts, ok := tree.Get("config").(*toml.Tree)
+	if !ok {
+		return nil, fmt.Errorf("the config has to be a table")
+	}
+
+	for name, v := range opts.ToMap() {
+		opt, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the config option '%s' has to be a table", name)
+		}
+
+		t, _ := opt["type"].(string)
+		required, _ := opt["required"].(bool)
+		desc, _ := opt["description"].(string)
+
+		cfg[name] = manifestConfigOption{
+			Type:        t,
+			Required:    required,
+			Default:     opt["default"],
+			Description: desc,
+		}
+	}
+
+	return cfg, nil
+}
+
+// sortedKeys returns the names of the config options sorted, so the schema is always stored in the same order
+func sortedKeys(cfg map[string]manifestConfigOption) []string {
+	keys := []string{}
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin_test
+
+import (
+	"time"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/plugin"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+)
+
+const testManifest = `
+manifest_version = 1
+repo = "default"
+name = "tar"
+version = "v1.0.0"
+description = "Backups using tar"
+min_agent_version = "v0.1.0"
+default_timeout = "2h"
+arch = ["amd64"]
+
+[[os]]
+name = "linux"
+
+  [[os.distro]]
+  name = "debian"
+  versions = ">= 9, < 12"
+
+  [[os.distro]]
+  name = "ubuntu"
+  versions = ">= 18.04"
+
+[config.compression]
+type = "string"
+default = "gzip"
+description = "The compression algorithm"
+
+[config.level]
+type = "int"
+default = 6
+`
+
+func (s *TestPluginSuite) TestParseManifest() {
+	s.Run("should parse and normalize the manifest", func() {
+		m, err := plugin.ParseManifest([]byte(testManifest))
+		s.Require().NoError(err)
+
+		s.Equal("default", m.Repo)
+		s.Equal("tar", m.Name)
+		s.Equal("v1.0.0", m.Version)
+		s.Equal(1, m.ManifestVersion)
+		s.Equal("Backups using tar", m.Description)
+		s.Equal("v0.1.0", m.MinAgentVersion)
+		s.Equal(2*time.Hour, m.DefaultTimeout)
+		s.Equal([]*models.PluginManifestArch{{Arch: os.ArchAmd64}}, m.Archs)
+		s.Equal([]*models.PluginManifestPlatform{
+			{OS: os.Linux, Distro: "debian", DistroVersions: ">= 9, < 12"},
+			{OS: os.Linux, Distro: "ubuntu", DistroVersions: ">= 18.04"},
+		}, m.Platforms)
+		s.Equal([]*models.PluginManifestConfig{
+			{Name: "compression", Type: "string", Default: "gzip", Description: "The compression algorithm"},
+			{Name: "level", Type: "int", Default: "6"},
+		}, m.Config)
+	})
+
+	for _, tc := range []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{"invalid TOML", `manifest_version = `, ""},
+		{"unsupported manifest version", `manifest_version = 2`, "invalid plugin manifest: unsupported manifest version 2"},
+		{"missing plugin", `manifest_version = 1`, "invalid plugin manifest: the repo, name and version are required"},
+		{"invalid arch", testPlugin + `arch = ["mips"]`, "invalid plugin manifest: unknown arch 'mips'"},
+		{"invalid timeout", testPlugin + `default_timeout = "forever"`, "invalid plugin manifest: invalid default_timeout 'forever'"},
+		{"invalid os", testPlugin + "[[os]]\nname = \"amiga\"", "invalid plugin manifest: unknown OS 'amiga'"},
+		{"invalid distro versions", testPlugin + "[[os]]\nname = \"linux\"\n[[os.distro]]\nname = \"debian\"\nversions = \">= nine\"", "invalid plugin manifest: invalid 'debian' versions: invalid version constraint '>= nine': invalid version 'nine'"},
+		{"invalid config type", testPlugin + "[config.level]\ntype = \"float\"", "invalid plugin manifest: invalid type 'float' of the config option 'level'"},
+		{"invalid config default", testPlugin + "[config.level]\ntype = \"int\"\ndefault = \"six\"", "invalid plugin manifest: invalid default value of the config option 'level': it has to be a int"},
+	} {
+		s.Run("should return an error if the manifest has an "+tc.name, func() {
+			m, err := plugin.ParseManifest([]byte(tc.manifest))
+			s.Nil(m)
+			s.Error(err)
+			if tc.err != "" {
+				s.EqualError(err, tc.err)
+			}
+		})
+	}
+}
+
+// testPlugin is the required part of a manifest
+const testPlugin = `
+manifest_version = 1
+repo = "default"
+name = "tar"
+version = "v1.0.0"
+`
+
+func (s *TestPluginSuite) TestSetManifest() {
+	s.Run("should set the manifest and its constraints", func() {
+		p := &models.Plugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.NoError(plugin.SetManifest(p, []byte(testManifest)))
+		s.NotNil(p.Manifest)
+		s.Equal([]os.Arch{os.ArchAmd64}, p.Arch)
+		s.Equal([]os.OS{os.Linux}, p.OS)
+	})
+
+	s.Run("should return an error if the manifest is for another plugin", func() {
+		p := &models.Plugin{Repo: "default", Name: "rsync", Version: "v1.0.0"}
+
+		s.EqualError(plugin.SetManifest(p, []byte(testManifest)), "the manifest is for the plugin 'default/tar' instead of 'default/rsync'")
+	})
+}
+
+func (s *TestPluginSuite) TestInstallCompatibility() {
+	agent := func() *models.Agent {
+		return &models.Agent{Version: "v0.2.0", Arch: os.ArchAmd64, OS: os.Linux, Distro: "debian", DistroVersion: `"10"`}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		agent func(a *models.Agent)
+		err   string
+	}{
+		{"the agent version is too old", func(a *models.Agent) { a.Version = "v0.0.9" }, "unsupported agent version: the plugin requires at least 'v0.1.0'"},
+		{"the agent version is unknown", func(a *models.Agent) { a.Version = "" }, "unsupported agent version: the plugin requires at least 'v0.1.0'"},
+		{"the distro isn't supported", func(a *models.Agent) { a.Distro = "centos" }, "unsupported distro or distro version"},
+		{"the distro version isn't supported", func(a *models.Agent) { a.DistroVersion = "12" }, "unsupported distro or distro version"},
+		{"the OS isn't supported", func(a *models.Agent) { a.OS = os.Darwin }, "unsupported os"},
+	} {
+		s.Run("should fail if "+tc.name, func() {
+			p := &models.Plugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+			s.Require().NoError(plugin.SetManifest(p, []byte(testManifest)))
+
+			a := agent()
+			tc.agent(a)
+
+			s.EqualError(plugin.Install(s.ctx, p, a, []byte("plugin"), nil), tc.err)
+		})
+	}
+
+	s.Run("should check the signature once the agent is compatible", func() {
+		p := &models.Plugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+		s.Require().NoError(plugin.SetManifest(p, []byte(testManifest)))
+
+		s.Equal(plugin.ErrUnsignedPlugin, plugin.Install(s.ctx, p, agent(), []byte("plugin"), nil))
+	})
+
+	s.Run("should fail if the manifest is for another version", func() {
+		p := &models.Plugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+		s.Require().NoError(plugin.SetManifest(p, []byte(testManifest)))
+
+		s.EqualError(plugin.Update(s.ctx, agent(), p, "v1.1.0", []byte("plugin"), nil), "the manifest is for the version 'v1.0.0' instead of 'v1.1.0'")
+	})
+}
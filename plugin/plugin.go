@@ -3,20 +3,291 @@
 package plugin
 
 import (
+	"errors"
 	"fmt"
-	"os/user"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/scheduler"
+	"github.com/brainupdaters/drlm-core/ssh"
 
-	"github.com/brainupdaters/drlm-common/pkg/os"
 	"github.com/brainupdaters/drlm-common/pkg/os/client"
-	"github.com/brainupdaters/drlm-common/pkg/ssh"
+	log "github.com/sirupsen/logrus"
 )
 
-// Install installs a plugin on a Agent
-func Install(ctx *context.Context, p *models.Plugin, a *models.Agent, f []byte) error {
+var (
+	// ErrPluginInUse gets returned when removing a plugin that has jobs scheduled or running
+	ErrPluginInUse = errors.New("the plugin has scheduled or running jobs")
+	// ErrSameVersion gets returned when updating a plugin to the version that's already installed
+	ErrSameVersion = errors.New("the plugin version is already installed")
+	// ErrNoPreviousVersion gets returned when rolling back a plugin that has no version installed before the current one
+	ErrNoPreviousVersion = errors.New("the plugin has no previous version")
+	// ErrInvalidPlugin gets returned when the plugin doesn't have the `repo/name` format
+	ErrInvalidPlugin = errors.New("invalid plugin: it has to be 'repo/name'")
+)
+
+// Install installs a plugin on a Agent. If the plugin has a manifest, the agent has to be compatible with it. The plugin
+// has to be signed by one of the trusted keys of its repo and its checksum gets verified again in the agent after the
+// transfer
+func Install(ctx *context.Context, p *models.Plugin, a *models.Agent, f, sig []byte) error {
+	if err := checkSupported(p, a); err != nil {
+		return err
+	}
+
+	if p.Manifest != nil {
+		if err := checkCompatible(p.Manifest, p.Version, a); err != nil {
+			return err
+		}
+	}
+
+	if err := Verify(ctx, p.Repo, p.Name, p.Version, f, sig); err != nil {
+		return err
+	}
+
+	return installBinary(ctx, a, p.BinName(p.Version), f)
+}
+
+// installBinary installs a binary of a plugin in the agent
+//
+// TODO: Install the plugins through the agent connection (so the agents don't need to accept SSH connections) once the
+// AgentConnectionFromCore and AgentConnectionFromAgent messages have the message types for the plugin operations
+func installBinary(ctx *context.Context, a *models.Agent, name string, f []byte) error {
+	agentCli, err := ssh.Acquire(ctx, a)
+	if err != nil {
+		return err
+	}
+	defer agentCli.Release()
+
+	if err := a.OS.CmdPkgInstallBinary(agentCli, a.SSHUser, name, f); err != nil {
+		return err
+	}
+
+	return verifyInstalled(agentCli, a, name, Checksum(f))
+}
+
+// Add installs a plugin on a Agent (see Install) and adds it to the DB. If the plugin can't be added to the DB, it gets
+// removed from the agent, so the agents don't have plugins installed that the Core doesn't know about
+func Add(ctx *context.Context, p *models.Plugin, a *models.Agent, f, sig []byte) error {
+	if err := Install(ctx, p, a, f, sig); err != nil {
+		return err
+	}
+
+	return addInstalled(ctx, p, a)
+}
+
+// addInstalled adds a plugin that has been installed in the agent to the DB. If it can't be added, it gets removed from the agent
+func addInstalled(ctx *context.Context, p *models.Plugin, a *models.Agent) error {
+	if err := p.Add(ctx); err != nil {
+		if rErr := removeInstalled(ctx, a, p.BinName(p.Version)); rErr != nil {
+			log.Errorf("error removing the plugin '%s' version '%s' from the agent '%s' after failing to add it: %v", p, p.Version, a.Host, rErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// removeInstalled removes a binary of a plugin from the agent
+func removeInstalled(ctx *context.Context, a *models.Agent, name string) error {
+	agentCli, err := ssh.Acquire(ctx, a)
+	if err != nil {
+		return err
+	}
+	defer agentCli.Release()
+
+	return removeBinary(agentCli, a, name)
+}
+
+// List returns all the plugins installed in an agent
+func List(ctx *context.Context, a *models.Agent) ([]*models.Plugin, error) {
+	if err := a.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := a.LoadPlugins(ctx); err != nil {
+		return nil, err
+	}
+
+	return a.Plugins, nil
+}
+
+// Load loads a plugin (`repo/name`) of an agent. If the agent has multiple versions of the plugin installed, a version
+// or a version constraint can be used (`repo/name@version`). Otherwise, the pinned or the highest version is loaded
+func Load(ctx *context.Context, a *models.Agent, plugin string) (*models.Plugin, error) {
+	ref, constraint := models.ParsePluginRef(plugin)
+
+	repo, name, err := parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	versions, err := models.PluginVersions(ctx, a.Host, repo, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ResolvePluginVersion(versions, constraint, true)
+}
+
+// Remove uninstalls a version of a plugin from an agent. The version can't be removed while it has jobs scheduled or running.
+// The rest of the installed versions of the plugin are kept
+func Remove(ctx *context.Context, a *models.Agent, p *models.Plugin) error {
+	jobs, err := p.ActiveJobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	if jobs != 0 {
+		return ErrPluginInUse
+	}
+
+	agentCli, err := ssh.Acquire(ctx, a)
+	if err != nil {
+		return err
+	}
+	defer agentCli.Release()
+
+	used, err := usedVersions(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	if !used[p.Version] {
+		if err := removeBinary(agentCli, a, p.BinName(p.Version)); err != nil {
+			return err
+		}
+	}
+
+	return p.Delete(ctx)
+}
+
+// Update installs a new version of a plugin side by side with the installed ones and switches to it: the new
+// version gets pinned and the scheduled jobs of the plugin are resolved again. The previous versions are kept in the agent,
+// so the plugin can be rolled back. The new version has to be signed by one of the trusted keys of the plugin repo. If the
+// plugin has a manifest, it has to be the manifest of the new version. The new version can't be already installed in the
+// agent. Once updated, p is the new version
+func Update(ctx *context.Context, a *models.Agent, p *models.Plugin, version string, f, sig []byte) error {
+	if version == p.Version {
+		return ErrSameVersion
+	}
+
+	if err := checkSupported(p, a); err != nil {
+		return err
+	}
+
+	if p.Manifest != nil {
+		if err := checkCompatible(p.Manifest, version, a); err != nil {
+			return err
+		}
+	}
+
+	if err := Verify(ctx, p.Repo, p.Name, version, f, sig); err != nil {
+		return err
+	}
+
+	installed, err := models.PluginVersions(ctx, a.Host, p.Repo, p.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range installed {
+		if i.Version == version {
+			return ErrSameVersion
+		}
+	}
+
+	n := &models.Plugin{
+		AgentHost:   p.AgentHost,
+		Repo:        p.Repo,
+		Name:        p.Name,
+		Version:     version,
+		Arch:        p.Arch,
+		OS:          p.OS,
+		InstalledAt: time.Now(),
+		Manifest:    p.Manifest,
+	}
+
+	if err := installBinary(ctx, a, n.BinName(version), f); err != nil {
+		return err
+	}
+
+	if err := addInstalled(ctx, n, a); err != nil {
+		return err
+	}
+
+	if err := switchVersion(ctx, n); err != nil {
+		return err
+	}
+
+	*p = *n
+
+	return nil
+}
+
+// Rollback switches the plugin back to the version installed before p: the previous version gets pinned and the scheduled
+// jobs of the plugin are resolved again. The deprecated versions are skipped. Once rolled back, p is the previous version
+func Rollback(ctx *context.Context, p *models.Plugin) error {
+	versions, err := models.PluginVersions(ctx, p.AgentHost, p.Repo, p.Name)
+	if err != nil {
+		return err
+	}
+
+	var prev *models.Plugin
+	for _, v := range versions {
+		if v.ID == p.ID || v.Deprecated || !installedBefore(v, p) {
+			continue
+		}
+
+		if prev == nil || installedBefore(prev, v) {
+			prev = v
+		}
+	}
+
+	if prev == nil {
+		return ErrNoPreviousVersion
+	}
+
+	if err := switchVersion(ctx, prev); err != nil {
+		return err
+	}
+
+	*p = *prev
+
+	return nil
+}
+
+// switchVersion pins the version of the plugin and resolves again the scheduled jobs of the plugin
+func switchVersion(ctx *context.Context, p *models.Plugin) error {
+	if err := p.Pin(ctx); err != nil {
+		return err
+	}
+
+	if err := scheduler.UpdatePlugin(ctx, p.AgentHost, p.Repo, p.Name); err != nil {
+		log.Warnf("error updating the scheduled jobs of the plugin '%s' of the agent '%s': %v", p, p.AgentHost, err)
+	}
+
+	return nil
+}
+
+// installedBefore returns whether the version a of a plugin has been installed before the version b
+func installedBefore(a, b *models.Plugin) bool {
+	if a.InstalledAt.Equal(b.InstalledAt) {
+		return a.ID < b.ID
+	}
+
+	return a.InstalledAt.Before(b.InstalledAt)
+}
+
+// checkSupported checks that the agent arch and OS are supported by the plugin
+func checkSupported(p *models.Plugin, a *models.Agent) error {
 	if len(p.Arch) != 0 {
 		found := false
 		for _, arch := range p.Arch {
@@ -43,33 +314,59 @@ func Install(ctx *context.Context, p *models.Plugin, a *models.Agent, f []byte)
 		}
 	}
 
-	u, err := user.Current()
+	return nil
+}
+
+// usedVersions returns the versions whose binaries are used by the rest of the installed versions of the plugin in the agent
+func usedVersions(ctx *context.Context, p *models.Plugin) (map[string]bool, error) {
+	installed, err := models.PluginVersions(ctx, p.AgentHost, p.Repo, p.Name)
 	if err != nil {
-		return fmt.Errorf("error getting the current user: %v", err)
+		return nil, err
 	}
 
-	coreCli := &client.Local{}
-	coreOS, err := os.DetectOS(coreCli)
-	if err != nil {
-		return err
+	used := map[string]bool{}
+	for _, i := range installed {
+		if i.ID == p.ID {
+			continue
+		}
+
+		used[i.Version] = true
 	}
 
-	keysPath, err := coreOS.CmdSSHGetKeysPath(coreCli, u.Username)
+	return used, nil
+}
+
+// removeBinary removes a binary installed in the agent
+func removeBinary(c client.Client, a *models.Agent, name string) error {
+	home, err := a.OS.CmdFSHome(c, a.SSHUser)
 	if err != nil {
-		return err
+		return fmt.Errorf("error removing the plugin binary: %v", err)
 	}
 
-	keys := strings.Split(a.SSHHostKeys, "|||")
-	s, err := ssh.NewSessionWithKey(ctx.FS, a.Host, a.SSHPort, a.SSHUser, keysPath, keys)
+	bin := filepath.Join(home, ".bin", name)
+
+	exists, err := c.Exists(bin)
 	if err != nil {
-		return fmt.Errorf("error opening the ssh session with the agent: %v", err)
+		return fmt.Errorf("error removing the plugin binary: %v", err)
 	}
-	defer s.Close()
-	agentCli := &client.SSH{Session: s}
 
-	if err := a.OS.CmdPkgInstallBinary(agentCli, a.SSHUser, fmt.Sprintf("drlm-plugin-%s-%s-%s", p.Repo, p.Name, p.Version), f); err != nil {
-		return err
+	if !exists {
+		return nil
+	}
+
+	if err := c.Remove(bin); err != nil {
+		return fmt.Errorf("error removing the plugin binary: %v", err)
 	}
 
 	return nil
 }
+
+// parse parses a plugin with the `repo/name` format
+func parse(plugin string) (string, string, error) {
+	parts := strings.SplitN(plugin, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrInvalidPlugin
+	}
+
+	return parts[0], parts[1], nil
+}
@@ -3,24 +3,37 @@
 package plugin_test
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/models"
 	"github.com/brainupdaters/drlm-core/plugin"
 	"github.com/brainupdaters/drlm-core/utils/tests"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/suite"
 )
 
 type TestPluginSuite struct {
 	suite.Suite
-	ctx *context.Context
+	ctx  *context.Context
+	mock sqlmock.Sqlmock
 }
 
 func (s *TestPluginSuite) SetupTest() {
 	s.ctx = tests.GenerateCtx()
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+}
+
+func (s *TestPluginSuite) AfterTest() {
+	s.NoError(s.mock.ExpectationsWereMet())
 }
 
 func TestPlugin(t *testing.T) {
@@ -36,13 +49,151 @@ func (s *TestPluginSuite) TestInstall() {
 		p := &models.Plugin{Arch: []os.Arch{os.ArchAmd64}}
 		a := &models.Agent{Arch: os.Arch(999)}
 
-		plugin.Install(s.ctx, p, a, []byte("plugin"))
+		s.EqualError(plugin.Install(s.ctx, p, a, []byte("plugin"), nil), "unsupported arch")
 	})
 
 	s.Run("should fail if the OS is unsupported", func() {
 		p := &models.Plugin{OS: []os.OS{os.Linux}}
 		a := &models.Agent{OS: os.OS(999)}
 
-		plugin.Install(s.ctx, p, a, []byte("plugin"))
+		s.EqualError(plugin.Install(s.ctx, p, a, []byte("plugin"), nil), "unsupported os")
+	})
+
+	s.Run("should fail if the plugin isn't signed", func() {
+		p := &models.Plugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+
+		s.Equal(plugin.ErrUnsignedPlugin, plugin.Install(s.ctx, p, &models.Agent{}, []byte("plugin"), nil))
+	})
+}
+
+func (s *TestPluginSuite) TestLoad() {
+	s.Run("should load the plugin of the agent", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1 AND repo = $2 AND name = $3)) ORDER BY "id"`)).WithArgs("laptop", "default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version", "agent_host"}).AddRow(1, "default", "tar", "v1.0.0", "laptop"))
+
+		p, err := plugin.Load(s.ctx, &models.Agent{Host: "laptop"}, "default/tar")
+		s.NoError(err)
+		s.Equal("v1.0.0", p.Version)
+	})
+
+	s.Run("should load the version of the plugin that matches the constraint", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version", "agent_host", "deprecated"}).
+			AddRow(1, "default", "tar", "v1.0.0", "laptop", true).
+			AddRow(2, "default", "tar", "v2.0.0", "laptop", false),
+		)
+
+		p, err := plugin.Load(s.ctx, &models.Agent{Host: "laptop"}, "default/tar@v1.0.0")
+		s.NoError(err)
+		s.Equal(uint(1), p.ID)
+	})
+
+	s.Run("should return a not found error if the version isn't installed", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "laptop"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version", "agent_host"}).AddRow(1, "default", "tar", "v1.0.0", "laptop"))
+
+		p, err := plugin.Load(s.ctx, &models.Agent{Host: "laptop"}, "default/tar@^2")
+		s.True(gorm.IsRecordNotFoundError(err))
+		s.Nil(p)
+	})
+
+	s.Run("should return an error if the plugin is invalid", func() {
+		p, err := plugin.Load(s.ctx, &models.Agent{Host: "laptop"}, "tar")
+		s.Equal(plugin.ErrInvalidPlugin, err)
+		s.Nil(p)
+	})
+
+	s.Run("should return a not found error if the agent isn't found", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnError(gorm.ErrRecordNotFound)
+
+		p, err := plugin.Load(s.ctx, &models.Agent{Host: "laptop"}, "default/tar")
+		s.True(gorm.IsRecordNotFoundError(err))
+		s.Nil(p)
+	})
+}
+
+func (s *TestPluginSuite) TestRemove() {
+	s.Run("should refuse to remove the plugin if it has scheduled or running jobs", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "jobs"`)).WithArgs(1, models.JobStatusScheduled, models.JobStatusRunning).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		p := &models.Plugin{Model: gorm.Model{ID: 1}, Repo: "default", Name: "tar", Version: "v1.0.0"}
+		s.Equal(plugin.ErrPluginInUse, plugin.Remove(s.ctx, &models.Agent{Host: "laptop"}, p))
+	})
+}
+
+func (s *TestPluginSuite) TestUpdate() {
+	versions := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "agent_host", "repo", "name", "version"}).
+			AddRow(1, "laptop", "default", "tar", "v1.0.0").
+			AddRow(2, "laptop", "default", "tar", "v1.1.0")
+	}
+
+	s.Run("should return an error if the version is the current one", func() {
+		p := &models.Plugin{Repo: "default", Name: "tar", Version: "v1.0.0"}
+		s.Equal(plugin.ErrSameVersion, plugin.Update(s.ctx, &models.Agent{Host: "laptop"}, p, "v1.0.0", []byte("plugin"), nil))
+	})
+
+	s.Run("should return an error if the version is already installed", func() {
+		tests.GenerateCfg(s.T(), s.ctx)
+
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		s.Require().NoError(err)
+
+		s.ctx.Cfg.Plugins.TrustedKeys = map[string][]string{
+			"default": {base64.StdEncoding.EncodeToString(pub)},
+		}
+		defer func() { s.ctx.Cfg.Plugins.TrustedKeys = map[string][]string{} }()
+
+		f := []byte("plugin")
+		sig := ed25519.Sign(priv, plugin.SignedManifest("default", "tar", "v1.0.0", plugin.Checksum(f)))
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(versions())
+
+		p := &models.Plugin{Model: gorm.Model{ID: 2}, AgentHost: "laptop", Repo: "default", Name: "tar", Version: "v1.1.0"}
+		s.Equal(plugin.ErrSameVersion, plugin.Update(s.ctx, &models.Agent{Host: "laptop"}, p, "v1.0.0", f, sig))
+	})
+
+	s.Run("should fail if the arch is unsupported", func() {
+		p := &models.Plugin{AgentHost: "laptop", Repo: "default", Name: "tar", Version: "v1.0.0", Arch: []os.Arch{os.ArchAmd64}}
+		s.EqualError(plugin.Update(s.ctx, &models.Agent{Host: "laptop", Arch: os.Arch(999)}, p, "v1.2.0", []byte("plugin"), nil), "unsupported arch")
+	})
+
+	s.Run("should fail if the new version isn't signed by a trusted key", func() {
+		tests.GenerateCfg(s.T(), s.ctx)
+
+		p := &models.Plugin{AgentHost: "laptop", Repo: "default", Name: "tar", Version: "v1.0.0"}
+		s.Equal(plugin.ErrUntrustedPlugin, plugin.Update(s.ctx, &models.Agent{Host: "laptop"}, p, "v1.2.0", []byte("plugin"), []byte("signature")))
+	})
+}
+
+func (s *TestPluginSuite) TestRollback() {
+	s.Run("should pin the version installed before the current one", func() {
+		now := time.Now()
+		rows := func() *sqlmock.Rows {
+			return sqlmock.NewRows([]string{"id", "agent_host", "repo", "name", "version", "installed_at", "deprecated"}).
+				AddRow(1, "laptop", "default", "tar", "v1.0.0", now.Add(-2*time.Hour), false).
+				AddRow(2, "laptop", "default", "tar", "v1.0.1", now.Add(-time.Hour), true).
+				AddRow(3, "laptop", "default", "tar", "v0.9.0", now.Add(-3*time.Hour), false).
+				AddRow(4, "laptop", "default", "tar", "v1.1.0", now, false)
+		}
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(rows())
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugins" SET "pinned" = $1 WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $2 AND repo = $3 AND name = $4 AND id <> $5))`)).WithArgs(false, "laptop", "default", "tar", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "plugins" SET "pinned" = $1 WHERE "plugins"."deleted_at" IS NULL AND "plugins"."id" = $2`)).WithArgs(true, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		s.mock.ExpectCommit()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(rows())
+
+		p := &models.Plugin{Model: gorm.Model{ID: 4}, AgentHost: "laptop", Repo: "default", Name: "tar", Version: "v1.1.0", InstalledAt: now}
+		s.NoError(plugin.Rollback(s.ctx, p))
+		s.Equal("v1.0.0", p.Version)
+		s.True(p.Pinned)
+	})
+
+	s.Run("should return an error if there's no version installed before the current one", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "repo", "name", "version"}).AddRow(1, "laptop", "default", "tar", "v1.0.0"))
+
+		p := &models.Plugin{Model: gorm.Model{ID: 1}, AgentHost: "laptop", Repo: "default", Name: "tar", Version: "v1.0.0"}
+		s.Equal(plugin.ErrNoPreviousVersion, plugin.Rollback(s.ctx, p))
 	})
 }
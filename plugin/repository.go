@@ -0,0 +1,392 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/afero"
+)
+
+var (
+	// ErrUnknownRepository gets returned when the plugin repository isn't configured
+	ErrUnknownRepository = errors.New("unknown plugin repository")
+	// ErrRepositoryPluginNotFound gets returned when the plugin or the version aren't in the repository index
+	ErrRepositoryPluginNotFound = errors.New("plugin not found in the repository")
+	// ErrNoRepositoryBinary gets returned when the repository plugin has no binary for the arch and the OS of the agent
+	ErrNoRepositoryBinary = errors.New("the repository plugin has no binary for the agent arch and OS")
+)
+
+// RepositoryIndex is the index of a plugin repository. It's a JSON or TOML file that lists the plugins of the repository,
+// their versions and the URLs of their artifacts. The relative URLs are relative to the index URL:
+//
+//	repo = "default"
+//
+//	[[plugins]]
+//	name = "tar"
+//	description = "Backups using tar"
+//
+//	  [[plugins.versions]]
+//	  version = "v1.0.0"
+//	  manifest = "tar/v1.0.0/manifest.toml"
+//
+//	    [[plugins.versions.binaries]]
+//	    target = "linux/amd64"
+//	    url = "tar/v1.0.0/linux-amd64"
+//	    sha256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+//	    signature = "Xn2b...=="
+type RepositoryIndex struct {
+	Repo    string              `json:"repo" toml:"repo"`
+	Plugins []*RepositoryPlugin `json:"plugins" toml:"plugins"`
+}
+
+// RepositoryPlugin is a plugin of a repository index
+type RepositoryPlugin struct {
+	Name        string                     `json:"name" toml:"name"`
+	Description string                     `json:"description" toml:"description"`
+	Versions    []*RepositoryPluginVersion `json:"versions" toml:"versions"`
+}
+
+// RepositoryPluginVersion is a version of a plugin of a repository index
+type RepositoryPluginVersion struct {
+	Version  string              `json:"version" toml:"version"`
+	Manifest string              `json:"manifest" toml:"manifest"` // The URL of the TOML manifest of the version. It's optional
+	Binaries []*RepositoryBinary `json:"binaries" toml:"binaries"`
+}
+
+// RepositoryBinary is a binary of a plugin version of a repository index
+type RepositoryBinary struct {
+	Target    string `json:"target" toml:"target"` // The `os/arch` target of the binary (e.g. `linux/amd64`). `any` means any OS or arch
+	URL       string `json:"url" toml:"url"`
+	SHA256    string `json:"sha256" toml:"sha256"`
+	Signature string `json:"signature" toml:"signature"` // The ed25519 signature of the binary (base64 encoded)
+}
+
+// RepositorySearchResult is a plugin found when searching the repositories
+type RepositorySearchResult struct {
+	Repo        string
+	Name        string
+	Description string
+	Versions    []string
+}
+
+// Repositories returns the names of the configured plugin repositories, sorted
+func Repositories(ctx *context.Context) []string {
+	repos := []string{}
+	for r := range ctx.Cfg.Plugins.Repositories {
+		repos = append(repos, r)
+	}
+	sort.Strings(repos)
+
+	return repos
+}
+
+// RefreshRepository downloads the index of a plugin repository, validates it and stores it in the cache
+func RefreshRepository(ctx *context.Context, repo string) (*RepositoryIndex, error) {
+	u, ok := ctx.Cfg.Plugins.Repositories[repo]
+	if !ok {
+		return nil, ErrUnknownRepository
+	}
+
+	b, err := fetch(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading the plugin repository index: %v", err)
+	}
+
+	idx, err := parseIndex(repo, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.FS.MkdirAll(ctx.Cfg.Plugins.RepositoriesCachePath, 0755); err != nil {
+		return nil, fmt.Errorf("error caching the plugin repository index: %v", err)
+	}
+
+	if err := afero.WriteFile(ctx.FS, indexCachePath(ctx, repo), b, 0644); err != nil {
+		return nil, fmt.Errorf("error caching the plugin repository index: %v", err)
+	}
+
+	return idx, nil
+}
+
+// LoadRepository returns the cached index of a plugin repository. If the index isn't cached yet, it gets refreshed
+func LoadRepository(ctx *context.Context, repo string) (*RepositoryIndex, error) {
+	if _, ok := ctx.Cfg.Plugins.Repositories[repo]; !ok {
+		return nil, ErrUnknownRepository
+	}
+
+	exists, err := afero.Exists(ctx.FS, indexCachePath(ctx, repo))
+	if err != nil {
+		return nil, fmt.Errorf("error loading the cached plugin repository index: %v", err)
+	}
+
+	if !exists {
+		return RefreshRepository(ctx, repo)
+	}
+
+	b, err := afero.ReadFile(ctx.FS, indexCachePath(ctx, repo))
+	if err != nil {
+		return nil, fmt.Errorf("error loading the cached plugin repository index: %v", err)
+	}
+
+	return parseIndex(repo, b)
+}
+
+// SearchRepositories searches the plugins of all the repositories whose name or description contain the query. The
+// search is case insensitive and uses the cached indexes. An empty query returns all the plugins
+func SearchRepositories(ctx *context.Context, query string) ([]*RepositorySearchResult, error) {
+	query = strings.ToLower(query)
+	results := []*RepositorySearchResult{}
+
+	for _, r := range Repositories(ctx) {
+		idx, err := LoadRepository(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("error searching the plugin repository '%s': %v", r, err)
+		}
+
+		for _, p := range idx.Plugins {
+			if !strings.Contains(strings.ToLower(p.Name), query) && !strings.Contains(strings.ToLower(p.Description), query) {
+				continue
+			}
+
+			rslt := &RepositorySearchResult{Repo: idx.Repo, Name: p.Name, Description: p.Description}
+			for _, v := range p.Versions {
+				rslt.Versions = append(rslt.Versions, v.Version)
+			}
+
+			results = append(results, rslt)
+		}
+	}
+
+	return results, nil
+}
+
+// InstallFromRepository installs a plugin (`repo/name` or `repo/name@constraint`) of a repository in an agent. The highest
+// version that matches the constraint is installed side by side with the other installed versions of the plugin, using
+// the binary for the agent arch and OS. The binary checksum and signature are verified after downloading it. It returns
+// the installed version
+func InstallFromRepository(ctx *context.Context, a *models.Agent, plugin string) (string, error) {
+	ref, constraint := models.ParsePluginRef(plugin)
+
+	repo, name, err := parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if err := a.Load(ctx); err != nil {
+		return "", err
+	}
+
+	idx, err := LoadRepository(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+
+	v, err := idx.resolve(name, constraint)
+	if err != nil {
+		return "", err
+	}
+
+	base := ctx.Cfg.Plugins.Repositories[repo]
+
+	c := &models.CatalogPlugin{Repo: repo, Name: name, Version: v.Version}
+	for _, b := range v.Binaries {
+		arch, pOS, err := ParseBinaryTarget(b.Target)
+		if err != nil {
+			return "", err
+		}
+
+		u, err := resolveURL(base, b.URL)
+		if err != nil {
+			return "", err
+		}
+
+		c.Binaries = append(c.Binaries, &models.CatalogPluginBinary{Arch: arch, OS: pOS, Key: u, SHA256: b.SHA256, Signature: b.Signature})
+	}
+
+	bin := c.Binary(a.Arch, a.OS)
+	if bin == nil {
+		return "", ErrNoRepositoryBinary
+	}
+
+	f, err := fetch(ctx, bin.Key)
+	if err != nil {
+		return "", fmt.Errorf("error downloading the plugin binary: %v", err)
+	}
+
+	if Checksum(f) != strings.ToLower(bin.SHA256) {
+		return "", ErrChecksumMismatch
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(bin.Signature)
+	if err != nil {
+		return "", fmt.Errorf("error decoding the plugin signature: %v", err)
+	}
+
+	var m *models.PluginManifest
+	if v.Manifest != "" {
+		u, err := resolveURL(base, v.Manifest)
+		if err != nil {
+			return "", err
+		}
+
+		b, err := fetch(ctx, u)
+		if err != nil {
+			return "", fmt.Errorf("error downloading the plugin manifest: %v", err)
+		}
+
+		if m, err = ParseManifest(b); err != nil {
+			return "", err
+		}
+
+		if m.Repo != repo || m.Name != name || m.Version != v.Version {
+			return "", fmt.Errorf("the manifest is for the plugin '%s/%s@%s'", m.Repo, m.Name, m.Version)
+		}
+	}
+
+	if err := installVersion(ctx, a, repo, name, v.Version, m, bin, f, sig); err != nil {
+		return "", err
+	}
+
+	return v.Version, nil
+}
+
+// resolve returns the highest version of the plugin of the index that matches the constraint
+func (idx *RepositoryIndex) resolve(name, constraint string) (*RepositoryPluginVersion, error) {
+	for _, p := range idx.Plugins {
+		if p.Name != name {
+			continue
+		}
+
+		// The index versions are resolved the same way as the installed ones. The ID is the position of the version
+		versions := []*models.Plugin{}
+		for i, v := range p.Versions {
+			versions = append(versions, &models.Plugin{Model: gorm.Model{ID: uint(i)}, Version: v.Version})
+		}
+
+		rslt, err := models.ResolvePluginVersion(versions, constraint, true)
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return nil, ErrRepositoryPluginNotFound
+			}
+
+			return nil, err
+		}
+
+		return p.Versions[rslt.ID], nil
+	}
+
+	return nil, ErrRepositoryPluginNotFound
+}
+
+// parseIndex parses and validates a JSON or a TOML repository index
+func parseIndex(repo string, b []byte) (*RepositoryIndex, error) {
+	idx := &RepositoryIndex{}
+
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		if err := json.Unmarshal(b, idx); err != nil {
+			return nil, fmt.Errorf("error parsing the plugin repository index: %v", err)
+		}
+	} else {
+		if err := toml.Unmarshal(b, idx); err != nil {
+			return nil, fmt.Errorf("error parsing the plugin repository index: %v", err)
+		}
+	}
+
+	if idx.Repo != repo {
+		return nil, fmt.Errorf("invalid plugin repository index: the index is for the repo '%s' instead of '%s'", idx.Repo, repo)
+	}
+
+	for _, p := range idx.Plugins {
+		if p.Name == "" {
+			return nil, errors.New("invalid plugin repository index: the plugins require a name")
+		}
+
+		for _, v := range p.Versions {
+			if v.Version == "" {
+				return nil, fmt.Errorf("invalid plugin repository index: the '%s' versions require a version", p.Name)
+			}
+
+			for _, bin := range v.Binaries {
+				if _, _, err := ParseBinaryTarget(bin.Target); err != nil {
+					return nil, fmt.Errorf("invalid plugin repository index: %v", err)
+				}
+
+				if bin.URL == "" || bin.SHA256 == "" {
+					return nil, fmt.Errorf("invalid plugin repository index: the '%s@%s' binaries require an URL and a checksum", p.Name, v.Version)
+				}
+			}
+		}
+	}
+
+	return idx, nil
+}
+
+// indexCachePath returns the path where the index of the repository is cached
+func indexCachePath(ctx *context.Context, repo string) string {
+	return filepath.Join(ctx.Cfg.Plugins.RepositoriesCachePath, repo+".index")
+}
+
+// resolveURL resolves an URL of the repository index, that can be relative to the index URL
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid plugin repository URL '%s': %v", base, err)
+	}
+
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid plugin repository URL '%s': %v", ref, err)
+	}
+
+	return b.ResolveReference(r).String(), nil
+}
+
+// fetch downloads a file over HTTP(S) or reads it from the filesystem if it's a `file://` URL
+func fetch(ctx *context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s': %v", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return afero.ReadFile(ctx.FS, u.Path)
+
+	case "http", "https":
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		rsp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status '%s'", rsp.Status)
+		}
+
+		return ioutil.ReadAll(rsp.Body)
+
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme '%s'", u.Scheme)
+	}
+}
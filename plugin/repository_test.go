@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/plugin"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/spf13/afero"
+)
+
+const testIndexJSON = `{
+  "repo": "default",
+  "plugins": [
+    {
+      "name": "tar",
+      "description": "Backups using tar",
+      "versions": [
+        {"version": "v1.0.0", "binaries": [{"target": "linux/amd64", "url": "tar/v1.0.0/linux-amd64", "sha256": "abcd"}]}
+      ]
+    }
+  ]
+}`
+
+// testIndexTOML returns a TOML index with two versions of the plugin. Only the binary of the v1.2.0 version exists
+func testIndexTOML(sum, sig string) string {
+	return fmt.Sprintf(`
+repo = "default"
+
+[[plugins]]
+name = "tar"
+description = "Backups using tar"
+
+  [[plugins.versions]]
+  version = "v1.0.0"
+
+    [[plugins.versions.binaries]]
+    target = "linux/any"
+    url = "tar/v1.0.0/linux-any"
+    sha256 = "%[1]s"
+    signature = "%[2]s"
+
+  [[plugins.versions]]
+  version = "v1.2.0"
+
+    [[plugins.versions.binaries]]
+    target = "linux/any"
+    url = "tar/v1.2.0/linux-any"
+    sha256 = "%[1]s"
+    signature = "%[2]s"
+
+[[plugins]]
+name = "rsync"
+description = "Incremental backups"
+`, sum, sig)
+}
+
+func (s *TestPluginSuite) TestRefreshRepository() {
+	tests.GenerateCfg(s.T(), s.ctx)
+
+	s.Run("should download the index over HTTP and cache it", func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.Equal("/index.json", r.URL.Path)
+			w.Write([]byte(testIndexJSON))
+		}))
+		defer ts.Close()
+
+		s.ctx.Cfg.Plugins.Repositories = map[string]string{"default": ts.URL + "/index.json"}
+
+		idx, err := plugin.RefreshRepository(s.ctx, "default")
+		s.Require().NoError(err)
+		s.Equal("tar", idx.Plugins[0].Name)
+		s.Equal("v1.0.0", idx.Plugins[0].Versions[0].Version)
+
+		b, err := afero.ReadFile(s.ctx.FS, "plugins/repositories/default.index")
+		s.NoError(err)
+		s.Equal(testIndexJSON, string(b))
+	})
+
+	s.Run("should read the index from the filesystem", func() {
+		s.Require().NoError(afero.WriteFile(s.ctx.FS, "/repo/index.toml", []byte(testIndexTOML("abcd", "")), 0644))
+		s.ctx.Cfg.Plugins.Repositories = map[string]string{"default": "file:///repo/index.toml"}
+
+		idx, err := plugin.RefreshRepository(s.ctx, "default")
+		s.Require().NoError(err)
+		s.Len(idx.Plugins, 2)
+		s.Len(idx.Plugins[0].Versions, 2)
+	})
+
+	s.Run("should return an error if the repository isn't configured", func() {
+		s.ctx.Cfg.Plugins.Repositories = map[string]string{}
+
+		idx, err := plugin.RefreshRepository(s.ctx, "default")
+		s.Equal(plugin.ErrUnknownRepository, err)
+		s.Nil(idx)
+	})
+
+	s.Run("should return an error if the index can't be downloaded", func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer ts.Close()
+
+		s.ctx.Cfg.Plugins.Repositories = map[string]string{"default": ts.URL + "/index.json"}
+
+		idx, err := plugin.RefreshRepository(s.ctx, "default")
+		s.EqualError(err, "error downloading the plugin repository index: unexpected status '404 Not Found'")
+		s.Nil(idx)
+	})
+
+	for _, tc := range []struct {
+		name  string
+		index string
+		err   string
+	}{
+		{"is for another repo", `{"repo": "other"}`, "invalid plugin repository index: the index is for the repo 'other' instead of 'default'"},
+		{"has a binary without checksum", `{"repo": "default", "plugins": [{"name": "tar", "versions": [{"version": "v1.0.0", "binaries": [{"target": "linux/amd64", "url": "tar"}]}]}]}`, "invalid plugin repository index: the 'tar@v1.0.0' binaries require an URL and a checksum"},
+		{"has an invalid binary target", `{"repo": "default", "plugins": [{"name": "tar", "versions": [{"version": "v1.0.0", "binaries": [{"target": "linux", "url": "tar", "sha256": "abcd"}]}]}]}`, "invalid plugin repository index: invalid plugin binary target 'linux': it has to be 'os/arch'"},
+	} {
+		s.Run("should return an error if the index "+tc.name, func() {
+			s.Require().NoError(afero.WriteFile(s.ctx.FS, "/repo/index.json", []byte(tc.index), 0644))
+			s.ctx.Cfg.Plugins.Repositories = map[string]string{"default": "file:///repo/index.json"}
+
+			idx, err := plugin.RefreshRepository(s.ctx, "default")
+			s.EqualError(err, tc.err)
+			s.Nil(idx)
+		})
+	}
+}
+
+func (s *TestPluginSuite) TestLoadRepository() {
+	tests.GenerateCfg(s.T(), s.ctx)
+
+	s.Run("should use the cached index once it has been downloaded", func() {
+		requests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Write([]byte(testIndexJSON))
+		}))
+
+		s.ctx.Cfg.Plugins.Repositories = map[string]string{"default": ts.URL + "/index.json"}
+
+		idx, err := plugin.LoadRepository(s.ctx, "default")
+		s.Require().NoError(err)
+		s.Len(idx.Plugins, 1)
+
+		// The repository is offline now
+		ts.Close()
+
+		idx, err = plugin.LoadRepository(s.ctx, "default")
+		s.Require().NoError(err)
+		s.Len(idx.Plugins, 1)
+		s.Equal(1, requests)
+	})
+}
+
+func (s *TestPluginSuite) TestSearchRepositories() {
+	tests.GenerateCfg(s.T(), s.ctx)
+	s.Require().NoError(afero.WriteFile(s.ctx.FS, "/repo/index.toml", []byte(testIndexTOML("abcd", "")), 0644))
+	s.ctx.Cfg.Plugins.Repositories = map[string]string{"default": "file:///repo/index.toml"}
+
+	s.Run("should return the plugins whose name or description match the query", func() {
+		results, err := plugin.SearchRepositories(s.ctx, "INCREMENTAL")
+		s.NoError(err)
+		s.Equal([]*plugin.RepositorySearchResult{{Repo: "default", Name: "rsync", Description: "Incremental backups"}}, results)
+	})
+
+	s.Run("should return all the plugins if there's no query", func() {
+		results, err := plugin.SearchRepositories(s.ctx, "")
+		s.NoError(err)
+		s.Len(results, 2)
+		s.Equal([]string{"v1.0.0", "v1.2.0"}, results[0].Versions)
+	})
+}
+
+func (s *TestPluginSuite) TestInstallFromRepository() {
+	tests.GenerateCfg(s.T(), s.ctx)
+
+	f := []byte("plugin")
+	s.Require().NoError(afero.WriteFile(s.ctx.FS, "/repo/tar/v1.2.0/linux-any", f, 0644))
+	s.ctx.Cfg.Plugins.Repositories = map[string]string{"default": "file:///repo/index.toml"}
+
+	expectAgent := func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "arch", "os"}).AddRow(1, "laptop", os.ArchAmd64, os.Linux))
+	}
+
+	writeIndex := func(index string) {
+		s.Require().NoError(afero.WriteFile(s.ctx.FS, "/repo/index.toml", []byte(index), 0644))
+		s.Require().NoError(s.ctx.FS.RemoveAll("plugins/repositories"))
+	}
+
+	s.Run("should download the highest version that matches the constraint and verify its signature", func() {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		s.Require().NoError(err)
+
+		sig := ed25519.Sign(priv, plugin.SignedManifest("default", "tar", "v1.2.0", plugin.Checksum(f)))
+		writeIndex(testIndexTOML(plugin.Checksum(f), base64.StdEncoding.EncodeToString(sig)))
+
+		expectAgent()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WithArgs("laptop", "default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version"}))
+
+		// The key that signed the binary isn't trusted
+		v, err := plugin.InstallFromRepository(s.ctx, &models.Agent{Host: "laptop"}, "default/tar@^1")
+		s.Equal(plugin.ErrUntrustedPlugin, err)
+		s.Equal("", v)
+	})
+
+	s.Run("should return an error if the binary checksum doesn't match the index", func() {
+		writeIndex(testIndexTOML(plugin.Checksum([]byte("another plugin")), ""))
+
+		expectAgent()
+
+		v, err := plugin.InstallFromRepository(s.ctx, &models.Agent{Host: "laptop"}, "default/tar@v1.2.0")
+		s.Equal(plugin.ErrChecksumMismatch, err)
+		s.Equal("", v)
+	})
+
+	s.Run("should return an error if no version matches the constraint", func() {
+		writeIndex(testIndexTOML(plugin.Checksum(f), ""))
+
+		expectAgent()
+
+		v, err := plugin.InstallFromRepository(s.ctx, &models.Agent{Host: "laptop"}, "default/tar@^2")
+		s.Equal(plugin.ErrRepositoryPluginNotFound, err)
+		s.Equal("", v)
+	})
+
+	s.Run("should return an error if there's no binary for the agent", func() {
+		writeIndex(testIndexTOML(plugin.Checksum(f), ""))
+
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents"`)).WithArgs("laptop").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "arch", "os"}).AddRow(1, "laptop", os.ArchAmd64, os.Darwin))
+
+		v, err := plugin.InstallFromRepository(s.ctx, &models.Agent{Host: "laptop"}, "default/tar")
+		s.Equal(plugin.ErrNoRepositoryBinary, err)
+		s.Equal("", v)
+	})
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+)
+
+var (
+	// ErrUnsignedPlugin gets returned when installing or uploading a plugin that has no signature
+	ErrUnsignedPlugin = errors.New("the plugin isn't signed")
+	// ErrUntrustedPlugin gets returned when the plugin signature isn't valid for any of the trusted keys of its repo
+	ErrUntrustedPlugin = errors.New("the plugin signature isn't valid for any of the trusted keys of the repo")
+)
+
+// SignedManifest returns the manifest that the plugin publishers sign with their ed25519 key. It contains the plugin
+// and the SHA-256 checksum of its binary
+func SignedManifest(repo, name, version, sum string) []byte {
+	return []byte(fmt.Sprintf("repo = %q\nname = %q\nversion = %q\nsha256 = %q\n", repo, name, version, sum))
+}
+
+// Checksum returns the SHA-256 checksum of a plugin binary, hex encoded
+func Checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that the signature of the plugin binary is valid for one of the trusted keys of the plugin repo
+func Verify(ctx *context.Context, repo, name, version string, f, sig []byte) error {
+	if len(sig) == 0 {
+		return ErrUnsignedPlugin
+	}
+
+	manifest := SignedManifest(repo, name, version, Checksum(f))
+
+	for _, k := range ctx.Cfg.Plugins.TrustedKeys[repo] {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid trusted key of the plugin repo '%s'", repo)
+		}
+
+		if ed25519.Verify(ed25519.PublicKey(key), manifest, sig) {
+			return nil
+		}
+	}
+
+	return ErrUntrustedPlugin
+}
+
+// verifyInstalled checks that the checksum of the binary installed in the agent matches the expected one. If it doesn't,
+// the binary gets removed from the agent
+func verifyInstalled(c client.Client, a *models.Agent, name, sum string) error {
+	home, err := a.OS.CmdFSHome(c, a.SSHUser)
+	if err != nil {
+		return fmt.Errorf("error verifying the plugin binary: %v", err)
+	}
+
+	bin := filepath.Join(home, ".bin", name)
+
+	out, err := c.Exec("sha256sum", bin)
+	if err != nil {
+		return fmt.Errorf("error verifying the plugin binary: %v", err)
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || fields[0] != sum {
+		if err := c.Remove(bin); err != nil {
+			return fmt.Errorf("error removing the tampered plugin binary: %v", err)
+		}
+
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package plugin_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+
+	"github.com/brainupdaters/drlm-core/plugin"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+)
+
+func (s *TestPluginSuite) TestVerify() {
+	tests.GenerateCfg(s.T(), s.ctx)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	s.Require().NoError(err)
+
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	s.Require().NoError(err)
+
+	s.ctx.Cfg.Plugins.TrustedKeys = map[string][]string{
+		"default": {base64.StdEncoding.EncodeToString(pub)},
+	}
+
+	f := []byte("plugin")
+	sig := ed25519.Sign(priv, plugin.SignedManifest("default", "tar", "v1.0.0", plugin.Checksum(f)))
+
+	s.Run("should accept a plugin signed by a trusted key of the repo", func() {
+		s.NoError(plugin.Verify(s.ctx, "default", "tar", "v1.0.0", f, sig))
+	})
+
+	s.Run("should reject an unsigned plugin", func() {
+		s.Equal(plugin.ErrUnsignedPlugin, plugin.Verify(s.ctx, "default", "tar", "v1.0.0", f, nil))
+	})
+
+	s.Run("should reject a tampered plugin", func() {
+		s.Equal(plugin.ErrUntrustedPlugin, plugin.Verify(s.ctx, "default", "tar", "v1.0.0", []byte("tampered"), sig))
+	})
+
+	s.Run("should reject a signature for another version", func() {
+		s.Equal(plugin.ErrUntrustedPlugin, plugin.Verify(s.ctx, "default", "tar", "v1.1.0", f, sig))
+	})
+
+	s.Run("should reject a plugin signed by an untrusted key", func() {
+		otherSig := ed25519.Sign(otherPriv, plugin.SignedManifest("default", "tar", "v1.0.0", plugin.Checksum(f)))
+		s.Equal(plugin.ErrUntrustedPlugin, plugin.Verify(s.ctx, "default", "tar", "v1.0.0", f, otherSig))
+	})
+
+	s.Run("should reject a plugin of a repo without trusted keys", func() {
+		s.Equal(plugin.ErrUntrustedPlugin, plugin.Verify(s.ctx, "other", "tar", "v1.0.0", f, sig))
+	})
+
+	s.Run("should return an error if a trusted key is invalid", func() {
+		s.ctx.Cfg.Plugins.TrustedKeys["invalid"] = []string{"not a key"}
+		s.EqualError(plugin.Verify(s.ctx, "invalid", "tar", "v1.0.0", f, sig), "invalid trusted key of the plugin repo 'invalid'")
+	})
+}
+
+func (s *TestPluginSuite) TestSignedManifest() {
+	s.Equal(`repo = "default"
+name = "tar"
+version = "v1.0.0"
+sha256 = "1234"
+`, string(plugin.SignedManifest("default", "tar", "v1.0.0", "1234")))
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+)
+
+// JobConfigData is the data that the job config templates can reference (e.g. `{{ .Agent.Host }}`,
+// `{{ .Agent.Labels.env }}` or `{{ .Job.Bucket }}`). The templates get rendered right before starting the job, so the
+// same job config can be used for different agents. Only the configs of the jobs added as templates get rendered
+type JobConfigData struct {
+	Agent JobConfigAgent
+	Job   JobConfigJob
+}
+
+// JobConfigAgent are the facts of the agent of the job
+type JobConfigAgent struct {
+	Host          string
+	Version       string
+	Arch          string // The lowercase name of the arch (e.g. `amd64`)
+	OS            string // The lowercase name of the OS (e.g. `linux`)
+	OSVersion     string
+	Distro        string
+	DistroVersion string
+	Labels        map[string]string
+}
+
+// JobConfigJob are the details of the job
+type JobConfigJob struct {
+	ID     uint
+	Bucket string
+	Time   time.Time
+}
+
+// newJobConfigData returns the data of the job config templates. The labels of the agent have to be loaded
+func newJobConfigData(a *models.Agent, j *models.Job) *JobConfigData {
+	labels := map[string]string{}
+	for _, l := range a.Labels {
+		labels[l.Name] = l.Value
+	}
+
+	return &JobConfigData{
+		Agent: JobConfigAgent{
+			Host:          a.Host,
+			Version:       a.Version,
+			Arch:          strings.ToLower(strings.TrimPrefix(drlm.Arch(a.Arch).String(), "ARCH_")),
+			OS:            strings.ToLower(strings.TrimPrefix(drlm.OS(a.OS).String(), "OS_")),
+			OSVersion:     a.OSVersion,
+			Distro:        a.Distro,
+			DistroVersion: a.DistroVersion,
+			Labels:        labels,
+		},
+		Job: JobConfigJob{
+			ID:     j.ID,
+			Bucket: j.BucketName,
+			Time:   j.Time,
+		},
+	}
+}
+
+// renderJobConfig renders the job config template. Referencing labels that the agent doesn't have is an error
+func renderJobConfig(cfg string, data *JobConfigData) (string, error) {
+	tmpl, err := template.New("config").Option("missingkey=error").Parse(cfg)
+	if err != nil {
+		return "", fmt.Errorf("error parsing the job config template: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("error rendering the job config template: %v", err)
+	}
+
+	return b.String(), nil
+}
+
+// jobConfig returns the config of the job. If it's a template, it gets rendered using the current facts of its agent
+func jobConfig(ctx *context.Context, j *models.Job) (string, error) {
+	if !j.ConfigTemplate {
+		return j.Config, nil
+	}
+
+	a := &models.Agent{Host: j.AgentHost}
+	if err := a.Load(ctx); err != nil {
+		return "", err
+	}
+
+	if err := a.LoadLabels(ctx); err != nil {
+		return "", err
+	}
+
+	return renderJobConfig(j.Config, newJobConfigData(a, j))
+}
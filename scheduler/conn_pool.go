@@ -3,6 +3,7 @@
 package scheduler
 
 import (
+	"errors"
 	"sync"
 
 	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
@@ -10,33 +11,112 @@ import (
 
 var (
 	// AgentConnections are all the active agent connections
-	AgentConnections = connPool{v: map[string]drlm.DRLM_AgentConnectionServer{}}
+	AgentConnections = connPool{v: map[string]*agentConn{}}
 	// PendingAgentConnections are all the active connections from agents that havent been accepted yet
-	PendingAgentConnections = connPool{v: map[string]drlm.DRLM_AgentConnectionServer{}}
+	PendingAgentConnections = connPool{v: map[string]*agentConn{}}
 )
 
+// ErrConnNotFound gets returned when sending a message to an agent that has no connection in the pool
+var ErrConnNotFound = errors.New("agent connection not found")
+
+// agentConn is a connection of an agent. The gRPC streams can't send messages concurrently, so all the sends through the
+// connection have to hold its send mutex. The closed channel gets closed when the connection is removed from the pool
+type agentConn struct {
+	stream  drlm.DRLM_AgentConnectionServer
+	sendMux sync.Mutex
+	closed  chan struct{}
+}
+
+func newAgentConn(stream drlm.DRLM_AgentConnectionServer) *agentConn {
+	return &agentConn{stream: stream, closed: make(chan struct{})}
+}
+
 type connPool struct {
-	v   map[string]drlm.DRLM_AgentConnectionServer
+	v   map[string]*agentConn
 	mux sync.Mutex
 }
 
 func (c *connPool) Get(agent string) (stream drlm.DRLM_AgentConnectionServer, ok bool) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	stream, ok = c.v[agent]
-	return
+
+	cn, ok := c.v[agent]
+	if !ok {
+		return nil, false
+	}
+
+	return cn.stream, true
 }
 
 func (c *connPool) Add(agent string, stream drlm.DRLM_AgentConnectionServer) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
-	c.v[agent] = stream
+	c.v[agent] = newAgentConn(stream)
 }
 
+// Send sends a message through the connection of the agent. The sends through the same connection are serialized
+func (c *connPool) Send(agent string, msg *drlm.AgentConnectionFromCore) error {
+	c.mux.Lock()
+	cn, ok := c.v[agent]
+	c.mux.Unlock()
+
+	if !ok {
+		return ErrConnNotFound
+	}
+
+	cn.sendMux.Lock()
+	defer cn.sendMux.Unlock()
+
+	return cn.stream.Send(msg)
+}
+
+// Closed returns a channel that gets closed when the stream is removed from the pool (e.g. the agent gets quarantined or deleted),
+// so the stream can be ended. If the stream isn't in the pool, the channel is nil
+func (c *connPool) Closed(stream drlm.DRLM_AgentConnectionServer) <-chan struct{} {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for _, cn := range c.v {
+		if cn.stream == stream {
+			return cn.closed
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the connection of the agent from the pool and closes it
 func (c *connPool) Delete(agent string) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
-	delete(c.v, agent)
+	if cn, ok := c.v[agent]; ok {
+		delete(c.v, agent)
+		close(cn.closed)
+	}
+}
+
+// DeleteStream removes the stream from the pool, whatever the agent host it's stored with is (the agent host can change
+// while it's connected). If the agent has connected again with another stream, the new stream is kept
+func (c *connPool) DeleteStream(stream drlm.DRLM_AgentConnectionServer) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for agent, cn := range c.v {
+		if cn.stream == stream {
+			delete(c.v, agent)
+			close(cn.closed)
+		}
+	}
+}
+
+func (c *connPool) Rename(old, agent string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if cn, ok := c.v[old]; ok {
+		delete(c.v, old)
+		c.v[agent] = cn
+	}
 }
@@ -3,10 +3,15 @@
 package scheduler
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
 	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -22,8 +27,8 @@ func (s *TestConnPoolInternalSuite) TestGet() {
 	s.Run("should return true and the value if the connection is in the pool", func() {
 		conn := &tests.AgentConnectionServerMock{}
 
-		c := &connPool{v: map[string]drlm.DRLM_AgentConnectionServer{
-			"127.0.0.1": conn,
+		c := &connPool{v: map[string]*agentConn{
+			"127.0.0.1": newAgentConn(conn),
 		}}
 		poolConn, ok := c.Get("127.0.0.1")
 
@@ -32,7 +37,7 @@ func (s *TestConnPoolInternalSuite) TestGet() {
 	})
 
 	s.Run("should return false if the connection isn't in the pool", func() {
-		c := &connPool{v: map[string]drlm.DRLM_AgentConnectionServer{}}
+		c := &connPool{v: map[string]*agentConn{}}
 		_, ok := c.Get("127.0.0.1")
 
 		s.False(ok)
@@ -42,20 +47,153 @@ func (s *TestConnPoolInternalSuite) TestGet() {
 func (s *TestConnPoolInternalSuite) TestAdd() {
 	conn := &tests.AgentConnectionServerMock{}
 
-	c := &connPool{v: map[string]drlm.DRLM_AgentConnectionServer{}}
+	c := &connPool{v: map[string]*agentConn{}}
 	c.Add("127.0.0.1", conn)
 
-	s.Equal(conn, c.v["127.0.0.1"])
+	s.Equal(conn, c.v["127.0.0.1"].stream)
+}
+
+func (s *TestConnPoolInternalSuite) TestSend() {
+	s.Run("should send the message through the connection of the agent", func() {
+		msg := &drlm.AgentConnectionFromCore{MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOB_NEW}
+
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", msg).Return(nil)
+
+		c := &connPool{v: map[string]*agentConn{}}
+		c.Add("127.0.0.1", stream)
+
+		s.NoError(c.Send("127.0.0.1", msg))
+		stream.AssertExpectations(s.T())
+	})
+
+	s.Run("should serialize the sends through the same connection", func() {
+		msg := &drlm.AgentConnectionFromCore{MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOB_NEW}
+
+		var sending, concurrent int32
+		stream := &tests.AgentConnectionServerMock{}
+		stream.On("Send", msg).Run(func(mock.Arguments) {
+			if atomic.AddInt32(&sending, 1) > 1 {
+				atomic.StoreInt32(&concurrent, 1)
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&sending, -1)
+		}).Return(nil)
+
+		c := &connPool{v: map[string]*agentConn{}}
+		c.Add("127.0.0.1", stream)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.NoError(c.Send("127.0.0.1", msg))
+			}()
+		}
+		wg.Wait()
+
+		s.Zero(atomic.LoadInt32(&concurrent))
+	})
+
+	s.Run("should return an error if the connection isn't in the pool", func() {
+		c := &connPool{v: map[string]*agentConn{}}
+
+		s.Equal(ErrConnNotFound, c.Send("127.0.0.1", &drlm.AgentConnectionFromCore{}))
+	})
+}
+
+func (s *TestConnPoolInternalSuite) TestClosed() {
+	s.Run("should return the closed channel of the stream", func() {
+		conn := &tests.AgentConnectionServerMock{}
+
+		cn := newAgentConn(conn)
+		c := &connPool{v: map[string]*agentConn{
+			"laptop": cn,
+		}}
+
+		s.Equal((<-chan struct{})(cn.closed), c.Closed(conn))
+	})
+
+	s.Run("should return a nil channel if the stream isn't in the pool", func() {
+		c := &connPool{v: map[string]*agentConn{}}
+
+		s.Nil(c.Closed(&tests.AgentConnectionServerMock{}))
+	})
 }
 
 func (s *TestConnPoolInternalSuite) TestDelete() {
-	conn := &tests.AgentConnectionServerMock{}
+	s.Run("should remove and close the connection", func() {
+		conn := &tests.AgentConnectionServerMock{}
 
-	c := &connPool{v: map[string]drlm.DRLM_AgentConnectionServer{
-		"127.0.0.1": conn,
-	}}
-	c.Delete("127.0.0.1")
+		c := &connPool{v: map[string]*agentConn{
+			"127.0.0.1": newAgentConn(conn),
+		}}
+		closed := c.Closed(conn)
+		c.Delete("127.0.0.1")
+
+		_, ok := c.v["127.0.0.1"]
+		s.False(ok)
 
-	_, ok := c.v["127.0.0.1"]
-	s.False(ok)
+		select {
+		case <-closed:
+		default:
+			s.Fail("the connection hasn't been closed")
+		}
+	})
+
+	s.Run("should do nothing if the connection isn't in the pool", func() {
+		c := &connPool{v: map[string]*agentConn{}}
+		c.Delete("127.0.0.1")
+
+		s.Empty(c.v)
+	})
+}
+
+func (s *TestConnPoolInternalSuite) TestDeleteStream() {
+	s.Run("should remove the stream even if the agent host has changed", func() {
+		conn := &tests.AgentConnectionServerMock{}
+
+		c := &connPool{v: map[string]*agentConn{
+			"127.0.0.1": newAgentConn(conn),
+		}}
+		c.Rename("127.0.0.1", "laptop")
+		c.DeleteStream(conn)
+
+		s.Empty(c.v)
+	})
+
+	s.Run("should keep the new stream of the agent", func() {
+		old := &tests.AgentConnectionServerMock{}
+		conn := &tests.AgentConnectionServerMock{}
+
+		c := &connPool{v: map[string]*agentConn{
+			"laptop": newAgentConn(conn),
+		}}
+		c.DeleteStream(old)
+
+		s.Equal(conn, c.v["laptop"].stream)
+	})
+}
+
+func (s *TestConnPoolInternalSuite) TestRename() {
+	s.Run("should move the connection to the new agent host", func() {
+		conn := &tests.AgentConnectionServerMock{}
+
+		c := &connPool{v: map[string]*agentConn{
+			"127.0.0.1": newAgentConn(conn),
+		}}
+		c.Rename("127.0.0.1", "laptop")
+
+		_, ok := c.v["127.0.0.1"]
+		s.False(ok)
+		s.Equal(conn, c.v["laptop"].stream)
+	})
+
+	s.Run("should do nothing if the connection isn't in the pool", func() {
+		c := &connPool{v: map[string]*agentConn{}}
+		c.Rename("127.0.0.1", "laptop")
+
+		s.Empty(c.v)
+	})
 }
@@ -12,6 +12,9 @@ import (
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/minio"
 	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
 )
 
 var (
@@ -39,32 +42,69 @@ func (j *jobList) Add(jobs ...*models.Job) {
 	j.v = append(j.v, jobs...)
 }
 
-// AddJob adds a new job to the scheduler
-func AddJob(ctx *context.Context, host, job, config string, t time.Time) error {
-	a := &models.Agent{Host: host}
-	if err := a.Load(ctx); err != nil {
-		return err
+// RenameAgent moves the connections and the scheduled jobs of an agent from its previous host to the new one
+func RenameAgent(old, host string) {
+	AgentConnections.Rename(old, host)
+	PendingAgentConnections.Rename(old, host)
+
+	for _, j := range jobs.List() {
+		j.Mux.Lock()
+		if j.AgentHost == old {
+			j.AgentHost = host
+		}
+
+		if j.Plugin != nil && j.Plugin.AgentHost == old {
+			j.Plugin.AgentHost = host
+		}
+		j.Mux.Unlock()
 	}
+}
 
-	if err := a.LoadPlugins(ctx); err != nil {
+// UpdatePlugin resolves again the version of the scheduled jobs of a plugin (`repo/name`) of the agent after its installed
+// versions change (e.g. a version gets pinned). Each job is resolved within its version constraint. The jobs whose version
+// can't be resolved keep it
+func UpdatePlugin(ctx *context.Context, host, repo, name string) error {
+	versions, err := models.PluginVersions(ctx, host, repo, name)
+	if err != nil {
 		return err
 	}
 
-	j := &models.Job{
-		Status:    models.JobStatusScheduled,
-		AgentHost: a.Host,
-		Config:    config,
-		Time:      t,
+	ids := map[uint]bool{}
+	for _, v := range versions {
+		ids[v.ID] = true
 	}
 
-	for _, p := range a.Plugins {
-		if p.String() == job {
-			j.Plugin = p
-			j.PluginID = p.ID
+	for _, j := range jobs.List() {
+		j.Mux.Lock()
+		if ids[j.PluginID] && j.Status == models.JobStatusScheduled {
+			if p, err := models.ResolvePluginVersion(versions, j.PluginConstraint, false); err != nil {
+				log.Warnf("error resolving the plugin '%s/%s' version of the job %d: %v", repo, name, j.ID, err)
+
+			} else {
+				j.Plugin = p
+				j.PluginID = p.ID
+				j.PluginVersion = p.Version
+
+				if err := j.Update(ctx); err != nil {
+					log.Error(err.Error())
+				}
+			}
 		}
+		j.Mux.Unlock()
 	}
-	if j.PluginID == 0 {
-		return ErrPluginNotFound
+
+	return nil
+}
+
+// AddJob adds a new job to the scheduler. The job is the plugin (`repo/name`) and optionally a version constraint of it
+// (`repo/name@constraint`, e.g. `default/tar@^1.2`). It gets resolved to the best version of the plugin installed in the
+// agent, that's recorded in the job. The config of the job overrides the plugin config of the agent and the effective
+// config gets recorded in the job too. If tmpl is true, the config is a template with the agent facts (see JobConfigData),
+// that gets rendered right before starting the job. Otherwise, the config is sent to the agent as it is
+func AddJob(ctx *context.Context, host, job, config string, tmpl bool, t time.Time) error {
+	a, j, err := resolveJob(ctx, host, job, config, tmpl, t)
+	if err != nil {
+		return err
 	}
 
 	// TODO: Check Agent availability if the task is scheduled for now (before time.Now())
@@ -83,3 +123,69 @@ func AddJob(ctx *context.Context, host, job, config string, t time.Time) error {
 
 	return nil
 }
+
+// PreviewJobConfig returns the effective config that a job would have if it was added to the scheduler, without adding it
+func PreviewJobConfig(ctx *context.Context, host, job, config string) (string, error) {
+	_, j, err := resolveJob(ctx, host, job, config, false, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return j.Config, nil
+}
+
+// resolveJob resolves the plugin version and the effective config of a new job of the agent. If the config is a template,
+// it's rendered with the current agent facts, so the template errors are returned before scheduling the job
+func resolveJob(ctx *context.Context, host, job, config string, tmpl bool, t time.Time) (*models.Agent, *models.Job, error) {
+	a := &models.Agent{Host: host}
+	if err := a.Load(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	if err := a.LoadPlugins(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	name, constraint := models.ParsePluginRef(job)
+
+	versions := []*models.Plugin{}
+	for _, p := range a.Plugins {
+		if p.String() == name {
+			versions = append(versions, p)
+		}
+	}
+
+	p, err := models.ResolvePluginVersion(versions, constraint, false)
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil, ErrPluginNotFound
+		}
+
+		return nil, nil, err
+	}
+
+	cfg, err := models.EffectivePluginConfig(ctx, a, p, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j := &models.Job{
+		Status:           models.JobStatusScheduled,
+		AgentHost:        a.Host,
+		Config:           cfg,
+		Time:             t,
+		Plugin:           p,
+		PluginID:         p.ID,
+		PluginVersion:    p.Version,
+		PluginConstraint: constraint,
+		ConfigTemplate:   tmpl,
+	}
+
+	if j.ConfigTemplate {
+		if _, err := renderJobConfig(j.Config, newJobConfigData(a, j)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return a, j, nil
+}
@@ -3,10 +3,15 @@
 package scheduler
 
 import (
+	"errors"
+	"regexp"
 	"testing"
 
 	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -35,3 +40,65 @@ func (s *TestJobListInternalSuite) TestAdd() {
 
 	s.Len(j.v, 1)
 }
+
+func (s *TestJobListInternalSuite) TestRenameAgent() {
+	job := &models.Job{AgentHost: "laptop", Plugin: &models.Plugin{AgentHost: "laptop"}}
+	other := &models.Job{AgentHost: "server"}
+
+	jobs.v = []*models.Job{job, other}
+	defer func() { jobs.v = []*models.Job{} }()
+
+	RenameAgent("laptop", "laptop.example.com")
+
+	s.Equal("laptop.example.com", job.AgentHost)
+	s.Equal("laptop.example.com", job.Plugin.AgentHost)
+	s.Equal("server", other.AgentHost)
+}
+
+func (s *TestJobListInternalSuite) TestUpdatePlugin() {
+	s.Run("should resolve again the version of the scheduled jobs of the plugin", func() {
+		ctx := tests.GenerateCtx()
+		mock := tests.GenerateDB(s.T(), ctx)
+
+		job := &models.Job{Model: gorm.Model{ID: 1}, PluginID: 1, PluginVersion: "v1.0.0", AgentHost: "laptop", Status: models.JobStatusScheduled}
+		running := &models.Job{Model: gorm.Model{ID: 2}, PluginID: 1, PluginVersion: "v1.0.0", AgentHost: "laptop", Status: models.JobStatusRunning}
+		other := &models.Job{Model: gorm.Model{ID: 3}, PluginID: 4, PluginVersion: "v1.0.0", AgentHost: "laptop", Status: models.JobStatusScheduled}
+		constrained := &models.Job{Model: gorm.Model{ID: 4}, PluginID: 1, PluginVersion: "v1.0.0", PluginConstraint: "~1.0.0", AgentHost: "laptop", Status: models.JobStatusScheduled}
+
+		jobs.v = []*models.Job{job, running, other, constrained}
+		defer func() { jobs.v = []*models.Job{} }()
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1 AND repo = $2 AND name = $3)) ORDER BY "id"`)).WithArgs("laptop", "default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "repo", "name", "version", "pinned"}).
+			AddRow(1, "laptop", "default", "tar", "v1.0.0", false).
+			AddRow(2, "laptop", "default", "tar", "v1.1.0", true).
+			AddRow(3, "laptop", "default", "tar", "v1.0.1", false),
+		)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs"`)).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		s.NoError(UpdatePlugin(ctx, "laptop", "default", "tar"))
+
+		s.Equal(uint(2), job.PluginID)
+		s.Equal("v1.1.0", job.PluginVersion)
+		s.Equal("v1.1.0", job.Plugin.Version)
+		s.Equal(uint(1), running.PluginID)
+		s.Equal("v1.0.0", running.PluginVersion)
+		s.Equal(uint(4), other.PluginID)
+		s.Equal(uint(3), constrained.PluginID)
+		s.Equal("v1.0.1", constrained.PluginVersion)
+		s.NoError(mock.ExpectationsWereMet())
+	})
+
+	s.Run("should return an error if there's an error getting the plugin versions", func() {
+		ctx := tests.GenerateCtx()
+		mock := tests.GenerateDB(s.T(), ctx)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"`)).WillReturnError(errors.New("testing error"))
+
+		s.EqualError(UpdatePlugin(ctx, "laptop", "default", "tar"), "error getting the plugin versions: testing error")
+	})
+}
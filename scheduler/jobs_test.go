@@ -12,6 +12,7 @@ import (
 
 	"github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/minio"
+	"github.com/brainupdaters/drlm-core/models"
 	"github.com/brainupdaters/drlm-core/scheduler"
 	"github.com/brainupdaters/drlm-core/utils/tests"
 
@@ -39,8 +40,11 @@ func (s *TestJobsSuite) TestAddJob() {
 	s.Run("should add the job correctly", func() {
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name"}).AddRow(1, "default", "tar"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING "jobs"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts","plugin_version","plugin_constraint","config_template") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING "jobs"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		s.mock.ExpectCommit()
 
 		minio.Init(s.ctx)
@@ -64,15 +68,87 @@ func (s *TestJobsSuite) TestAddJob() {
 		ts := tests.GenerateMinio(s.ctx, mux)
 		defer ts.Close()
 
-		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", time.Now())
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", false, time.Now())
 
 		s.NoError(err)
 	})
 
+	s.Run("should add the job with the version of the plugin that matches the constraint", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version"}).
+			AddRow(1, "default", "tar", "v1.2.0").
+			AddRow(2, "default", "tar", "v1.4.1").
+			AddRow(3, "default", "tar", "v2.0.0"),
+		)
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 2, "192.168.1.61", models.JobStatusScheduled, sqlmock.AnyArg(), "", sqlmock.AnyArg(), "", 0, "v1.4.1", "^1.2", false).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		s.mock.ExpectCommit()
+
+		minio.Init(s.ctx)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/minio/admin/v2/add-canned-policy", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		mux.HandleFunc("/minio/admin/v2/set-user-or-group-policy", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.String(), "/drlm-") {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			s.Fail(r.URL.String())
+		})
+
+		ts := tests.GenerateMinio(s.ctx, mux)
+		defer ts.Close()
+
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar@^1.2", "", false, time.Now())
+
+		s.NoError(err)
+	})
+
+	s.Run("should return an error if the versions of the plugin that match the constraint are deprecated", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version", "deprecated"}).AddRow(1, "default", "tar", "v1.2.0", true))
+
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar@^1.2", "", false, time.Now())
+
+		s.Equal(models.ErrPluginDeprecated, err)
+	})
+
+	s.Run("should return an error if the job config template can't be rendered with the agent facts", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name"}).AddRow(1, "default", "tar"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "name", "value"}).AddRow(1, "192.168.1.61", "env", "prod"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", `{"path": "/{{ .Agent.Labels.site }}/{{ .Agent.Host }}"}`, true, time.Now())
+		s.EqualError(err, `error rendering the job config template: template: config:1:20: executing "config" at <.Agent.Labels.site>: map has no entry for key "site"`)
+	})
+
+	s.Run("should return an error if the job config template is invalid", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name"}).AddRow(1, "default", "tar"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", `{"path": "/{{ .Agent.Host }"}`, true, time.Now())
+
+		s.EqualError(err, `error parsing the job config template: template: config:1: unexpected "}" in operand`)
+	})
+
 	s.Run("should return an error if there's an error loading the agent from the DB", func() {
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnError(errors.New("testing error"))
 
-		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", time.Now())
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", false, time.Now())
 
 		s.EqualError(err, "error loading the agent from the DB: testing error")
 	})
@@ -81,7 +157,7 @@ func (s *TestJobsSuite) TestAddJob() {
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnError(errors.New("testing error"))
 
-		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", time.Now())
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", false, time.Now())
 
 		s.EqualError(err, "error getting the plugins list: testing error")
 	})
@@ -90,7 +166,7 @@ func (s *TestJobsSuite) TestAddJob() {
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name"}))
 
-		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", time.Now())
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", false, time.Now())
 
 		s.EqualError(err, "plugin for the job not found in the agent")
 	})
@@ -98,6 +174,9 @@ func (s *TestJobsSuite) TestAddJob() {
 	s.Run("should return an error if there's an error creating the minio bucket", func() {
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name"}).AddRow(1, "default", "tar"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 
 		minio.Init(s.ctx)
 
@@ -106,7 +185,7 @@ func (s *TestJobsSuite) TestAddJob() {
 		}))
 		defer ts.Close()
 
-		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", time.Now())
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", false, time.Now())
 
 		s.EqualError(err, "error adding the job: error creating the storage bucket: The specified bucket does not exist.")
 	})
@@ -114,8 +193,11 @@ func (s *TestJobsSuite) TestAddJob() {
 	s.Run("should return an error if there's an error adding the job to the DB", func() {
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
 		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name"}).AddRow(1, "default", "tar"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 		s.mock.ExpectBegin()
-		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING "jobs"."id"`)).WillReturnError(errors.New("testing error"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts","plugin_version","plugin_constraint","config_template") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING "jobs"."id"`)).WillReturnError(errors.New("testing error"))
 
 		minio.Init(s.ctx)
 
@@ -138,8 +220,36 @@ func (s *TestJobsSuite) TestAddJob() {
 		ts := tests.GenerateMinio(s.ctx, mux)
 		defer ts.Close()
 
-		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", time.Now())
+		err := scheduler.AddJob(s.ctx, "192.168.1.61", "default/tar", "", false, time.Now())
 
 		s.EqualError(err, "error adding the job: error adding the job to the DB: testing error")
 	})
 }
+
+func (s *TestJobsSuite) TestPreviewJobConfig() {
+	s.Run("should return the effective config of the job without adding it", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "version"}).AddRow(1, "default", "tar", "v1.0.0"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WithArgs("default", "tar", "v1.0.0").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WithArgs("192.168.1.61").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "name", "value"}).AddRow(1, "192.168.1.61", "env", "prod"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WithArgs("default", "tar").WillReturnRows(sqlmock.NewRows([]string{"id", "scope", "target", "config"}).
+			AddRow(1, models.PluginConfigScopeGroup, "env=prod", `{"compression": "zstd"}`).
+			AddRow(2, models.PluginConfigScopeAgent, "192.168.1.61", `{"level": 9}`),
+		)
+
+		cfg, err := scheduler.PreviewJobConfig(s.ctx, "192.168.1.61", "default/tar", `{"level": 3}`)
+
+		s.NoError(err)
+		s.JSONEq(`{"compression": "zstd", "level": 3}`, cfg)
+	})
+
+	s.Run("should return an error if the agent doesn't have the plugin requested", func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "192.168.1.61"))
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins" WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name"}))
+
+		cfg, err := scheduler.PreviewJobConfig(s.ctx, "192.168.1.61", "default/tar", "")
+
+		s.Equal(scheduler.ErrPluginNotFound, err)
+		s.Equal("", cfg)
+	})
+}
@@ -4,7 +4,6 @@ package scheduler
 
 import (
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/brainupdaters/drlm-core/context"
@@ -43,9 +42,23 @@ func scheduler(ctx *context.Context, queue chan *models.Job) {
 	for {
 		select {
 		case <-ticker.C:
+			// The jobs of the quarantined agents are held (they stay scheduled) until the agents are enabled again. The
+			// quarantined agents are only checked if there are jobs to execute
+			var quarantined map[string]bool
+			held := func(host string) bool {
+				if quarantined == nil {
+					var err error
+					if quarantined, err = models.QuarantinedAgentHosts(ctx); err != nil {
+						log.Errorf("scheduler: %v", err)
+					}
+				}
+
+				return quarantined[host]
+			}
+
 			for _, j := range jobs.List() {
 				j.Mux.Lock()
-				if time.Now().After(j.Time) && j.Status == models.JobStatusScheduled {
+				if time.Now().After(j.Time) && j.Status == models.JobStatusScheduled && !held(j.AgentHost) {
 					queue <- j
 				} else {
 					j.Mux.Unlock()
@@ -78,21 +91,23 @@ func worker(ctx *context.Context, queue chan *models.Job) {
 	for {
 		select {
 		case j := <-queue:
-			stream, ok := AgentConnections.Get(j.AgentHost)
-			if !ok {
+			if _, ok := AgentConnections.Get(j.AgentHost); !ok {
 				handleJobError(j, errAgentUnavailable)
 
+			} else if cfg, err := jobConfig(ctx, j); err != nil {
+				handleJobError(j, err)
+
 			} else {
-				if err := stream.Send(&drlm.AgentConnectionFromCore{
+				if err := AgentConnections.Send(j.AgentHost, &drlm.AgentConnectionFromCore{
 					MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOB_NEW,
 					JobNew: &drlm.AgentConnectionFromCore_JobNew{
 						Id:     uint32(j.ID),
-						Name:   fmt.Sprintf("drlm-plugin-%s-%s-%s", j.Plugin.Repo, j.Plugin.Name, j.Plugin.Version),
-						Config: j.Config,
+						Name:   j.Plugin.BinName(j.PluginVersion),
+						Config: cfg,
 						Target: j.BucketName,
 					},
 				}); err != nil {
-					if s, ok := status.FromError(err); ok && s.Code() == codes.Unavailable {
+					if s, ok := status.FromError(err); (ok && s.Code() == codes.Unavailable) || err == ErrConnNotFound {
 						err = errAgentUnavailable
 					}
 
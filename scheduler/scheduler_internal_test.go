@@ -14,6 +14,7 @@ import (
 	"github.com/brainupdaters/drlm-core/utils/tests"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/brainupdaters/drlm-common/pkg/os"
 	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
 	"github.com/brainupdaters/drlm-common/pkg/test"
 	"github.com/jinzhu/gorm"
@@ -33,6 +34,8 @@ func TestSchedulerInternal(t *testing.T) {
 func (s *TestSchedulerInternalSuite) TestScheduler() {
 	s.Run("should add the job to the queue correctly", func() {
 		ctx, cancel := context.WithCancel()
+		mock := tests.GenerateDB(s.T(), ctx)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT host FROM "agents"`)).WillReturnRows(sqlmock.NewRows([]string{"host"}))
 		jobs.v = []*models.Job{}
 
 		j := &models.Job{
@@ -58,6 +61,33 @@ func (s *TestSchedulerInternalSuite) TestScheduler() {
 		s.Equal(j, queueJob)
 	})
 
+	s.Run("should hold the jobs of the quarantined agents", func() {
+		ctx, cancel := context.WithCancel()
+		mock := tests.GenerateDB(s.T(), ctx)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT host FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((quarantined = $1))`)).WithArgs(true).WillReturnRows(sqlmock.NewRows([]string{"host"}).AddRow("laptop"))
+		jobs.v = []*models.Job{}
+
+		held := &models.Job{AgentHost: "laptop", Status: models.JobStatusScheduled, Time: time.Now()}
+		j := &models.Job{AgentHost: "server", Status: models.JobStatusScheduled, Time: time.Now()}
+		jobs.Add(held, j)
+
+		queue := make(chan *models.Job)
+
+		go scheduler(ctx, queue)
+		ctx.WG.Add(1)
+
+		queueJob := <-queue
+		queueJob.Mux.Unlock()
+
+		cancel()
+
+		s.Equal(j, queueJob)
+
+		held.Mux.Lock()
+		s.Equal(models.JobStatusScheduled, held.Status)
+		held.Mux.Unlock()
+	})
+
 	s.Run("should skip the job if the job doesn't have to be executed", func() {
 		ctx, cancel := context.WithCancel()
 		jobs.v = []*models.Job{}
@@ -104,7 +134,58 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 		mock := tests.GenerateDB(s.T(), ctx)
 
 		mock.ExpectBegin()
-		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $11`)).WillReturnResult(sqlmock.NewResult(83, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10, "plugin_version" = $11, "plugin_constraint" = $12, "config_template" = $13  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $14`)).WillReturnResult(sqlmock.NewResult(83, 1))
+		mock.ExpectCommit()
+
+		j := &models.Job{
+			Model: gorm.Model{ID: 85},
+			Plugin: &models.Plugin{
+				Repo:    "default",
+				Name:    "tar",
+				Version: "v1.0.0",
+			},
+			PluginVersion: "v1.0.0",
+			AgentHost:     "127.0.0.1",
+			Status:        models.JobStatusScheduled,
+			Config:        "{}",
+			BucketName:    "drlm-agent-1-name",
+		}
+		j.Mux.Lock()
+
+		agentConnMock := &tests.AgentConnectionServerMock{}
+		agentConnMock.On("Send", &drlm.AgentConnectionFromCore{
+			MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOB_NEW,
+			JobNew: &drlm.AgentConnectionFromCore_JobNew{
+				Id:     uint32(j.ID),
+				Name:   fmt.Sprintf("drlm-plugin-%s-%s-%s", j.Plugin.Repo, j.Plugin.Name, j.PluginVersion),
+				Config: j.Config,
+				Target: j.BucketName,
+			},
+		}).Return(nil)
+
+		AgentConnections.Add("127.0.0.1", agentConnMock)
+		defer AgentConnections.Delete("127.0.0.1")
+
+		queue := make(chan *models.Job)
+
+		go worker(ctx, queue)
+		ctx.WG.Add(1)
+
+		queue <- j
+
+		cancel()
+		ctx.WG.Wait()
+
+		s.Equal(models.JobStatusRunning, j.Status)
+		agentConnMock.AssertExpectations(s.T())
+	})
+
+	s.Run("should send the job config as it is if it isn't a template", func() {
+		ctx, cancel := context.WithCancel()
+		mock := tests.GenerateDB(s.T(), ctx)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10, "plugin_version" = $11, "plugin_constraint" = $12, "config_template" = $13  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $14`)).WillReturnResult(sqlmock.NewResult(83, 1))
 		mock.ExpectCommit()
 
 		j := &models.Job{
@@ -114,10 +195,11 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 				Name:    "tar",
 				Version: "v1.0.0",
 			},
-			AgentHost:  "127.0.0.1",
-			Status:     models.JobStatusScheduled,
-			Config:     "{}",
-			BucketName: "drlm-agent-1-name",
+			PluginVersion: "v1.0.0",
+			AgentHost:     "127.0.0.1",
+			Status:        models.JobStatusScheduled,
+			Config:        `{"exclude": "{{ .tmp }}"}`,
+			BucketName:    "drlm-agent-1-name",
 		}
 		j.Mux.Lock()
 
@@ -126,7 +208,7 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 			MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOB_NEW,
 			JobNew: &drlm.AgentConnectionFromCore_JobNew{
 				Id:     uint32(j.ID),
-				Name:   fmt.Sprintf("drlm-plugin-%s-%s-%s", j.Plugin.Repo, j.Plugin.Name, j.Plugin.Version),
+				Name:   fmt.Sprintf("drlm-plugin-%s-%s-%s", j.Plugin.Repo, j.Plugin.Name, j.PluginVersion),
 				Config: j.Config,
 				Target: j.BucketName,
 			},
@@ -154,7 +236,7 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 		mock := tests.GenerateDB(s.T(), ctx)
 
 		mock.ExpectBegin()
-		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING "jobs"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts","plugin_version","plugin_constraint","config_template") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING "jobs"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 		mock.ExpectCommit()
 
 		j := &models.Job{AgentHost: "127.0.0.1"}
@@ -178,7 +260,7 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 		mock := tests.GenerateDB(s.T(), ctx)
 
 		mock.ExpectBegin()
-		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $11`)).WillReturnResult(sqlmock.NewResult(83, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10, "plugin_version" = $11, "plugin_constraint" = $12, "config_template" = $13  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $14`)).WillReturnResult(sqlmock.NewResult(83, 1))
 		mock.ExpectCommit()
 
 		j := &models.Job{
@@ -188,10 +270,11 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 				Name:    "tar",
 				Version: "v1.0.0",
 			},
-			AgentHost:  "127.0.0.1",
-			Status:     models.JobStatusScheduled,
-			Config:     "{}",
-			BucketName: "drlm-agent-1-name",
+			PluginVersion: "v1.0.0",
+			AgentHost:     "127.0.0.1",
+			Status:        models.JobStatusScheduled,
+			Config:        "{}",
+			BucketName:    "drlm-agent-1-name",
 		}
 		j.Mux.Lock()
 
@@ -200,7 +283,7 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 			MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOB_NEW,
 			JobNew: &drlm.AgentConnectionFromCore_JobNew{
 				Id:     uint32(j.ID),
-				Name:   fmt.Sprintf("drlm-plugin-%s-%s-%s", j.Plugin.Repo, j.Plugin.Name, j.Plugin.Version),
+				Name:   fmt.Sprintf("drlm-plugin-%s-%s-%s", j.Plugin.Repo, j.Plugin.Name, j.PluginVersion),
 				Config: j.Config,
 				Target: j.BucketName,
 			},
@@ -228,7 +311,7 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 		mock := tests.GenerateDB(s.T(), ctx)
 
 		mock.ExpectBegin()
-		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $11`)).WillReturnResult(sqlmock.NewResult(83, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "updated_at" = $1, "deleted_at" = $2, "plugin_id" = $3, "agent_host" = $4, "status" = $5, "time" = $6, "config" = $7, "bucket_name" = $8, "info" = $9, "reconn_attempts" = $10, "plugin_version" = $11, "plugin_constraint" = $12, "config_template" = $13  WHERE "jobs"."deleted_at" IS NULL AND "jobs"."id" = $14`)).WillReturnResult(sqlmock.NewResult(83, 1))
 		mock.ExpectCommit()
 
 		j := &models.Job{
@@ -238,10 +321,11 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 				Name:    "tar",
 				Version: "v1.0.0",
 			},
-			AgentHost:  "127.0.0.1",
-			Status:     models.JobStatusScheduled,
-			Config:     "{}",
-			BucketName: "drlm-agent-1-name",
+			PluginVersion: "v1.0.0",
+			AgentHost:     "127.0.0.1",
+			Status:        models.JobStatusScheduled,
+			Config:        "{}",
+			BucketName:    "drlm-agent-1-name",
 		}
 		j.Mux.Lock()
 
@@ -250,7 +334,7 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 			MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOB_NEW,
 			JobNew: &drlm.AgentConnectionFromCore_JobNew{
 				Id:     uint32(j.ID),
-				Name:   fmt.Sprintf("drlm-plugin-%s-%s-%s", j.Plugin.Repo, j.Plugin.Name, j.Plugin.Version),
+				Name:   fmt.Sprintf("drlm-plugin-%s-%s-%s", j.Plugin.Repo, j.Plugin.Name, j.PluginVersion),
 				Config: j.Config,
 				Target: j.BucketName,
 			},
@@ -274,12 +358,108 @@ func (s *TestSchedulerInternalSuite) TestWorker() {
 		agentConnMock.AssertExpectations(s.T())
 	})
 
+	s.Run("should render the job config template with the agent facts", func() {
+		ctx, cancel := context.WithCancel()
+		mock := tests.GenerateDB(s.T(), ctx)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1))`)).WithArgs("127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"id", "host", "arch", "os", "distro"}).AddRow(1, "127.0.0.1", os.ArchAmd64, os.Linux, "debian"))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WithArgs("127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "name", "value"}).AddRow(1, "127.0.0.1", "env", "prod"))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs"`)).WillReturnResult(sqlmock.NewResult(85, 1))
+		mock.ExpectCommit()
+
+		j := &models.Job{
+			Model: gorm.Model{ID: 85},
+			Plugin: &models.Plugin{
+				Repo:    "default",
+				Name:    "tar",
+				Version: "v1.0.0",
+			},
+			PluginVersion:  "v1.0.0",
+			AgentHost:      "127.0.0.1",
+			Status:         models.JobStatusScheduled,
+			Time:           time.Date(2020, time.April, 2, 10, 30, 0, 0, time.UTC),
+			ConfigTemplate: true,
+			Config:         `{"path": "/{{ .Agent.Labels.env }}/{{ .Agent.Host }}-{{ .Agent.Distro }}-{{ .Agent.OS }}-{{ .Agent.Arch }}", "job": "{{ .Job.ID }}-{{ .Job.Bucket }}-{{ .Job.Time.Format "2006-01-02" }}"}`,
+			BucketName:     "drlm-agent-1-name",
+		}
+		j.Mux.Lock()
+
+		agentConnMock := &tests.AgentConnectionServerMock{}
+		agentConnMock.On("Send", &drlm.AgentConnectionFromCore{
+			MessageType: drlm.AgentConnectionFromCore_MESSAGE_TYPE_JOB_NEW,
+			JobNew: &drlm.AgentConnectionFromCore_JobNew{
+				Id:     uint32(j.ID),
+				Name:   "drlm-plugin-default-tar-v1.0.0",
+				Config: `{"path": "/prod/127.0.0.1-debian-linux-amd64", "job": "85-drlm-agent-1-name-2020-04-02"}`,
+				Target: j.BucketName,
+			},
+		}).Return(nil)
+
+		AgentConnections.Add("127.0.0.1", agentConnMock)
+		defer AgentConnections.Delete("127.0.0.1")
+
+		queue := make(chan *models.Job)
+
+		go worker(ctx, queue)
+		ctx.WG.Add(1)
+
+		queue <- j
+
+		cancel()
+		ctx.WG.Wait()
+
+		s.Equal(models.JobStatusRunning, j.Status)
+		agentConnMock.AssertExpectations(s.T())
+	})
+
+	s.Run("should fail the job if the job config template can't be rendered", func() {
+		ctx, cancel := context.WithCancel()
+		mock := tests.GenerateDB(s.T(), ctx)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1))`)).WithArgs("127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"id", "host"}).AddRow(1, "127.0.0.1"))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WithArgs("127.0.0.1").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs"`)).WillReturnResult(sqlmock.NewResult(85, 1))
+		mock.ExpectCommit()
+
+		j := &models.Job{
+			Model:          gorm.Model{ID: 85},
+			Plugin:         &models.Plugin{Repo: "default", Name: "tar"},
+			AgentHost:      "127.0.0.1",
+			Status:         models.JobStatusScheduled,
+			ConfigTemplate: true,
+			Config:         `{"path": "/{{ .Agent.Labels.env }}"}`,
+			BucketName:     "drlm-agent-1-name",
+		}
+		j.Mux.Lock()
+
+		agentConnMock := &tests.AgentConnectionServerMock{}
+
+		AgentConnections.Add("127.0.0.1", agentConnMock)
+		defer AgentConnections.Delete("127.0.0.1")
+
+		queue := make(chan *models.Job)
+
+		go worker(ctx, queue)
+		ctx.WG.Add(1)
+
+		queue <- j
+
+		cancel()
+		ctx.WG.Wait()
+
+		s.Equal(models.JobStatusFailed, j.Status)
+		s.Contains(j.Info, `error rendering the job config template`)
+		agentConnMock.AssertNotCalled(s.T(), "Send")
+	})
+
 	s.Run("should log an error if there's an error updating the job in the DB", func() {
 		ctx, _ := context.WithCancel()
 		mock := tests.GenerateDB(s.T(), ctx)
 
 		mock.ExpectBegin()
-		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING "jobs"."id"`)).WillReturnError(errors.New("testing error"))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts","plugin_version","plugin_constraint","config_template") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING "jobs"."id"`)).WillReturnError(errors.New("testing error"))
 
 		j := &models.Job{AgentHost: "127.0.0.1"}
 		j.Mux.Lock()
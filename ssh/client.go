@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+	"github.com/pkg/sftp"
+)
+
+// Client is a client.Client that runs the operations through a session of the pool. Each client has its own SFTP channel,
+// so if an operation takes longer than the command timeout or the context gets cancelled, only the operation gets aborted
+// (the command gets killed and the SFTP channel gets closed) and the session keeps being usable by the rest of its users.
+// The client can't be used after an operation has been aborted
+type Client struct {
+	ctx   *context.Context
+	host  string
+	p     *pooledSession
+	slot  chan struct{}
+	cli   client.Client
+	sftp  *sftp.Client
+	abort chan struct{}
+	once  sync.Once
+}
+
+// newClient returns a new client of the session. If the client can't be created, the user of the session is released
+func newClient(ctx *context.Context, host string, p *pooledSession, slot chan struct{}) (*Client, error) {
+	cli, err := sftp.NewClient(p.s.conn)
+	if err != nil {
+		pool.release(ctx.Cfg.SSH, host, p)
+		pool.free(slot)
+
+		return nil, fmt.Errorf("error creating the SFTP client: %v", err)
+	}
+
+	abort := make(chan struct{})
+
+	return &Client{
+		ctx:   ctx,
+		host:  host,
+		p:     p,
+		slot:  slot,
+		cli:   &sftpClient{s: p.s, sftp: cli, abort: abort},
+		sftp:  cli,
+		abort: abort,
+	}, nil
+}
+
+// Release returns the session to the pool. The client can't be used after releasing it
+func (c *Client) Release() {
+	c.once.Do(func() {
+		c.sftp.Close()
+		pool.release(c.ctx.Cfg.SSH, c.host, c.p)
+		pool.free(c.slot)
+	})
+}
+
+// do runs the operation. If it gets aborted, the operation is waited, so it can't outlive the call
+func (c *Client) do(f func() error) error {
+	if err := c.ctx.Err(); err != nil {
+		return fmt.Errorf("error running the ssh operation: %v", err)
+	}
+
+	select {
+	case <-c.abort:
+		return fmt.Errorf("error running the ssh operation: %v", errAborted)
+	default:
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	var timeout <-chan time.Time
+	if c.ctx.Cfg.SSH.CommandTimeout != 0 {
+		timer := time.NewTimer(c.ctx.Cfg.SSH.CommandTimeout)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case err := <-done:
+		return err
+
+	case <-timeout:
+		err = fmt.Errorf("error running the ssh operation: timeout after %s", c.ctx.Cfg.SSH.CommandTimeout)
+
+	case <-c.ctx.Done():
+		err = fmt.Errorf("error running the ssh operation: %v", c.ctx.Err())
+	}
+
+	close(c.abort)
+	c.sftp.Close()
+	<-done
+
+	return err
+}
+
+// Exec implements client.Client.Exec
+func (c *Client) Exec(name string, arg ...string) ([]byte, error) {
+	var out []byte
+	err := c.do(func() error {
+		var err error
+		out, err = c.cli.Exec(name, arg...)
+		return err
+	})
+
+	return out, err
+}
+
+// Chmod implements client.Client.Chmod
+func (c *Client) Chmod(path string, mode os.FileMode) error {
+	return c.do(func() error { return c.cli.Chmod(path, mode) })
+}
+
+// Chown implements client.Client.Chown
+func (c *Client) Chown(path string, uid, gid int) error {
+	return c.do(func() error { return c.cli.Chown(path, uid, gid) })
+}
+
+// Exists implements client.Client.Exists
+func (c *Client) Exists(path string) (bool, error) {
+	var exists bool
+	err := c.do(func() error {
+		var err error
+		exists, err = c.cli.Exists(path)
+		return err
+	})
+
+	return exists, err
+}
+
+// MkdirAll implements client.Client.MkdirAll
+func (c *Client) MkdirAll(path string, perm os.FileMode) error {
+	return c.do(func() error { return c.cli.MkdirAll(path, perm) })
+}
+
+// Write implements client.Client.Write
+func (c *Client) Write(path string, b []byte) error {
+	return c.do(func() error { return c.cli.Write(path, b) })
+}
+
+// Append implements client.Client.Append
+func (c *Client) Append(path string, b []byte) error {
+	return c.do(func() error { return c.cli.Append(path, b) })
+}
+
+// ReadFile implements client.Client.ReadFile
+func (c *Client) ReadFile(path string) ([]byte, error) {
+	var b []byte
+	err := c.do(func() error {
+		var err error
+		b, err = c.cli.ReadFile(path)
+		return err
+	})
+
+	return b, err
+}
+
+// Remove implements client.Client.Remove
+func (c *Client) Remove(path string) error {
+	return c.do(func() error { return c.cli.Remove(path) })
+}
+
+// Copy implements client.Client.Copy
+func (c *Client) Copy(src, dst string) error {
+	return c.do(func() error { return c.cli.Copy(src, dst) })
+}
+
+// Move implements client.Client.Move
+func (c *Client) Move(src, dst string) error {
+	return c.do(func() error { return c.cli.Move(src, dst) })
+}
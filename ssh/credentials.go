@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	"github.com/spf13/afero"
+	stdSSH "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoCredentials gets returned when the credentials have no authentication method
+var ErrNoCredentials = errors.New("no SSH password, private key or agent socket provided")
+
+// ErrUnknownCredentials gets returned when the credentials ID isn't in the Core configuration
+var ErrUnknownCredentials = errors.New("unknown SSH credentials")
+
+// Credentials are the credentials used to access a host before the Core SSH key is authorized in it. All the authentication
+// methods that are provided are tried
+type Credentials struct {
+	User        string // The user that logs in. If it's empty, the agent SSH user is used
+	Password    string
+	PrivateKey  []byte // An existing private key, in PEM format
+	Passphrase  string // The passphrase of the private key, if it's encrypted
+	AgentSocket string // The socket of an SSH agent that has the keys of the user
+
+	Sudo         bool   // Whether the operations are run with sudo. It's required if the user isn't the agent SSH user
+	SudoPassword string // The password of the user for sudo. If it's empty, sudo has to be allowed without password
+}
+
+// LoadCredentials returns the credentials of the Core configuration with the ID
+func LoadCredentials(ctx *context.Context, id string) (Credentials, error) {
+	cfg, ok := ctx.Cfg.SSH.Credentials[id]
+	if !ok {
+		return Credentials{}, ErrUnknownCredentials
+	}
+
+	creds := Credentials{
+		User:         cfg.User,
+		Password:     cfg.Password,
+		Passphrase:   cfg.Passphrase,
+		Sudo:         cfg.Sudo,
+		SudoPassword: cfg.SudoPassword,
+	}
+
+	if cfg.PrivateKeyPath != "" {
+		var err error
+		creds.PrivateKey, err = afero.ReadFile(ctx.FS, cfg.PrivateKeyPath)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("error reading the SSH private key: %v", err)
+		}
+	}
+
+	if cfg.Agent {
+		creds.AgentSocket = ctx.Cfg.SSH.AgentSocket
+	}
+
+	return creds, nil
+}
+
+// authMethods returns the SSH authentication methods of the credentials. If the SSH agent is used, the returned closer
+// closes the connection with it, and it has to be called once the session is open
+func (c Credentials) authMethods() ([]stdSSH.AuthMethod, io.Closer, error) {
+	auth := []stdSSH.AuthMethod{}
+
+	if len(c.PrivateKey) != 0 {
+		var signer stdSSH.Signer
+		var err error
+		if c.Passphrase != "" {
+			signer, err = stdSSH.ParsePrivateKeyWithPassphrase(c.PrivateKey, []byte(c.Passphrase))
+		} else {
+			signer, err = stdSSH.ParsePrivateKey(c.PrivateKey)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing the SSH private key: %v", err)
+		}
+
+		auth = append(auth, stdSSH.PublicKeys(signer))
+	}
+
+	var conn net.Conn
+	if c.AgentSocket != "" {
+		var err error
+		conn, err = net.Dial("unix", c.AgentSocket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error connecting to the SSH agent: %v", err)
+		}
+
+		auth = append(auth, stdSSH.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if c.Password != "" {
+		auth = append(auth, stdSSH.Password(c.Password))
+	}
+
+	if len(auth) == 0 {
+		return nil, nil, ErrNoCredentials
+	}
+
+	if conn == nil {
+		return auth, nil, nil
+	}
+
+	return auth, conn, nil
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh_test
+
+import (
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/cfg/types"
+	"github.com/brainupdaters/drlm-core/ssh"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestCredentialsSuite struct {
+	suite.Suite
+}
+
+func TestCredentials(t *testing.T) {
+	suite.Run(t, &TestCredentialsSuite{})
+}
+
+func (s *TestCredentialsSuite) TestLoadCredentials() {
+	s.Run("should return the credentials of the configuration", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		ctx.Cfg.SSH.AgentSocket = "/run/ssh-agent.sock"
+		ctx.Cfg.SSH.Credentials = map[string]types.DRLMCoreSSHCredentialsConfig{
+			"admin": {User: "admin", PrivateKeyPath: "/keys/admin", Passphrase: "p4$$w0rd", Agent: true, Sudo: true, SudoPassword: "s3cr3t"},
+		}
+		s.Require().NoError(afero.WriteFile(ctx.FS, "/keys/admin", []byte("key"), 0600))
+
+		creds, err := ssh.LoadCredentials(ctx, "admin")
+		s.NoError(err)
+		s.Equal(ssh.Credentials{
+			User:         "admin",
+			PrivateKey:   []byte("key"),
+			Passphrase:   "p4$$w0rd",
+			AgentSocket:  "/run/ssh-agent.sock",
+			Sudo:         true,
+			SudoPassword: "s3cr3t",
+		}, creds)
+	})
+
+	s.Run("should return an error if the credentials aren't in the configuration", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+
+		_, err := ssh.LoadCredentials(ctx, "admin")
+		s.Equal(ssh.ErrUnknownCredentials, err)
+	})
+
+	s.Run("should return an error if the private key can't be read", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		ctx.Cfg.SSH.Credentials = map[string]types.DRLMCoreSSHCredentialsConfig{
+			"admin": {PrivateKeyPath: "/keys/admin"},
+		}
+
+		_, err := ssh.LoadCredentials(ctx, "admin")
+		s.EqualError(err, "error reading the SSH private key: open /keys/admin: file does not exist")
+	})
+}
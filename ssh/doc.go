@@ -0,0 +1,6 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package ssh manages the SSH key pair of DRLM Core
+// and opens the SSH sessions with the agents hosts,
+// which are kept in a pool to be reused
+package ssh
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+)
+
+// scanHostKeys returns the host keys presented by a host. It's a variable so it can be replaced in the tests
+var scanHostKeys = HostKeys
+
+// HostKeyMismatchError gets returned when the agent host presents keys that aren't trusted. The new keys are recorded
+// as pending and the agent can't be reached through SSH until they get accepted
+type HostKeyMismatchError struct {
+	Host    string
+	Trusted []string // The fingerprints of the trusted keys
+	New     []string // The fingerprints of the keys presented by the host
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("the SSH host keys of the agent '%s' have changed (trusted: %s, new: %s). The new keys have to be accepted", e.Host, strings.Join(e.Trusted, ", "), strings.Join(e.New, ", "))
+}
+
+// trustedKeys returns the loaded trusted host keys of the agent in the known_hosts format
+func trustedKeys(a *models.Agent) []string {
+	keys := []string{}
+	for _, k := range a.TrustedHostKeys() {
+		keys = append(keys, k.Key)
+	}
+
+	return keys
+}
+
+// isHostKeyMismatch checks whether an error opening a session is caused by the host presenting an untrusted key. The
+// common SSH session doesn't allow setting the host key callback, so the error message has to be checked
+func isHostKeyMismatch(err error) bool {
+	return strings.Contains(err.Error(), "ssh: host key mismatch")
+}
+
+// hostKeyMismatch records the keys presented by the agent host as pending and returns the mismatch error
+func hostKeyMismatch(ctx *context.Context, a *models.Agent, bastions []*models.Bastion) error {
+	keys, err := scanHostKeys(ctx, bastions, a.Host, a.SSHPort)
+	if err != nil {
+		return fmt.Errorf("error getting the new SSH host keys of the agent: %v", err)
+	}
+
+	if err := a.RecordHostKeyMismatch(ctx, keys); err != nil {
+		return err
+	}
+
+	return &HostKeyMismatchError{
+		Host:    a.Host,
+		Trusted: models.Fingerprints(a.TrustedHostKeys()),
+		New:     models.Fingerprints(a.PendingHostKeys()),
+	}
+}
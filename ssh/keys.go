@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	stdSSH "golang.org/x/crypto/ssh"
+)
+
+const (
+	// keyName is the file name of the Core private key. The public key has the same name with the `.pub` extension
+	keyName = "id_core"
+	// keyComment is the comment of the Core public key. It's used to identify it in the authorized_keys
+	keyComment = "drlm-core"
+)
+
+// Init generates the Core SSH key pair if it doesn't exist yet
+func Init(ctx *context.Context) {
+	exists, err := afero.Exists(ctx.FS, privKeyPath(ctx))
+	if err != nil {
+		log.Fatalf("error checking the Core SSH key: %v", err)
+	}
+
+	if !exists {
+		if err := generateKeyPair(ctx, privKeyPath(ctx)); err != nil {
+			log.Fatalf("error generating the Core SSH key: %v", err)
+		}
+
+		log.Infof("generated the Core SSH key pair at '%s'", ctx.Cfg.Security.SSHKeysPath)
+	}
+}
+
+// PublicKey returns the Core public key in the authorized_keys format
+func PublicKey(ctx *context.Context) ([]byte, error) {
+	b, err := afero.ReadFile(ctx.FS, privKeyPath(ctx)+".pub")
+	if err != nil {
+		return nil, fmt.Errorf("error reading the Core SSH public key: %v", err)
+	}
+
+	return b, nil
+}
+
+func privKeyPath(ctx *context.Context) string {
+	return filepath.Join(ctx.Cfg.Security.SSHKeysPath, keyName)
+}
+
+// prevKeyPath is the path of the previous Core private key, that is kept while a partial rotation is pending
+func prevKeyPath(ctx *context.Context) string {
+	return privKeyPath(ctx) + ".prev"
+}
+
+// newKeyPath is the path of the new Core private key while it's being deployed
+func newKeyPath(ctx *context.Context) string {
+	return privKeyPath(ctx) + ".new"
+}
+
+// signers returns the signers of the private key of the path (named as key in the errors). If it's the Core private key and there's a partial
+// rotation pending, the previous key is returned too, since some hosts only have the previous key authorized
+func signers(ctx *context.Context, path, key string) ([]stdSSH.Signer, error) {
+	paths := []string{path}
+	if path == privKeyPath(ctx) {
+		exists, err := afero.Exists(ctx.FS, prevKeyPath(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("error checking the previous Core SSH key: %v", err)
+		}
+
+		if exists {
+			paths = append(paths, prevKeyPath(ctx))
+		}
+	}
+
+	signers := []stdSSH.Signer{}
+	for _, p := range paths {
+		b, err := afero.ReadFile(ctx.FS, p)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the %s: %v", key, err)
+		}
+
+		signer, err := stdSSH.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the %s: %v", key, err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+// generateKeyPair generates a new key pair of the configured type and writes it to the path (and the public key to path.pub)
+func generateKeyPair(ctx *context.Context, path string) error {
+	var pub crypto.PublicKey
+	var priv crypto.PrivateKey
+
+	switch strings.ToLower(ctx.Cfg.Security.SSHKeyType) {
+	case "", "ed25519":
+		var err error
+		pub, priv, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("error generating the private key: %v", err)
+		}
+
+	case "rsa":
+		k, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			return fmt.Errorf("error generating the private key: %v", err)
+		}
+
+		pub = &k.PublicKey
+		priv = k
+
+	default:
+		return fmt.Errorf("unsupported SSH key type '%s'", ctx.Cfg.Security.SSHKeyType)
+	}
+
+	sshPub, err := stdSSH.NewPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("error generating the public key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("error encoding the private key: %v", err)
+	}
+
+	if err := ctx.FS.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating the SSH keys directory: %v", err)
+	}
+
+	if err := afero.WriteFile(ctx.FS, path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return fmt.Errorf("error writting the private key: %v", err)
+	}
+
+	authKey := strings.TrimSpace(string(stdSSH.MarshalAuthorizedKey(sshPub))) + " " + keyComment + "\n"
+	if err := afero.WriteFile(ctx.FS, path+".pub", []byte(authKey), 0644); err != nil {
+		return fmt.Errorf("error writting the public key: %v", err)
+	}
+
+	return nil
+}
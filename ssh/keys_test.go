@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/ssh"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/brainupdaters/drlm-common/pkg/test"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	stdSSH "golang.org/x/crypto/ssh"
+)
+
+type TestKeysSuite struct {
+	test.Test
+	ctx *context.Context
+}
+
+func TestKeys(t *testing.T) {
+	suite.Run(t, new(TestKeysSuite))
+}
+
+func (s *TestKeysSuite) SetupTest() {
+	s.ctx = tests.GenerateCtx()
+	tests.GenerateCfg(s.T(), s.ctx)
+}
+
+func (s *TestKeysSuite) TestInit() {
+	s.Run("should generate an ed25519 key pair if it doesn't exist", func() {
+		ssh.Init(s.ctx)
+
+		b, err := afero.ReadFile(s.ctx.FS, "ssh/id_core")
+		s.NoError(err)
+
+		k, err := stdSSH.ParsePrivateKey(b)
+		s.NoError(err)
+		s.Equal(stdSSH.KeyAlgoED25519, k.PublicKey().Type())
+
+		pub, err := ssh.PublicKey(s.ctx)
+		s.NoError(err)
+		s.Equal(string(stdSSH.MarshalAuthorizedKey(k.PublicKey())), strings.TrimSuffix(string(pub), " drlm-core\n")+"\n")
+	})
+
+	s.Run("should not overwrite an existing key pair", func() {
+		s.Require().NoError(afero.WriteFile(s.ctx.FS, "ssh/id_core", []byte("key"), 0600))
+
+		ssh.Init(s.ctx)
+
+		b, err := afero.ReadFile(s.ctx.FS, "ssh/id_core")
+		s.NoError(err)
+		s.Equal("key", string(b))
+	})
+
+	s.Run("should generate a RSA key pair if it's configured", func() {
+		s.ctx.FS = afero.NewMemMapFs()
+		s.ctx.Cfg.Security.SSHKeyType = "rsa"
+
+		ssh.Init(s.ctx)
+
+		b, err := afero.ReadFile(s.ctx.FS, "ssh/id_core")
+		s.NoError(err)
+
+		k, err := stdSSH.ParsePrivateKey(b)
+		s.NoError(err)
+		s.Equal(stdSSH.KeyAlgoRSA, k.PublicKey().Type())
+	})
+
+	s.Run("should exit if the key type isn't supported", func() {
+		s.ctx.FS = afero.NewMemMapFs()
+		s.ctx.Cfg.Security.SSHKeyType = "dsa"
+
+		s.Exits(func() { ssh.Init(s.ctx) })
+	})
+}
+
+func (s *TestKeysSuite) TestPublicKey() {
+	s.Run("should return an error if the public key doesn't exist", func() {
+		_, err := ssh.PublicKey(s.ctx)
+		s.EqualError(err, "error reading the Core SSH public key: open ssh/id_core.pub: file does not exist")
+	})
+}
+
+func (s *TestKeysSuite) TestNewSession() {
+	s.Run("should return an error if the private key doesn't exist", func() {
+		_, err := ssh.NewSession(s.ctx, nil, "laptop", 22, "drlm", []string{})
+		s.EqualError(err, "error reading the Core SSH private key: open ssh/id_core: file does not exist")
+	})
+}
@@ -0,0 +1,326 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/cfg/types"
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pool has the SSH sessions with the agents that are kept open to be reused
+var pool = &sessionPool{
+	sessions: map[string]*pooledSession{},
+	slots:    map[string]chan struct{}{},
+}
+
+// sessionPool keeps the SSH sessions with the agents open, so they can be reused between calls. It also limits the
+// number of concurrent users of the sessions with each host
+type sessionPool struct {
+	mu       sync.Mutex
+	sessions map[string]*pooledSession
+	slots    map[string]chan struct{}
+}
+
+// pooledSession is a session of the pool
+type pooledSession struct {
+	s        *Session
+	id       string // The connection parameters of the session. If they change, a new session gets opened
+	users    int
+	lastUsed time.Time
+	dropped  bool // The session isn't in the pool anymore and it gets closed once it has no users
+	closed   bool
+	stop     chan struct{}
+}
+
+// Acquire returns a client of the SSH session with the agent host, using the Core SSH key. If there's already a session
+// with the host, it gets reused. It waits while the host has the maximum number of concurrent users. The client has to
+// be released after using it. The quarantined agents can't be reached
+func Acquire(ctx *context.Context, a *models.Agent) (*Client, error) {
+	if a.Quarantined {
+		return nil, models.ErrAgentQuarantined
+	}
+
+	slot, err := pool.wait(ctx, a.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("%s@%s:%d", a.SSHUser, a.Host, a.SSHPort)
+
+	pool.mu.Lock()
+	if p, ok := pool.sessions[a.Host]; ok {
+		if p.id == id {
+			p.users++
+			pool.mu.Unlock()
+
+			return newClient(ctx, a.Host, p, slot)
+		}
+
+		pool.drop(a.Host, p)
+	}
+	pool.mu.Unlock()
+
+	s, err := openAgentSession(ctx, a, func(bastions []*models.Bastion, keys []string) (*Session, error) {
+		return NewSession(ctx, bastions, a.Host, a.SSHPort, a.SSHUser, keys)
+	})
+	if err != nil {
+		pool.free(slot)
+		return nil, err
+	}
+
+	pool.mu.Lock()
+	// Another call might have opened a session with the host in the meantime
+	if p, ok := pool.sessions[a.Host]; ok {
+		if p.id == id {
+			p.users++
+			pool.mu.Unlock()
+			s.Close()
+
+			return newClient(ctx, a.Host, p, slot)
+		}
+
+		pool.drop(a.Host, p)
+	}
+
+	p := &pooledSession{s: s, id: id, users: 1, lastUsed: time.Now(), stop: make(chan struct{})}
+	pool.sessions[a.Host] = p
+	pool.mu.Unlock()
+
+	go pool.keepalive(ctx.Cfg.SSH, a.Host, p)
+
+	return newClient(ctx, a.Host, p, slot)
+}
+
+// AcquireWithCredentials returns a client of a new SSH session with the agent host, using the credentials. If the
+// credentials use sudo, all the operations of the client are run with sudo. The session isn't reused and it gets closed
+// when the client is released, but it counts for the maximum number of concurrent users
+func AcquireWithCredentials(ctx *context.Context, a *models.Agent, creds Credentials) (*Client, error) {
+	if a.Quarantined {
+		return nil, models.ErrAgentQuarantined
+	}
+
+	if creds.User == "" {
+		creds.User = a.SSHUser
+	}
+
+	slot, err := pool.wait(ctx, a.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := openAgentSession(ctx, a, func(bastions []*models.Bastion, keys []string) (*Session, error) {
+		return NewSessionWithCredentials(ctx, bastions, a.Host, a.SSHPort, creds, keys)
+	})
+	if err != nil {
+		pool.free(slot)
+		return nil, err
+	}
+
+	p := &pooledSession{s: s, users: 1, dropped: true, stop: make(chan struct{})}
+
+	c, err := newClient(ctx, a.Host, p, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	if creds.Sudo {
+		c.cli = &sudoClient{s: s, sftp: c.sftp, pwd: creds.SudoPassword, abort: c.abort}
+	}
+
+	return c, nil
+}
+
+// Evict removes the session with the host from the pool. If it's being used, it gets closed once it's released
+func Evict(host string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if p, ok := pool.sessions[host]; ok {
+		pool.drop(host, p)
+	}
+}
+
+// CloseAll removes all the sessions from the pool
+func CloseAll() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for host, p := range pool.sessions {
+		pool.drop(host, p)
+	}
+}
+
+// openAgentSession opens a new session with the agent host, through the agent bastions and verifying the trusted host keys.
+// If the host keys don't match, the keys presented by the host are recorded as pending
+func openAgentSession(ctx *context.Context, a *models.Agent, open func(bastions []*models.Bastion, keys []string) (*Session, error)) (*Session, error) {
+	bastions, err := models.AgentBastionChain(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.LoadHostKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	s, err := open(bastions, trustedKeys(a))
+	if err != nil {
+		if isHostKeyMismatch(err) {
+			return nil, hostKeyMismatch(ctx, a, bastions)
+		}
+
+		return nil, fmt.Errorf("error opening the ssh session with the agent: %v", err)
+	}
+
+	return s, nil
+}
+
+// wait waits until the host has a free slot and takes it. If there's no limit of concurrent users, the slot is nil
+func (pl *sessionPool) wait(ctx *context.Context, host string) (chan struct{}, error) {
+	if ctx.Cfg.SSH.MaxSessions == 0 {
+		return nil, nil
+	}
+
+	pl.mu.Lock()
+	slot, ok := pl.slots[host]
+	if !ok {
+		slot = make(chan struct{}, ctx.Cfg.SSH.MaxSessions)
+		pl.slots[host] = slot
+	}
+	pl.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return slot, nil
+
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error waiting for a free ssh session with the agent: %v", ctx.Err())
+	}
+}
+
+// free frees a slot taken with wait
+func (pl *sessionPool) free(slot chan struct{}) {
+	if slot != nil {
+		<-slot
+	}
+}
+
+// release releases an user of the session. If the session has no users and it has been dropped (or the sessions
+// aren't kept open), it gets closed
+func (pl *sessionPool) release(cfg types.DRLMCoreSSHConfig, host string, p *pooledSession) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	p.users--
+	p.lastUsed = time.Now()
+
+	if p.dropped || cfg.IdleTimeout == 0 {
+		pl.drop(host, p)
+	}
+}
+
+// drop removes the session from the pool and closes it if it has no users. It has to be called with the pool locked
+func (pl *sessionPool) drop(host string, p *pooledSession) {
+	if pl.sessions[host] == p {
+		delete(pl.sessions, host)
+	}
+
+	p.dropped = true
+
+	if p.users == 0 {
+		pl.close(p)
+	}
+}
+
+// kill removes the session from the pool and closes it, even if it has users
+func (pl *sessionPool) kill(host string, p *pooledSession) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	pl.drop(host, p)
+	pl.close(p)
+}
+
+// close closes the session. It has to be called with the pool locked
+func (pl *sessionPool) close(p *pooledSession) {
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	close(p.stop)
+	p.s.Close()
+}
+
+// keepalive sends keepalives through the session and closes it if it fails or it has been unused longer than the idle timeout
+func (pl *sessionPool) keepalive(cfg types.DRLMCoreSSHConfig, host string, p *pooledSession) {
+	interval := cfg.KeepaliveInterval
+	if interval == 0 {
+		interval = cfg.IdleTimeout
+	}
+
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+
+		case <-ticker.C:
+			pl.mu.Lock()
+			idle := p.users == 0 && time.Since(p.lastUsed) >= cfg.IdleTimeout
+			if idle {
+				pl.drop(host, p)
+			}
+			pl.mu.Unlock()
+
+			if idle {
+				return
+			}
+
+			if cfg.KeepaliveInterval != 0 {
+				if err := runKeepalive(p.s, cfg.ConnectTimeout); err != nil {
+					log.Warnf("closing the ssh session with '%s': %v", host, err)
+					pl.kill(host, p)
+
+					return
+				}
+			}
+		}
+	}
+}
+
+// runKeepalive sends a keepalive through the session, which fails if there's no response before the timeout
+func runKeepalive(s *Session, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.keepalive()
+	}()
+
+	var expired <-chan time.Time
+	if timeout != 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case err := <-errs:
+		return err
+
+	case <-expired:
+		return fmt.Errorf("error sending the keepalive: timeout after %s", timeout)
+	}
+}
@@ -0,0 +1,422 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	stdSSH "golang.org/x/crypto/ssh"
+)
+
+type TestPoolInternalSuite struct {
+	suite.Suite
+	ctx    *context.Context
+	cancel func()
+	mock   sqlmock.Sqlmock
+	srv    *testSSHServer
+}
+
+func TestPoolInternal(t *testing.T) {
+	suite.Run(t, &TestPoolInternalSuite{})
+}
+
+func (s *TestPoolInternalSuite) SetupTest() {
+	s.ctx, s.cancel = context.WithCancel()
+	s.ctx.FS = afero.NewMemMapFs()
+	tests.GenerateCfg(s.T(), s.ctx)
+	s.mock = tests.GenerateDB(s.T(), s.ctx)
+	s.Require().NoError(generateKeyPair(s.ctx, privKeyPath(s.ctx)))
+
+	s.srv = newTestSSHServer(s.T())
+
+	pool.mu.Lock()
+	pool.slots = map[string]chan struct{}{}
+	pool.mu.Unlock()
+}
+
+func (s *TestPoolInternalSuite) TearDownTest() {
+	CloseAll()
+	s.srv.Close()
+	s.cancel()
+}
+
+func (s *TestPoolInternalSuite) agent() *models.Agent {
+	host, port, err := net.SplitHostPort(s.srv.Addr().String())
+	s.Require().NoError(err)
+
+	p, err := net.LookupPort("tcp", port)
+	s.Require().NoError(err)
+
+	return &models.Agent{
+		Host:    host,
+		SSHPort: p,
+		SSHUser: "drlm",
+	}
+}
+
+// expectSession expects the queries of opening a new session with the agent, which has the key as trusted host key
+func (s *TestPoolInternalSuite) expectSession(key string) {
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "bastions"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "key", "trusted"}).AddRow(1, "127.0.0.1", key, true))
+}
+
+func (s *TestPoolInternalSuite) TestAcquire() {
+	s.Run("should reuse the session with the agent", func() {
+		s.expectSession(s.srv.hostKey)
+		a := s.agent()
+
+		c, err := Acquire(s.ctx, a)
+		s.Require().NoError(err)
+
+		out, err := c.Exec("echo", "hello")
+		s.NoError(err)
+		s.Equal("echo hello", string(out))
+		c.Release()
+
+		c, err = Acquire(s.ctx, a)
+		s.Require().NoError(err)
+
+		exists, err := c.Exists("/")
+		s.NoError(err)
+		s.True(exists)
+		c.Release()
+
+		s.Equal(int32(1), s.srv.conns())
+	})
+
+	s.Run("should open a new session if the connection parameters have changed", func() {
+		s.expectSession(s.srv.hostKey)
+		a := s.agent()
+		a.SSHUser = "root"
+
+		c, err := Acquire(s.ctx, a)
+		s.Require().NoError(err)
+		c.Release()
+
+		s.Equal(int32(2), s.srv.conns())
+	})
+
+	s.Run("should only abort the operation if a command exceeds the timeout", func() {
+		s.ctx.Cfg.SSH.CommandTimeout = 100 * time.Millisecond
+		defer func() { s.ctx.Cfg.SSH.CommandTimeout = 10 * time.Minute }()
+
+		s.expectSession(s.srv.hostKey)
+		a := s.agent()
+
+		c, err := Acquire(s.ctx, a)
+		s.Require().NoError(err)
+
+		other, err := Acquire(s.ctx, a)
+		s.Require().NoError(err)
+		defer other.Release()
+
+		_, err = c.Exec("sleep")
+		s.EqualError(err, "error running the ssh operation: timeout after 100ms")
+
+		_, err = c.Exec("echo", "hello")
+		s.EqualError(err, "error running the ssh operation: connection aborted")
+		c.Release()
+
+		out, err := other.Exec("echo", "hello")
+		s.NoError(err)
+		s.Equal("echo hello", string(out))
+
+		exists, err := other.Exists("/")
+		s.NoError(err)
+		s.True(exists)
+
+		c, err = Acquire(s.ctx, a)
+		s.Require().NoError(err)
+		c.Release()
+
+		s.Equal(int32(3), s.srv.conns())
+	})
+}
+
+func (s *TestPoolInternalSuite) TestAcquireQuarantined() {
+	a := s.agent()
+	a.Quarantined = true
+
+	_, err := Acquire(s.ctx, a)
+	s.Equal(models.ErrAgentQuarantined, err)
+
+	_, err = AcquireWithCredentials(s.ctx, a, Credentials{Password: "p4$$w0rd"})
+	s.Equal(models.ErrAgentQuarantined, err)
+	s.Equal(int32(0), s.srv.conns())
+}
+
+func (s *TestPoolInternalSuite) TestAcquireWithCredentials() {
+	s.Run("should open a new session that isn't reused", func() {
+		s.expectSession(s.srv.hostKey)
+		a := s.agent()
+
+		c, err := AcquireWithCredentials(s.ctx, a, Credentials{Password: "p4$$w0rd"})
+		s.Require().NoError(err)
+
+		out, err := c.Exec("echo", "hello")
+		s.NoError(err)
+		s.Equal("echo hello", string(out))
+		c.Release()
+
+		pool.mu.Lock()
+		_, ok := pool.sessions[a.Host]
+		pool.mu.Unlock()
+		s.False(ok)
+	})
+
+	s.Run("should run the operations with sudo", func() {
+		s.expectSession(s.srv.hostKey)
+
+		c, err := AcquireWithCredentials(s.ctx, s.agent(), Credentials{User: "admin", Password: "p4$$w0rd", Sudo: true, SudoPassword: "p4$$w0rd"})
+		s.Require().NoError(err)
+		defer c.Release()
+
+		out, err := c.Exec("id", "-u", "drlm")
+		s.NoError(err)
+		s.Equal("sudo -S -p '' -- id -u drlm", string(out))
+
+		out, err = c.ReadFile("/etc/it's")
+		s.NoError(err)
+		s.Equal(`sudo -S -p '' -- cat '/etc/it'\''s'`, string(out))
+	})
+
+	s.Run("should return an error if there are no credentials", func() {
+		s.expectSession(s.srv.hostKey)
+
+		_, err := AcquireWithCredentials(s.ctx, s.agent(), Credentials{})
+		s.EqualError(err, "error opening the ssh session with the agent: no SSH password, private key or agent socket provided")
+	})
+}
+
+func (s *TestPoolInternalSuite) TestAcquireHostKeyMismatch() {
+	oldKey := "127.0.0.1 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBL1gLRk1rz6bWtEdvVMmXQGLHIGOjFmwOBuNvJCNLEH"
+	oldFp, err := models.HostKeyFingerprint(oldKey)
+	s.Require().NoError(err)
+	newFp, err := models.HostKeyFingerprint(s.srv.hostKey)
+	s.Require().NoError(err)
+
+	scanHostKeys = func(ctx *context.Context, bastions []*models.Bastion, host string, port int) ([]string, error) {
+		return []string{s.srv.hostKey}, nil
+	}
+	defer func() { scanHostKeys = HostKeys }()
+
+	s.expectSession(oldKey)
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "key", "fingerprint", "trusted"}).AddRow(1, "127.0.0.1", oldKey, oldFp, true))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "agent_host_keys" SET "deleted_at"=$1`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_keys"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "127.0.0.1", "", s.srv.hostKey, newFp, false).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "agent_host_key_events"`)).WithArgs(tests.DBAnyTime{}, tests.DBAnyTime{}, nil, "127.0.0.1", models.HostKeyActionMismatch, "", oldFp, newFp).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_host_keys"`)).WillReturnRows(sqlmock.NewRows([]string{"id", "agent_host", "key", "fingerprint", "trusted"}).
+		AddRow(1, "127.0.0.1", oldKey, oldFp, true).
+		AddRow(2, "127.0.0.1", s.srv.hostKey, newFp, false),
+	)
+
+	_, err = Acquire(s.ctx, s.agent())
+	s.Equal(&HostKeyMismatchError{Host: "127.0.0.1", Trusted: []string{oldFp}, New: []string{newFp}}, err)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TestPoolInternalSuite) TestAcquireConnectTimeout() {
+	s.ctx.Cfg.SSH.ConnectTimeout = 100 * time.Millisecond
+
+	// The listener accepts the connections but never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	defer l.Close()
+
+	s.expectSession(s.srv.hostKey)
+	a := s.agent()
+	a.SSHPort = l.Addr().(*net.TCPAddr).Port
+
+	_, err = Acquire(s.ctx, a)
+	s.EqualError(err, "error opening the ssh session with the agent: error connecting to '127.0.0.1': timeout after 100ms")
+}
+
+func (s *TestPoolInternalSuite) TestAcquireMaxSessions() {
+	s.ctx.Cfg.SSH.MaxSessions = 1
+
+	s.expectSession(s.srv.hostKey)
+	a := s.agent()
+
+	c, err := Acquire(s.ctx, a)
+	s.Require().NoError(err)
+	defer c.Release()
+
+	ctx, cancel := context.WithRequest(s.ctx, s.ctx)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = Acquire(ctx, a)
+	s.EqualError(err, "error waiting for a free ssh session with the agent: context canceled")
+}
+
+func (s *TestPoolInternalSuite) TestClientCancel() {
+	s.expectSession(s.srv.hostKey)
+	a := s.agent()
+
+	ctx, cancel := context.WithRequest(s.ctx, s.ctx)
+
+	c, err := Acquire(ctx, a)
+	s.Require().NoError(err)
+	defer c.Release()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = c.Exec("sleep")
+	s.EqualError(err, "error running the ssh operation: context canceled")
+}
+
+func (s *TestPoolInternalSuite) TestIdleTimeout() {
+	s.ctx.Cfg.SSH.KeepaliveInterval = 50 * time.Millisecond
+	s.ctx.Cfg.SSH.IdleTimeout = 100 * time.Millisecond
+
+	s.expectSession(s.srv.hostKey)
+	a := s.agent()
+
+	c, err := Acquire(s.ctx, a)
+	s.Require().NoError(err)
+	c.Release()
+
+	s.Eventually(func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+
+		_, ok := pool.sessions[a.Host]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+// testSSHServer is a minimal SSH server that runs the 'echo' and 'sleep' commands and has the SFTP subsystem
+type testSSHServer struct {
+	net.Listener
+	hostKey string
+	n       int32
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := stdSSH.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &stdSSH.ServerConfig{
+		PublicKeyCallback: func(stdSSH.ConnMetadata, stdSSH.PublicKey) (*stdSSH.Permissions, error) {
+			return nil, nil
+		},
+		PasswordCallback: func(stdSSH.ConnMetadata, []byte) (*stdSSH.Permissions, error) {
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &testSSHServer{
+		Listener: l,
+		hostKey:  "127.0.0.1 " + strings.TrimSpace(string(stdSSH.MarshalAuthorizedKey(signer.PublicKey()))),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			atomic.AddInt32(&srv.n, 1)
+			go srv.serve(conn, cfg)
+		}
+	}()
+
+	return srv
+}
+
+func (srv *testSSHServer) conns() int32 {
+	return atomic.LoadInt32(&srv.n)
+}
+
+func (srv *testSSHServer) serve(conn net.Conn, cfg *stdSSH.ServerConfig) {
+	_, chans, reqs, err := stdSSH.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go stdSSH.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for req := range chReqs {
+				switch req.Type {
+				case "pty-req":
+					req.Reply(true, nil)
+
+				case "exec":
+					req.Reply(true, nil)
+
+					cmd := string(req.Payload[4:])
+					if cmd == "sleep" {
+						continue
+					}
+
+					ch.Write([]byte(cmd))
+					status := make([]byte, 4)
+					binary.BigEndian.PutUint32(status, 0)
+					ch.SendRequest("exit-status", false, status)
+					ch.Close()
+
+				case "subsystem":
+					req.Reply(true, nil)
+
+					sftpSrv, err := sftp.NewServer(ch)
+					if err != nil {
+						ch.Close()
+						continue
+					}
+
+					go func() {
+						sftpSrv.Serve()
+						ch.Close()
+					}()
+
+				default:
+					req.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,379 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// ErrKeyNotDeployed gets returned when the new Core SSH key couldn't be deployed to all the agents during a rotation
+var ErrKeyNotDeployed = errors.New("error rotating the Core SSH key: the new key couldn't be deployed to all the agents")
+
+// RotateKey generates a new Core SSH key pair and deploys the new public key to all the agents that are managed through SSH
+// and to the bastions that use the Core SSH key, and then the previous public key gets removed from them. If the new key can't
+// be deployed to all of them, the rotation is reverted, unless it's partial: then the new key replaces the current one and the
+// previous key pair is kept (and used along with the new one) until it has been replaced in all the hosts. If there's already
+// a partial rotation pending, the rotation is resumed instead of generating a new key. It returns the result of each agent and
+// bastion host and the hosts that still have the previous key authorized
+func RotateKey(ctx *context.Context, partial bool) (map[string]error, []string, error) {
+	resume, err := afero.Exists(ctx.FS, prevKeyPath(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking the previous Core SSH key: %v", err)
+	}
+
+	var oldPub, newPub []byte
+	if resume {
+		if oldPub, err = afero.ReadFile(ctx.FS, prevKeyPath(ctx)+".pub"); err != nil {
+			return nil, nil, fmt.Errorf("error reading the previous Core SSH public key: %v", err)
+		}
+
+		if newPub, err = PublicKey(ctx); err != nil {
+			return nil, nil, err
+		}
+
+	} else {
+		if oldPub, err = PublicKey(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		if err := generateKeyPair(ctx, newKeyPath(ctx)); err != nil {
+			return nil, nil, fmt.Errorf("error generating the new Core SSH key: %v", err)
+		}
+
+		if newPub, err = afero.ReadFile(ctx.FS, newKeyPath(ctx)+".pub"); err != nil {
+			return nil, nil, fmt.Errorf("error reading the new Core SSH public key: %v", err)
+		}
+	}
+
+	d, err := deployAll(ctx, newPub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !resume {
+		if len(d.failed) != 0 && !partial {
+			d.revert(ctx, newPub)
+
+			if err := removeKeyPair(ctx, newKeyPath(ctx)); err != nil {
+				log.Warnf("error removing the new Core SSH key: %v", err)
+			}
+
+			return d.results, nil, ErrKeyNotDeployed
+		}
+
+		if err := switchKeys(ctx); err != nil {
+			return d.results, nil, err
+		}
+	}
+
+	pending := d.retire(ctx, oldPub)
+	sort.Strings(pending)
+
+	if len(pending) == 0 {
+		if err := removeKeyPair(ctx, prevKeyPath(ctx)); err != nil {
+			log.Warnf("error removing the previous Core SSH key: %v", err)
+		}
+	}
+
+	return d.results, pending, nil
+}
+
+// deployment is the deployment of a public key to the agents and the bastions
+type deployment struct {
+	results  map[string]error
+	failed   []string
+	agents   []*models.Agent
+	bastions []*models.Bastion
+}
+
+// deployAll deploys the public key to all the agents that are managed through SSH and to the bastions that use the Core SSH key
+func deployAll(ctx *context.Context, pub []byte) (*deployment, error) {
+	agents, err := models.AgentList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bastions, err := models.BastionList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &deployment{results: map[string]error{}}
+
+	// The bastions have to be deployed first, since the agents behind them are reached using the current key
+	for _, b := range bastions {
+		// The bastion uses its own key
+		if b.KeyPath != "" {
+			continue
+		}
+
+		if err := deployBastionKey(ctx, b, pub); err != nil {
+			d.results[b.Host] = err
+			d.failed = append(d.failed, b.Host)
+			continue
+		}
+
+		d.results[b.Host] = nil
+		d.bastions = append(d.bastions, b)
+	}
+
+	for _, a := range agents {
+		if err := a.LoadHostKeys(ctx); err != nil {
+			d.results[a.Host] = err
+			d.failed = append(d.failed, a.Host)
+			continue
+		}
+
+		// The agent isn't managed through SSH
+		if len(a.TrustedHostKeys()) == 0 {
+			continue
+		}
+
+		if err := deployKey(ctx, privKeyPath(ctx), a, pub); err != nil {
+			d.results[a.Host] = err
+			d.failed = append(d.failed, a.Host)
+			continue
+		}
+
+		d.results[a.Host] = nil
+		d.agents = append(d.agents, a)
+	}
+
+	return d, nil
+}
+
+// revert removes the deployed public key from the agents and the bastions
+func (d *deployment) revert(ctx *context.Context, pub []byte) {
+	for _, a := range d.agents {
+		if err := removeKey(ctx, privKeyPath(ctx), a, pub); err != nil {
+			log.Warnf("error removing the new Core SSH key from the agent '%s': %v", a.Host, err)
+		}
+	}
+
+	for _, b := range d.bastions {
+		if err := removeBastionKey(ctx, b, pub); err != nil {
+			log.Warnf("error removing the new Core SSH key from the bastion '%s': %v", b.Name, err)
+		}
+	}
+}
+
+// retire removes the previous public key from the agents and the bastions where the new one has been deployed. It returns
+// the hosts that still have the previous key authorized
+func (d *deployment) retire(ctx *context.Context, pub []byte) []string {
+	pending := append([]string{}, d.failed...)
+
+	for _, a := range d.agents {
+		if err := removeKey(ctx, privKeyPath(ctx), a, pub); err != nil {
+			log.Warnf("error removing the previous Core SSH key from the agent '%s': %v", a.Host, err)
+			pending = append(pending, a.Host)
+		}
+	}
+
+	for _, b := range d.bastions {
+		if err := removeBastionKey(ctx, b, pub); err != nil {
+			log.Warnf("error removing the previous Core SSH key from the bastion '%s': %v", b.Name, err)
+			pending = append(pending, b.Host)
+		}
+	}
+
+	return pending
+}
+
+// switchKeys replaces the current Core SSH key pair with the new one. The current key pair is kept as the previous one
+func switchKeys(ctx *context.Context) error {
+	for _, ext := range []string{"", ".pub"} {
+		if err := ctx.FS.Rename(privKeyPath(ctx)+ext, prevKeyPath(ctx)+ext); err != nil {
+			return fmt.Errorf("error keeping the previous Core SSH key: %v", err)
+		}
+	}
+
+	for _, ext := range []string{"", ".pub"} {
+		if err := ctx.FS.Rename(newKeyPath(ctx)+ext, privKeyPath(ctx)+ext); err != nil {
+			return fmt.Errorf("error replacing the Core SSH key: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// deployKey adds the public key to the authorized keys of the agent SSH user
+func deployKey(ctx *context.Context, path string, a *models.Agent, pub []byte) error {
+	bastions, err := models.AgentBastionChain(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSessionWithKey(ctx, path, bastions, a.Host, a.SSHPort, a.SSHUser, trustedKeys(a))
+	if err != nil {
+		return fmt.Errorf("error opening the ssh session with the agent: %v", err)
+	}
+	defer s.Close()
+
+	return authorizeKey(s.client(), a.OS, a.SSHUser, pub)
+}
+
+// removeKey removes the public key from the authorized keys of the agent SSH user
+func removeKey(ctx *context.Context, path string, a *models.Agent, pub []byte) error {
+	bastions, err := models.AgentBastionChain(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSessionWithKey(ctx, path, bastions, a.Host, a.SSHPort, a.SSHUser, trustedKeys(a))
+	if err != nil {
+		return fmt.Errorf("error opening the ssh session with the agent: %v", err)
+	}
+	defer s.Close()
+
+	return removeAuthorizedKey(s.client(), a.OS, a.SSHUser, pub)
+}
+
+// deployBastionKey adds the public key to the authorized keys of the bastion SSH user
+func deployBastionKey(ctx *context.Context, b *models.Bastion, pub []byte) error {
+	s, err := bastionSession(ctx, b)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	c := s.client()
+	o, err := os.DetectOS(c)
+	if err != nil {
+		return err
+	}
+
+	return authorizeKey(c, o, b.User, pub)
+}
+
+// removeBastionKey removes the public key from the authorized keys of the bastion SSH user
+func removeBastionKey(ctx *context.Context, b *models.Bastion, pub []byte) error {
+	s, err := bastionSession(ctx, b)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	c := s.client()
+	o, err := os.DetectOS(c)
+	if err != nil {
+		return err
+	}
+
+	return removeAuthorizedKey(c, o, b.User, pub)
+}
+
+// bastionSession opens a new SSH session with a bastion using the Core SSH key, through the bastions that reach it
+func bastionSession(ctx *context.Context, b *models.Bastion) (*Session, error) {
+	chain := []*models.Bastion{}
+	if b.Via != "" {
+		var err error
+		chain, err = models.BastionChain(ctx, models.LabelSelector{models.BastionLabel: b.Via})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.LoadHostKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	s, err := NewSession(ctx, chain, b.Host, b.Port, b.User, b.Keys())
+	if err != nil {
+		return nil, fmt.Errorf("error opening the ssh session with the bastion: %v", err)
+	}
+
+	return s, nil
+}
+
+// authorizeKey adds a public key to the authorized_keys file of an user, unless it's already there (e.g. the rotation is being resumed)
+func authorizeKey(c client.Client, o os.OS, usr string, pub []byte) error {
+	k := strings.Fields(string(pub))
+	if len(k) < 2 {
+		return errors.New("error adding the authorized key: invalid public key")
+	}
+
+	home, err := o.CmdFSHome(c, usr)
+	if err != nil {
+		return fmt.Errorf("error adding the authorized key: %v", err)
+	}
+
+	authKeys := filepath.Join(home, ".ssh", "authorized_keys")
+	exists, err := c.Exists(authKeys)
+	if err != nil {
+		return fmt.Errorf("error checking for the authorized_keys file: %v", err)
+	}
+
+	if exists {
+		b, err := c.ReadFile(authKeys)
+		if err != nil {
+			return fmt.Errorf("error reading the authorized_keys file: %v", err)
+		}
+
+		for _, l := range strings.Split(string(b), "\n") {
+			if f := strings.Fields(l); len(f) >= 2 && f[1] == k[1] {
+				return nil
+			}
+		}
+	}
+
+	return o.CmdSSHCopyID(c, usr, pub)
+}
+
+// removeAuthorizedKey removes a public key from the authorized_keys file of an user
+func removeAuthorizedKey(c client.Client, o os.OS, usr string, pub []byte) error {
+	k := strings.Fields(string(pub))
+	if len(k) < 2 {
+		return errors.New("error removing the authorized key: invalid public key")
+	}
+
+	home, err := o.CmdFSHome(c, usr)
+	if err != nil {
+		return fmt.Errorf("error removing the authorized key: %v", err)
+	}
+
+	authKeys := filepath.Join(home, ".ssh", "authorized_keys")
+	b, err := c.ReadFile(authKeys)
+	if err != nil {
+		return fmt.Errorf("error reading the authorized_keys file: %v", err)
+	}
+
+	keys := []string{}
+	for _, l := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(l); len(f) >= 2 && f[1] == k[1] {
+			continue
+		}
+
+		keys = append(keys, l)
+	}
+
+	if err := c.Write(authKeys, []byte(strings.Join(keys, "\n"))); err != nil {
+		return fmt.Errorf("error writting the authorized_keys file: %v", err)
+	}
+
+	if err := c.Chmod(authKeys, 0600); err != nil {
+		return fmt.Errorf("error changing the authorized_keys permissions: %v", err)
+	}
+
+	return nil
+}
+
+// removeKeyPair removes a key pair
+func removeKeyPair(ctx *context.Context, path string) error {
+	if err := ctx.FS.Remove(path); err != nil {
+		return err
+	}
+
+	return ctx.FS.Remove(path + ".pub")
+}
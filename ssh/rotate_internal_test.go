@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestRotateInternalSuite struct {
+	suite.Suite
+}
+
+func TestRotateInternal(t *testing.T) {
+	suite.Run(t, &TestRotateInternalSuite{})
+}
+
+func (s *TestRotateInternalSuite) TestAuthorizeKey() {
+	s.Run("should not add the key if it's already authorized", func() {
+		c := &tests.OSClientMock{
+			Out: map[string]string{"getent passwd drlm": "drlm:x:1000:1000::/home/drlm:/bin/bash\n"},
+			Files: map[string]string{
+				"/home/drlm/.ssh/authorized_keys": "ssh-rsa AAAAother user@laptop\nssh-ed25519 AAAAnew drlm-core\n",
+			},
+		}
+
+		s.NoError(authorizeKey(c, os.Linux, "drlm", []byte("ssh-ed25519 AAAAnew drlm-core\n")))
+		s.Equal([]string{"getent passwd drlm"}, c.Execs)
+		s.Equal("ssh-rsa AAAAother user@laptop\nssh-ed25519 AAAAnew drlm-core\n", c.Files["/home/drlm/.ssh/authorized_keys"])
+	})
+
+	s.Run("should return an error if the key is invalid", func() {
+		s.EqualError(authorizeKey(&tests.OSClientMock{}, os.Linux, "drlm", []byte("invalid")), "error adding the authorized key: invalid public key")
+	})
+}
+
+func (s *TestRotateInternalSuite) TestSwitchKeys() {
+	s.Run("should keep the current key pair as the previous one and replace it with the new one", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		for path, content := range map[string]string{
+			"ssh/id_core": "current", "ssh/id_core.pub": "current.pub",
+			"ssh/id_core.new": "new", "ssh/id_core.new.pub": "new.pub",
+		} {
+			s.Require().NoError(afero.WriteFile(ctx.FS, path, []byte(content), 0600))
+		}
+
+		s.NoError(switchKeys(ctx))
+
+		for path, content := range map[string]string{
+			"ssh/id_core": "new", "ssh/id_core.pub": "new.pub",
+			"ssh/id_core.prev": "current", "ssh/id_core.prev.pub": "current.pub",
+		} {
+			b, err := afero.ReadFile(ctx.FS, path)
+			s.NoError(err)
+			s.Equal(content, string(b))
+		}
+
+		exists, err := afero.Exists(ctx.FS, "ssh/id_core.new")
+		s.NoError(err)
+		s.False(exists)
+	})
+
+	s.Run("should return an error if there's no new key pair", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		s.Require().NoError(afero.WriteFile(ctx.FS, "ssh/id_core", []byte("current"), 0600))
+		s.Require().NoError(afero.WriteFile(ctx.FS, "ssh/id_core.pub", []byte("current.pub"), 0600))
+
+		s.EqualError(switchKeys(ctx), "error replacing the Core SSH key: rename ssh/id_core.new: file does not exist")
+	})
+}
+
+func (s *TestRotateInternalSuite) TestSigners() {
+	s.Run("should return the previous key signer if there's a partial rotation pending", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		s.Require().NoError(generateKeyPair(ctx, privKeyPath(ctx)))
+		s.Require().NoError(generateKeyPair(ctx, prevKeyPath(ctx)))
+
+		signers, err := signers(ctx, privKeyPath(ctx), "Core SSH private key")
+		s.NoError(err)
+		s.Len(signers, 2)
+	})
+
+	s.Run("should only return the key signer if there's no partial rotation pending", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		s.Require().NoError(generateKeyPair(ctx, privKeyPath(ctx)))
+
+		signers, err := signers(ctx, privKeyPath(ctx), "Core SSH private key")
+		s.NoError(err)
+		s.Len(signers, 1)
+	})
+}
+
+func (s *TestRotateInternalSuite) TestRemoveAuthorizedKey() {
+	s.Run("should remove the key from the authorized_keys", func() {
+		c := &tests.OSClientMock{
+			Out: map[string]string{"getent passwd drlm": "drlm:x:1000:1000::/home/drlm:/bin/bash\n"},
+			Files: map[string]string{
+				"/home/drlm/.ssh/authorized_keys": "ssh-ed25519 AAAAold drlm-core\nssh-rsa AAAAother user@laptop\nssh-ed25519 AAAAnew drlm-core\n",
+			},
+		}
+
+		s.NoError(removeAuthorizedKey(c, os.Linux, "drlm", []byte("ssh-ed25519 AAAAold drlm-core\n")))
+		s.Equal("ssh-rsa AAAAother user@laptop\nssh-ed25519 AAAAnew drlm-core\n", c.Files["/home/drlm/.ssh/authorized_keys"])
+	})
+
+	s.Run("should return an error if the key is invalid", func() {
+		s.EqualError(removeAuthorizedKey(&tests.OSClientMock{}, os.Linux, "drlm", []byte("invalid")), "error removing the authorized key: invalid public key")
+	})
+
+	s.Run("should return an error if there's an error reading the authorized_keys", func() {
+		c := &tests.OSClientMock{Out: map[string]string{"getent passwd drlm": "drlm:x:1000:1000::/home/drlm:/bin/bash\n"}}
+
+		s.EqualError(removeAuthorizedKey(c, os.Linux, "drlm", []byte("ssh-ed25519 AAAAold drlm-core")), "error reading the authorized_keys file: file not found")
+	})
+}
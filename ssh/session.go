@@ -0,0 +1,504 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"bytes"
+	stdContext "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brainupdaters/drlm-core/context"
+	"github.com/brainupdaters/drlm-core/models"
+
+	"github.com/brainupdaters/drlm-common/pkg/os"
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+	cmnSSH "github.com/brainupdaters/drlm-common/pkg/ssh"
+	"github.com/pkg/sftp"
+	stdSSH "golang.org/x/crypto/ssh"
+)
+
+// Session is an SSH session with a host, which might be reached through a chain of bastions
+type Session struct {
+	conn     *stdSSH.Client
+	SFTP     *sftp.Client
+	bastions []*stdSSH.Client
+}
+
+// Close closes the session and the connections with the bastions
+func (s *Session) Close() error {
+	s.SFTP.Close()
+	err := s.conn.Close()
+
+	closeClients(s.bastions)
+
+	return err
+}
+
+// NewSession opens a new SSH session with a host using the Core SSH key, through the chain of bastions
+func NewSession(ctx *context.Context, bastions []*models.Bastion, host string, port int, usr string, hostKeys []string) (*Session, error) {
+	return newSessionWithKey(ctx, privKeyPath(ctx), bastions, host, port, usr, hostKeys)
+}
+
+// NewSessionWithPassword opens a new SSH session with a host using a password, through the chain of bastions
+func NewSessionWithPassword(ctx *context.Context, bastions []*models.Bastion, host string, port int, usr, pwd string, hostKeys []string) (*Session, error) {
+	return newSession(ctx, bastions, host, port, usr, []stdSSH.AuthMethod{stdSSH.Password(pwd)}, hostKeys)
+}
+
+// NewSessionWithCredentials opens a new SSH session with a host using the credentials, through the chain of bastions
+func NewSessionWithCredentials(ctx *context.Context, bastions []*models.Bastion, host string, port int, creds Credentials, hostKeys []string) (*Session, error) {
+	auth, closer, err := creds.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	return newSession(ctx, bastions, host, port, creds.User, auth, hostKeys)
+}
+
+// ErrInvalidHost gets returned when the host isn't a valid host name nor IP address
+var ErrInvalidHost = errors.New("invalid host: it has to be a host name or an IP address")
+
+// hostNameRgx matches the valid host names (RFC 1123)
+var hostNameRgx = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+// validHost checks whether the host is a valid host name or IP address
+func validHost(host string) bool {
+	return net.ParseIP(host) != nil || (len(host) <= 253 && hostNameRgx.MatchString(host))
+}
+
+// HostKeys returns the SSH host keys of a host. If there are bastions, the keys are retrieved from the last bastion of the chain.
+// Retrieving the keys fails if it takes longer than the command timeout or the context gets cancelled
+func HostKeys(ctx *context.Context, bastions []*models.Bastion, host string, port int) ([]string, error) {
+	if !validHost(host) {
+		return nil, ErrInvalidHost
+	}
+
+	cmdCtx, cancel := commandContext(ctx)
+	defer cancel()
+
+	if len(bastions) == 0 {
+		o, err := detectLocalOS(&client.Local{})
+		if err != nil {
+			return nil, err
+		}
+
+		return o.CmdSSHGetHostKeys(&localClient{ctx: cmdCtx}, host, port)
+	}
+
+	clients, err := dialBastions(ctx, nil, bastions)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClients(clients)
+
+	s, err := clients[len(clients)-1].NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("error creating the SSH session with the bastion: %v", err)
+	}
+	defer s.Close()
+
+	var out bytes.Buffer
+	s.Stdout = &out
+
+	if err := runSession(s, fmt.Sprintf("ssh-keyscan -p %d %s", port, shellQuote(host)), cmdCtx.Done()); err != nil {
+		if cmdCtx.Err() != nil {
+			err = cmdCtx.Err()
+		}
+
+		return nil, fmt.Errorf("error getting the host SSH keys: %v", err)
+	}
+
+	var keys []string
+	for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if l != "" && !strings.HasPrefix(l, "#") {
+			keys = append(keys, l)
+		}
+	}
+
+	return keys, nil
+}
+
+// commandContext returns a context that gets cancelled when the context gets cancelled or after the command timeout
+func commandContext(ctx *context.Context) (stdContext.Context, stdContext.CancelFunc) {
+	if ctx.Cfg.SSH.CommandTimeout == 0 {
+		return stdContext.WithCancel(ctx)
+	}
+
+	return stdContext.WithTimeout(ctx, ctx.Cfg.SSH.CommandTimeout)
+}
+
+// localClient is a client.Local whose commands get killed once the context is done
+type localClient struct {
+	client.Local
+	ctx stdContext.Context
+}
+
+// Exec implements client.Client.Exec
+func (c *localClient) Exec(name string, arg ...string) ([]byte, error) {
+	cmd := exec.CommandContext(c.ctx, name, arg...)
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if c.ctx.Err() != nil {
+			return stdout.Bytes(), c.ctx.Err()
+		}
+
+		return stdout.Bytes(), fmt.Errorf("%v: %s", err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+var localOS struct {
+	sync.Mutex
+	os       os.OS
+	detected bool
+}
+
+// detectLocalOS detects the OS of the Core host. The OS is only detected once
+func detectLocalOS(c client.Client) (os.OS, error) {
+	localOS.Lock()
+	defer localOS.Unlock()
+
+	if !localOS.detected {
+		o, err := os.DetectOS(c)
+		if err != nil {
+			return o, err
+		}
+
+		localOS.os = o
+		localOS.detected = true
+	}
+
+	return localOS.os, nil
+}
+
+// newSessionWithKey opens a new SSH session with a host using the private key of the path, through the chain of bastions
+func newSessionWithKey(ctx *context.Context, path string, bastions []*models.Bastion, host string, port int, usr string, hostKeys []string) (*Session, error) {
+	signers, err := signers(ctx, path, "Core SSH private key")
+	if err != nil {
+		return nil, err
+	}
+
+	return newSession(ctx, bastions, host, port, usr, []stdSSH.AuthMethod{stdSSH.PublicKeys(signers...)}, hostKeys)
+}
+
+// newSession connects to the chain of bastions and opens the session with the host through them. If connecting takes
+// longer than the connect timeout or the context gets cancelled, all the connections get closed
+func newSession(ctx *context.Context, bastions []*models.Bastion, host string, port int, usr string, auth []stdSSH.AuthMethod, hostKeys []string) (*Session, error) {
+	var hk []stdSSH.PublicKey
+	for _, k := range hostKeys {
+		_, _, h, _, _, err := stdSSH.ParseKnownHosts([]byte(k))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the host public key: %v", err)
+		}
+
+		hk = append(hk, h)
+	}
+
+	cfg := &stdSSH.ClientConfig{
+		User:            usr,
+		Auth:            auth,
+		HostKeyCallback: cmnSSH.MultipleFixedHostKeys(hk),
+		Timeout:         ctx.Cfg.SSH.ConnectTimeout,
+	}
+
+	type result struct {
+		s   *Session
+		err error
+	}
+
+	t := &tracker{}
+	rslt := make(chan result, 1)
+
+	go func() {
+		s, err := connect(ctx, t, bastions, net.JoinHostPort(host, strconv.Itoa(port)), cfg)
+		rslt <- result{s, err}
+	}()
+
+	var timeout <-chan time.Time
+	if ctx.Cfg.SSH.ConnectTimeout != 0 {
+		timer := time.NewTimer(ctx.Cfg.SSH.ConnectTimeout)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case r := <-rslt:
+		return r.s, r.err
+
+	case <-timeout:
+		err = fmt.Errorf("error connecting to '%s': timeout after %s", host, ctx.Cfg.SSH.ConnectTimeout)
+
+	case <-ctx.Done():
+		err = fmt.Errorf("error connecting to '%s': %v", host, ctx.Err())
+	}
+
+	t.abort()
+	go func() {
+		if r := <-rslt; r.err == nil {
+			r.s.Close()
+		}
+	}()
+
+	return nil, err
+}
+
+// connect does the actual connection of newSession
+func connect(ctx *context.Context, t *tracker, bastions []*models.Bastion, addr string, cfg *stdSSH.ClientConfig) (*Session, error) {
+	var d dialer = &net.Dialer{
+		Timeout:   ctx.Cfg.SSH.ConnectTimeout,
+		KeepAlive: ctx.Cfg.SSH.KeepaliveInterval,
+	}
+
+	var clients []*stdSSH.Client
+	if len(bastions) != 0 {
+		var err error
+		clients, err = dialBastions(ctx, t, bastions)
+		if err != nil {
+			return nil, err
+		}
+
+		d = clients[len(clients)-1]
+	}
+
+	conn, err := dial(d, t, addr, cfg)
+	if err != nil {
+		closeClients(clients)
+		return nil, fmt.Errorf("error dialing the host: %v", err)
+	}
+
+	cli, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		closeClients(clients)
+		return nil, fmt.Errorf("error creating the SFTP client: %v", err)
+	}
+
+	return &Session{conn: conn, SFTP: cli, bastions: clients}, nil
+}
+
+// dialBastions connects to each bastion of the chain through the previous one
+func dialBastions(ctx *context.Context, t *tracker, bastions []*models.Bastion) ([]*stdSSH.Client, error) {
+	clients := []*stdSSH.Client{}
+
+	for _, b := range bastions {
+		cfg, err := bastionConfig(ctx, b)
+		if err != nil {
+			closeClients(clients)
+			return nil, err
+		}
+
+		var d dialer = &net.Dialer{Timeout: cfg.Timeout, KeepAlive: ctx.Cfg.SSH.KeepaliveInterval}
+		if len(clients) != 0 {
+			d = clients[len(clients)-1]
+		}
+
+		c, err := dial(d, t, net.JoinHostPort(b.Host, strconv.Itoa(b.Port)), cfg)
+		if err != nil {
+			closeClients(clients)
+			return nil, fmt.Errorf("error connecting to the bastion '%s': %v", b.Name, err)
+		}
+
+		clients = append(clients, c)
+	}
+
+	return clients, nil
+}
+
+// bastionConfig returns the SSH client configuration of a bastion
+func bastionConfig(ctx *context.Context, b *models.Bastion) (*stdSSH.ClientConfig, error) {
+	path := b.KeyPath
+	if path == "" {
+		path = privKeyPath(ctx)
+	}
+
+	signers, err := signers(ctx, path, fmt.Sprintf("bastion '%s' private key", b.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b.Keys()) == 0 {
+		return nil, fmt.Errorf("error connecting to the bastion '%s': the bastion has no host keys", b.Name)
+	}
+
+	var hk []stdSSH.PublicKey
+	for _, k := range b.Keys() {
+		_, _, h, _, _, err := stdSSH.ParseKnownHosts([]byte(k))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the bastion '%s' host key: %v", b.Name, err)
+		}
+
+		hk = append(hk, h)
+	}
+
+	return &stdSSH.ClientConfig{
+		User:            b.User,
+		Auth:            []stdSSH.AuthMethod{stdSSH.PublicKeys(signers...)},
+		HostKeyCallback: cmnSSH.MultipleFixedHostKeys(hk),
+		Timeout:         ctx.Cfg.SSH.ConnectTimeout,
+	}, nil
+}
+
+type dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// dial connects to the address through the dialer and does the SSH handshake
+func dial(d dialer, t *tracker, addr string, cfg *stdSSH.ClientConfig) (*stdSSH.Client, error) {
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !t.track(conn) {
+		return nil, errAborted
+	}
+
+	sshConn, chans, reqs, err := stdSSH.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return stdSSH.NewClient(sshConn, chans, reqs), nil
+}
+
+// errAborted gets returned when a connection gets opened after connecting has been aborted
+var errAborted = errors.New("connection aborted")
+
+// tracker keeps the connections opened while connecting to a host, so they can be closed if connecting gets aborted
+type tracker struct {
+	mu      sync.Mutex
+	conns   []io.Closer
+	aborted bool
+}
+
+// track adds a connection to the tracker. If connecting has been aborted, the connection gets closed and it returns false
+func (t *tracker) track(c io.Closer) bool {
+	if t == nil {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.aborted {
+		c.Close()
+		return false
+	}
+
+	t.conns = append(t.conns, c)
+	return true
+}
+
+// abort closes all the tracked connections
+func (t *tracker) abort() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.aborted = true
+	for _, c := range t.conns {
+		c.Close()
+	}
+}
+
+// keepalive checks that the connections with the bastions and the host are still alive
+func (s *Session) keepalive() error {
+	for _, b := range s.bastions {
+		if _, _, err := b.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			return fmt.Errorf("error sending the keepalive to the bastion: %v", err)
+		}
+	}
+
+	if _, _, err := s.conn.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		return fmt.Errorf("error sending the keepalive: %v", err)
+	}
+
+	return nil
+}
+
+// Exec executes a command in a PTY and returns its output
+func (s *Session) Exec(cmd string) ([]byte, error) {
+	return s.run(cmd, nil, true, nil)
+}
+
+// run executes a command, with the input as stdin, and returns its output. The stderr is added to the error. If the abort
+// channel gets closed, the command gets killed
+func (s *Session) run(cmd string, stdin io.Reader, pty bool, abort <-chan struct{}) ([]byte, error) {
+	sshSess, err := s.conn.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("error creating the SSH session: %v", err)
+	}
+	defer sshSess.Close()
+
+	if pty {
+		modes := stdSSH.TerminalModes{
+			stdSSH.ECHO:          0,
+			stdSSH.TTY_OP_ISPEED: 14400,
+			stdSSH.TTY_OP_OSPEED: 14400,
+		}
+
+		if err := sshSess.RequestPty("xterm", 80, 40, modes); err != nil {
+			return nil, fmt.Errorf("error requesting the PTY: %v", err)
+		}
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	sshSess.Stdin = stdin
+	sshSess.Stdout = &stdout
+	sshSess.Stderr = &stderr
+
+	if err := runSession(sshSess, cmd, abort); err != nil {
+		return stdout.Bytes(), fmt.Errorf("%v: %s", err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// runSession runs the command in the SSH session. If the abort channel gets closed, the command gets killed and the SSH
+// session (only this one, not the connection) gets closed
+func runSession(sshSess *stdSSH.Session, cmd string, abort <-chan struct{}) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- sshSess.Run(cmd)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+
+	case <-abort:
+		sshSess.Signal(stdSSH.SIGKILL)
+		sshSess.Close()
+		<-done
+
+		return errAborted
+	}
+}
+
+func closeClients(clients []*stdSSH.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/models"
+	"github.com/brainupdaters/drlm-core/utils/tests"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	stdSSH "golang.org/x/crypto/ssh"
+)
+
+type TestSessionInternalSuite struct {
+	suite.Suite
+}
+
+func TestSessionInternal(t *testing.T) {
+	suite.Run(t, &TestSessionInternalSuite{})
+}
+
+type netDialer struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (d *netDialer) Dial(network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.addrs = append(d.addrs, addr)
+	d.mu.Unlock()
+
+	return net.Dial(network, addr)
+}
+
+func (s *TestSessionInternalSuite) TestDial() {
+	srv := newTestSSHServer(s.T())
+	defer srv.Close()
+
+	_, _, hk, _, _, err := stdSSH.ParseKnownHosts([]byte(srv.hostKey))
+	s.Require().NoError(err)
+
+	cfg := &stdSSH.ClientConfig{
+		User:            "drlm",
+		Auth:            []stdSSH.AuthMethod{stdSSH.Password("drlm")},
+		HostKeyCallback: stdSSH.FixedHostKey(hk),
+	}
+
+	s.Run("should connect through the dialer", func() {
+		d := &netDialer{}
+		c, err := dial(d, nil, srv.Addr().String(), cfg)
+		s.Require().NoError(err)
+		defer c.Close()
+
+		d.mu.Lock()
+		s.Equal([]string{srv.Addr().String()}, d.addrs)
+		d.mu.Unlock()
+	})
+
+	s.Run("should return an error if connecting has been aborted", func() {
+		t := &tracker{}
+		t.abort()
+
+		_, err := dial(&netDialer{}, t, srv.Addr().String(), cfg)
+		s.Equal(errAborted, err)
+	})
+}
+
+func (s *TestSessionInternalSuite) TestTracker() {
+	s.Run("should close the tracked connections and the new ones once aborted", func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		s.Require().NoError(err)
+
+		t := &tracker{}
+		s.True(t.track(l))
+
+		t.abort()
+
+		_, err = l.Accept()
+		s.Error(err)
+
+		l2, err := net.Listen("tcp", "127.0.0.1:0")
+		s.Require().NoError(err)
+
+		s.False(t.track(l2))
+
+		_, err = l2.Accept()
+		s.Error(err)
+	})
+}
+
+func (s *TestSessionInternalSuite) TestBastionConfig() {
+	ctx := tests.GenerateCtx()
+	tests.GenerateCfg(s.T(), ctx)
+	ctx.FS = afero.NewMemMapFs()
+	s.Require().NoError(generateKeyPair(ctx, privKeyPath(ctx)))
+
+	s.Run("should use the Core SSH key if the bastion has no key", func() {
+		cfg, err := bastionConfig(ctx, &models.Bastion{
+			Name:     "dmz",
+			User:     "jump",
+			HostKeys: []*models.AgentHostKey{{Key: "dmz.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBL1gLRk1rz6bWtEdvVMmXQGLHIGOjFmwOBuNvJCNLEH"}},
+		})
+		s.NoError(err)
+		s.Equal("jump", cfg.User)
+		s.Len(cfg.Auth, 1)
+	})
+
+	s.Run("should return an error if the bastion key can't be read", func() {
+		_, err := bastionConfig(ctx, &models.Bastion{Name: "dmz", KeyPath: "/nonexisting"})
+		s.EqualError(err, "error reading the bastion 'dmz' private key: open /nonexisting: file does not exist")
+	})
+
+	s.Run("should return an error if a bastion host key is invalid", func() {
+		_, err := bastionConfig(ctx, &models.Bastion{Name: "dmz", HostKeys: []*models.AgentHostKey{{Key: "invalid"}}})
+		s.Error(err)
+		s.Contains(err.Error(), "error parsing the bastion 'dmz' host key")
+	})
+
+	s.Run("should return an error if the bastion has no host keys", func() {
+		_, err := bastionConfig(ctx, &models.Bastion{Name: "dmz"})
+		s.EqualError(err, "error connecting to the bastion 'dmz': the bastion has no host keys")
+	})
+}
+
+func (s *TestSessionInternalSuite) TestHostKeys() {
+	ctx := tests.GenerateCtx()
+	tests.GenerateCfg(s.T(), ctx)
+	ctx.FS = afero.NewMemMapFs()
+	s.Require().NoError(generateKeyPair(ctx, privKeyPath(ctx)))
+
+	s.Run("should scan the host keys through the bastion, quoting the host", func() {
+		srv := newTestSSHServer(s.T())
+		defer srv.Close()
+
+		host, port, err := net.SplitHostPort(srv.Addr().String())
+		s.Require().NoError(err)
+
+		p, err := net.LookupPort("tcp", port)
+		s.Require().NoError(err)
+
+		// The test server replies with the command that it has received
+		keys, err := HostKeys(ctx, []*models.Bastion{{Name: "dmz", Host: host, Port: p, User: "jump", HostKeys: []*models.AgentHostKey{{Key: srv.hostKey}}}}, "laptop.example.com", 22)
+		s.NoError(err)
+		s.Equal([]string{"ssh-keyscan -p 22 'laptop.example.com'"}, keys)
+	})
+
+	s.Run("should return an error if the host isn't a host name or an IP address", func() {
+		for _, h := range []string{"", "laptop; rm -rf /", "$(id)", "-oProxyCommand=id", "laptop..example.com", "laptop.example.com "} {
+			_, err := HostKeys(ctx, nil, h, 22)
+			s.Equal(ErrInvalidHost, err, h)
+		}
+	})
+}
+
+func (s *TestSessionInternalSuite) TestValidHost() {
+	for _, h := range []string{"laptop", "laptop.example.com", "192.168.1.61", "::1", "fe80::1"} {
+		s.True(validHost(h), h)
+	}
+}
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/brainupdaters/drlm-common/pkg/os/client"
+	"github.com/pkg/sftp"
+)
+
+// sftpClient is a client.Client that runs the commands through the SSH session and the file operations through SFTP. If
+// the abort channel gets closed, the running command gets killed
+type sftpClient struct {
+	s     *Session
+	sftp  *sftp.Client
+	abort <-chan struct{}
+}
+
+// client returns a client.Client of the session
+func (s *Session) client() client.Client {
+	return &sftpClient{s: s, sftp: s.SFTP}
+}
+
+// Exec implements client.Client.Exec
+func (c *sftpClient) Exec(name string, arg ...string) ([]byte, error) {
+	cmd := append([]string{name}, arg...)
+	return c.s.run(strings.Join(cmd, " "), nil, true, c.abort)
+}
+
+// Chmod implements client.Client.Chmod
+func (c *sftpClient) Chmod(path string, mode os.FileMode) error {
+	if err := c.sftp.Chmod(path, mode); err != nil {
+		return fmt.Errorf("error changing the mode of the file: %v", err)
+	}
+
+	return nil
+}
+
+// Chown implements client.Client.Chown
+func (c *sftpClient) Chown(path string, uid, gid int) error {
+	if err := c.sftp.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("error changing the owners of the file: %v", err)
+	}
+
+	return nil
+}
+
+// Exists implements client.Client.Exists
+func (c *sftpClient) Exists(path string) (bool, error) {
+	if _, err := c.sftp.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return true, fmt.Errorf("error checking the file existence: %v", err)
+	}
+
+	return true, nil
+}
+
+// MkdirAll implements client.Client.MkdirAll
+func (c *sftpClient) MkdirAll(path string, perm os.FileMode) error {
+	if err := c.sftp.MkdirAll(path); err != nil {
+		return fmt.Errorf("error creating the directory: %v", err)
+	}
+
+	if err := c.Chmod(path, perm); err != nil {
+		return fmt.Errorf("error changing the directory permissions: %v", err)
+	}
+
+	return nil
+}
+
+// Write implements client.Client.Write
+func (c *sftpClient) Write(path string, b []byte) error {
+	exists, err := c.Exists(path)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		if err := c.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	f, err := c.sftp.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating the file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("error writting the file: %v", err)
+	}
+
+	return nil
+}
+
+// Append implements client.Client.Append
+func (c *sftpClient) Append(path string, b []byte) error {
+	f, err := c.sftp.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return fmt.Errorf("error opening the file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("error writting the file: %v", err)
+	}
+
+	return nil
+}
+
+// ReadFile implements client.Client.ReadFile
+func (c *sftpClient) ReadFile(path string) ([]byte, error) {
+	f, err := c.sftp.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the file: %v", err)
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the file: %v", err)
+	}
+
+	return b, nil
+}
+
+// Remove implements client.Client.Remove
+func (c *sftpClient) Remove(path string) error {
+	if err := c.sftp.Remove(path); err != nil {
+		return fmt.Errorf("error removing the file: %v", err)
+	}
+
+	return nil
+}
+
+// Copy implements client.Client.Copy. It's recursive, tries to preserve permissions and skips symlinks
+func (c *sftpClient) Copy(src, dst string) error {
+	sF, err := c.sftp.Stat(src)
+	if err != nil {
+		return fmt.Errorf("error checking the file properties: %v", err)
+	}
+
+	if _, err := c.sftp.Stat(dst); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error checking the destination properties: %v", err)
+	} else if err == nil {
+		return errors.New("error copying the file: the destination already exists")
+	}
+
+	if sF.IsDir() {
+		if err := c.copyDir(src, dst, sF.Mode()); err != nil {
+			return fmt.Errorf("error copying the directory: %v", err)
+		}
+
+		return nil
+	}
+
+	if err := c.copyFile(src, dst, sF.Mode()); err != nil {
+		return fmt.Errorf("error copying the file: %v", err)
+	}
+
+	return nil
+}
+
+func (c *sftpClient) copyDir(src, dst string, mode os.FileMode) error {
+	if err := c.sftp.Mkdir(dst); err != nil {
+		return fmt.Errorf("error creating the directory: %v", err)
+	}
+
+	if err := c.sftp.Chmod(dst, mode); err != nil {
+		return fmt.Errorf("error changing the directory permissions: %v", err)
+	}
+
+	entries, err := c.sftp.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("error checking the directory items: %v", err)
+	}
+
+	for _, e := range entries {
+		srcPath := path.Join(src, e.Name())
+		dstPath := path.Join(dst, e.Name())
+
+		switch {
+		case e.IsDir():
+			err = c.copyDir(srcPath, dstPath, e.Mode())
+
+		case e.Mode()&os.ModeSymlink != 0:
+			continue
+
+		default:
+			err = c.copyFile(srcPath, dstPath, e.Mode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *sftpClient) copyFile(src, dst string, mode os.FileMode) error {
+	sF, err := c.sftp.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening the file: %v", err)
+	}
+	defer sF.Close()
+
+	dF, err := c.sftp.Create(dst)
+	if err != nil {
+		return fmt.Errorf("error creating the file: %v", err)
+	}
+
+	if _, err := io.Copy(dF, sF); err != nil {
+		dF.Close()
+		return fmt.Errorf("error copying the file contents: %v", err)
+	}
+
+	if err := dF.Close(); err != nil {
+		return fmt.Errorf("error closing the new file: %v", err)
+	}
+
+	if err := c.Chmod(dst, mode); err != nil {
+		return fmt.Errorf("error changing the file permissions: %v", err)
+	}
+
+	return nil
+}
+
+// Move implements client.Client.Move
+func (c *sftpClient) Move(src, dst string) error {
+	if err := c.sftp.Rename(src, dst); err != nil {
+		return fmt.Errorf("error moving the file: %v", err)
+	}
+
+	return nil
+}
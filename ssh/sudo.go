@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+)
+
+// sudoClient is a client.Client that runs all the operations with sudo. The files are transferred through a temporary
+// file of the session user, which gets copied with sudo. The commands don't run in a PTY, so sudo can't require a TTY
+type sudoClient struct {
+	s     *Session
+	sftp  *sftp.Client
+	pwd   string
+	abort <-chan struct{}
+}
+
+// sudo runs the command with sudo. If there's a password, it's written to the sudo stdin
+func (c *sudoClient) sudo(cmd string) ([]byte, error) {
+	var stdin io.Reader
+	flags := "-n"
+	if c.pwd != "" {
+		stdin = strings.NewReader(c.pwd + "\n")
+		flags = "-S -p ''"
+	}
+
+	return c.s.run(fmt.Sprintf("sudo %s -- %s", flags, cmd), stdin, false, c.abort)
+}
+
+// upload writes the content to a temporary file of the session user and returns its path
+func (c *sudoClient) upload(b []byte) (string, error) {
+	tmp := path.Join("/tmp", "drlm-"+xid.New().String())
+
+	f, err := c.sftp.Create(tmp)
+	if err != nil {
+		return "", fmt.Errorf("error creating the temporary file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(b); err != nil {
+		c.sftp.Remove(tmp)
+		return "", fmt.Errorf("error writting the temporary file: %v", err)
+	}
+
+	return tmp, nil
+}
+
+// Exec implements client.Client.Exec
+func (c *sudoClient) Exec(name string, arg ...string) ([]byte, error) {
+	cmd := append([]string{name}, arg...)
+	return c.sudo(strings.Join(cmd, " "))
+}
+
+// Chmod implements client.Client.Chmod
+func (c *sudoClient) Chmod(path string, mode os.FileMode) error {
+	if _, err := c.sudo(fmt.Sprintf("chmod %o %s", mode.Perm(), shellQuote(path))); err != nil {
+		return fmt.Errorf("error changing the mode of the file: %v", err)
+	}
+
+	return nil
+}
+
+// Chown implements client.Client.Chown
+func (c *sudoClient) Chown(path string, uid, gid int) error {
+	if _, err := c.sudo(fmt.Sprintf("chown %d:%d %s", uid, gid, shellQuote(path))); err != nil {
+		return fmt.Errorf("error changing the owners of the file: %v", err)
+	}
+
+	return nil
+}
+
+// Exists implements client.Client.Exists
+func (c *sudoClient) Exists(path string) (bool, error) {
+	out, err := c.sudo(fmt.Sprintf("sh -c %s", shellQuote(fmt.Sprintf("test -e %s && echo true || echo false", shellQuote(path)))))
+	if err != nil {
+		return true, fmt.Errorf("error checking the file existence: %v", err)
+	}
+
+	return strings.TrimSpace(string(out)) == "true", nil
+}
+
+// MkdirAll implements client.Client.MkdirAll
+func (c *sudoClient) MkdirAll(path string, perm os.FileMode) error {
+	if _, err := c.sudo(fmt.Sprintf("mkdir -p %s", shellQuote(path))); err != nil {
+		return fmt.Errorf("error creating the directory: %v", err)
+	}
+
+	if err := c.Chmod(path, perm); err != nil {
+		return fmt.Errorf("error changing the directory permissions: %v", err)
+	}
+
+	return nil
+}
+
+// Write implements client.Client.Write
+func (c *sudoClient) Write(path string, b []byte) error {
+	tmp, err := c.upload(b)
+	if err != nil {
+		return err
+	}
+	defer c.sftp.Remove(tmp)
+
+	if _, err := c.sudo(fmt.Sprintf("cp %s %s", shellQuote(tmp), shellQuote(path))); err != nil {
+		return fmt.Errorf("error writting the file: %v", err)
+	}
+
+	return nil
+}
+
+// Append implements client.Client.Append
+func (c *sudoClient) Append(path string, b []byte) error {
+	tmp, err := c.upload(b)
+	if err != nil {
+		return err
+	}
+	defer c.sftp.Remove(tmp)
+
+	if _, err := c.sudo(fmt.Sprintf("sh -c %s", shellQuote(fmt.Sprintf("cat %s >> %s", shellQuote(tmp), shellQuote(path))))); err != nil {
+		return fmt.Errorf("error writting the file: %v", err)
+	}
+
+	return nil
+}
+
+// ReadFile implements client.Client.ReadFile
+func (c *sudoClient) ReadFile(path string) ([]byte, error) {
+	b, err := c.sudo(fmt.Sprintf("cat %s", shellQuote(path)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the file: %v", err)
+	}
+
+	return b, nil
+}
+
+// Remove implements client.Client.Remove
+func (c *sudoClient) Remove(path string) error {
+	if _, err := c.sudo(fmt.Sprintf("rm -f %s", shellQuote(path))); err != nil {
+		return fmt.Errorf("error removing the file: %v", err)
+	}
+
+	return nil
+}
+
+// Copy implements client.Client.Copy. It's recursive and preserves permissions
+func (c *sudoClient) Copy(src, dst string) error {
+	exists, err := c.Exists(dst)
+	if err != nil {
+		return fmt.Errorf("error checking the destination properties: %v", err)
+	}
+	if exists {
+		return fmt.Errorf("error copying the file: the destination already exists")
+	}
+
+	if _, err := c.sudo(fmt.Sprintf("cp -Rp %s %s", shellQuote(src), shellQuote(dst))); err != nil {
+		return fmt.Errorf("error copying the file: %v", err)
+	}
+
+	return nil
+}
+
+// Move implements client.Client.Move
+func (c *sudoClient) Move(src, dst string) error {
+	if _, err := c.sudo(fmt.Sprintf("mv %s %s", shellQuote(src), shellQuote(dst))); err != nil {
+		return fmt.Errorf("error moving the file: %v", err)
+	}
+
+	return nil
+}
+
+// shellQuote quotes the string so it's used as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -4,20 +4,29 @@ package grpc
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
-	"strings"
+	"net"
+	"time"
 
 	"github.com/brainupdaters/drlm-core/agent"
 	"github.com/brainupdaters/drlm-core/auth"
+	"github.com/brainupdaters/drlm-core/ca"
+	coreContext "github.com/brainupdaters/drlm-core/context"
 	"github.com/brainupdaters/drlm-core/models"
 	"github.com/brainupdaters/drlm-core/plugin"
 	"github.com/brainupdaters/drlm-core/scheduler"
+	coreSSH "github.com/brainupdaters/drlm-core/ssh"
 
 	"github.com/brainupdaters/drlm-common/pkg/os"
 	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
 	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
+	gRPC "google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
@@ -60,7 +69,23 @@ func (c *CoreServer) AgentInstall(stream drlm.DRLM_AgentInstallServer) error {
 					return status.Errorf(codes.Unknown, "error loading the agent from the DB: %v", err)
 				}
 
-				if err := agent.Install(c.ctx, a, sshPwd, f); err != nil {
+				ctx, cancel := coreContext.WithRequest(c.ctx, stream.Context())
+				defer cancel()
+
+				creds, err := c.installCredentials(stream.Context(), sshPwd)
+				if err != nil {
+					if err == coreSSH.ErrUnknownCredentials {
+						return status.Error(codes.InvalidArgument, err.Error())
+					}
+
+					return status.Error(codes.Unknown, err.Error())
+				}
+
+				if err := agent.Install(ctx, a, creds, f); err != nil {
+					if err == agent.ErrSudoRequired {
+						return status.Error(codes.InvalidArgument, err.Error())
+					}
+
 					return status.Error(codes.Unknown, err.Error())
 				}
 
@@ -80,9 +105,39 @@ func (c *CoreServer) AgentInstall(stream drlm.DRLM_AgentInstallServer) error {
 	}
 }
 
+// installCredentials returns the SSH credentials used to install the agent. Besides the password of the request, the
+// request can reference credentials of the Core configuration through the `ssh_credentials` metadata key (their ID)
+// TODO: Read the credentials ID from the AgentInstallRequest once it has the field for it
+func (c *CoreServer) installCredentials(ctx context.Context, sshPwd string) (coreSSH.Credentials, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("ssh_credentials")) == 0 {
+		return coreSSH.Credentials{Password: sshPwd}, nil
+	}
+
+	creds, err := coreSSH.LoadCredentials(c.ctx, md.Get("ssh_credentials")[0])
+	if err != nil {
+		return coreSSH.Credentials{}, err
+	}
+
+	if sshPwd != "" {
+		creds.Password = sshPwd
+	}
+
+	return creds, nil
+}
+
 // AgentDelete removes the agent from the DB and might do a clenup in the agent machine
 func (c *CoreServer) AgentDelete(ctx context.Context, req *drlm.AgentDeleteRequest) (*drlm.AgentDeleteResponse, error) {
-	return &drlm.AgentDeleteResponse{}, status.Error(codes.Unimplemented, "not implemented yet")
+	// TODO: Cleanup the agent machine (req.Cleanup)
+	if err := agent.Delete(c.ctx, &models.Agent{Host: req.Host}); err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &drlm.AgentDeleteResponse{}, status.Error(codes.NotFound, "agent not found")
+		}
+
+		return &drlm.AgentDeleteResponse{}, status.Errorf(codes.Unknown, "error deleting the agent: %v", err)
+	}
+
+	return &drlm.AgentDeleteResponse{}, nil
 }
 
 // AgentList returns a list of all the agents
@@ -124,6 +179,24 @@ func (c *CoreServer) AgentGet(ctx context.Context, req *drlm.AgentGetRequest) (*
 		return &drlm.AgentGetResponse{}, status.Errorf(codes.Unknown, "error getting the agent from the DB: %v", err)
 	}
 
+	// The inventory is sent as JSON through the `inventory-bin` header, if the agent has any
+	// TODO: Return the agent inventory in the AgentGetResponse once it has the fields for it
+	if err := a.LoadInventory(c.ctx); err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			return &drlm.AgentGetResponse{}, status.Errorf(codes.Unknown, "error getting the agent inventory from the DB: %v", err)
+		}
+
+	} else {
+		b, err := json.Marshal(a.Inventory)
+		if err != nil {
+			return &drlm.AgentGetResponse{}, status.Errorf(codes.Unknown, "error encoding the agent inventory: %v", err)
+		}
+
+		if err := gRPC.SetHeader(ctx, metadata.Pairs("inventory-bin", string(b))); err != nil {
+			return &drlm.AgentGetResponse{}, status.Errorf(codes.Unknown, "error sending the agent inventory: %v", err)
+		}
+	}
+
 	return &drlm.AgentGetResponse{
 		Host:          a.Host,
 		Port:          int32(a.SSHPort),
@@ -165,25 +238,49 @@ func (c *CoreServer) AgentPluginAdd(stream drlm.DRLM_AgentPluginAddServer) error
 					return status.Errorf(codes.Unknown, "error adding the plugin: %v", err)
 				}
 
+				// Multiple versions of the plugin can be installed side by side, but not the same version twice
+				installed, err := models.PluginVersions(c.ctx, a.Host, repo, pName)
+				if err != nil {
+					return status.Errorf(codes.Unknown, "error adding the plugin: %v", err)
+				}
+
+				for _, i := range installed {
+					if i.Version == version {
+						return pluginStatus("error adding the plugin", plugin.ErrSameVersion)
+					}
+				}
+
 				p := &models.Plugin{
-					AgentHost: a.Host,
-					Repo:      repo,
-					Name:      pName,
-					Version:   version,
-					Arch:      arch,
-					OS:        pOS,
+					AgentHost:   a.Host,
+					Repo:        repo,
+					Name:        pName,
+					Version:     version,
+					Arch:        arch,
+					OS:          pOS,
+					InstalledAt: time.Now(),
 				}
 
-				if p.Add(c.ctx); err != nil {
-					return status.Errorf(codes.Unknown, "error adding the plugin: %v", err)
+				sig, err := pluginSignature(stream.Context())
+				if err != nil {
+					return status.Errorf(codes.InvalidArgument, "error installing the plugin: %v", err)
+				}
+
+				if err := pluginManifest(stream.Context(), p); err != nil {
+					return status.Errorf(codes.InvalidArgument, "error installing the plugin: %v", err)
 				}
 
-				if err := plugin.Install(c.ctx, p, a, f); err != nil {
-					return status.Errorf(codes.Unknown, "error installing the plugin: %v", err)
+				ctx, cancel := coreContext.WithRequest(c.ctx, stream.Context())
+				defer cancel()
+
+				// The plugin is added once it's installed, so the unsigned or tampered plugins are never added
+				if err := plugin.Add(ctx, p, a, f, sig); err != nil {
+					return pluginStatus("error installing the plugin", err)
 				}
 
 				return stream.SendAndClose(&drlm.AgentPluginAddResponse{})
 			}
+
+			return status.Errorf(codes.Unknown, "error receiving the plugin: %v", err)
 		}
 
 		host = req.Host
@@ -204,94 +301,361 @@ func (c *CoreServer) AgentPluginAdd(stream drlm.DRLM_AgentPluginAddServer) error
 
 // AgentPluginRemove removes a plugin from the Agent
 func (c *CoreServer) AgentPluginRemove(ctx context.Context, req *drlm.AgentPluginRemoveRequest) (*drlm.AgentPluginRemoveResponse, error) {
-	return &drlm.AgentPluginRemoveResponse{}, status.Error(codes.Unimplemented, "not implemented yet")
+	a := &models.Agent{Host: req.Host}
+	p, err := plugin.Load(c.ctx, a, req.Plugin)
+	if err != nil {
+		return &drlm.AgentPluginRemoveResponse{}, pluginStatus("error removing the plugin", err)
+	}
+
+	rCtx, cancel := coreContext.WithRequest(c.ctx, ctx)
+	defer cancel()
+
+	if err := plugin.Remove(rCtx, a, p); err != nil {
+		return &drlm.AgentPluginRemoveResponse{}, pluginStatus("error removing the plugin", err)
+	}
+
+	return &drlm.AgentPluginRemoveResponse{}, nil
 }
 
-// AgentPluginUpdate updates a plugin of the Agent
+// AgentPluginUpdate updates a plugin of the Agent. The previous versions are kept in the Agent for rollbacks
 func (c *CoreServer) AgentPluginUpdate(stream drlm.DRLM_AgentPluginUpdateServer) error {
-	return status.Error(codes.Unimplemented, "not implemented yet")
+	var (
+		host,
+		pName,
+		version string
+		f []byte
+	)
+
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				return status.Errorf(codes.Unknown, "error receiving the plugin: %v", err)
+			}
+
+			a := &models.Agent{Host: host}
+			p, err := plugin.Load(c.ctx, a, pName)
+			if err != nil {
+				return pluginStatus("error updating the plugin", err)
+			}
+
+			sig, err := pluginSignature(stream.Context())
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "error updating the plugin: %v", err)
+			}
+
+			if err := pluginManifest(stream.Context(), p); err != nil {
+				return status.Errorf(codes.InvalidArgument, "error updating the plugin: %v", err)
+			}
+
+			ctx, cancel := coreContext.WithRequest(c.ctx, stream.Context())
+			defer cancel()
+
+			if err := plugin.Update(ctx, a, p, version, f, sig); err != nil {
+				return pluginStatus("error updating the plugin", err)
+			}
+
+			return stream.SendAndClose(&drlm.AgentPluginUpdateResponse{})
+		}
+
+		host = req.Host
+		pName = req.Plugin
+		version = req.Version
+		f = append(f, req.Bin...)
+	}
 }
 
 // AgentPluginList lists the plugins of the Agent
 func (c *CoreServer) AgentPluginList(ctx context.Context, req *drlm.AgentPluginListRequest) (*drlm.AgentPluginListResponse, error) {
-	return &drlm.AgentPluginListResponse{}, status.Error(codes.Unimplemented, "not implemented yet")
+	plugins, err := plugin.List(c.ctx, &models.Agent{Host: req.Host})
+	if err != nil {
+		return &drlm.AgentPluginListResponse{}, pluginStatus("error listing the plugins", err)
+	}
+
+	// The version is encoded in the name (`repo/name@version`), since multiple versions of a plugin can be installed
+	// TODO: Return the arch and OS constraints and the install time of the plugins once the AgentPluginListResponse has the fields for them
+	rsp := &drlm.AgentPluginListResponse{}
+	for _, p := range plugins {
+		rsp.Plugins = append(rsp.Plugins, fmt.Sprintf("%s@%s", p, p.Version))
+	}
+
+	return rsp, nil
+}
+
+// TODO: Receive the plugin signature and manifest in the requests once the protobuf has the fields for them
+
+// pluginSignature returns the plugin signature sent (base64 encoded) in the `signature` metadata of the request
+func pluginSignature(ctx context.Context) ([]byte, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("signature")) == 0 {
+		return nil, nil
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(md.Get("signature")[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin signature: %v", err)
+	}
+
+	return sig, nil
+}
+
+// pluginManifest sets the plugin manifest sent (base64 encoded TOML) in the `manifest` metadata of the request. The
+// manifest is optional and it overrides the arch and OS constraints of the request
+func pluginManifest(ctx context.Context, p *models.Plugin) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("manifest")) == 0 {
+		return nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(md.Get("manifest")[0])
+	if err != nil {
+		return fmt.Errorf("invalid plugin manifest: %v", err)
+	}
+
+	return plugin.SetManifest(p, b)
+}
+
+// pluginStatus returns the gRPC status of an error of the plugins
+func pluginStatus(msg string, err error) error {
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		return status.Errorf(codes.NotFound, "%s: agent or plugin not found", msg)
+
+	case err == plugin.ErrInvalidPlugin || err == plugin.ErrSameVersion:
+		return status.Errorf(codes.InvalidArgument, "%s: %v", msg, err)
+
+	case err == plugin.ErrPluginInUse:
+		return status.Errorf(codes.FailedPrecondition, "%s: %v", msg, err)
+
+	case err == plugin.ErrUnsignedPlugin || err == plugin.ErrUntrustedPlugin || err == plugin.ErrChecksumMismatch:
+		return status.Errorf(codes.PermissionDenied, "%s: %v", msg, err)
+
+	default:
+		return status.Errorf(codes.Unknown, "%s: %v", msg, err)
+	}
 }
 
 // AgentConnection creates the connection between the Agent and the Core. It's used for both notifying new jobs and for returning the response / updates of them
 func (c *CoreServer) AgentConnection(stream drlm.DRLM_AgentConnectionServer) error {
-	for {
-		req, err := stream.Recv()
+	req, err := stream.Recv()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
 
-		var host string
-		if req.MessageType != drlm.AgentConnectionFromAgent_MESSAGE_TYPE_JOIN_REQUEST {
-			md, ok := metadata.FromIncomingContext(stream.Context())
-			if ok && len(md.Get("tkn")) > 0 {
-				tkn := auth.Token(md.Get("tkn")[0])
-				host, ok = tkn.ValidateAgent(c.ctx)
-				if !ok {
-					return status.Error(codes.InvalidArgument, "invalid token")
-				}
-			} else {
-				return status.Error(codes.InvalidArgument, "unable to parse the token")
-			}
+		return status.Errorf(codes.Unknown, "error receiving the agent message: %v", err)
+	}
 
-		} else {
-			if p, ok := peer.FromContext(stream.Context()); ok {
-				host = strings.Split(p.Addr.String(), ":")[0]
-			} else {
-				return status.Error(codes.InvalidArgument, "unable to parse the agent host")
+	// The agent is authenticated only once per stream
+	host, tkn, authenticated, err := c.agentIdentity(stream, req.MessageType)
+	if err != nil {
+		return err
+	}
+
+	// The agents that request to join from an alias of an existing agent are resolved to that agent
+	if !authenticated {
+		if host, err = models.ResolveAgentHost(c.ctx, host); err != nil {
+			return status.Errorf(codes.Unknown, "error resolving the agent host: %v", err)
+		}
+	}
+
+	if authenticated {
+		a := &models.Agent{Host: host}
+		if err := a.Load(c.ctx); err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return status.Error(codes.NotFound, "agent not found")
 			}
+
+			return status.Errorf(codes.Unknown, "error loading the agent from the DB: %v", err)
 		}
 
-		if err != nil {
-			if err == io.EOF {
-				if _, ok := scheduler.AgentConnections.Get(host); ok {
-					scheduler.AgentConnections.Delete(host)
-				}
+		if a.Quarantined {
+			return status.Error(codes.PermissionDenied, "agent quarantined")
+		}
+	}
 
-				return nil
+	// The messages are received in a goroutine, so the stream can be ended while waiting for the next message if the Core
+	// closes the agent connection (e.g. the agent has been quarantined or deleted)
+	done := make(chan struct{})
+	defer close(done)
+
+	msgs := make(chan *drlm.AgentConnectionFromAgent)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case msgs <- req:
+			case <-done:
+				return
 			}
 		}
+	}()
 
-		if req != nil {
-			switch req.MessageType {
-			case drlm.AgentConnectionFromAgent_MESSAGE_TYPE_JOIN_REQUEST:
-				scheduler.PendingAgentConnections.Add(host, stream)
-				agent.AddRequest(c.ctx, &models.Agent{
-					Host: host,
-					Arch: os.Arch(req.JoinRequest.Arch),
-					OS:   os.OS(req.JoinRequest.Os),
-				})
+	var closed <-chan struct{}
+	for {
+		// The agents that are requesting to join can't do anything else in the same stream
+		if !authenticated && req.MessageType != drlm.AgentConnectionFromAgent_MESSAGE_TYPE_JOIN_REQUEST {
+			return status.Error(codes.Unauthenticated, "not authenticated")
+		}
 
-			case drlm.AgentConnectionFromAgent_MESSAGE_TYPE_CONN_ESTABLISH:
-				log.Infof("agent '%s' has established a connection", host)
-				scheduler.AgentConnections.Add(host, stream)
+		switch req.MessageType {
+		case drlm.AgentConnectionFromAgent_MESSAGE_TYPE_JOIN_REQUEST:
+			// TODO: Read the enrollment token from the JoinRequest once it has a field for it
+			var enrollTkn string
+			if md, ok := metadata.FromIncomingContext(stream.Context()); ok && len(md.Get("enrollment_token")) > 0 {
+				enrollTkn = md.Get("enrollment_token")[0]
+			}
 
-			case drlm.AgentConnectionFromAgent_MESSAGE_TYPE_JOB_UPDATE:
-				j := &models.Job{
-					Model: gorm.Model{
-						ID: uint(req.JobUpdate.JobId),
-					},
+			// The join policy is checked against the address the request comes from, not against the resolved agent host
+			if err := agent.Join(c.ctx, stream, &models.Agent{
+				Host: host,
+				Arch: os.Arch(req.JoinRequest.Arch),
+				OS:   os.OS(req.JoinRequest.Os),
+			}, peerAddr(stream), enrollTkn); err != nil {
+				if err == agent.ErrJoinRejected {
+					return status.Error(codes.PermissionDenied, "join request rejected")
 				}
 
-				if err := j.Load(c.ctx); err != nil {
-					if gorm.IsRecordNotFoundError(err) {
-						return status.Error(codes.NotFound, "job not found")
-					}
+				if err == models.ErrAgentQuarantined {
+					return status.Error(codes.PermissionDenied, "agent quarantined")
+				}
+
+				return status.Errorf(codes.Unknown, "error handling the join request: %v", err)
+			}
+
+		case drlm.AgentConnectionFromAgent_MESSAGE_TYPE_CONN_ESTABLISH:
+			log.Infof("agent '%s' has established a connection", host)
 
-					return status.Errorf(codes.Unknown, "error loading the job: %v", err)
+			// The certificate has to be sent before the connection is used to send anything else
+			if err := agent.RenewConnCert(c.ctx, stream, host); err != nil {
+				log.Errorf("error renewing the agent '%s' certificate: %v", host, err)
+			}
+
+			scheduler.AgentConnections.Add(host, stream)
+			closed = scheduler.AgentConnections.Closed(stream)
+
+			// The agents authenticated with their client certificate have an empty token, unless they send their secret too
+			sec := tkn.String()
+			if md, ok := metadata.FromIncomingContext(stream.Context()); ok && sec == "" && len(md.Get("tkn")) > 0 {
+				sec = md.Get("tkn")[0]
+			}
+
+			if err := agent.ConfirmSecretRotation(c.ctx, host, sec); err != nil {
+				log.Errorf("error confirming the agent '%s' secret rotation: %v", host, err)
+			}
+
+			// TODO: Read the agent version from the ConnEstablish message once it has a field for it
+			// The agents report their version (e.g. `v1.2.0`) in the `version` metadata of the stream. It's used to
+			// check that the upgraded agents reconnect with the new version
+			if md, ok := metadata.FromIncomingContext(stream.Context()); ok && len(md.Get("version")) > 0 {
+				if err := agent.UpdateVersion(c.ctx, host, md.Get("version")[0]); err != nil {
+					log.Errorf("error updating the agent '%s' version: %v", host, err)
 				}
+			}
 
-				j.Status = models.JobStatus(req.JobUpdate.Status)
-				j.Info += "\n" + req.JobUpdate.Info
+		case drlm.AgentConnectionFromAgent_MESSAGE_TYPE_JOB_UPDATE:
+			j := &models.Job{
+				Model: gorm.Model{
+					ID: uint(req.JobUpdate.JobId),
+				},
+			}
 
-				if err := j.Update(c.ctx); err != nil {
-					return status.Errorf(codes.Unknown, "error updating the job: %v", err)
+			if err := j.Load(c.ctx); err != nil {
+				if gorm.IsRecordNotFoundError(err) {
+					return status.Error(codes.NotFound, "job not found")
 				}
 
-			default:
-				return status.Error(codes.InvalidArgument, "unknown message type")
+				return status.Errorf(codes.Unknown, "error loading the job: %v", err)
+			}
+
+			j.Status = models.JobStatus(req.JobUpdate.Status)
+			j.Info += "\n" + req.JobUpdate.Info
+
+			if err := j.Update(c.ctx); err != nil {
+				return status.Errorf(codes.Unknown, "error updating the job: %v", err)
 			}
+
+		default:
+			return status.Error(codes.InvalidArgument, "unknown message type")
+		}
+
+		select {
+		case req = <-msgs:
+		case err := <-errs:
+			scheduler.AgentConnections.DeleteStream(stream)
+
+			if err == io.EOF {
+				return nil
+			}
+
+			return status.Errorf(codes.Unknown, "error receiving the agent message: %v", err)
+
+		case <-closed:
+			log.Infof("agent '%s' connection has been closed by the Core", host)
+			return status.Error(codes.PermissionDenied, "agent connection closed")
 		}
 	}
 }
+
+// agentIdentity returns the host of the agent of the stream and whether it's authenticated. The agents are identified by their
+// client certificate and, unless the certificate is required, by their secret. The agents that request to join aren't authenticated
+// and are identified by their address
+func (c *CoreServer) agentIdentity(stream drlm.DRLM_AgentConnectionServer, msgType drlm.AgentConnectionFromAgent_MessageType) (string, auth.Token, bool, error) {
+	p, ok := peer.FromContext(stream.Context())
+	if ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			host, err := ca.Verify(c.ctx, tlsInfo.State.VerifiedChains[0][0])
+			if err != nil {
+				return "", "", false, status.Errorf(codes.PermissionDenied, "invalid certificate: %v", err)
+			}
+
+			return host, "", true, nil
+		}
+	}
+
+	if msgType == drlm.AgentConnectionFromAgent_MESSAGE_TYPE_JOIN_REQUEST {
+		if !ok {
+			return "", "", false, status.Error(codes.InvalidArgument, "unable to parse the agent host")
+		}
+
+		return peerAddr(stream), "", false, nil
+	}
+
+	if c.ctx.Cfg.Security.AgentCertRequired {
+		return "", "", false, status.Error(codes.Unauthenticated, "client certificate required")
+	}
+
+	md, ok := metadata.FromIncomingContext(stream.Context())
+	if !ok || len(md.Get("tkn")) == 0 {
+		return "", "", false, status.Error(codes.InvalidArgument, "unable to parse the token")
+	}
+
+	tkn := auth.Token(md.Get("tkn")[0])
+	host, ok := tkn.ValidateAgent(c.ctx)
+	if !ok {
+		return "", "", false, status.Error(codes.InvalidArgument, "invalid token")
+	}
+
+	return host, tkn, true, nil
+}
+
+// peerAddr returns the address of the agent at the other end of the stream
+func peerAddr(stream drlm.DRLM_AgentConnectionServer) string {
+	p, ok := peer.FromContext(stream.Context())
+	if !ok {
+		return ""
+	}
+
+	addr, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return addr
+}
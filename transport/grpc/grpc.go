@@ -4,11 +4,14 @@ package grpc
 
 import (
 	stdContext "context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 
 	"github.com/brainupdaters/drlm-core/auth"
 	"github.com/brainupdaters/drlm-core/auth/types"
+	"github.com/brainupdaters/drlm-core/ca"
 	"github.com/brainupdaters/drlm-core/context"
 
 	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
@@ -35,6 +38,10 @@ func NewCoreServer(ctx *context.Context) *CoreServer {
 
 // Serve starts the DRLM Core GRPC server
 func Serve(ctx *context.Context) {
+	if err := checkCfg(ctx); err != nil {
+		log.Fatal(err)
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", ctx.Cfg.GRPC.Port))
 	if err != nil {
 		log.Fatalf("error listening at port %d: %v", ctx.Cfg.GRPC.Port, err)
@@ -48,7 +55,7 @@ func Serve(ctx *context.Context) {
 	}
 
 	if ctx.Cfg.GRPC.TLS {
-		creds, err := credentials.NewServerTLSFromFile(
+		cert, err := tls.LoadX509KeyPair(
 			ctx.Cfg.GRPC.CertPath,
 			ctx.Cfg.GRPC.KeyPath,
 		)
@@ -56,7 +63,17 @@ func Serve(ctx *context.Context) {
 			log.Fatalf("error loading the TLS credentials: %v", err)
 		}
 
-		opts = append(opts, gRPC.Creds(creds))
+		// The agents authenticate with the client certificates issued by the Core CA. The users don't have client certificates
+		pool, err := ca.CertPool(ctx)
+		if err != nil {
+			log.Fatalf("error loading the TLS credentials: %v", err)
+		}
+
+		opts = append(opts, gRPC.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    pool,
+		})))
 	}
 
 	grpcServer := gRPC.NewServer(opts...)
@@ -78,6 +95,16 @@ func Serve(ctx *context.Context) {
 	}
 }
 
+// checkCfg checks that the GRPC server configuration is consistent
+func checkCfg(ctx *context.Context) error {
+	// The agents can only present their client certificates through TLS, so all of them would be refused
+	if ctx.Cfg.Security.AgentCertRequired && !ctx.Cfg.GRPC.TLS {
+		return errors.New("invalid configuration: the agents client certificates can't be required without TLS (grpc.tls)")
+	}
+
+	return nil
+}
+
 func (c *CoreServer) unaryInterceptor(inCtx stdContext.Context, req interface{}, info *gRPC.UnaryServerInfo, handler gRPC.UnaryHandler) (interface{}, error) {
 	// If it's not the login method, check for the token authenticity
 	if info.FullMethod != "/drlm.DRLM/UserLogin" && info.FullMethod != "/drlm.DRLM/UserTokenRenew" {
@@ -36,6 +36,23 @@ func TestGRPCInternal(t *testing.T) {
 	suite.Run(t, &TestGRPCInternalSuite{})
 }
 
+func (s *TestGRPCInternalSuite) TestCheckCfg() {
+	s.Run("should accept requiring the agents certificates with TLS", func() {
+		tests.GenerateCfg(s.T(), s.c.ctx)
+		s.c.ctx.Cfg.Security.AgentCertRequired = true
+
+		s.NoError(checkCfg(s.c.ctx))
+	})
+
+	s.Run("should return an error if the agents certificates are required without TLS", func() {
+		tests.GenerateCfg(s.T(), s.c.ctx)
+		s.c.ctx.Cfg.Security.AgentCertRequired = true
+		s.c.ctx.Cfg.GRPC.TLS = false
+
+		s.EqualError(checkCfg(s.c.ctx), "invalid configuration: the agents client certificates can't be required without TLS (grpc.tls)")
+	})
+}
+
 func (s *TestGRPCInternalSuite) TestUnaryInterceptor() {
 	h := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return "", errors.New("failed! :0")
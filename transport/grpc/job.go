@@ -12,10 +12,14 @@ import (
 	drlm "github.com/brainupdaters/drlm-common/pkg/proto"
 	"github.com/jinzhu/gorm"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-// JobSchedule schedules a new job
+// TODO: Add the RPC that previews the effective config of a job (scheduler.PreviewJobConfig) once the protobuf has it
+
+// JobSchedule schedules a new job. Its config overrides the plugin config of the agent and it can be a template with the
+// agent facts (see scheduler.JobConfigData)
 func (c *CoreServer) JobSchedule(ctx context.Context, req *drlm.JobScheduleRequest) (*drlm.JobScheduleResponse, error) {
 	var t time.Time
 	if req.Time == nil {
@@ -24,7 +28,15 @@ func (c *CoreServer) JobSchedule(ctx context.Context, req *drlm.JobScheduleReque
 		t = time.Unix(req.Time.Seconds, int64(req.Time.Nanos))
 	}
 
-	if err := scheduler.AddJob(c.ctx, req.AgentHost, req.Name, req.Config, t); err != nil {
+	// TODO: Read whether the config is a template from the JobScheduleRequest once it has a field for it
+	// The clients opt in to the config templates with the `config-template` metadata set to `true`. Otherwise, the config
+	// is sent to the agent as it is
+	tmpl := false
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("config-template")) > 0 {
+		tmpl = md.Get("config-template")[0] == "true"
+	}
+
+	if err := scheduler.AddJob(c.ctx, req.AgentHost, req.Name, req.Config, tmpl, t); err != nil {
 		return &drlm.JobScheduleResponse{}, status.Error(codes.Unknown, err.Error())
 	}
 
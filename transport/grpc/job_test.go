@@ -21,6 +21,7 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -79,8 +80,11 @@ func (s *TestJobSuite) TestSchedule() {
 			AddRow(1, "default", "tar", 161).
 			AddRow(2, "default", "copy", 161),
 		)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 		mock.ExpectBegin()
-		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING "jobs"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts","plugin_version","plugin_constraint","config_template") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING "jobs"."id"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).
 			AddRow(161),
 		)
 		mock.ExpectCommit()
@@ -129,8 +133,11 @@ func (s *TestJobSuite) TestSchedule() {
 			AddRow(1, "default", "tar", 161).
 			AddRow(2, "default", "copy", 161),
 		)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
 		mock.ExpectBegin()
-		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING "jobs"."id"`)).WillReturnError(errors.New("testing error"))
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs" ("created_at","updated_at","deleted_at","plugin_id","agent_host","status","time","config","bucket_name","info","reconn_attempts","plugin_version","plugin_constraint","config_template") VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14) RETURNING "jobs"."id"`)).WillReturnError(errors.New("testing error"))
 
 		req := &drlm.JobScheduleRequest{
 			Name:      "default/tar",
@@ -143,6 +150,59 @@ func (s *TestJobSuite) TestSchedule() {
 		s.Equal(status.Error(codes.Unknown, "error adding the job: error adding the job to the DB: testing error"), err)
 		s.Equal(&drlm.JobScheduleResponse{}, rsp)
 	})
+
+	s.Run("should schedule the job with a config template if the client opts in to it", func() {
+		ctx := tests.GenerateCtx()
+		tests.GenerateCfg(s.T(), ctx)
+		mock := tests.GenerateDB(s.T(), ctx)
+		c := grpc.NewCoreServer(ctx)
+		minio.Init(ctx)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/minio/admin/v2/add-canned-policy", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		mux.HandleFunc("/minio/admin/v2/set-user-or-group-policy", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.String(), "/drlm-") {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			s.Fail(r.URL.String())
+		})
+
+		ts := tests.GenerateMinio(ctx, mux)
+		defer ts.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agents" WHERE "agents"."deleted_at" IS NULL AND ((host = $1)) ORDER BY "agents"."id" ASC LIMIT 1`)).WillReturnRows(sqlmock.NewRows([]string{"id", "host", "port", "user"}).
+			AddRow(161, "192.168.1.61", 1312, "drlm"),
+		)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugins"  WHERE "plugins"."deleted_at" IS NULL AND ((agent_host = $1))`)).WillReturnRows(sqlmock.NewRows([]string{"id", "repo", "name", "agent_host"}).
+			AddRow(1, "default", "tar", 161).
+			AddRow(2, "default", "copy", 161),
+		)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_manifests"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "agent_labels"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "plugin_configs"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "jobs"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 1, "192.168.1.61", models.JobStatusScheduled, sqlmock.AnyArg(), `{"path": "/{{ .Agent.Host }}"}`, sqlmock.AnyArg(), "", 0, "", "", true).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		req := &drlm.JobScheduleRequest{
+			Name:      "default/tar",
+			AgentHost: "192.168.1.61",
+			Config:    `{"path": "/{{ .Agent.Host }}"}`,
+			Time:      &timestamp.Timestamp{Seconds: 1257894000},
+		}
+
+		rsp, err := c.JobSchedule(metadata.NewIncomingContext(ctx, metadata.Pairs("config-template", "true")), req)
+
+		s.NoError(err)
+		s.Equal(&drlm.JobScheduleResponse{}, rsp)
+	})
 }
 
 func (s *TestJobSuite) TestList() {
@@ -4,12 +4,19 @@ package secret
 
 import (
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// saltSize is the size in bytes of the salt of the secrets hashes
+const saltSize = 16
+
 // New creates a secret
 func New(id string) (string, error) {
 	h, err := bcrypt.GenerateFromPassword([]byte(id), bcrypt.DefaultCost)
@@ -22,3 +29,47 @@ func New(id string) (string, error) {
 
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
+
+// Lookup returns a digest of the secret that can be stored and indexed to find the owner of a secret
+func Lookup(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:16])
+}
+
+// Hash returns a salted hash of the secret. The secrets are random and long enough to not need a slow hash function
+func Hash(s string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating the secret salt: %v", err)
+	}
+
+	return hex.EncodeToString(salt) + "$" + hex.EncodeToString(hash(salt, s)), nil
+}
+
+// Check checks, in constant time, whether a secret matches a hash
+func Check(s, h string) bool {
+	parts := strings.SplitN(h, "$", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	salt, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(hash(salt, s), expected) == 1
+}
+
+func hash(salt []byte, s string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(s))
+
+	return h.Sum(nil)
+}
@@ -16,6 +16,8 @@ var errNotImplemented = errors.New("mock method not implemented")
 // AgentConnectionServerMock is a mock for the Agent Connection gRPC server
 type AgentConnectionServerMock struct {
 	mock.Mock
+	Header     metadata.MD // The headers set in the stream
+	HeaderSent bool        // Whether the headers have already been sent, so they can't be set anymore
 }
 
 // Send mocks the AgentConnectionServer Send method
@@ -30,8 +32,13 @@ func (a *AgentConnectionServerMock) Recv() (*drlm.AgentConnectionFromAgent, erro
 }
 
 // SetHeader mocks the grpc.ServerStream SetHeader method
-func (a *AgentConnectionServerMock) SetHeader(metadata.MD) error {
-	return errNotImplemented
+func (a *AgentConnectionServerMock) SetHeader(md metadata.MD) error {
+	if a.HeaderSent {
+		return errors.New("the headers have already been sent")
+	}
+
+	a.Header = metadata.Join(a.Header, md)
+	return nil
 }
 
 // SendHeader mocks the grpc.ServerStream SendHeader method
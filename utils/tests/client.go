@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tests
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// OSClientMock is an in memory OS client. The commands return the output in Out (using the command and the arguments
+// joined with spaces as key) and the files are read and written in Files
+type OSClientMock struct {
+	Out   map[string]string
+	Files map[string]string
+	Execs []string
+}
+
+// Exec mocks the Client Exec method
+func (c *OSClientMock) Exec(name string, arg ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, arg...), " ")
+	c.Execs = append(c.Execs, cmd)
+
+	out, ok := c.Out[cmd]
+	if !ok {
+		return nil, errors.New("command not found")
+	}
+
+	return []byte(out), nil
+}
+
+// Chmod mocks the Client Chmod method
+func (c *OSClientMock) Chmod(path string, mode os.FileMode) error {
+	return nil
+}
+
+// Chown mocks the Client Chown method
+func (c *OSClientMock) Chown(path string, uid, gid int) error {
+	return nil
+}
+
+// Exists mocks the Client Exists method
+func (c *OSClientMock) Exists(path string) (bool, error) {
+	_, ok := c.Files[path]
+	return ok, nil
+}
+
+// MkdirAll mocks the Client MkdirAll method
+func (c *OSClientMock) MkdirAll(path string, perm os.FileMode) error {
+	return nil
+}
+
+// Write mocks the Client Write method
+func (c *OSClientMock) Write(path string, b []byte) error {
+	if c.Files == nil {
+		c.Files = map[string]string{}
+	}
+
+	c.Files[path] = string(b)
+	return nil
+}
+
+// Append mocks the Client Append method
+func (c *OSClientMock) Append(path string, b []byte) error {
+	if c.Files == nil {
+		c.Files = map[string]string{}
+	}
+
+	c.Files[path] += string(b)
+	return nil
+}
+
+// ReadFile mocks the Client ReadFile method
+func (c *OSClientMock) ReadFile(path string) ([]byte, error) {
+	b, ok := c.Files[path]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+
+	return []byte(b), nil
+}
+
+// Remove mocks the Client Remove method
+func (c *OSClientMock) Remove(path string) error {
+	if _, ok := c.Files[path]; !ok {
+		return errors.New("file not found")
+	}
+
+	delete(c.Files, path)
+	return nil
+}
+
+// Copy mocks the Client Copy method
+func (c *OSClientMock) Copy(src, dst string) error {
+	b, ok := c.Files[src]
+	if !ok {
+		return errors.New("file not found")
+	}
+
+	c.Files[dst] = b
+	return nil
+}
+
+// Move mocks the Client Move method
+func (c *OSClientMock) Move(src, dst string) error {
+	if err := c.Copy(src, dst); err != nil {
+		return err
+	}
+
+	delete(c.Files, src)
+	return nil
+}
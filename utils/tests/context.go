@@ -10,7 +10,8 @@ import (
 
 // GenerateCtx generates a
 func GenerateCtx() *context.Context {
-	return &context.Context{
-		FS: afero.NewMemMapFs(),
-	}
+	ctx := context.Background()
+	ctx.FS = afero.NewMemMapFs()
+
+	return ctx
 }
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package version
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint is a list of conditions that a version has to match, splitted with `,` (e.g. `>= 9, < 12`). The supported
+// operators are `=`, `!=`, `>`, `>=`, `<`, `<=`, `~` (same minor version, e.g. `~1.2.3` is `>= 1.2.3, < 1.3.0`) and `^`
+// (same major version, e.g. `^1.2.3` is `>= 1.2.3, < 2.0.0`). A version without operator has to be equal. An empty
+// constraint or `*` match any version
+type Constraint struct {
+	raw   string
+	conds []condition
+}
+
+type condition struct {
+	op string
+	v  Version
+}
+
+// operators are the supported operators, with the longest ones first so they get matched before their prefixes
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "~", "^"}
+
+// ParseConstraint parses a version constraint
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{raw: strings.TrimSpace(s)}
+	if c.raw == "" || c.raw == "*" {
+		return c, nil
+	}
+
+	for _, cond := range strings.Split(c.raw, ",") {
+		cond = strings.TrimSpace(cond)
+
+		op := "="
+		for _, o := range operators {
+			if strings.HasPrefix(cond, o) {
+				op = o
+				cond = strings.TrimSpace(strings.TrimPrefix(cond, o))
+				break
+			}
+		}
+
+		v, err := Parse(cond)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint '%s': %v", s, err)
+		}
+
+		switch op {
+		case "~":
+			i := 1
+			if len(v.Parts) < 2 {
+				i = 0
+			}
+
+			c.conds = append(c.conds, condition{">=", v}, condition{"<", bump(v, i)})
+
+		case "^":
+			// The first non zero part is the one that can't change (e.g. `^0.2.3` is `>= 0.2.3, < 0.3.0`)
+			i := 0
+			for i < len(v.Parts)-1 && v.Parts[i] == 0 {
+				i++
+			}
+
+			c.conds = append(c.conds, condition{">=", v}, condition{"<", bump(v, i)})
+
+		default:
+			c.conds = append(c.conds, condition{op, v})
+		}
+	}
+
+	return c, nil
+}
+
+// Check returns whether the version matches the constraint
+func (c *Constraint) Check(v Version) bool {
+	for _, cond := range c.conds {
+		cmp := v.Compare(cond.v)
+
+		var ok bool
+		switch cond.op {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+// bump returns the lowest version that increases the part of the version with the index
+func bump(v Version, i int) Version {
+	parts := make([]int, i+1)
+	copy(parts, v.Parts)
+	parts[i]++
+
+	return Version{Parts: parts}
+}
@@ -0,0 +1,5 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package version is responsible for comparing versions and
+// checking them against version constraints
+package version
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed dotted version (e.g. `v1.2.3`, `10`, `18.04` or `v1.0.0-rc1`)
+type Version struct {
+	Parts      []int
+	PreRelease string
+}
+
+// Parse parses a dotted version. The `v` prefix, the surrounding quotes and the build metadata (`+...`) are ignored
+func Parse(s string) (Version, error) {
+	v := strings.Trim(strings.TrimSpace(s), `"'`)
+	v = strings.TrimPrefix(v, "v")
+
+	if i := strings.Index(v, "+"); i != -1 {
+		v = v[:i]
+	}
+
+	var pre string
+	if i := strings.Index(v, "-"); i != -1 {
+		v, pre = v[:i], v[i+1:]
+	}
+
+	if v == "" {
+		return Version{}, fmt.Errorf("invalid version '%s'", s)
+	}
+
+	rslt := Version{PreRelease: pre}
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version '%s'", s)
+		}
+
+		rslt.Parts = append(rslt.Parts, n)
+	}
+
+	return rslt, nil
+}
+
+// Compare compares two versions. It returns -1 if a is lower than b, 0 if they're equal and 1 if a is greater than b. The
+// missing parts are 0 (`1.2` equals `1.2.0`) and the pre releases are lower than their release
+func (a Version) Compare(b Version) int {
+	for i := 0; i < len(a.Parts) || i < len(b.Parts); i++ {
+		var pa, pb int
+		if i < len(a.Parts) {
+			pa = a.Parts[i]
+		}
+		if i < len(b.Parts) {
+			pb = b.Parts[i]
+		}
+
+		if pa != pb {
+			if pa < pb {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	switch {
+	case a.PreRelease == b.PreRelease:
+		return 0
+	case a.PreRelease == "":
+		return 1
+	case b.PreRelease == "":
+		return -1
+	case a.PreRelease < b.PreRelease:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func (a Version) String() string {
+	parts := []string{}
+	for _, p := range a.Parts {
+		parts = append(parts, strconv.Itoa(p))
+	}
+
+	s := strings.Join(parts, ".")
+	if a.PreRelease != "" {
+		s += "-" + a.PreRelease
+	}
+
+	return s
+}
+
+// Compare parses and compares two versions
+func Compare(a, b string) (int, error) {
+	va, err := Parse(a)
+	if err != nil {
+		return 0, err
+	}
+
+	vb, err := Parse(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return va.Compare(vb), nil
+}
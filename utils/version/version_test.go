@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package version_test
+
+import (
+	"testing"
+
+	"github.com/brainupdaters/drlm-core/utils/version"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.0.0", "1.0.0", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.10.0", "1.9.0", 1},
+		{"9", "10", -1},
+		{`"18.04"`, "18.4", 0},
+		{"v1.0.0-rc1", "v1.0.0", -1},
+		{"v1.0.0-rc2", "v1.0.0-rc1", 1},
+		{"v1.0.0+build1", "v1.0.0", 0},
+	} {
+		cmp, err := version.Compare(tc.a, tc.b)
+		assert.NoError(err)
+		assert.Equal(tc.expected, cmp, "%s <=> %s", tc.a, tc.b)
+	}
+
+	_, err := version.Compare("v1.x", "v1.0")
+	assert.EqualError(err, "invalid version 'v1.x'")
+}
+
+func TestConstraint(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"", "v1.0.0", true},
+		{"*", "v1.0.0", true},
+		{"v1.0.0", "1.0", true},
+		{"!= 1.0.0", "1.0.0", false},
+		{">= 9, < 12", "11", true},
+		{">= 9, < 12", "12", false},
+		{">=9,<12", "8", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^1.2.3", "1.2.2", false},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.2.3", "0.2.9", true},
+	} {
+		c, err := version.ParseConstraint(tc.constraint)
+		assert.NoError(err)
+
+		v, err := version.Parse(tc.version)
+		assert.NoError(err)
+
+		assert.Equal(tc.expected, c.Check(v), "%s %s", tc.version, tc.constraint)
+	}
+
+	_, err := version.ParseConstraint(">= 9, < twelve")
+	assert.EqualError(err, "invalid version constraint '>= 9, < twelve': invalid version 'twelve'")
+}